@@ -56,6 +56,7 @@ func main() {
 		agent.WithLogger(logger),
 		agent.WithReportURL(cfg.ReportType),
 		agent.WithSignKey([]byte(cfg.SecretKey)),
+		agent.WithHashAlgo(cfg.HashAlgo),
 		agent.WithKey([]byte(cfg.CryptoKey)),
 	)
 
@@ -5,15 +5,14 @@ import (
 	"fmt"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"metrics-and-alerting/internal/pubsub"
 	"metrics-and-alerting/internal/server"
 	handler "metrics-and-alerting/internal/server/handlers"
 	"metrics-and-alerting/internal/storage"
-	"metrics-and-alerting/internal/storage/dbstore"
-	"metrics-and-alerting/internal/storage/filestorage"
-	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/internal/tracing"
 	"metrics-and-alerting/pkg/logpack"
+	"metrics-and-alerting/pkg/metric"
 )
 
 var (
@@ -22,13 +21,6 @@ var (
 	buildCommit  = "N/A"
 )
 
-var (
-	_ storage.Repository = (*server.MetricsManager)(nil)
-	_ storage.Repository = (*memstore.Storage)(nil)
-	_ storage.Repository = (*filestorage.Storage)(nil)
-	_ storage.Repository = (*dbstore.Storage)(nil)
-)
-
 func init() {
 
 	fmt.Printf("Build version: %s\n", buildVersion)
@@ -39,65 +31,129 @@ func init() {
 func main() {
 
 	logger := logpack.NewLogger()
-	cfg := server.DefaultConfig()
+	cfg, err := server.Load()
+	if err != nil {
+		logger.Fatal.Fatalf("error config: %v\n", err)
+	}
 
-	if err := cfg.ParseFlags(); err != nil {
-		logger.Fatal.Fatalf("error argv: %v\n", err)
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal.Fatalf("invalid config: %v\n", err)
 	}
 
-	cfg.ReadEnvVars()
+	level, _ := logpack.ParseLevel(cfg.LogLevel)
+	logger.SetLevel(level)
+
 	fmt.Println(cfg)
 
-	var store storage.Repository
-	if len(cfg.DatabaseDSN) != 0 {
+	shutdownTracing, err := tracing.Setup(cfg.OTELEndpoint)
+	if err != nil {
+		logger.Fatal.Fatalf("could not setup tracing: %v\n", err)
+	}
 
-		cfg.StoreInterval.Duration = 0
-		db, err := dbstore.New(cfg.DatabaseDSN, logger)
-		if err != nil {
-			panic(err)
-		}
+	if err := metric.SetNameRules(cfg.NamePattern, cfg.NameMaxLen); err != nil {
+		logger.Fatal.Fatalf("error metric name rules: %v\n", err)
+	}
 
-		store = db
-		logger.Info.Println("Using storage: Database")
+	if len(cfg.DatabaseDSN) != 0 {
+		cfg.StoreInterval.Duration = 0
 	}
 
-	if store == nil && len(cfg.StoreFile) != 0 {
-		store = filestorage.New(cfg.StoreFile, logger)
-		logger.Info.Println("Using storage: File")
+	storageCfg := storage.Config{
+		DatabaseDSN:  cfg.DatabaseDSN,
+		StoreFile:    cfg.StoreFile,
+		StoreFormat:  cfg.StoreFormat,
+		StoreBackups: cfg.StoreBackups,
+
+		DBMaxOpenConns:    cfg.DBMaxOpenConn,
+		DBMaxIdleConns:    cfg.DBMaxIdleConn,
+		DBConnMaxLifetime: cfg.DBConnMaxLife.Duration,
+
+		EnableReadCache: cfg.ReadCache,
+		ReadCacheTTL:    cfg.ReadCacheTTL.Duration,
 	}
 
-	if store == nil {
-		store = memstore.New()
-		logger.Info.Println("Using storage: Memory")
+	store, err := storage.New(storageCfg, logger)
+	if err != nil {
+		logger.Fatal.Fatalf("could not create storage: %v\n", err)
 	}
 
-	storeManager := server.New(
-		store,
-		logger,
+	kind, _ := storageCfg.Kind()
+	logger.Info.Printf("Using storage: %s\n", kind)
+
+	broker := pubsub.NewBroker()
+
+	managerOpts := []server.OptionsManager{
 		server.WithSignKey([]byte(cfg.SecretKey)),
+		server.WithPreviousSignKeys(cfg.PreviousSignKeys()...),
+		server.WithHashAlgo(cfg.HashAlgo),
 		server.WithFlush(cfg.StoreInterval.Duration),
 		server.WithRestore(cfg.Restore),
-	)
+		server.WithTTL(cfg.MetricTTL.Duration, cfg.TTLCounters),
+		server.WithCompact(cfg.CompactEvery.Duration),
+		server.WithRejectNegativeCounter(cfg.NoNegCounters),
+		server.WithBroker(broker),
+		server.WithCapacity(cfg.MaxMetrics, cfg.EvictPolicy, cfg.EvictCounters),
+		server.WithShutdownSave(cfg.SaveWait.Duration),
+	}
+
+	if cfg.ClampGauges {
+		managerOpts = append(managerOpts, server.WithGaugeBounds(cfg.GaugeMin, cfg.GaugeMax))
+	}
+
+	if cfg.TrackStats {
+		managerOpts = append(managerOpts, server.WithGaugeStats(cfg.StatsWindow))
+	}
+
+	if cfg.SelfMonitor {
+		managerOpts = append(managerOpts, server.WithSelfMonitor(cfg.MonitorEvery.Duration))
+	}
+
+	storeManager := server.New(store, logger, managerOpts...)
 
 	handlers := handler.New(storeManager,
 		logger,
 		handler.WithKey(cfg.CryptoKey),
-		handler.WithTrustedSubnet(cfg.TrustedSubnet))
+		handler.WithCompressLevel(cfg.CompressLevel),
+		handler.WithCompressMinSize(cfg.CompressMin),
+		handler.WithTrustedSubnet(cfg.TrustedSubnet),
+		handler.WithImportBatchSize(cfg.ImportBatch),
+		handler.WithRequestCounter(storeManager),
+		handler.WithMaxBodyBytes(cfg.MaxBodyBytes),
+		handler.WithStorageKind(kind),
+		handler.WithBroker(broker),
+		handler.WithIdempotency(cfg.IdempotentMax, cfg.IdempotentTTL.Duration),
+		handler.WithOptionalContentType(cfg.OptionalCType),
+		handler.WithRateLimit(cfg.RateLimit, cfg.RateBurst))
+
+	servOpts := []server.OptionsServer{
+		server.WithProfiling(cfg.Profiling),
+		server.WithTimeouts(cfg.HTTPTimeouts()),
+		server.WithBasePath(cfg.BasePath),
+	}
+	if len(cfg.TLSCertFile) != 0 {
+		tlsConfig, err := server.NewTLSConfig(cfg.TLSMinVersion, cfg.TLSClientCA)
+		if err != nil {
+			logger.Fatal.Fatalf("could not configure TLS: %v\n", err)
+		}
 
-	serv := server.NewHTTPServer(cfg.Addr, handlers)
+		servOpts = append(servOpts, server.WithTLS(cfg.TLSCertFile, cfg.TLSKeyFile, tlsConfig))
+	}
+
+	serv := server.NewHTTPServer(cfg.Addr, handlers, servOpts...)
 	serv.Start()
 	logger.Info.Println("HTTP server started")
 
+	var gServ *server.GRPCServer
 	if len(cfg.AddrRPC) != 0 {
-		gServ, errServ := server.NewGRPCServer(cfg.AddrRPC, storeManager)
+		var errServ error
+
+		gServ, errServ = server.NewGRPCServer(cfg.AddrRPC, storeManager)
 		if errServ != nil {
 			logger.Err.Fatalf("failed create gRPC server: %v\n", errServ)
 		}
 
 		gServ.Start()
 		logger.Info.Println("gRPC server started")
-
-		defer gServ.Stop()
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
@@ -105,10 +161,20 @@ func main() {
 	<-ctx.Done()
 	stop()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	if err := serv.Shutdown(ctx); err != nil {
-		logger.Err.Printf("HTTP server Shutdown: %v\n", err)
+	if gServ != nil {
+		gServ.GracefulStop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownWait.Duration)
+	defer cancel()
+
+	if err := server.Stop(ctx, serv, storeManager); err != nil {
+		logger.Err.Printf("could not stop server: %v\n", err)
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Err.Printf("could not stop tracing: %v\n", err)
 	}
-	cancel()
 
+	logger.Info.Println("Server stopped")
 }
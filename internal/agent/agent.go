@@ -24,6 +24,7 @@ type Agent struct {
 	addr           string
 	reportType     string
 	signKey        []byte
+	hashAlgo       string
 	publicKey      []byte
 	storage        storage.Repository
 	conn           *grpc.ClientConn
@@ -80,6 +81,13 @@ func WithSignKey(key []byte) OptionsAgent {
 	}
 }
 
+// WithHashAlgo Алгоритм хеширования для подписи метрик
+func WithHashAlgo(algo string) OptionsAgent {
+	return func(agent *Agent) {
+		agent.hashAlgo = algo
+	}
+}
+
 func WithKey(key []byte) OptionsAgent {
 	return func(agent *Agent) {
 		agent.publicKey = key
@@ -146,6 +154,7 @@ func (a *Agent) reportMetrics(ctx context.Context) {
 		a.storage,
 		a.logger,
 		reporter.WithSignKey(a.signKey),
+		reporter.WithHashAlgo(a.hashAlgo),
 		reporter.WithKey(a.publicKey),
 		reporter.WithRPC(a.conn))
 
@@ -161,7 +170,7 @@ func (a *Agent) reportMetrics(ctx context.Context) {
 
 			// Сброс значения метрики PollCount
 			pollCount, _ := metric.CreateMetric(metric.CounterType, "PollCount", metric.WithValueInt(0))
-			if err := a.storage.Delete(pollCount); err != nil && err != errs.ErrNotFound {
+			if err := a.storage.Delete(ctx, pollCount); err != nil && err != errs.ErrNotFound {
 				a.logger.Err.Printf("error delete metric %s after report: %v\n", pollCount.ShotString(), err)
 			}
 
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"metrics-and-alerting/internal/agent/services/reporter"
+	"metrics-and-alerting/pkg/metric"
 
 	"github.com/caarlos0/env"
 )
@@ -23,6 +24,7 @@ type Config struct {
 	ReportType     string   `env:"REPORT_TYPE"     json:"report_type"    `
 	SecretKey      string   `env:"KEY"             json:"key"            `
 	CryptoKey      string   `env:"CRYPTO_KEY"      json:"crypto_key"     `
+	HashAlgo       string   `env:"HASH_ALGO"       json:"hash_algo"      `
 	ConfigFile     string   `env:"CONFIG"`
 }
 
@@ -36,6 +38,7 @@ func DefaultConfig() *Config {
 		ReportType:     reporter.ReportAsBatchJSON,
 		SecretKey:      "",
 		CryptoKey:      "",
+		HashAlgo:       metric.HashSHA256,
 	}
 }
 
@@ -75,6 +78,8 @@ func (cfg *Config) ParseFlags() error {
 	flag.StringVar(&cfg.ReportType, "rt", cfg.ReportType, fmt.Sprint("support types: ",
 		reporter.ReportAsURL, "|", reporter.ReportAsJSON, "|", reporter.ReportAsBatchJSON, "|", reporter.ReportAsGRPC))
 	flag.StringVar(&cfg.ConfigFile, "c", cfg.ConfigFile, "string - path to config in JSON format")
+	flag.StringVar(&cfg.HashAlgo, "hash-algo", cfg.HashAlgo, fmt.Sprint("string - sign hash algorithm: ",
+		metric.HashSHA256, "|", metric.HashSHA512))
 	addr := flag.String("a", "", "ip address: ip:port")
 	flag.Parse()
 
@@ -82,6 +87,10 @@ func (cfg *Config) ParseFlags() error {
 		return err
 	}
 
+	if _, err := metric.HashFunc(cfg.HashAlgo); err != nil {
+		return fmt.Errorf("incorrect hash algorithm %q: %w", cfg.HashAlgo, err)
+	}
+
 	if len(cryptoPath) == 0 {
 		cryptoPath = cfg.CryptoKey
 	}
@@ -158,6 +167,7 @@ func (cfg Config) String() string {
 	builder.WriteString(fmt.Sprintf("\t REPORT_INTERVAL: %s\n", cfg.ReportInterval.String()))
 	builder.WriteString(fmt.Sprintf("\t POLL_INTERVAL: %s\n", cfg.PollInterval.String()))
 	builder.WriteString(fmt.Sprintf("\t REPORT_TYPE: %s\n", cfg.ReportType))
+	builder.WriteString(fmt.Sprintf("\t HASH_ALGO: %s\n", cfg.HashAlgo))
 	builder.WriteString(fmt.Sprintf("\t KEY: %s\n", cfg.SecretKey))
 
 	if len(cfg.CryptoKey) != 0 {
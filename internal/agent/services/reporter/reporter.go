@@ -8,12 +8,14 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
 	"metrics-and-alerting/internal/storage"
 	"metrics-and-alerting/pkg/logpack"
 	"metrics-and-alerting/pkg/metric"
 	"net/http"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 
@@ -33,19 +35,34 @@ type (
 	Reporter struct {
 		addr      string
 		signKey   []byte
+		hashAlgo  string
 		storage   storage.Repository
 		rpcClient pb.MetricsClient
 		logger    *logpack.LogPack
 		publicKey *rsa.PublicKey
+		retries   []time.Duration
+	}
+
+	// statusError Ответ сервера с кодом, отличным от 200 OK
+	statusError struct {
+		code int
 	}
 )
 
+// DefaultRetryIntervals Паузы между повторными попытками отправки метрик
+var DefaultRetryIntervals = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("server return no success status: %d", err.code)
+}
+
 func NewReporter(addr string, storage storage.Repository, logger *logpack.LogPack, opts ...OptionReporter) *Reporter {
 
 	r := &Reporter{
 		addr:    addr,
 		storage: storage,
 		logger:  logger,
+		retries: DefaultRetryIntervals,
 	}
 
 	for _, opt := range opts {
@@ -61,6 +78,13 @@ func WithSignKey(key []byte) OptionReporter {
 	}
 }
 
+// WithHashAlgo Алгоритм хеширования для подписи метрик
+func WithHashAlgo(algo string) OptionReporter {
+	return func(reporter *Reporter) {
+		reporter.hashAlgo = algo
+	}
+}
+
 func WithKey(key []byte) OptionReporter {
 	return func(reporter *Reporter) {
 
@@ -89,6 +113,14 @@ func WithKey(key []byte) OptionReporter {
 	}
 }
 
+// WithRetryIntervals Паузы между повторными попытками отправки метрик.
+// Без пауз метрики отправляются один раз
+func WithRetryIntervals(intervals ...time.Duration) OptionReporter {
+	return func(reporter *Reporter) {
+		reporter.retries = intervals
+	}
+}
+
 func WithRPC(conn *grpc.ClientConn) OptionReporter {
 	return func(reporter *Reporter) {
 		if conn != nil {
@@ -161,13 +193,13 @@ func (r Reporter) Report(ctx context.Context, reportType string) error {
 // reportGRPC Отправка метрик GRPC шлюз
 func (r Reporter) reportGRPC(ctx context.Context) error {
 
-	metrics, errStorage := r.storage.GetBatch()
+	metrics, errStorage := r.storage.GetBatch(ctx)
 	if errStorage != nil {
 		return errStorage
 	}
 	for _, m := range metrics {
 
-		sign, errSign := m.Sign(r.signKey)
+		sign, errSign := m.SignWith(r.hashAlgo, r.signKey)
 		if errSign != nil {
 			return fmt.Errorf("could not report metrics: %v", errSign)
 		}
@@ -202,7 +234,7 @@ func (r Reporter) reportGRPC(ctx context.Context) error {
 // reportURL Отправка метрик через URL отдельными запросами
 func (r Reporter) reportURL(ctx context.Context) error {
 
-	metrics, errStorage := r.storage.GetBatch()
+	metrics, errStorage := r.storage.GetBatch(ctx)
 	if errStorage != nil {
 		return fmt.Errorf("could not report metrics: %v", errStorage)
 	}
@@ -211,19 +243,17 @@ func (r Reporter) reportURL(ctx context.Context) error {
 
 	for _, m := range metrics {
 
-		resp, err := client.R().
-			SetHeader("Content-Type", "text/plain").
-			SetPathParams(m.Map()).
-			SetContext(ctx).
-			Post(r.addr + "/update/" + "{type}/{name}/{value}")
+		err := r.post(ctx, func() (*resty.Response, error) {
+			return client.R().
+				SetHeader("Content-Type", "text/plain").
+				SetPathParams(m.Map()).
+				SetContext(ctx).
+				Post(r.addr + "/update/" + "{type}/{name}/{value}")
+		})
 
 		if err != nil {
 			return fmt.Errorf("could not send metrics as URL: %w", err)
 		}
-
-		if resp.StatusCode() != http.StatusOK {
-			return fmt.Errorf("server return no success status on update metrics as URL: %d", resp.StatusCode())
-		}
 	}
 
 	return nil
@@ -232,7 +262,7 @@ func (r Reporter) reportURL(ctx context.Context) error {
 // reportJSON Отправка метрик в виде JSON отдельными запросами
 func (r Reporter) reportJSON(ctx context.Context) error {
 
-	metrics, errStorage := r.storage.GetBatch()
+	metrics, errStorage := r.storage.GetBatch(ctx)
 	if errStorage != nil {
 		return fmt.Errorf("could not report metrics: %v", errStorage)
 	}
@@ -241,7 +271,7 @@ func (r Reporter) reportJSON(ctx context.Context) error {
 
 	for _, m := range metrics {
 
-		sign, errSign := m.Sign(r.signKey)
+		sign, errSign := m.SignWith(r.hashAlgo, r.signKey)
 		if errSign != nil {
 			return fmt.Errorf("could not report metrics: %v", errSign)
 		}
@@ -258,19 +288,17 @@ func (r Reporter) reportJSON(ctx context.Context) error {
 			return fmt.Errorf("error encrypt metric marshaled data: %w", err)
 		}
 
-		resp, err := client.R().
-			SetHeader("Content-Type", "application/json").
-			SetBody(data).
-			SetContext(ctx).
-			Post(r.addr + "/update")
+		err = r.post(ctx, func() (*resty.Response, error) {
+			return client.R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(data).
+				SetContext(ctx).
+				Post(r.addr + "/update")
+		})
 
 		if err != nil {
 			return fmt.Errorf("could not send metrics as JSON: %w", err)
 		}
-
-		if resp.StatusCode() != http.StatusOK {
-			return fmt.Errorf("server return no success status on update metrics as JSON: %d", resp.StatusCode())
-		}
 	}
 
 	return nil
@@ -279,7 +307,7 @@ func (r Reporter) reportJSON(ctx context.Context) error {
 // reportBatchJSON Отправка метрик в виде JSON одним запросом
 func (r Reporter) reportBatchJSON(ctx context.Context) error {
 
-	metrics, errStorage := r.storage.GetBatch()
+	metrics, errStorage := r.storage.GetBatch(ctx)
 	if errStorage != nil {
 		return fmt.Errorf("could not report metrics: %v", errStorage)
 	}
@@ -289,7 +317,7 @@ func (r Reporter) reportBatchJSON(ctx context.Context) error {
 
 	for i, m := range metrics {
 
-		sign, errSign := m.Sign(r.signKey)
+		sign, errSign := m.SignWith(r.hashAlgo, r.signKey)
 		if errSign != nil {
 			return fmt.Errorf("could not report metrics: %v", errSign)
 		}
@@ -310,20 +338,74 @@ func (r Reporter) reportBatchJSON(ctx context.Context) error {
 	}
 
 	client := resty.New()
-	resp, err := client.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("X-Real-IP", "125.3.21.1").
-		SetBody(data).
-		SetContext(ctx).
-		Post(r.addr + "/updates")
+	err = r.post(ctx, func() (*resty.Response, error) {
+		return client.R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader("X-Real-IP", "125.3.21.1").
+			SetBody(data).
+			SetContext(ctx).
+			Post(r.addr + "/updates")
+	})
 
 	if err != nil {
 		return fmt.Errorf("could not send metrics as Batch-JSON: %w", err)
 	}
 
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("server return no success status on update metrics as Batch-JSON: %d", resp.StatusCode())
+	return nil
+}
+
+// retryable Признак ошибки, после которой отправку стоит повторить:
+// сетевая ошибка или ответ сервера 5xx. Ответы 4xx не повторяются
+func retryable(err error) bool {
+
+	var errStatus *statusError
+	if errors.As(err, &errStatus) {
+		return errStatus.code >= http.StatusInternalServerError
+	}
+
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// retry Вызов send с повторами через паузы r.retries, пока ошибка retryable.
+// Ожидание прерывается при завершении ctx. Возвращается последняя ошибка
+func (r Reporter) retry(ctx context.Context, send func() error) error {
+
+	err := send()
+
+	for _, interval := range r.retries {
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		r.logger.Warn.Printf("could not send metrics, retry in %s: %v\n", interval, err)
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		err = send()
 	}
 
-	return nil
+	return err
+}
+
+// post Отправка запроса с повторами при сетевых ошибках и ответах 5xx
+func (r Reporter) post(ctx context.Context, request func() (*resty.Response, error)) error {
+	return r.retry(ctx, func() error {
+
+		resp, err := request()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode() != http.StatusOK {
+			return &statusError{code: resp.StatusCode()}
+		}
+
+		return nil
+	})
 }
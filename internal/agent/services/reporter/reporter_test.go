@@ -0,0 +1,146 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/logpack"
+	"metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer Сервер, который обрывает соединение failures раз, а затем отвечает status
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	calls    int
+	received []metric.Metric
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+
+	if s.calls <= s.failures {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	data, _ := io.ReadAll(r.Body)
+
+	var m metric.Metric
+	if err := json.Unmarshal(data, &m); err == nil {
+		s.received = append(s.received, m)
+	}
+
+	w.WriteHeader(s.status)
+}
+
+func newTestReporter(t *testing.T, addr string, retries ...time.Duration) *Reporter {
+
+	store := memstore.New()
+
+	gauge, err := metric.CreateMetric(metric.GaugeType, "Alloc", metric.WithValueFloat(12.5))
+	require.NoError(t, err)
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+
+	return NewReporter(addr, store, logpack.NewLogger(), WithRetryIntervals(retries...))
+}
+
+func TestReporter_Retry(t *testing.T) {
+
+	retries := []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 50 * time.Millisecond}
+
+	tests := []struct {
+		name      string
+		failures  int
+		status    int
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "Fails twice then succeeds",
+			failures:  2,
+			status:    http.StatusOK,
+			wantCalls: 3,
+		},
+		{
+			name:      "Network errors exceed retries",
+			failures:  10,
+			status:    http.StatusOK,
+			wantCalls: len(retries) + 1,
+			wantErr:   true,
+		},
+		{
+			name:      "Client error is not retried",
+			status:    http.StatusBadRequest,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "Server error is retried",
+			status:    http.StatusServiceUnavailable,
+			wantCalls: len(retries) + 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			srv := &flakyServer{failures: tt.failures, status: tt.status}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			err := newTestReporter(t, ts.URL, retries...).Report(context.Background(), ReportAsJSON)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+
+			assert.Equal(t, tt.wantCalls, srv.calls)
+
+			if !tt.wantErr {
+				require.Len(t, srv.received, 1)
+				assert.Equal(t, "Alloc", srv.received[0].ID)
+				assert.Equal(t, 12.5, *srv.received[0].Value)
+			}
+		})
+	}
+}
+
+func TestReporter_RetryDeadline(t *testing.T) {
+
+	srv := &flakyServer{failures: 10, status: http.StatusOK}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := newTestReporter(t, ts.URL, time.Minute).Report(ctx, ReportAsJSON)
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
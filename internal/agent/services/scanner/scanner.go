@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"runtime"
@@ -15,11 +16,13 @@ import (
 
 type Scanner struct {
 	storage storage.Repository
+	random  *rand.Rand // генератор RandomValue, инициализируется один раз
 }
 
 func NewScanner(storage storage.Repository) *Scanner {
 	return &Scanner{
 		storage: storage,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
@@ -47,9 +50,7 @@ func (scan *Scanner) updateRuntime() error {
 	var ms runtime.MemStats
 	runtime.ReadMemStats(&ms)
 
-	generator := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	RandomValue, _ := metric.CreateMetric(metric.GaugeType, "RandomValue", metric.WithValueFloat(generator.Float64()))
+	RandomValue, _ := metric.CreateMetric(metric.GaugeType, "RandomValue", metric.WithValueFloat(scan.random.Float64()))
 	Alloc, _ := metric.CreateMetric(metric.GaugeType, "Alloc", metric.WithValueInt(int64(ms.Alloc)))
 	BuckHashSys, _ := metric.CreateMetric(metric.GaugeType, "BuckHashSys", metric.WithValueInt(int64(ms.BuckHashSys)))
 	Frees, _ := metric.CreateMetric(metric.GaugeType, "Frees", metric.WithValueInt(int64(ms.Frees)))
@@ -109,7 +110,7 @@ func (scan *Scanner) updateRuntime() error {
 	metrics = append(metrics, TotalAlloc)
 	metrics = append(metrics, PollCount)
 
-	return scan.storage.UpsertBatch(metrics)
+	return scan.storage.UpsertBatch(context.Background(), metrics)
 }
 
 // updateRuntime Обновление метрик загрузки памяти и ядер процессора
@@ -137,5 +138,5 @@ func (scan *Scanner) updateWorkload() error {
 		metrics = append(metrics, cpuN)
 	}
 
-	return scan.storage.UpsertBatch(metrics)
+	return scan.storage.UpsertBatch(context.Background(), metrics)
 }
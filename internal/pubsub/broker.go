@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"sync"
+
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// DefaultBufferSize Количество обновлений, которые подписчик может не прочитать,
+// прежде чем будет отключен
+const DefaultBufferSize = 256
+
+type (
+	// Broker Рассылка обновлений метрик подписчикам.
+	// Публикация не блокируется: подписчик, не успевающий читать обновления, отключается
+	Broker struct {
+		mu          sync.Mutex
+		subscribers map[*Subscription]struct{}
+	}
+
+	// Subscription Подписка на обновления метрик.
+	// Канал C закрывается при отмене подписки и при отключении медленного подписчика
+	Subscription struct {
+		C <-chan metricPkg.Metric
+
+		ch         chan metricPkg.Metric
+		typeMetric string
+		dropped    bool
+	}
+)
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe Подписка на обновления метрик типа typeMetric, пустой тип - на все обновления.
+// size - количество непрочитанных обновлений, при превышении которого подписчик отключается
+func (b *Broker) Subscribe(typeMetric string, size int) *Subscription {
+
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	ch := make(chan metricPkg.Metric, size)
+	sub := &Subscription{C: ch, ch: ch, typeMetric: typeMetric}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe Отмена подписки. Повторный вызов ничего не делает
+func (b *Broker) Unsubscribe(sub *Subscription) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(b.subscribers, sub)
+	close(sub.ch)
+}
+
+// Publish Рассылка обновлений подписчикам без ожидания.
+// Подписчик с заполненным буфером отключается и больше обновлений не получает
+func (b *Broker) Publish(metrics ...metricPkg.Metric) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		for _, metric := range metrics {
+
+			if len(sub.typeMetric) != 0 && sub.typeMetric != metric.MType {
+				continue
+			}
+
+			select {
+			case sub.ch <- metric:
+			default:
+				sub.dropped = true
+				delete(b.subscribers, sub)
+				close(sub.ch)
+			}
+
+			if sub.dropped {
+				break
+			}
+		}
+	}
+}
+
+// Subscribers Количество подписчиков
+func (b *Broker) Subscribers() int {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subscribers)
+}
+
+// Dropped Признак отключения подписчика из-за того, что он не успевал читать обновления.
+// Значение достоверно после закрытия канала C
+func (sub *Subscription) Dropped() bool {
+	return sub.dropped
+}
@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"testing"
+
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker(t *testing.T) {
+
+	gauge, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1))
+	require.NoError(t, err)
+
+	counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(1))
+	require.NoError(t, err)
+
+	t.Run("Filter by type", func(t *testing.T) {
+		broker := NewBroker()
+		all := broker.Subscribe("", 2)
+		gauges := broker.Subscribe(metricPkg.GaugeType, 2)
+
+		broker.Publish(gauge, counter)
+
+		require.Equal(t, gauge, <-all.C)
+		require.Equal(t, counter, <-all.C)
+		require.Equal(t, gauge, <-gauges.C)
+		require.Len(t, gauges.C, 0)
+	})
+
+	t.Run("Drop slow subscriber", func(t *testing.T) {
+		broker := NewBroker()
+		slow := broker.Subscribe("", 1)
+		fast := broker.Subscribe("", 4)
+
+		broker.Publish(gauge, gauge, gauge)
+
+		<-slow.C
+		_, ok := <-slow.C
+		require.False(t, ok)
+		require.True(t, slow.Dropped())
+
+		require.Len(t, fast.C, 3)
+		require.False(t, fast.Dropped())
+		require.Equal(t, 1, broker.Subscribers())
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		broker := NewBroker()
+		sub := broker.Subscribe("", 1)
+
+		broker.Unsubscribe(sub)
+		broker.Unsubscribe(sub)
+
+		_, ok := <-sub.C
+		require.False(t, ok)
+		require.False(t, sub.Dropped())
+		require.Equal(t, 0, broker.Subscribers())
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"math"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// gaugeBounds Границы, в которые приводятся значения gauge
+type gaugeBounds struct {
+	min float64
+	max float64
+}
+
+// WithGaugeBounds Приведение значений gauge к границам [min, max] перед сохранением.
+// Значение за границей заменяется ближайшей границей
+func WithGaugeBounds(min, max float64) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.gaugeBounds = &gaugeBounds{min: min, max: max}
+	}
+}
+
+// WithRejectNegativeCounter Отклонение отрицательных приращений counter
+func WithRejectNegativeCounter(reject bool) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.rejectNegative = reject
+	}
+}
+
+// checkDelta Проверка приращения counter до его накопления
+func (manager MetricsManager) checkDelta(metric metricPkg.Metric) error {
+
+	if manager.rejectNegative && metric.MType == metricPkg.CounterType && metric.Delta != nil && *metric.Delta < 0 {
+		return fmt.Errorf("%w: negative counter delta %d", errs.ErrInvalidValue, *metric.Delta)
+	}
+
+	return nil
+}
+
+// boundGauge Проверка и приведение к границам значения gauge после приращения.
+// Приращение, после которого значение перестало быть конечным, отклоняется
+func (manager MetricsManager) boundGauge(metric *metricPkg.Metric) error {
+
+	if metric.MType != metricPkg.GaugeType || metric.Value == nil {
+		return nil
+	}
+
+	if math.IsNaN(*metric.Value) || math.IsInf(*metric.Value, 0) {
+		return fmt.Errorf("%w: gauge %s is not finite", errs.ErrInvalidValue, metric.ID)
+	}
+
+	if manager.gaugeBounds == nil {
+		return nil
+	}
+
+	value := math.Max(manager.gaugeBounds.min, math.Min(manager.gaugeBounds.max, *metric.Value))
+	if value != *metric.Value {
+		manager.logger.Debug.Printf("gauge %s clamped from %s to %s\n",
+			metric.ID, metricPkg.FormatFloat(*metric.Value), metricPkg.FormatFloat(value))
+		metric.Value = &value
+	}
+
+	return nil
+}
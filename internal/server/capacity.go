@@ -0,0 +1,213 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// Политики при достижении предельного количества метрик
+const (
+	CapacityReject = "reject" // новые метрики отклоняются
+	CapacityEvict  = "evict"  // удаляются метрики, которые дольше всех не обновлялись
+)
+
+// capacity Предельное количество метрик и порядок их обновления для вытеснения.
+// Используется под блокировкой менеджера
+type capacity struct {
+	max           int
+	policy        string
+	evictCounters bool
+	entries       map[string]*list.Element // все метрики, для защищенных от вытеснения - nil
+	lru           *list.List               // метрики, которые можно вытеснить, от недавно обновленных к давно обновленным
+}
+
+// WithCapacity Предельное количество метрик max и политика при его достижении:
+// CapacityReject или CapacityEvict. Counter и float_counter вытесняются только при evictCounters = true.
+// 0 - количество метрик не ограничено
+func WithCapacity(max int, policy string, evictCounters bool) OptionsManager {
+	return func(manager *MetricsManager) {
+
+		if max <= 0 {
+			manager.capacity = nil
+			return
+		}
+
+		manager.capacity = &capacity{
+			max:           max,
+			policy:        policy,
+			evictCounters: evictCounters,
+			entries:       make(map[string]*list.Element),
+			lru:           list.New(),
+		}
+	}
+}
+
+// ValidCapacityPolicy Проверка, что политика при достижении предельного количества метрик поддерживается
+func ValidCapacityPolicy(policy string) bool {
+	return policy == CapacityReject || policy == CapacityEvict
+}
+
+// evictable Признак метрики, которую можно вытеснить
+func (c *capacity) evictable(metric metricPkg.Metric) bool {
+	return c.evictCounters || !metricPkg.IsCounter(metric.MType)
+}
+
+// touch Учет обновления метрики: метрика становится последней обновленной
+func (c *capacity) touch(metric metricPkg.Metric) {
+
+	key := metric.Key()
+	if elem, ok := c.entries[key]; ok {
+		if elem != nil {
+			c.lru.MoveToFront(elem)
+		}
+
+		return
+	}
+
+	if !c.evictable(metric) {
+		c.entries[key] = nil
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(metricPkg.Metric{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+}
+
+// forget Учет удаления метрики
+func (c *capacity) forget(metric metricPkg.Metric) {
+
+	key := metric.Key()
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	if elem != nil {
+		c.lru.Remove(elem)
+	}
+
+	delete(c.entries, key)
+}
+
+// reload Заполнение по метрикам хранилища в порядке времени их обновления
+func (c *capacity) reload(metrics []metricPkg.Metric) {
+
+	c.entries = make(map[string]*list.Element, len(metrics))
+	c.lru.Init()
+
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].LastUpdate.Before(metrics[j].LastUpdate)
+	})
+
+	for _, metric := range metrics {
+		c.touch(metric)
+	}
+}
+
+// loadCapacity Учет метрик, уже сохраненных в хранилище
+func (manager MetricsManager) loadCapacity(ctx context.Context) error {
+
+	if manager.capacity == nil {
+		return nil
+	}
+
+	metrics, err := manager.storage.GetBatch(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load metrics for capacity limit: %w", err)
+	}
+
+	manager.capacity.reload(metrics)
+	return nil
+}
+
+// admit Проверка, что новые метрики набора помещаются в хранилище.
+// При политике CapacityEvict место освобождается удалением метрик, которые дольше всех не обновлялись,
+// кроме метрик самого набора. Если места не хватает, возвращается errs.ErrCapacity
+func (manager MetricsManager) admit(ctx context.Context, metrics []metricPkg.Metric) error {
+
+	c := manager.capacity
+	if c == nil {
+		return nil
+	}
+
+	batch := make(map[string]struct{}, len(metrics))
+	added := 0
+	for _, m := range metrics {
+		key := m.Key()
+		if _, ok := batch[key]; ok {
+			continue
+		}
+
+		batch[key] = struct{}{}
+		if _, ok := c.entries[key]; !ok {
+			added++
+		}
+	}
+
+	excess := len(c.entries) + added - c.max
+	if excess <= 0 {
+		return nil
+	}
+
+	if c.policy != CapacityEvict {
+		return fmt.Errorf("%w: limit of %d metrics reached", errs.ErrCapacity, c.max)
+	}
+
+	victims := make([]metricPkg.Metric, 0, excess)
+	for elem := c.lru.Back(); elem != nil && len(victims) < excess; elem = elem.Prev() {
+
+		victim := elem.Value.(metricPkg.Metric)
+		if _, ok := batch[victim.Key()]; !ok {
+			victims = append(victims, victim)
+		}
+	}
+
+	if len(victims) < excess {
+		return fmt.Errorf("%w: limit of %d metrics reached, nothing to evict", errs.ErrCapacity, c.max)
+	}
+
+	for _, victim := range victims {
+		if err := manager.storage.Delete(ctx, victim); err != nil {
+			return fmt.Errorf("could not evict metric %s: %w", victim.Key(), err)
+		}
+
+		manager.signs.drop(victim)
+		manager.trackDelete(victim)
+
+		manager.logger.Ctx(ctx).Info.Printf("metric evicted: %s\n", victim.Key())
+	}
+
+	return nil
+}
+
+// trackUpdate Учет сохраненных метрик для вытеснения и статистики gauge
+func (manager MetricsManager) trackUpdate(metrics ...metricPkg.Metric) {
+
+	for _, metric := range metrics {
+		if manager.capacity != nil {
+			manager.capacity.touch(metric)
+		}
+
+		if manager.stats != nil {
+			manager.stats.record(metric)
+		}
+	}
+}
+
+// trackDelete Учет удаленных метрик для вытеснения и статистики gauge
+func (manager MetricsManager) trackDelete(metrics ...metricPkg.Metric) {
+
+	for _, metric := range metrics {
+		if manager.capacity != nil {
+			manager.capacity.forget(metric)
+		}
+
+		if manager.stats != nil {
+			manager.stats.forget(metric)
+		}
+	}
+}
@@ -1,32 +1,96 @@
 package server
 
 import (
+	"compress/gzip"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
+	handler "metrics-and-alerting/internal/server/handlers"
+	"metrics-and-alerting/internal/storage"
+	"metrics-and-alerting/internal/storage/dbstore"
+	"metrics-and-alerting/internal/storage/filestorage"
+	"metrics-and-alerting/internal/tracing"
+	"metrics-and-alerting/pkg/logpack"
+	"metrics-and-alerting/pkg/metric"
+
 	"github.com/caarlos0/env"
 )
 
 type Config struct {
-	Addr          string   `env:"ADDRESS"        json:"address"        `
-	AddrRPC       string   `env:"ADDRESS_RPC"    json:"address_rpc"    `
-	StoreInterval Duration `env:"STORE_INTERVAL" json:"store_interval" `
-	Restore       bool     `env:"RESTORE"        json:"restore"        `
-	DatabaseDSN   string   `env:"DATABASE_DSN"   json:"database_dsn"   `
-	StoreFile     string   `env:"STORE_FILE"     json:"store_file"     `
-	SecretKey     string   `env:"KEY"            json:"secret_key"     `
-	CryptoKey     string   `env:"CRYPTO_KEY"     json:"crypto_key"     `
-	TrustedSubnet string   `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	ConfigFile    string   `env:"CONFIG"`
+	Addr          string   `env:"ADDRESS"          json:"address"         `
+	AddrRPC       string   `env:"ADDRESS_RPC"      json:"address_rpc"     `
+	BasePath      string   `env:"BASE_PATH"        json:"base_path"       `
+	StoreInterval Duration `env:"STORE_INTERVAL"   json:"store_interval"  `
+	Restore       bool     `env:"RESTORE"          json:"restore"         `
+	DatabaseDSN   string   `env:"DATABASE_DSN"     json:"database_dsn"    `
+	DBMaxOpenConn int      `env:"DB_MAX_OPEN_CONN" json:"db_max_open_conn"`
+	DBMaxIdleConn int      `env:"DB_MAX_IDLE_CONN" json:"db_max_idle_conn"`
+	DBConnMaxLife Duration `env:"DB_CONN_LIFETIME" json:"db_conn_lifetime"`
+	ReadCache     bool     `env:"READ_CACHE"       json:"read_cache"      `
+	ReadCacheTTL  Duration `env:"READ_CACHE_TTL"   json:"read_cache_ttl"  `
+	StoreFile     string   `env:"STORE_FILE"       json:"store_file"      `
+	StoreFormat   string   `env:"STORE_FORMAT"     json:"store_format"    `
+	StoreBackups  int      `env:"STORE_BACKUPS"    json:"store_backups"   `
+	CompactEvery  Duration `env:"COMPACT_INTERVAL" json:"compact_interval"`
+	SecretKey     string   `env:"KEY"              json:"secret_key"      `
+	PreviousKeys  string   `env:"PREVIOUS_KEYS"    json:"previous_keys"   `
+	CryptoKey     string   `env:"CRYPTO_KEY"       json:"crypto_key"      `
+	TrustedSubnet string   `env:"TRUSTED_SUBNET"   json:"trusted_subnet"  `
+	CompressLevel int      `env:"COMPRESS_LEVEL"   json:"compress_level"  `
+	CompressMin   int      `env:"COMPRESS_MIN"     json:"compress_min"    `
+	HashAlgo      string   `env:"HASH_ALGO"        json:"hash_algo"       `
+	MetricTTL     Duration `env:"METRIC_TTL"       json:"metric_ttl"      `
+	ClampGauges   bool     `env:"CLAMP_GAUGES"     json:"clamp_gauges"    `
+	GaugeMin      float64  `env:"GAUGE_MIN"        json:"gauge_min"       `
+	GaugeMax      float64  `env:"GAUGE_MAX"        json:"gauge_max"       `
+	NoNegCounters bool     `env:"REJECT_NEG_DELTA" json:"reject_neg_delta"`
+	TTLCounters   bool     `env:"TTL_COUNTERS"     json:"ttl_counters"    `
+	MaxMetrics    int      `env:"MAX_METRICS"      json:"max_metrics"     `
+	EvictPolicy   string   `env:"EVICT_POLICY"     json:"evict_policy"    `
+	EvictCounters bool     `env:"EVICT_COUNTERS"   json:"evict_counters"  `
+	TrackStats    bool     `env:"TRACK_STATS"      json:"track_stats"     `
+	StatsWindow   int      `env:"STATS_WINDOW"     json:"stats_window"    `
+	NamePattern   string   `env:"NAME_PATTERN"     json:"name_pattern"    `
+	NameMaxLen    int      `env:"NAME_MAX_LEN"     json:"name_max_len"    `
+	ShutdownWait  Duration `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout"`
+	SaveWait      Duration `env:"SAVE_TIMEOUT"     json:"save_timeout"    `
+	HeaderWait    Duration `env:"HEADER_TIMEOUT"   json:"header_timeout"  `
+	ReadWait      Duration `env:"READ_TIMEOUT"     json:"read_timeout"    `
+	WriteWait     Duration `env:"WRITE_TIMEOUT"    json:"write_timeout"   `
+	IdleWait      Duration `env:"IDLE_TIMEOUT"     json:"idle_timeout"    `
+	Profiling     bool     `env:"ENABLE_PROFILING" json:"enable_profiling"`
+	RateLimit     float64  `env:"RATE_LIMIT"       json:"rate_limit"      `
+	RateBurst     int      `env:"RATE_BURST"       json:"rate_burst"      `
+	LogLevel      string   `env:"LOG_LEVEL"        json:"log_level"       `
+	OTELEndpoint  string   `env:"OTEL_ENDPOINT"    json:"otel_endpoint"   `
+	ImportBatch   int      `env:"IMPORT_BATCH"     json:"import_batch"    `
+	TLSCertFile   string   `env:"TLS_CERT_FILE"    json:"tls_cert_file"   `
+	TLSKeyFile    string   `env:"TLS_KEY_FILE"     json:"tls_key_file"    `
+	TLSMinVersion string   `env:"TLS_MIN_VERSION"  json:"tls_min_version" `
+	TLSClientCA   string   `env:"TLS_CLIENT_CA"    json:"tls_client_ca"   `
+	SelfMonitor   bool     `env:"SELF_MONITOR"     json:"self_monitor"    `
+	MonitorEvery  Duration `env:"MONITOR_INTERVAL" json:"monitor_interval"`
+	MaxBodyBytes  int64    `env:"MAX_BODY_BYTES"   json:"max_body_bytes"  `
+	IdempotentMax int      `env:"IDEMPOTENCY_MAX"  json:"idempotency_max" `
+	IdempotentTTL Duration `env:"IDEMPOTENCY_TTL"  json:"idempotency_ttl" `
+	OptionalCType bool     `env:"OPTIONAL_CTYPE"   json:"optional_ctype"  `
+	ConfigFile    string   `env:"CONFIG"           json:"-"`
 }
 
+// EnvConfig Переменная окружения с путем к файлу конфигурации
+const EnvConfig = "CONFIG"
+
 type Duration struct {
 	time.Duration
 }
@@ -39,10 +103,35 @@ func DefaultConfig() *Config {
 		AddrRPC:       ":3200",
 		Restore:       true,
 		DatabaseDSN:   "",
+		DBMaxIdleConn: dbstore.DefaultMaxIdleConns,
+		ReadCacheTTL:  Duration{Duration: storage.DefaultReadCacheTTL},
 		StoreFile:     "",
+		StoreFormat:   filestorage.FormatJSONL,
 		SecretKey:     "",
 		CryptoKey:     "",
 		StoreInterval: Duration{Duration: 10 * time.Second},
+		CompressLevel: gzip.DefaultCompression,
+		CompressMin:   1400,
+		HashAlgo:      metric.HashSHA256,
+		EvictPolicy:   CapacityReject,
+		StatsWindow:   DefaultStatsWindow,
+		GaugeMin:      -math.MaxFloat64,
+		GaugeMax:      math.MaxFloat64,
+		NamePattern:   metric.DefaultNamePattern,
+		NameMaxLen:    metric.DefaultNameMaxLen,
+		ShutdownWait:  Duration{Duration: 2 * time.Second},
+		SaveWait:      Duration{Duration: DefaultShutdownSaveTimeout},
+		HeaderWait:    Duration{Duration: DefaultReadHeaderTimeout},
+		ReadWait:      Duration{Duration: DefaultReadTimeout},
+		WriteWait:     Duration{Duration: DefaultWriteTimeout},
+		IdleWait:      Duration{Duration: DefaultIdleTimeout},
+		LogLevel:      "info",
+		ImportBatch:   handler.DefaultImportBatchSize,
+		TLSMinVersion: DefaultTLSMinVersion,
+		MonitorEvery:  Duration{Duration: DefaultSelfMonitorInterval},
+		MaxBodyBytes:  handler.DefaultMaxBodyBytes,
+		IdempotentMax: handler.DefaultIdempotencySize,
+		IdempotentTTL: Duration{Duration: handler.DefaultIdempotencyTTL},
 	}
 }
 
@@ -67,49 +156,194 @@ func (duration *Duration) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (cfg *Config) ReadConfig() error {
+// UnmarshalText Чтение длительности из переменной окружения в формате time.ParseDuration
+func (duration *Duration) UnmarshalText(b []byte) error {
 
-	if len(cfg.ConfigFile) == 0 {
-		return nil
+	d, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
 	}
 
-	data, errRead := ioutil.ReadFile(cfg.ConfigFile)
+	duration.Duration = d
+	return nil
+}
+
+// readConfigFile Чтение конфигурации из JSON файла.
+// Неизвестные ключи игнорируются с предупреждением
+func (cfg *Config) readConfigFile(path string) error {
+
+	data, errRead := ioutil.ReadFile(path)
 	if errRead != nil {
 		return errRead
 	}
 
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	known := configKeys()
+	for key := range keys {
+		if _, ok := known[key]; !ok {
+			logpack.NewLogger().Warn.Printf("config file %s: unknown key %q ignored\n", path, key)
+		}
+	}
+
 	return json.Unmarshal(data, cfg)
 }
 
-func (cfg *Config) ParseFlags() error {
+// configKeys Ключи JSON конфигурации
+func configKeys() map[string]struct{} {
 
-	var cryptoPath string
-	var trustedSubnet string
+	keys := make(map[string]struct{})
 
-	flag.BoolVar(&cfg.Restore, "r", cfg.Restore, "bool - restore metrics")
-	flag.StringVar(&cfg.StoreFile, "f", cfg.StoreFile, "string - path to fileStorage storage")
-	flag.DurationVar(&cfg.StoreInterval.Duration, "i", cfg.StoreInterval.Duration, "duration - interval store metrics")
-	flag.StringVar(&cfg.SecretKey, "k", cfg.SecretKey, "string - key sign")
-	flag.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "string - dbstore data source name")
-	flag.StringVar(&cryptoPath, "crypto-key", cfg.CryptoKey, "string - path to file with private crypto key")
-	flag.StringVar(&cfg.ConfigFile, "c", cfg.ConfigFile, "string - path to config in JSON format")
-	flag.StringVar(&trustedSubnet, "t", trustedSubnet, "string - CIDR")
-	flag.StringVar(&cfg.AddrRPC, "rpc", cfg.AddrRPC, "string - address grpc gate")
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
 
-	addr := flag.String("a", "", "string - host:port")
-	flag.Parse()
+		name := strings.TrimSpace(strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+		if len(name) == 0 || name == "-" {
+			continue
+		}
 
-	if err := cfg.ReadConfig(); err != nil {
-		return err
+		keys[name] = struct{}{}
+	}
+
+	return keys
+}
+
+// Load Чтение конфигурации сервера.
+// Значения по умолчанию переопределяются файлом конфигурации,
+// файл - флагами, флаги - переменными окружения
+func Load() (*Config, error) {
+	return load(os.Args[1:])
+}
+
+func load(args []string) (*Config, error) {
+
+	cfg := DefaultConfig()
+
+	if err := cfg.parseFlags(args); err != nil {
+		return nil, fmt.Errorf("could not parse flags: %w", err)
+	}
+
+	path := cfg.ConfigFile
+	if envPath, ok := os.LookupEnv(EnvConfig); ok {
+		path = envPath
+	}
+
+	// Флаги применяются повторно поверх значений из файла
+	if len(path) != 0 {
+
+		cfg = DefaultConfig()
+		if err := cfg.readConfigFile(path); err != nil {
+			return nil, fmt.Errorf("could not read config file: %w", err)
+		}
+
+		if err := cfg.parseFlags(args); err != nil {
+			return nil, fmt.Errorf("could not parse flags: %w", err)
+		}
 	}
 
-	if len(cryptoPath) == 0 {
-		cryptoPath = cfg.CryptoKey
+	if err := cfg.readEnvVars(); err != nil {
+		return nil, fmt.Errorf("could not parse environment variables: %w", err)
 	}
 
-	if len(cryptoPath) > 0 {
+	if err := cfg.prepare(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) parseFlags(args []string) error {
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.Addr, "a", cfg.Addr, "string - host:port")
+	fs.BoolVar(&cfg.Restore, "r", cfg.Restore, "bool - restore metrics")
+	fs.StringVar(&cfg.StoreFile, "f", cfg.StoreFile, "string - path to fileStorage storage")
+	fs.StringVar(&cfg.StoreFormat, "store-format", cfg.StoreFormat, fmt.Sprint("string - store file format: ",
+		filestorage.FormatJSONL, "|", filestorage.FormatJSON))
+	fs.IntVar(&cfg.StoreBackups, "store-backups", cfg.StoreBackups, "int - number of store file backups to keep, 0 - disabled")
+	fs.DurationVar(&cfg.CompactEvery.Duration, "compact-interval", cfg.CompactEvery.Duration, "duration - interval to remove duplicates from store file, 0 - disabled")
+	fs.DurationVar(&cfg.StoreInterval.Duration, "i", cfg.StoreInterval.Duration, "duration - interval store metrics")
+	fs.StringVar(&cfg.SecretKey, "k", cfg.SecretKey, "string - key sign")
+	fs.StringVar(&cfg.PreviousKeys, "previous-keys", cfg.PreviousKeys, "string - comma separated previous sign keys, still accepted for verification")
+	fs.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "string - dbstore data source name")
+	fs.IntVar(&cfg.DBMaxOpenConn, "db-max-open-conns", cfg.DBMaxOpenConn, "int - max open database connections, 0 - unlimited")
+	fs.IntVar(&cfg.DBMaxIdleConn, "db-max-idle-conns", cfg.DBMaxIdleConn, "int - max idle database connections, 0 - keep none")
+	fs.DurationVar(&cfg.DBConnMaxLife.Duration, "db-conn-max-lifetime", cfg.DBConnMaxLife.Duration, "duration - max lifetime of database connection, 0 - unlimited")
+	fs.BoolVar(&cfg.ReadCache, "read-cache", cfg.ReadCache, "bool - cache metric reads in front of database")
+	fs.DurationVar(&cfg.ReadCacheTTL.Duration, "read-cache-ttl", cfg.ReadCacheTTL.Duration, "duration - lifetime of metric in read cache")
+	fs.StringVar(&cfg.CryptoKey, "crypto-key", cfg.CryptoKey, "string - path to file with private crypto key")
+	fs.StringVar(&cfg.ConfigFile, "c", cfg.ConfigFile, "string - path to config in JSON format")
+	fs.StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, "string - path to config in JSON format")
+	fs.StringVar(&cfg.TrustedSubnet, "t", cfg.TrustedSubnet, "string - trusted subnets in CIDR notation, comma separated")
+	fs.StringVar(&cfg.AddrRPC, "rpc", cfg.AddrRPC, "string - address grpc gate")
+	fs.StringVar(&cfg.BasePath, "base-path", cfg.BasePath, "string - path prefix of all HTTP routes, e.g. /metrics-server")
+	fs.IntVar(&cfg.CompressLevel, "compress-level", cfg.CompressLevel, "int - gzip compression level")
+	fs.IntVar(&cfg.CompressMin, "compress-min", cfg.CompressMin, "int - minimal response size in bytes to compress")
+	fs.DurationVar(&cfg.MetricTTL.Duration, "ttl", cfg.MetricTTL.Duration, "duration - delete metrics not updated within ttl, 0 - disabled")
+	fs.BoolVar(&cfg.TTLCounters, "ttl-counters", cfg.TTLCounters, "bool - apply metric ttl to counters")
+	fs.IntVar(&cfg.MaxMetrics, "max-metrics", cfg.MaxMetrics, "int - max number of stored metrics, 0 - unlimited")
+	fs.StringVar(&cfg.EvictPolicy, "evict-policy", cfg.EvictPolicy, fmt.Sprint("string - policy when max metrics reached: ",
+		CapacityReject, "|", CapacityEvict))
+	fs.BoolVar(&cfg.EvictCounters, "evict-counters", cfg.EvictCounters, "bool - allow eviction of counters")
+	fs.BoolVar(&cfg.TrackStats, "track-stats", cfg.TrackStats, "bool - track min/max/avg of recent gauge values")
+	fs.IntVar(&cfg.StatsWindow, "stats-window", cfg.StatsWindow, "int - number of recent gauge values for -track-stats")
+	fs.BoolVar(&cfg.ClampGauges, "clamp-gauges", cfg.ClampGauges, "bool - clamp gauge values to -gauge-min and -gauge-max")
+	fs.Float64Var(&cfg.GaugeMin, "gauge-min", cfg.GaugeMin, "float - lower bound of gauge values with -clamp-gauges")
+	fs.Float64Var(&cfg.GaugeMax, "gauge-max", cfg.GaugeMax, "float - upper bound of gauge values with -clamp-gauges")
+	fs.BoolVar(&cfg.NoNegCounters, "reject-negative-counter", cfg.NoNegCounters, "bool - reject negative counter deltas")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "float - requests per second for each client, 0 - unlimited")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "int - requests burst for each client")
+	fs.BoolVar(&cfg.Profiling, "pprof", cfg.Profiling, "bool - expose net/http/pprof handlers on /debug/pprof/")
+	fs.DurationVar(&cfg.ShutdownWait.Duration, "shutdown-timeout", cfg.ShutdownWait.Duration, "duration - wait for in-flight requests on shutdown")
+	fs.DurationVar(&cfg.SaveWait.Duration, "save-timeout", cfg.SaveWait.Duration, "duration - wait for metrics to be saved on shutdown, 0 - unlimited")
+	fs.DurationVar(&cfg.HeaderWait.Duration, "header-timeout", cfg.HeaderWait.Duration, "duration - read request headers timeout, 0 - unlimited")
+	fs.DurationVar(&cfg.ReadWait.Duration, "read-timeout", cfg.ReadWait.Duration, "duration - read request timeout, 0 - unlimited")
+	fs.DurationVar(&cfg.WriteWait.Duration, "write-timeout", cfg.WriteWait.Duration, "duration - write response timeout, 0 - unlimited")
+	fs.DurationVar(&cfg.IdleWait.Duration, "idle-timeout", cfg.IdleWait.Duration, "duration - keep-alive idle timeout, 0 - unlimited")
+	fs.StringVar(&cfg.NamePattern, "name-pattern", cfg.NamePattern, "string - regexp for metric names")
+	fs.IntVar(&cfg.NameMaxLen, "name-max-len", cfg.NameMaxLen, "int - max length of metric names")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "string - minimal log level: debug|info|warn|error")
+	fs.IntVar(&cfg.ImportBatch, "import-batch", cfg.ImportBatch, "int - metrics stored at once by /import")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "string - path to TLS certificate in PEM, enables HTTPS with -tls-key")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "string - path to TLS private key in PEM")
+	fs.StringVar(&cfg.TLSMinVersion, "tls-min-version", cfg.TLSMinVersion, "string - minimal TLS version: 1.0|1.1|1.2|1.3")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "string - path to CA certificates in PEM to verify client certificates")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", cfg.MaxBodyBytes, "int - max request body size in bytes, also after decompression")
+	fs.IntVar(&cfg.IdempotentMax, "idempotency-max", cfg.IdempotentMax, "int - number of remembered Idempotency-Key values, 0 - disabled")
+	fs.DurationVar(&cfg.IdempotentTTL.Duration, "idempotency-ttl", cfg.IdempotentTTL.Duration, "duration - how long Idempotency-Key values are remembered, 0 - disabled")
+	fs.BoolVar(&cfg.OptionalCType, "optional-content-type", cfg.OptionalCType, "bool - accept requests without Content-Type for URL path handlers")
+	fs.BoolVar(&cfg.SelfMonitor, "self-monitor", cfg.SelfMonitor, "bool - store server runtime metrics")
+	fs.DurationVar(&cfg.MonitorEvery.Duration, "monitor-interval", cfg.MonitorEvery.Duration, "duration - interval to collect server runtime metrics")
+	fs.StringVar(&cfg.OTELEndpoint, "otel-endpoint", cfg.OTELEndpoint, "string - OTLP/HTTP endpoint to export traces, empty - tracing disabled")
+	fs.StringVar(&cfg.HashAlgo, "hash-algo", cfg.HashAlgo, fmt.Sprint("string - sign hash algorithm: ",
+		metric.HashSHA256, "|", metric.HashSHA512))
+
+	return fs.Parse(args)
+}
+
+func (cfg *Config) readEnvVars() error {
+
+	// Чтение переменных среды
+	if err := env.Parse(cfg); err != nil {
+		return err
+	}
 
-		key, err := ioutil.ReadFile(cryptoPath)
+	// Убираем пробелы из адреса
+	cfg.Addr = strings.TrimSpace(cfg.Addr)
+
+	return nil
+}
+
+// prepare Чтение приватного ключа
+func (cfg *Config) prepare() error {
+
+	if len(cfg.CryptoKey) > 0 {
+
+		key, err := ioutil.ReadFile(cfg.CryptoKey)
 		if err != nil {
 			return err
 		}
@@ -117,11 +351,216 @@ func (cfg *Config) ParseFlags() error {
 		cfg.CryptoKey = string(key)
 	}
 
-	if addr == nil || *addr == "" {
-		*addr = cfg.Addr
+	return nil
+}
+
+// Validate Проверка конфигурации перед запуском сервера
+func (cfg Config) Validate() error {
+
+	if err := validateAddr(cfg.Addr); err != nil {
+		return fmt.Errorf("incorrect address %q: %w", cfg.Addr, err)
+	}
+
+	if strings.ContainsAny(cfg.BasePath, "?# \t") {
+		return fmt.Errorf("incorrect base path %q: must be a plain URL path", cfg.BasePath)
+	}
+
+	if cfg.StoreInterval.Duration < 0 {
+		return fmt.Errorf("incorrect store interval %s: must not be negative", cfg.StoreInterval)
+	}
+
+	storageCfg := storage.Config{
+		DatabaseDSN: cfg.DatabaseDSN,
+		StoreFile:   cfg.StoreFile,
+	}
+
+	if _, err := storageCfg.Kind(); err != nil {
+		return err
+	}
+
+	if !filestorage.ValidFormat(cfg.StoreFormat) {
+		return fmt.Errorf("incorrect store format %q: use %s or %s",
+			cfg.StoreFormat, filestorage.FormatJSONL, filestorage.FormatJSON)
+	}
+
+	for name, timeout := range map[string]Duration{
+		"header": cfg.HeaderWait,
+		"read":   cfg.ReadWait,
+		"write":  cfg.WriteWait,
+		"idle":   cfg.IdleWait,
+		"save":   cfg.SaveWait,
+	} {
+		if timeout.Duration < 0 {
+			return fmt.Errorf("incorrect %s timeout %s: must not be negative", name, timeout)
+		}
+	}
+
+	if cfg.DBMaxOpenConn < 0 {
+		return fmt.Errorf("incorrect max open database connections %d: must not be negative", cfg.DBMaxOpenConn)
+	}
+
+	if cfg.DBMaxIdleConn < 0 {
+		return fmt.Errorf("incorrect max idle database connections %d: must not be negative", cfg.DBMaxIdleConn)
+	}
+
+	if cfg.DBConnMaxLife.Duration < 0 {
+		return fmt.Errorf("incorrect database connection lifetime %s: must not be negative", cfg.DBConnMaxLife)
+	}
+
+	if cfg.ReadCache && cfg.ReadCacheTTL.Duration <= 0 {
+		return fmt.Errorf("incorrect read cache ttl %s: must be positive", cfg.ReadCacheTTL)
+	}
+
+	if cfg.StoreBackups < 0 {
+		return fmt.Errorf("incorrect store backups count %d: must not be negative", cfg.StoreBackups)
+	}
+
+	if cfg.ClampGauges && !(cfg.GaugeMin <= cfg.GaugeMax) {
+		return fmt.Errorf("incorrect gauge bounds [%v, %v]: min must not exceed max", cfg.GaugeMin, cfg.GaugeMax)
+	}
+
+	if cfg.CompactEvery.Duration < 0 {
+		return fmt.Errorf("incorrect compact interval %s: must not be negative", cfg.CompactEvery)
+	}
+
+	if len(cfg.StoreFile) != 0 {
+		if err := checkWritableDir(filepath.Dir(cfg.StoreFile)); err != nil {
+			return fmt.Errorf("store file %q: %w", cfg.StoreFile, err)
+		}
+	}
+
+	if cfg.SelfMonitor && cfg.MonitorEvery.Duration <= 0 {
+		return fmt.Errorf("incorrect self monitor interval %s: must be positive", cfg.MonitorEvery)
+	}
+
+	if cfg.MaxMetrics < 0 {
+		return fmt.Errorf("incorrect max metrics %d: must not be negative", cfg.MaxMetrics)
+	}
+
+	if !ValidCapacityPolicy(cfg.EvictPolicy) {
+		return fmt.Errorf("incorrect evict policy %q: use %s or %s", cfg.EvictPolicy, CapacityReject, CapacityEvict)
+	}
+
+	if cfg.TrackStats && cfg.StatsWindow <= 0 {
+		return fmt.Errorf("incorrect stats window %d: must be positive", cfg.StatsWindow)
+	}
+
+	if cfg.IdempotentMax < 0 {
+		return fmt.Errorf("incorrect idempotency keys count %d: must not be negative", cfg.IdempotentMax)
+	}
+
+	if cfg.IdempotentTTL.Duration < 0 {
+		return fmt.Errorf("incorrect idempotency keys ttl %s: must not be negative", cfg.IdempotentTTL)
+	}
+
+	if cfg.MaxBodyBytes <= 0 {
+		return fmt.Errorf("incorrect max body size %d: must be positive", cfg.MaxBodyBytes)
+	}
+
+	if cfg.ImportBatch <= 0 {
+		return fmt.Errorf("incorrect import batch size %d: must be positive", cfg.ImportBatch)
+	}
+
+	if _, err := metric.HashFunc(cfg.HashAlgo); err != nil {
+		return fmt.Errorf("incorrect hash algorithm %q: %w", cfg.HashAlgo, err)
+	}
+
+	if len(cfg.TrustedSubnet) != 0 {
+		if _, err := handler.ParseTrustedSubnet(cfg.TrustedSubnet); err != nil {
+			return err
+		}
+	}
+
+	if _, err := logpack.ParseLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+
+	if err := cfg.validateTLS(); err != nil {
+		return err
+	}
+
+	if len(cfg.OTELEndpoint) != 0 {
+		if _, err := tracing.NewExporter(cfg.OTELEndpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PreviousSignKeys Предыдущие ключи подписи из списка через запятую.
+// Пустые элементы списка пропускаются
+func (cfg Config) PreviousSignKeys() [][]byte {
+
+	keys := make([][]byte, 0)
+	for _, key := range strings.Split(cfg.PreviousKeys, ",") {
+		if key = strings.TrimSpace(key); len(key) != 0 {
+			keys = append(keys, []byte(key))
+		}
 	}
 
-	parsedAddr := strings.Split(*addr, ":")
+	return keys
+}
+
+// HTTPTimeouts Тайм-ауты HTTP сервера из конфигурации
+func (cfg Config) HTTPTimeouts() Timeouts {
+	return Timeouts{
+		ReadHeader: cfg.HeaderWait.Duration,
+		Read:       cfg.ReadWait.Duration,
+		Write:      cfg.WriteWait.Duration,
+		Idle:       cfg.IdleWait.Duration,
+	}
+}
+
+// validateTLS Проверка настроек TLS: сертификат и ключ задаются вместе и должны загружаться
+func (cfg Config) validateTLS() error {
+
+	if _, err := ParseTLSVersion(cfg.TLSMinVersion); err != nil {
+		return err
+	}
+
+	if len(cfg.TLSCertFile) == 0 && len(cfg.TLSKeyFile) == 0 {
+		if len(cfg.TLSClientCA) != 0 {
+			return fmt.Errorf("client CA is set, but TLS certificate and key are not")
+		}
+
+		return nil
+	}
+
+	if len(cfg.TLSCertFile) == 0 || len(cfg.TLSKeyFile) == 0 {
+		return fmt.Errorf("TLS certificate and key must be set together")
+	}
+
+	if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+
+	if _, err := NewTLSConfig(cfg.TLSMinVersion, cfg.TLSClientCA); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkWritableDir Проверка, что в директории можно создать файл
+func checkWritableDir(dir string) error {
+
+	file, err := os.CreateTemp(dir, ".write-check*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(file.Name())
+}
+
+// validateAddr Проверка адреса в формате host:port
+func validateAddr(addr string) error {
+
+	parsedAddr := strings.Split(addr, ":")
 	if len(parsedAddr) != 2 {
 		return fmt.Errorf("need address in a format host:port")
 	}
@@ -136,20 +575,6 @@ func (cfg *Config) ParseFlags() error {
 		return fmt.Errorf("incorrect port: " + parsedAddr[1])
 	}
 
-	cfg.Addr = *addr
-
-	if len(trustedSubnet) != 0 {
-		trustedSubnet = strings.ReplaceAll(trustedSubnet, " ", "")
-		ipSet := strings.Split(trustedSubnet, ",")
-		for _, ip := range ipSet {
-			if netIP := net.ParseIP(ip); netIP == nil {
-				return fmt.Errorf("incorrect subnet ip: " + ip)
-			}
-		}
-
-		cfg.TrustedSubnet = trustedSubnet
-	}
-
 	return nil
 }
 
@@ -160,27 +585,66 @@ func (cfg Config) String() string {
 	builder.WriteString("\n")
 	builder.WriteString(fmt.Sprintf("\t ADDRESS: %s\n", cfg.Addr))
 	builder.WriteString(fmt.Sprintf("\t ADDRESS RPC: %s\n", cfg.AddrRPC))
+	builder.WriteString(fmt.Sprintf("\t BASE_PATH: %s\n", cfg.BasePath))
 	builder.WriteString(fmt.Sprintf("\t STORE_INTERVAL: %s\n", cfg.StoreInterval.String()))
 	builder.WriteString(fmt.Sprintf("\t RESTORE: %v\n", cfg.Restore))
 	builder.WriteString(fmt.Sprintf("\t DATABASE_DSN: %s\n", cfg.DatabaseDSN))
+	builder.WriteString(fmt.Sprintf("\t DB_MAX_OPEN_CONN: %d\n", cfg.DBMaxOpenConn))
+	builder.WriteString(fmt.Sprintf("\t DB_MAX_IDLE_CONN: %d\n", cfg.DBMaxIdleConn))
+	builder.WriteString(fmt.Sprintf("\t DB_CONN_LIFETIME: %s\n", cfg.DBConnMaxLife.String()))
+	builder.WriteString(fmt.Sprintf("\t READ_CACHE: %v\n", cfg.ReadCache))
+	builder.WriteString(fmt.Sprintf("\t READ_CACHE_TTL: %s\n", cfg.ReadCacheTTL.String()))
 	builder.WriteString(fmt.Sprintf("\t STORE_FILE: %s\n", cfg.StoreFile))
+	builder.WriteString(fmt.Sprintf("\t STORE_FORMAT: %s\n", cfg.StoreFormat))
+	builder.WriteString(fmt.Sprintf("\t STORE_BACKUPS: %d\n", cfg.StoreBackups))
+	builder.WriteString(fmt.Sprintf("\t COMPACT_INTERVAL: %s\n", cfg.CompactEvery.String()))
 	builder.WriteString(fmt.Sprintf("\t KEY: %s\n", cfg.SecretKey))
 	builder.WriteString(fmt.Sprintf("\t TRUSTED_SUBNET: %s\n", cfg.TrustedSubnet))
+	builder.WriteString(fmt.Sprintf("\t COMPRESS_LEVEL: %d\n", cfg.CompressLevel))
+	builder.WriteString(fmt.Sprintf("\t COMPRESS_MIN: %d\n", cfg.CompressMin))
+	builder.WriteString(fmt.Sprintf("\t HASH_ALGO: %s\n", cfg.HashAlgo))
+	builder.WriteString(fmt.Sprintf("\t METRIC_TTL: %s\n", cfg.MetricTTL.String()))
+	builder.WriteString(fmt.Sprintf("\t NAME_PATTERN: %s\n", cfg.NamePattern))
+	builder.WriteString(fmt.Sprintf("\t SHUTDOWN_TIMEOUT: %s\n", cfg.ShutdownWait.String()))
+	builder.WriteString(fmt.Sprintf("\t SAVE_TIMEOUT: %s\n", cfg.SaveWait.String()))
+	builder.WriteString(fmt.Sprintf("\t HEADER_TIMEOUT: %s\n", cfg.HeaderWait.String()))
+	builder.WriteString(fmt.Sprintf("\t READ_TIMEOUT: %s\n", cfg.ReadWait.String()))
+	builder.WriteString(fmt.Sprintf("\t WRITE_TIMEOUT: %s\n", cfg.WriteWait.String()))
+	builder.WriteString(fmt.Sprintf("\t IDLE_TIMEOUT: %s\n", cfg.IdleWait.String()))
+	builder.WriteString(fmt.Sprintf("\t ENABLE_PROFILING: %v\n", cfg.Profiling))
+	builder.WriteString(fmt.Sprintf("\t RATE_LIMIT: %v\n", cfg.RateLimit))
+	builder.WriteString(fmt.Sprintf("\t RATE_BURST: %d\n", cfg.RateBurst))
+	builder.WriteString(fmt.Sprintf("\t NAME_MAX_LEN: %d\n", cfg.NameMaxLen))
+	builder.WriteString(fmt.Sprintf("\t TTL_COUNTERS: %v\n", cfg.TTLCounters))
+	builder.WriteString(fmt.Sprintf("\t CLAMP_GAUGES: %v\n", cfg.ClampGauges))
+	builder.WriteString(fmt.Sprintf("\t GAUGE_MIN: %v\n", cfg.GaugeMin))
+	builder.WriteString(fmt.Sprintf("\t GAUGE_MAX: %v\n", cfg.GaugeMax))
+	builder.WriteString(fmt.Sprintf("\t REJECT_NEG_DELTA: %v\n", cfg.NoNegCounters))
+	builder.WriteString(fmt.Sprintf("\t MAX_METRICS: %d\n", cfg.MaxMetrics))
+	builder.WriteString(fmt.Sprintf("\t EVICT_POLICY: %s\n", cfg.EvictPolicy))
+	builder.WriteString(fmt.Sprintf("\t EVICT_COUNTERS: %v\n", cfg.EvictCounters))
+	builder.WriteString(fmt.Sprintf("\t TRACK_STATS: %v\n", cfg.TrackStats))
+	builder.WriteString(fmt.Sprintf("\t STATS_WINDOW: %d\n", cfg.StatsWindow))
+	builder.WriteString(fmt.Sprintf("\t LOG_LEVEL: %s\n", cfg.LogLevel))
+	builder.WriteString(fmt.Sprintf("\t OTEL_ENDPOINT: %s\n", cfg.OTELEndpoint))
+	builder.WriteString(fmt.Sprintf("\t IMPORT_BATCH: %d\n", cfg.ImportBatch))
+	builder.WriteString(fmt.Sprintf("\t TLS_CERT_FILE: %s\n", cfg.TLSCertFile))
+	builder.WriteString(fmt.Sprintf("\t TLS_MIN_VERSION: %s\n", cfg.TLSMinVersion))
+	builder.WriteString(fmt.Sprintf("\t TLS_CLIENT_CA: %s\n", cfg.TLSClientCA))
+	builder.WriteString(fmt.Sprintf("\t MAX_BODY_BYTES: %d\n", cfg.MaxBodyBytes))
+	builder.WriteString(fmt.Sprintf("\t IDEMPOTENCY_MAX: %d\n", cfg.IdempotentMax))
+	builder.WriteString(fmt.Sprintf("\t IDEMPOTENCY_TTL: %s\n", cfg.IdempotentTTL.String()))
+	builder.WriteString(fmt.Sprintf("\t OPTIONAL_CTYPE: %v\n", cfg.OptionalCType))
+	builder.WriteString(fmt.Sprintf("\t SELF_MONITOR: %v\n", cfg.SelfMonitor))
+	builder.WriteString(fmt.Sprintf("\t MONITOR_INTERVAL: %s\n", cfg.MonitorEvery.String()))
 
 	if len(cfg.CryptoKey) != 0 {
 		builder.WriteString("\t CRYPTO_KEY: USE\n")
 	}
 
-	return builder.String()
-}
-
-func (cfg *Config) ReadEnvVars() {
-
-	// Чтение переменных среды
-	if err := env.Parse(cfg); err != nil {
-		log.Println(err)
+	if len(cfg.PreviousSignKeys()) != 0 {
+		builder.WriteString("\t PREVIOUS_KEYS: USE\n")
 	}
 
-	// Убираем пробелы из адреса
-	cfg.Addr = strings.TrimSpace(cfg.Addr)
+	return builder.String()
 }
@@ -0,0 +1,534 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    func(cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			want: func(cfg *Config) {},
+		},
+		{
+			name: "Flags",
+			args: []string{"-a", "127.0.0.1:9090", "-i", "30s", "-f", "/tmp/metrics.json", "-r=false", "-k", "flag-key", "-d", "postgres://flag"},
+			want: func(cfg *Config) {
+				cfg.Addr = "127.0.0.1:9090"
+				cfg.StoreInterval.Duration = 30 * time.Second
+				cfg.StoreFile = "/tmp/metrics.json"
+				cfg.Restore = false
+				cfg.SecretKey = "flag-key"
+				cfg.DatabaseDSN = "postgres://flag"
+			},
+		},
+		{
+			name: "Env",
+			env: map[string]string{
+				"ADDRESS":        " localhost:7070 ",
+				"STORE_INTERVAL": "1m30s",
+				"STORE_FILE":     "/tmp/env.json",
+				"RESTORE":        "false",
+				"KEY":            "env-key",
+				"DATABASE_DSN":   "postgres://env",
+			},
+			want: func(cfg *Config) {
+				cfg.Addr = "localhost:7070"
+				cfg.StoreInterval.Duration = 90 * time.Second
+				cfg.StoreFile = "/tmp/env.json"
+				cfg.Restore = false
+				cfg.SecretKey = "env-key"
+				cfg.DatabaseDSN = "postgres://env"
+			},
+		},
+		{
+			name: "Env overrides flags",
+			args: []string{"-a", ":9090", "-i", "30s", "-k", "flag-key"},
+			env: map[string]string{
+				"ADDRESS":        ":7070",
+				"STORE_INTERVAL": "5s",
+			},
+			want: func(cfg *Config) {
+				cfg.Addr = ":7070"
+				cfg.StoreInterval.Duration = 5 * time.Second
+				cfg.SecretKey = "flag-key"
+			},
+		},
+		{
+			name: "Database pool",
+			args: []string{"-db-max-open-conns", "20", "-db-conn-max-lifetime", "5m"},
+			env:  map[string]string{"DB_MAX_IDLE_CONN": "5"},
+			want: func(cfg *Config) {
+				cfg.DBMaxOpenConn = 20
+				cfg.DBMaxIdleConn = 5
+				cfg.DBConnMaxLife.Duration = 5 * time.Minute
+			},
+		},
+		{
+			name: "Previous keys",
+			args: []string{"-k", "new-key"},
+			env:  map[string]string{"PREVIOUS_KEYS": "old-key, older-key,"},
+			want: func(cfg *Config) {
+				cfg.SecretKey = "new-key"
+				cfg.PreviousKeys = "old-key, older-key,"
+			},
+		},
+		{
+			name:    "Malformed env duration",
+			env:     map[string]string{"STORE_INTERVAL": "10"},
+			wantErr: true,
+		},
+		{
+			name:    "Malformed flag duration",
+			args:    []string{"-i", "ten"},
+			wantErr: true,
+		},
+		{
+			name:    "Malformed env bool",
+			env:     map[string]string{"RESTORE": "maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown flag",
+			args:    []string{"-unknown"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := load(tt.args)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			want := DefaultConfig()
+			tt.want(want)
+			assert.Equal(t, want, cfg)
+		})
+	}
+}
+
+func TestConfig_PreviousSignKeys(t *testing.T) {
+
+	cfg := DefaultConfig()
+	require.Empty(t, cfg.PreviousSignKeys())
+
+	cfg.PreviousKeys = "old-key, older-key,"
+	require.Equal(t, [][]byte{[]byte("old-key"), []byte("older-key")}, cfg.PreviousSignKeys())
+}
+
+// TestConfig_StringPreviousKeys Предыдущие ключи подписи не выводятся вместе с конфигурацией
+func TestConfig_StringPreviousKeys(t *testing.T) {
+
+	cfg := DefaultConfig()
+	require.NotContains(t, cfg.String(), "PREVIOUS_KEYS")
+
+	cfg.PreviousKeys = "old-key, older-key,"
+	require.Contains(t, cfg.String(), "PREVIOUS_KEYS: USE")
+	require.NotContains(t, cfg.String(), "old-key")
+}
+
+func TestLoadConfigFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+		"address": "localhost:8081",
+		"store_interval": "20s",
+		"store_file": "/tmp/file.json",
+		"restore": false,
+		"unknown_key": 1
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want func(cfg *Config)
+	}{
+		{
+			name: "File overrides defaults",
+			args: []string{"-config", path},
+			want: func(cfg *Config) {},
+		},
+		{
+			name: "Flag overrides file",
+			args: []string{"-c", path, "-a", ":9090", "-r"},
+			want: func(cfg *Config) {
+				cfg.Addr = ":9090"
+				cfg.Restore = true
+			},
+		},
+		{
+			name: "Env overrides file and flags",
+			args: []string{"-config", path, "-i", "30s"},
+			env:  map[string]string{"STORE_INTERVAL": "40s"},
+			want: func(cfg *Config) {
+				cfg.StoreInterval.Duration = 40 * time.Second
+			},
+		},
+		{
+			name: "Path from env",
+			env:  map[string]string{EnvConfig: path},
+			want: func(cfg *Config) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := load(tt.args)
+			require.NoError(t, err)
+
+			want := DefaultConfig()
+			want.ConfigFile = path
+			want.Addr = "localhost:8081"
+			want.StoreInterval.Duration = 20 * time.Second
+			want.StoreFile = "/tmp/file.json"
+			want.Restore = false
+			tt.want(want)
+
+			assert.Equal(t, want, cfg)
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+
+	dir := t.TempDir()
+
+	malformed := filepath.Join(dir, "malformed.json")
+	require.NoError(t, os.WriteFile(malformed, []byte(`{"store_interval": 10}`), 0644))
+
+	_, err := load([]string{"-config", malformed})
+	assert.Error(t, err)
+
+	_, err = load([]string{"-config", filepath.Join(dir, "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+
+	dir := t.TempDir()
+
+	readOnly := filepath.Join(dir, "read-only")
+	require.NoError(t, os.Mkdir(readOnly, 0555))
+
+	ca := newTestCert(t, dir, "ca", nil, true)
+	serverCert := newTestCert(t, dir, "server", ca, false)
+
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "Valid config",
+			modify: func(cfg *Config) {
+				cfg.Addr = "127.0.0.1:8080"
+				cfg.StoreFile = filepath.Join(dir, "metrics.json")
+				cfg.StoreInterval.Duration = 0
+			},
+		},
+		{
+			name: "Valid config with database",
+			modify: func(cfg *Config) {
+				cfg.DatabaseDSN = "postgres://localhost/metrics"
+			},
+		},
+		{
+			name:    "Malformed address",
+			modify:  func(cfg *Config) { cfg.Addr = "localhost" },
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect ip",
+			modify:  func(cfg *Config) { cfg.Addr = "host:8080" },
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect port",
+			modify:  func(cfg *Config) { cfg.Addr = ":http" },
+			wantErr: true,
+		},
+		{
+			name:    "Negative store interval",
+			modify:  func(cfg *Config) { cfg.StoreInterval.Duration = -time.Second },
+			wantErr: true,
+		},
+		{
+			name:    "Missing store file directory",
+			modify:  func(cfg *Config) { cfg.StoreFile = filepath.Join(dir, "missing", "metrics.json") },
+			wantErr: true,
+		},
+		{
+			name:    "Store file directory is not writable",
+			modify:  func(cfg *Config) { cfg.StoreFile = filepath.Join(readOnly, "metrics.json") },
+			wantErr: os.Getuid() != 0,
+		},
+		{
+			name: "Store file and database",
+			modify: func(cfg *Config) {
+				cfg.StoreFile = filepath.Join(dir, "metrics.json")
+				cfg.DatabaseDSN = "postgres://localhost/metrics"
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown store format",
+			modify:  func(cfg *Config) { cfg.StoreFormat = "yaml" },
+			wantErr: true,
+		},
+		{
+			name: "Database pool limits",
+			modify: func(cfg *Config) {
+				cfg.DatabaseDSN = "postgres://localhost/metrics"
+				cfg.DBMaxOpenConn = 10
+				cfg.DBMaxIdleConn = 0
+				cfg.DBConnMaxLife.Duration = time.Minute
+			},
+		},
+		{
+			name:    "Negative max open database connections",
+			modify:  func(cfg *Config) { cfg.DBMaxOpenConn = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "Negative max idle database connections",
+			modify:  func(cfg *Config) { cfg.DBMaxIdleConn = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "Negative database connection lifetime",
+			modify:  func(cfg *Config) { cfg.DBConnMaxLife.Duration = -time.Second },
+			wantErr: true,
+		},
+		{
+			name:    "Negative store backups",
+			modify:  func(cfg *Config) { cfg.StoreBackups = -1 },
+			wantErr: true,
+		},
+		{
+			name: "Gauge bounds",
+			modify: func(cfg *Config) {
+				cfg.ClampGauges = true
+				cfg.GaugeMin = -10
+				cfg.GaugeMax = 10
+			},
+		},
+		{
+			name: "Inverted gauge bounds",
+			modify: func(cfg *Config) {
+				cfg.ClampGauges = true
+				cfg.GaugeMin = 10
+				cfg.GaugeMax = -10
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Negative compact interval",
+			modify:  func(cfg *Config) { cfg.CompactEvery.Duration = -time.Second },
+			wantErr: true,
+		},
+		{
+			name:    "Negative write timeout",
+			modify:  func(cfg *Config) { cfg.WriteWait.Duration = -time.Second },
+			wantErr: true,
+		},
+		{
+			name: "Zero timeouts",
+			modify: func(cfg *Config) {
+				cfg.HeaderWait.Duration = 0
+				cfg.ReadWait.Duration = 0
+				cfg.WriteWait.Duration = 0
+				cfg.IdleWait.Duration = 0
+				cfg.SaveWait.Duration = 0
+			},
+		},
+		{
+			name:    "Negative save timeout",
+			modify:  func(cfg *Config) { cfg.SaveWait.Duration = -time.Second },
+			wantErr: true,
+		},
+		{
+			name: "Read cache",
+			modify: func(cfg *Config) {
+				cfg.ReadCache = true
+				cfg.ReadCacheTTL.Duration = 500 * time.Millisecond
+			},
+		},
+		{
+			name: "Zero read cache ttl",
+			modify: func(cfg *Config) {
+				cfg.ReadCache = true
+				cfg.ReadCacheTTL.Duration = 0
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Base path",
+			modify: func(cfg *Config) { cfg.BasePath = "/metrics-server/" },
+		},
+		{
+			name:    "Base path with query",
+			modify:  func(cfg *Config) { cfg.BasePath = "/metrics?x=1" },
+			wantErr: true,
+		},
+		{
+			name:    "Unknown log level",
+			modify:  func(cfg *Config) { cfg.LogLevel = "verbose" },
+			wantErr: true,
+		},
+		{
+			name: "Valid TLS config",
+			modify: func(cfg *Config) {
+				cfg.TLSCertFile = serverCert.certFile
+				cfg.TLSKeyFile = serverCert.keyFile
+				cfg.TLSClientCA = ca.certFile
+				cfg.TLSMinVersion = "1.3"
+			},
+		},
+		{
+			name:    "TLS certificate without key",
+			modify:  func(cfg *Config) { cfg.TLSCertFile = serverCert.certFile },
+			wantErr: true,
+		},
+		{
+			name: "Missing TLS certificate",
+			modify: func(cfg *Config) {
+				cfg.TLSCertFile = filepath.Join(dir, "missing.crt")
+				cfg.TLSKeyFile = filepath.Join(dir, "missing.key")
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Client CA without TLS",
+			modify:  func(cfg *Config) { cfg.TLSClientCA = ca.certFile },
+			wantErr: true,
+		},
+		{
+			name:    "Unknown TLS version",
+			modify:  func(cfg *Config) { cfg.TLSMinVersion = "2.0" },
+			wantErr: true,
+		},
+		{
+			name: "Self monitor",
+			modify: func(cfg *Config) {
+				cfg.SelfMonitor = true
+				cfg.MonitorEvery.Duration = time.Second
+			},
+		},
+		{
+			name: "Zero self monitor interval",
+			modify: func(cfg *Config) {
+				cfg.SelfMonitor = true
+				cfg.MonitorEvery.Duration = 0
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Zero max body size",
+			modify:  func(cfg *Config) { cfg.MaxBodyBytes = 0 },
+			wantErr: true,
+		},
+		{
+			name: "Max metrics with eviction",
+			modify: func(cfg *Config) {
+				cfg.MaxMetrics = 1000
+				cfg.EvictPolicy = CapacityEvict
+			},
+		},
+		{
+			name:    "Negative max metrics",
+			modify:  func(cfg *Config) { cfg.MaxMetrics = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "Unknown evict policy",
+			modify:  func(cfg *Config) { cfg.EvictPolicy = "random" },
+			wantErr: true,
+		},
+		{
+			name: "Gauge stats",
+			modify: func(cfg *Config) {
+				cfg.TrackStats = true
+				cfg.StatsWindow = 10
+			},
+		},
+		{
+			name: "Gauge stats with zero window",
+			modify: func(cfg *Config) {
+				cfg.TrackStats = true
+				cfg.StatsWindow = 0
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Negative idempotency keys count",
+			modify:  func(cfg *Config) { cfg.IdempotentMax = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "Zero import batch size",
+			modify:  func(cfg *Config) { cfg.ImportBatch = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect OTLP endpoint",
+			modify:  func(cfg *Config) { cfg.OTELEndpoint = "ftp://collector:4318" },
+			wantErr: true,
+		},
+		{
+			name:    "Unknown hash algorithm",
+			modify:  func(cfg *Config) { cfg.HashAlgo = "md5" },
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect trusted subnet",
+			modify:  func(cfg *Config) { cfg.TrustedSubnet = "10.0.0.0/33" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -2,13 +2,18 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 
+	"metrics-and-alerting/pkg/errs"
 	metricPkg "metrics-and-alerting/pkg/metric"
 	pb "metrics-and-alerting/proto"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -63,7 +68,7 @@ func (serv *MetricsServiceRPC) UpsertGauge(ctx context.Context, in *pb.UpsertGau
 		return res, err
 	}
 
-	return res, serv.m.Upsert(metric)
+	return res, serv.m.Upsert(ctx, metric)
 }
 
 func (serv *MetricsServiceRPC) UpsertCounter(ctx context.Context, in *pb.UpsertCounterRequest) (*emptypb.Empty, error) {
@@ -80,5 +85,111 @@ func (serv *MetricsServiceRPC) UpsertCounter(ctx context.Context, in *pb.UpsertC
 		return res, err
 	}
 
-	return res, serv.m.Upsert(metric)
+	return res, serv.m.Upsert(ctx, metric)
+}
+
+// Update Обновление метрик из потока. Ответ отправляется после закрытия потока клиентом
+func (serv *MetricsServiceRPC) Update(stream pb.Metrics_UpdateServer) error {
+
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&emptypb.Empty{})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := serv.m.Upsert(stream.Context(), metricFromProto(in)); err != nil {
+			return statusError(err)
+		}
+	}
+}
+
+// UpdateBatch Обновление набора метрик. Подпись проверяется для каждой метрики набора
+func (serv *MetricsServiceRPC) UpdateBatch(ctx context.Context, in *pb.UpdateBatchRequest) (*emptypb.Empty, error) {
+
+	metrics := make([]metricPkg.Metric, 0, len(in.Metrics))
+	for _, m := range in.Metrics {
+		metrics = append(metrics, metricFromProto(m))
+	}
+
+	if err := serv.m.UpsertBatch(ctx, metrics); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// GetMetric Получение метрики по типу, ID и меткам
+func (serv *MetricsServiceRPC) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.Metric, error) {
+
+	metric, err := metricPkg.CreateMetric(in.Type, in.Id, metricPkg.WithLabels(in.Labels))
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	found, err := serv.m.Get(ctx, metric)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return metricToProto(found), nil
+}
+
+// metricFromProto Преобразование метрики gRPC в метрику
+func metricFromProto(in *pb.Metric) metricPkg.Metric {
+
+	metric := metricPkg.Metric{
+		ID:    in.Id,
+		MType: in.Type,
+		Delta: in.Delta,
+		Value: in.Value,
+		Hash:  in.Hash,
+	}
+
+	if len(in.Labels) != 0 {
+		metric.Labels = in.Labels
+	}
+
+	return metric
+}
+
+// metricToProto Преобразование метрики в метрику gRPC
+func metricToProto(metric metricPkg.Metric) *pb.Metric {
+
+	return &pb.Metric{
+		Id:     metric.ID,
+		Type:   metric.MType,
+		Delta:  metric.Delta,
+		Value:  metric.Value,
+		Hash:   metric.Hash,
+		Labels: metric.Labels,
+	}
+}
+
+// statusError Преобразование ошибки метрики в ошибку gRPC с соответствующим кодом
+func statusError(err error) error {
+
+	code := codes.Internal
+
+	var storeErr errs.ErrStorage
+	if errors.As(err, &storeErr) {
+		switch storeErr {
+		case errs.ErrNotFound:
+			code = codes.NotFound
+
+		case errs.ErrUnknownType:
+			code = codes.Unimplemented
+
+		case errs.ErrTypeMismatch:
+			code = codes.AlreadyExists
+
+		case errs.ErrInvalidID, errs.ErrInvalidType, errs.ErrInvalidValue, errs.ErrInvalidJSON, errs.ErrSignFailed:
+			code = codes.InvalidArgument
+		}
+	}
+
+	return status.Error(code, err.Error())
 }
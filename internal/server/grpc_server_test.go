@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+	pb "metrics-and-alerting/proto"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// newTestGRPCClient Клиент gRPC сервера с хранилищем в памяти и подписью метрик
+func newTestGRPCClient(t *testing.T) pb.MetricsClient {
+
+	manager := New(memstore.New(), logpack.NewLogger(), WithSignKey([]byte(signKey)))
+	t.Cleanup(manager.cancel)
+
+	serv, err := NewGRPCServer("127.0.0.1:0", manager)
+	require.NoError(t, err)
+
+	serv.Start()
+	t.Cleanup(serv.Stop)
+
+	conn, err := grpc.Dial(serv.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+
+	ctx := context.Background()
+	client := newTestGRPCClient(t)
+
+	t.Run("Update stream -> OK", func(t *testing.T) {
+		stream, err := client.Update(ctx)
+		require.NoError(t, err)
+
+		for _, delta := range []float64{1, 2, 3} {
+			m := signedMetric(t, metricPkg.CounterType, "streamCounter", delta)
+			require.NoError(t, stream.Send(metricToProto(m)))
+		}
+
+		_, err = stream.CloseAndRecv()
+		require.NoError(t, err)
+
+		got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "streamCounter", Type: metricPkg.CounterType})
+		require.NoError(t, err)
+		require.Equal(t, int64(6), got.GetDelta())
+	})
+
+	t.Run("Update batch -> OK", func(t *testing.T) {
+		batch := &pb.UpdateBatchRequest{
+			Metrics: []*pb.Metric{
+				metricToProto(signedMetric(t, metricPkg.GaugeType, "batchGauge", 1.5)),
+				metricToProto(signedMetric(t, metricPkg.CounterType, "batchCounter", 4)),
+			},
+		}
+
+		_, err := client.UpdateBatch(ctx, batch)
+		require.NoError(t, err)
+
+		got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "batchGauge", Type: metricPkg.GaugeType})
+		require.NoError(t, err)
+		require.Equal(t, 1.5, got.GetValue())
+
+		want := signedMetric(t, metricPkg.GaugeType, "batchGauge", 1.5)
+		require.Equal(t, want.Hash, got.GetHash())
+	})
+
+	t.Run("Update batch with invalid sign -> INVALID ARGUMENT", func(t *testing.T) {
+		m := signedMetric(t, metricPkg.GaugeType, "badGauge", 1.5)
+		m.Hash = "invalid"
+
+		_, err := client.UpdateBatch(ctx, &pb.UpdateBatchRequest{Metrics: []*pb.Metric{metricToProto(m)}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "badGauge", Type: metricPkg.GaugeType})
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
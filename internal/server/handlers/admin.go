@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+type (
+	// Saver Немедленное сохранение метрик хранилищем с количеством сохраненных метрик
+	Saver interface {
+		Save(ctx context.Context) (int, error)
+	}
+
+	// renameRequest Запрос переименования метрики: метрика {id, type, labels} и ее новый ID
+	renameRequest struct {
+		ID     string           `json:"id"`
+		MType  string           `json:"type"`
+		Labels metricPkg.Labels `json:"labels,omitempty"`
+		NewID  string           `json:"new_id"`
+	}
+
+	// flushResult Результат сохранения метрик по запросу /admin/flush
+	flushResult struct {
+		Metrics  int    `json:"metrics"`
+		Duration string `json:"duration"`
+	}
+)
+
+// AdminTrust Middleware Административные запросы принимаются только из доверенных подсетей.
+// В отличие от Trust, если доверенные подсети не заданы, запросы отклоняются
+func (h Handler) AdminTrust(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if len(h.trustedSubnet) == 0 {
+			h.logger.Warn.Printf("admin request %s rejected: trusted subnet is not configured\n", r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		h.Trust(next).ServeHTTP(w, r)
+	})
+}
+
+// AdminFlush Немедленное сохранение метрик без ожидания интервала сохранения.
+// Возвращается количество сохраненных метрик и длительность сохранения в формате JSON
+func (h Handler) AdminFlush() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		count, err := h.save(r.Context())
+		duration := time.Since(start)
+
+		if err != nil {
+			h.logger.Err.Printf("could not flush metrics by admin request: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info.Printf("flushed %d metrics by admin request in %s\n", count, duration)
+
+		encode, errEncode := json.Marshal(flushResult{Metrics: count, Duration: duration.String()})
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode flush result to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// save Сохранение метрик хранилищем. Если хранилище не поддерживает Saver,
+// вызывается Flush, а количество метрик определяется отдельно
+func (h Handler) save(ctx context.Context) (int, error) {
+
+	if saver, ok := h.store.(Saver); ok {
+		return saver.Save(ctx)
+	}
+
+	metrics, err := h.store.GetBatch(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(metrics), h.store.Flush(ctx)
+}
+
+// AdminRename Переименование метрики по JSON запросу {id, type, labels, new_id}.
+// Тип, метки и значение метрики сохраняются. Возвращается переименованная метрика.
+// Если метрика с новым ID уже существует, возвращается 409, если исходной метрики нет - 404
+func (h Handler) AdminRename() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !hasContentType(r, ApplicationJSON) {
+			h.logger.Err.Printf("request with unsupported Content-Type: %s\n", r.Header.Get(ContentType))
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				h.logger.Err.Printf("error close body in handler AdminRename: %v\n", err)
+			}
+		}()
+
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
+
+		data, errBody := io.ReadAll(reader)
+		if errBody != nil {
+			h.logger.Err.Printf("error read body: %v\n", errBody)
+			http.Error(w, errBody.Error(), bodyErrorStatus(errBody))
+			return
+		}
+
+		var request renameRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			h.logger.Err.Printf("error decode body to JSON: %v\n", err)
+			http.Error(w, errs.ErrInvalidJSON.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !knownType(request.MType) {
+			h.logger.Warn.Printf("rename metric with unknown type %q\n", request.MType)
+			http.Error(w, errs.ErrUnknownType.Error(), http.StatusBadRequest)
+			return
+		}
+
+		metric := metricPkg.Metric{ID: request.ID, MType: request.MType, Labels: request.Labels}
+		if err := h.store.Rename(r.Context(), metric, request.NewID); err != nil {
+			h.logger.Err.Printf("could not rename metric %s: %v\n", metric.Key(), err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		metric.ID = request.NewID
+		h.logger.Info.Printf("metric %s renamed to %s by admin request\n", request.ID, metric.Key())
+
+		renamed, errGet := h.store.Get(r.Context(), metric)
+		if errGet != nil {
+			h.logger.Err.Printf("could not get renamed metric from storage: %v\n", errGet)
+			http.Error(w, errGet.Error(), errs.ErrorHTTP(errGet))
+			return
+		}
+
+		encode, errEncode := json.Marshal(&renamed)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode metric to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// responseMarshal Кодирование ответа с метриками по заголовку Accept: MessagePack для application/msgpack,
+// иначе JSON. Устанавливается Content-Type ответа. Подпись метрики не зависит от кодирования
+func responseMarshal(w http.ResponseWriter, r *http.Request) func(v interface{}) ([]byte, error) {
+
+	w.Header().Add(Vary, Accept)
+
+	if acceptsMediaType(r, ApplicationMsgpack) {
+		w.Header().Set(ContentType, ApplicationMsgpack)
+		return metricPkg.EncodeMsgpack
+	}
+
+	w.Header().Set(ContentType, ApplicationJSON)
+	return json.Marshal
+}
+
+// hasMetricsContentType Проверка, что тело запроса с метриками в формате JSON или MessagePack
+func hasMetricsContentType(r *http.Request) bool {
+	return hasContentType(r, ApplicationJSON) || hasContentType(r, ApplicationMsgpack)
+}
+
+// decodeMetric Разбор метрики из тела запроса в формате из заголовка Content-Type
+func decodeMetric(r *http.Request, data []byte) (metricPkg.Metric, error) {
+
+	if hasContentType(r, ApplicationMsgpack) {
+		return metricPkg.DecodeMsgpack(data)
+	}
+
+	return metricPkg.DecodeJSON(data)
+}
+
+// decodeMetrics Разбор набора метрик из тела запроса в формате из заголовка Content-Type
+func decodeMetrics(r *http.Request, data []byte) ([]metricPkg.Metric, error) {
+
+	if hasContentType(r, ApplicationMsgpack) {
+		return metricPkg.DecodeMsgpackBatch(data)
+	}
+
+	return metricPkg.DecodeJSONBatch(data)
+}
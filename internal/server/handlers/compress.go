@@ -0,0 +1,445 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Brotli   = "br"
+	Zstd     = "zstd"
+	Identity = "identity"
+
+	// DefaultCompressMinSize Минимальный размер ответа, при котором он сжимается
+	DefaultCompressMinSize = 1400
+
+	// maxEncodings Максимальное количество последовательных сжатий тела запроса
+	maxEncodings = 2
+
+	// zstdMaxWindow Максимальный размер окна zstd при распаковке тела запроса.
+	// Ограничивает память, которую может потребовать сжатое тело
+	zstdMaxWindow = 8 << 20
+)
+
+var (
+	// ErrUnsupportedEncoding Тело запроса сжато неизвестным алгоритмом
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	// ErrTooManyEncodings Тело запроса сжато больше maxEncodings раз
+	ErrTooManyEncodings = errors.New("too many content encodings")
+)
+
+// compressedTypes Типы содержимого, которые уже сжаты и не требуют повторного сжатия
+var compressedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+}
+
+type (
+	// encoding Поддерживаемый алгоритм сжатия.
+	// Алгоритм без newWriter используется только для распаковки тела запроса
+	encoding struct {
+		newWriter func(w io.Writer) io.WriteCloser
+		newReader func(r io.Reader) (io.ReadCloser, error)
+	}
+
+	// decodedBody Распакованное тело запроса.
+	// Закрытие освобождает все слои распаковки и исходное тело
+	decodedBody struct {
+		io.Reader
+		closers []io.Closer
+	}
+
+	// compressWriter Сжатие ответа.
+	// Решение о сжатии принимается после накопления minSize байт ответа
+	compressWriter struct {
+		http.ResponseWriter
+		name    string
+		open    func(w io.Writer) (io.WriteCloser, func())
+		minSize int
+		buf     []byte
+		status  int
+		decided bool
+		writer  io.Writer
+		release func()
+	}
+)
+
+// encodings Поддерживаемые алгоритмы сжатия
+var encodings = map[string]encoding{
+	GZip: {
+		newWriter: func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	Brotli: {
+		newWriter: func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+	Zstd: {
+		newWriter: func(w io.Writer) io.WriteCloser {
+			// Ошибка возможна только при некорректных опциях
+			writer, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+			return writer
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			reader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			if err != nil {
+				return nil, err
+			}
+
+			return reader.IOReadCloser(), nil
+		},
+	},
+	Snappy: {
+		newReader: newSnappyReader,
+	},
+}
+
+// encodingPriority Порядок выбора алгоритма сжатия при одинаковом весе в Accept-Encoding
+var encodingPriority = []string{Brotli, Zstd, GZip}
+
+// newGzipPool Пул gzip writer с заданным уровнем сжатия
+func newGzipPool(level int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			writer, _ := gzip.NewWriterLevel(io.Discard, level)
+			return writer
+		},
+	}
+}
+
+// isCompressedType Проверка, что тип содержимого уже сжат
+func isCompressedType(contentType string) bool {
+
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range compressedTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.status = statusCode
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		return w.writer.Write(b)
+	}
+
+	if isCompressedType(w.Header().Get(ContentType)) {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+
+		return w.writer.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// decide Выбор между сжатием ответа и передачей без изменений с записью накопленных данных
+func (w *compressWriter) decide(compress bool) error {
+
+	w.decided = true
+	w.writer = w.ResponseWriter
+
+	if compress {
+		var writer io.WriteCloser
+
+		writer, w.release = w.open(w.ResponseWriter)
+		w.writer = writer
+
+		w.Header().Del("Content-Length")
+		w.Header().Set(ContentEncoding, w.name)
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	_, err := w.writer.Write(w.buf)
+	w.buf = nil
+
+	return err
+}
+
+// Flush Отправка сжатых данных клиенту.
+// Пока решение о сжатии не принято, данные остаются в буфере
+func (w *compressWriter) Flush() {
+
+	if !w.decided {
+		return
+	}
+
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack Передача соединения обработчику, например для WebSocket.
+// Ответ после этого не сжимается
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	w.decided = true
+	w.writer = w.ResponseWriter
+
+	return hijacker.Hijack()
+}
+
+// Close Запись оставшихся данных и завершение сжатого потока
+func (w *compressWriter) Close() error {
+
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+
+	closer, ok := w.writer.(io.WriteCloser)
+	if !ok || w.release == nil {
+		return nil
+	}
+
+	defer w.release()
+	return closer.Close()
+}
+
+// negotiateEncoding Выбор алгоритма сжатия ответа по заголовку Accept-Encoding с учетом весов q.
+// Если ни один из поддерживаемых алгоритмов не подходит, возвращается Identity
+func negotiateEncoding(header string) string {
+
+	weights := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(name) == 0 {
+			continue
+		}
+
+		weight := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err != nil {
+				q = 0
+			}
+
+			weight = q
+		}
+
+		weights[name] = weight
+	}
+
+	best, bestWeight := Identity, 0.0
+
+	for _, name := range encodingPriority {
+
+		weight, ok := weights[name]
+		if !ok {
+			weight, ok = weights["*"]
+		}
+
+		if ok && weight > bestWeight {
+			best, bestWeight = name, weight
+		}
+	}
+
+	return best
+}
+
+// Compress Middleware Сжатие ответа алгоритмом, выбранным по заголовку Accept-Encoding,
+// и распаковка тела запроса, сжатого одним или несколькими поддерживаемыми алгоритмами.
+// Если алгоритм сжатия тела неизвестен или сжатий больше maxEncodings, возвращается 415.
+// Размер распакованного тела не может превышать максимальный размер тела запроса
+func (h Handler) Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if len(r.Header.Values(ContentEncoding)) != 0 {
+
+			reader, err := BodyReader(r)
+			if err != nil {
+				h.logger.Err.Printf("could not decompress request body: %v\n", err)
+				http.Error(w, err.Error(), bodyErrorStatus(err))
+				return
+			}
+
+			// Размер распакованного тела ограничивается так же, как и сжатого
+			r.Body = limitBody(reader, h.maxBodyBytes)
+			r.Header.Del(ContentEncoding)
+		}
+
+		w.Header().Add(Vary, AcceptEncoding)
+
+		name := negotiateEncoding(r.Header.Get(AcceptEncoding))
+		if name == Identity {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			name:           name,
+			open:           h.encodingWriter(name),
+			minSize:        h.compressMinSize,
+		}
+
+		defer func() {
+			if err := cw.Close(); err != nil {
+				h.logger.Err.Printf("error close %s writer: %v\n", name, err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// encodingWriter Создание writer для алгоритма сжатия.
+// Возвращаемая функция освобождает writer после закрытия
+func (h Handler) encodingWriter(name string) func(w io.Writer) (io.WriteCloser, func()) {
+	return func(w io.Writer) (io.WriteCloser, func()) {
+
+		if name == GZip {
+			gz := h.gzipPool.Get().(*gzip.Writer)
+			gz.Reset(w)
+
+			return gz, func() { h.gzipPool.Put(gz) }
+		}
+
+		return encodings[name].newWriter(w), func() {}
+	}
+}
+
+// BodyReader Тело запроса с распаковкой, если оно сжато.
+// Алгоритмы из заголовка Content-Encoding применяются в обратном порядке
+func BodyReader(r *http.Request) (io.ReadCloser, error) {
+
+	names, err := contentEncodings(r.Header.Values(ContentEncoding))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return r.Body, nil
+	}
+
+	body := &decodedBody{Reader: r.Body, closers: []io.Closer{r.Body}}
+
+	for i := len(names) - 1; i >= 0; i-- {
+
+		reader, err := encodings[names[i]].newReader(body.Reader)
+		if err != nil {
+			_ = body.Close()
+			return nil, err
+		}
+
+		body.Reader = reader
+		body.closers = append(body.closers, reader)
+	}
+
+	return body, nil
+}
+
+// contentEncodings Алгоритмы сжатия тела запроса в порядке применения.
+// Значения заголовка могут содержать несколько алгоритмов через запятую, identity пропускается
+func contentEncodings(values []string) ([]string, error) {
+
+	names := make([]string, 0, len(values))
+
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+
+			name = strings.ToLower(strings.TrimSpace(name))
+			if len(name) == 0 || name == Identity {
+				continue
+			}
+
+			if _, ok := encodings[name]; !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, name)
+			}
+
+			names = append(names, name)
+		}
+	}
+
+	if len(names) > maxEncodings {
+		return nil, fmt.Errorf("%w: %d, maximum %d", ErrTooManyEncodings, len(names), maxEncodings)
+	}
+
+	return names, nil
+}
+
+// Close Закрытие слоев распаковки от внешнего к внутреннему
+func (body *decodedBody) Close() error {
+
+	var errClose error
+	for i := len(body.closers) - 1; i >= 0; i-- {
+		if err := body.closers[i].Close(); err != nil && errClose == nil {
+			errClose = err
+		}
+	}
+
+	return errClose
+}
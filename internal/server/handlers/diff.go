@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+type (
+	// metricRef Ссылка на метрику в результате сравнения
+	metricRef struct {
+		ID     string           `json:"id"`
+		MType  string           `json:"type"`
+		Labels metricPkg.Labels `json:"labels,omitempty"`
+	}
+
+	// metricDiff Различие значений метрики в хранилище и в снимке.
+	// Для gauge и float_counter заполняется Delta, для counter - Difference
+	metricDiff struct {
+		metricRef
+		Current    string   `json:"current"`
+		Snapshot   string   `json:"snapshot"`
+		Delta      *float64 `json:"delta,omitempty"`
+		Difference *int64   `json:"difference,omitempty"`
+	}
+
+	// snapshotDiff Результат сравнения хранилища со снимком.
+	// Added - метрики, которых нет в снимке, Removed - метрики снимка, которых нет в хранилище
+	snapshotDiff struct {
+		Changed   []metricDiff `json:"changed"`
+		Unchanged int          `json:"unchanged"`
+		Added     []metricRef  `json:"added"`
+		Removed   []metricRef  `json:"removed"`
+	}
+)
+
+// Diff Сравнение хранилища со снимком в формате NDJSON, в котором метрики выгружает Export.
+// Тело запроса может быть сжато. Для метрик, значение которых изменилось, возвращается
+// текущее значение, значение из снимка и разница между ними, а также списки добавленных
+// и удаленных с момента снимка метрик. Снимок с ошибками в строках не сравнивается
+func (h Handler) Diff() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !hasContentType(r, ApplicationNDJSON) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				h.logger.Err.Printf("error close body in handler Diff: %v\n", err)
+			}
+		}()
+
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
+
+		snapshot, errSnapshot := readSnapshot(reader)
+		if errSnapshot != nil {
+			h.logger.Warn.Printf("could not read snapshot: %v\n", errSnapshot)
+			http.Error(w, errSnapshot.Error(), bodyErrorStatus(errSnapshot))
+			return
+		}
+
+		current, err := h.store.GetBatch(r.Context())
+		if err != nil {
+			h.logger.Err.Printf("could not get all metrics from storage: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		encode, errEncode := json.Marshal(diffMetrics(current, snapshot))
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode diff to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// readSnapshot Чтение снимка метрик в формате NDJSON.
+// Повтор метрики в снимке заменяет ее предыдущее значение
+func readSnapshot(reader io.Reader) ([]metricPkg.Metric, error) {
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxImportLine)
+
+	metrics := make([]metricPkg.Metric, 0)
+	known := make(map[string]int)
+
+	for line := 1; scanner.Scan(); line++ {
+
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var metric metricPkg.Metric
+		if err := json.Unmarshal(data, &metric); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if err := metric.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if idx, ok := known[metric.Key()]; ok {
+			metrics[idx] = metric
+			continue
+		}
+
+		known[metric.Key()] = len(metrics)
+		metrics = append(metrics, metric)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// diffMetrics Сравнение метрик хранилища с метриками снимка.
+// Результаты упорядочены по ключу метрики
+func diffMetrics(current, snapshot []metricPkg.Metric) snapshotDiff {
+
+	diff := snapshotDiff{
+		Changed: make([]metricDiff, 0),
+		Added:   make([]metricRef, 0),
+		Removed: make([]metricRef, 0),
+	}
+
+	sort.Slice(current, func(i, j int) bool { return current[i].Key() < current[j].Key() })
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Key() < snapshot[j].Key() })
+
+	inSnapshot := make(map[string]metricPkg.Metric, len(snapshot))
+	for _, metric := range snapshot {
+		inSnapshot[metric.Key()] = metric
+	}
+
+	inCurrent := make(map[string]struct{}, len(current))
+
+	for _, metric := range current {
+		inCurrent[metric.Key()] = struct{}{}
+
+		old, ok := inSnapshot[metric.Key()]
+		if !ok {
+			diff.Added = append(diff.Added, newMetricRef(metric))
+			continue
+		}
+
+		if metric.StringValue() == old.StringValue() {
+			diff.Unchanged++
+			continue
+		}
+
+		diff.Changed = append(diff.Changed, newMetricDiff(metric, old))
+	}
+
+	for _, metric := range snapshot {
+		if _, ok := inCurrent[metric.Key()]; !ok {
+			diff.Removed = append(diff.Removed, newMetricRef(metric))
+		}
+	}
+
+	return diff
+}
+
+// newMetricRef Ссылка на метрику
+func newMetricRef(metric metricPkg.Metric) metricRef {
+	return metricRef{
+		ID:     metric.ID,
+		MType:  metric.MType,
+		Labels: metric.Labels,
+	}
+}
+
+// newMetricDiff Различие текущего значения метрики и значения из снимка
+func newMetricDiff(current, snapshot metricPkg.Metric) metricDiff {
+
+	diff := metricDiff{
+		metricRef: newMetricRef(current),
+		Current:   current.StringValue(),
+		Snapshot:  snapshot.StringValue(),
+	}
+
+	switch current.MType {
+	case metricPkg.GaugeType, metricPkg.FloatCounterType:
+		if current.Value != nil && snapshot.Value != nil {
+			delta := *current.Value - *snapshot.Value
+			diff.Delta = &delta
+		}
+
+	case metricPkg.CounterType:
+		if current.Delta != nil && snapshot.Delta != nil {
+			difference := *current.Delta - *snapshot.Delta
+			diff.Difference = &difference
+		}
+	}
+
+	return diff
+}
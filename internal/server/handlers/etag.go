@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+const (
+	ETag        = "ETag"
+	IfNoneMatch = "If-None-Match"
+)
+
+// metricETag Слабый ETag метрики, вычисляемый по ее ключу и значению
+func metricETag(metric metricPkg.Metric) string {
+
+	h := fnv.New64a()
+	h.Write([]byte(metric.Key()))
+	h.Write([]byte{0})
+	h.Write([]byte(metric.StringValue()))
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// notModified Признак того, что ETag из If-None-Match совпадает с etag.
+// Сравнение слабое: префикс W/ не учитывается
+func notModified(r *http.Request, etag string) bool {
+
+	header := r.Header.Get(IfNoneMatch)
+	if len(header) == 0 {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
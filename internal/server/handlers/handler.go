@@ -1,16 +1,20 @@
 package handler
 
 import (
+	"bytes"
 	"compress/gzip"
 	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 
+	"metrics-and-alerting/internal/pubsub"
 	"metrics-and-alerting/internal/storage"
 	"metrics-and-alerting/pkg/logpack"
 )
@@ -24,47 +28,71 @@ const (
 	partsUpdateURL = 3
 )
 
+// DefaultImportBatchSize Количество метрик, записываемых в хранилище за раз при загрузке
+const DefaultImportBatchSize = 1000
+
 const (
 	XRealIP         = "X-Real-IP"
+	XRequestID      = "X-Request-Id"
+	XTenantID       = "X-Tenant-Id"
 	ContentType     = "Content-Type"
 	ContentEncoding = "Content-Encoding"
 	AcceptEncoding  = "Accept-Encoding"
-
-	TextPlain       = "text/plain"
-	TextHTML        = "text/html"
-	ApplicationJSON = "application/json"
-	GZip            = "gzip"
+	Accept          = "Accept"
+	Vary            = "Vary"
+
+	TextPlain              = "text/plain"
+	TextHTML               = "text/html"
+	ApplicationJSON        = "application/json"
+	ApplicationNDJSON      = "application/x-ndjson"
+	ApplicationMetricsJSON = "application/vnd.metrics+json"
+	ApplicationMsgpack     = "application/msgpack"
+	GZip                   = "gzip"
 )
 
 type (
 	OptionsHandler func(*Handler)
 
 	Handler struct {
-		store         storage.Repository
-		logger        *logpack.LogPack
-		privateKey    *rsa.PrivateKey
-		trustedSubnet []string
-	}
-
-	gzipWriter struct {
-		http.ResponseWriter
-		Writer io.Writer
+		store           storage.Repository
+		logger          *logpack.LogPack
+		privateKey      *rsa.PrivateKey
+		trustedSubnet   []*net.IPNet
+		gzipPool        *sync.Pool
+		compressMinSize int
+		rateLimiter     *rateLimiter
+		importBatchSize int
+		requests        RequestCounter
+		maxBodyBytes    int64
+		storageKind     string
+		idempotency     *idempotencyCache
+		broker          *pubsub.Broker
+		optionalCType   bool
 	}
 )
 
 func New(store storage.Repository, logger *logpack.LogPack, opts ...OptionsHandler) *Handler {
 	h := &Handler{
-		store:  store,
-		logger: logger,
+		store:           store,
+		logger:          logger,
+		compressMinSize: DefaultCompressMinSize,
+		importBatchSize: DefaultImportBatchSize,
+		maxBodyBytes:    DefaultMaxBodyBytes,
+		idempotency:     newIdempotencyCache(DefaultIdempotencySize, DefaultIdempotencyTTL),
 	}
 
 	for _, opt := range opts {
 		opt(h)
 	}
 
+	if h.gzipPool == nil {
+		h.gzipPool = newGzipPool(gzip.DefaultCompression)
+	}
+
 	return h
 }
 
+// WithKey Закрытый RSA ключ в формате PEM (PKCS#1 или PKCS#8) для расшифровки тела запросов
 func WithKey(key string) OptionsHandler {
 	return func(h *Handler) {
 
@@ -78,9 +106,9 @@ func WithKey(key string) OptionsHandler {
 			return
 		}
 
-		privateKey, errParse := x509.ParsePKCS1PrivateKey(block.Bytes)
+		privateKey, errParse := parsePrivateKey(block.Bytes)
 		if errParse != nil {
-			h.logger.Err.Printf("failed parse private key: %v\n", privateKey)
+			h.logger.Err.Printf("failed parse private key: %v\n", errParse)
 			return
 		}
 
@@ -88,24 +116,131 @@ func WithKey(key string) OptionsHandler {
 	}
 }
 
+// parsePrivateKey Разбор закрытого RSA ключа в формате PKCS#1 или PKCS#8
+func parsePrivateKey(der []byte) (*rsa.PrivateKey, error) {
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return privateKey, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not RSA")
+	}
+
+	return privateKey, nil
+}
+
+// WithCompressLevel Уровень сжатия gzip.
+// При недопустимом значении используется уровень по умолчанию
+func WithCompressLevel(level int) OptionsHandler {
+	return func(h *Handler) {
+
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			h.logger.Err.Printf("invalid gzip compression level %d, using default\n", level)
+			level = gzip.DefaultCompression
+		}
+
+		h.gzipPool = newGzipPool(level)
+	}
+}
+
+// WithCompressMinSize Минимальный размер ответа в байтах, при котором он сжимается
+func WithCompressMinSize(size int) OptionsHandler {
+	return func(h *Handler) {
+		if size >= 0 {
+			h.compressMinSize = size
+		}
+	}
+}
+
+// WithImportBatchSize Количество метрик, записываемых в хранилище за раз при загрузке
+func WithImportBatchSize(size int) OptionsHandler {
+	return func(h *Handler) {
+		if size > 0 {
+			h.importBatchSize = size
+		}
+	}
+}
+
+// WithTrustedSubnet Список подсетей в формате CIDR через запятую, от которых принимаются запросы.
+// Отдельный IP адрес считается подсетью из одного адреса
 func WithTrustedSubnet(subnet string) OptionsHandler {
 	return func(h *Handler) {
 
-		if len(subnet) == 0 {
+		subnets, err := ParseTrustedSubnet(subnet)
+		if err != nil {
+			h.logger.Err.Printf("failed parse trusted subnet: %v\n", err)
 			return
 		}
 
-		subnet = strings.TrimSpace(subnet)
-		h.trustedSubnet = strings.Split(subnet, ",")
+		h.trustedSubnet = subnets
 	}
 }
 
-func (w gzipWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+// ParseTrustedSubnet Разбор списка подсетей в формате CIDR или IP адресов через запятую
+func ParseTrustedSubnet(subnet string) ([]*net.IPNet, error) {
+
+	subnet = strings.TrimSpace(subnet)
+	if len(subnet) == 0 {
+		return nil, nil
+	}
+
+	parts := strings.Split(subnet, ",")
+	subnets := make([]*net.IPNet, 0, len(parts))
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("incorrect subnet ip: %s", part)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect subnet: %w", err)
+		}
+
+		subnets = append(subnets, ipNet)
+	}
+
+	return subnets, nil
 }
 
-// Trust Middleware Проверяет, находится ли IP адрес клиента в списке IP адресов, от которых принимаются запросы.
-// Если такого скиска нет, то запросы обрабатываются от любого IP адреса.
+// ClientIP IP адрес клиента из заголовка X-Real-IP, а при его отсутствии - из адреса соединения.
+// Возвращает nil, если адрес не удалось разобрать
+func ClientIP(r *http.Request) net.IP {
+
+	if realIP := strings.TrimSpace(r.Header.Get(XRealIP)); len(realIP) != 0 {
+		return net.ParseIP(realIP)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// Trust Middleware Проверяет, находится ли IP адрес клиента в одной из доверенных подсетей.
+// Если доверенные подсети не заданы, то запросы обрабатываются от любого IP адреса.
 func (h Handler) Trust(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if len(h.trustedSubnet) == 0 {
@@ -113,10 +248,15 @@ func (h Handler) Trust(next http.Handler) http.Handler {
 			return
 		}
 
-		clientIP := r.Header.Get(XRealIP)
+		clientIP := ClientIP(r)
+		if clientIP == nil {
+			h.logger.Err.Printf("request from unknown ip: %s\n", r.Header.Get(XRealIP))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 
-		for _, ip := range h.trustedSubnet {
-			if ip == clientIP {
+		for _, subnet := range h.trustedSubnet {
+			if subnet.Contains(clientIP) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -126,26 +266,39 @@ func (h Handler) Trust(next http.Handler) http.Handler {
 	})
 }
 
-func (h Handler) DecompressRequest(next http.Handler) http.Handler {
+// RSADecrypt Middleware Расшифровка тела запроса закрытым ключом сервера.
+// Если ключ не задан, запрос передается без изменений
+func (h Handler) RSADecrypt(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !strings.Contains(r.Header.Get(AcceptEncoding), GZip) {
+		if h.privateKey == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		writer := gzip.NewWriter(w)
-		defer func() {
-			if err := writer.Close(); err != nil {
-				log.Printf("error close gzip writer: %v\n", err)
-			}
-		}()
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
 
-		w.Header().Set(ContentEncoding, GZip)
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: writer}, r)
+		data, err := h.Decrypt(reader)
+		if err != nil {
+			h.logger.Err.Printf("could not decrypt request body: %v\n", err)
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.ContentLength = int64(len(data))
+		r.Header.Del(ContentEncoding)
+
+		next.ServeHTTP(w, r)
 	})
 }
 
+// Decrypt Чтение и расшифровка данных закрытым ключом сервера
 func (h Handler) Decrypt(r io.ReadCloser) ([]byte, error) {
 
 	data, errRead := io.ReadAll(r)
@@ -155,7 +308,7 @@ func (h Handler) Decrypt(r io.ReadCloser) ([]byte, error) {
 		}
 	}()
 
-	if h.privateKey == nil {
+	if errRead != nil || h.privateKey == nil {
 		return data, errRead
 	}
 
@@ -179,13 +332,3 @@ func (h Handler) Decrypt(r io.ReadCloser) ([]byte, error) {
 
 	return decryptedBytes, nil
 }
-
-func BodyReader(r *http.Request) (io.ReadCloser, error) {
-
-	switch r.Header.Get(ContentEncoding) {
-	case GZip:
-		return gzip.NewReader(r.Body)
-	}
-
-	return r.Body, nil
-}
@@ -2,36 +2,53 @@ package handler
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	cryptoRand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"metrics-and-alerting/internal/agent/services/reporter"
 	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/internal/tracing"
 	"metrics-and-alerting/pkg/logpack"
 	metricPkg "metrics-and-alerting/pkg/metric"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const (
 	signKey = "KeySignMetric"
 )
 
+// random Генератор случайных значений метрик, инициализируется один раз
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
 func randFloat64() *float64 {
-	rand.Seed(time.Now().UnixNano())
-	val := rand.Float64()
+	val := random.Float64()
 	return &val
 }
 
 func randInt64() *int64 {
-	rand.Seed(time.Now().UnixNano())
-	val := rand.Int63()
+	val := random.Int63()
 	return &val
 }
 
@@ -90,6 +107,35 @@ func TestTrustedIP(t *testing.T) {
 			handler:    New(memstore.New(), logger),
 			wantStatus: http.StatusOK,
 		},
+		{
+			name:       "Success request: SERVER with trusted subnet, CLIENT in subnet",
+			handler:    New(memstore.New(), logger, WithTrustedSubnet("192.168.1.0/24")),
+			realIP:     "192.168.1.77",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Error request: SERVER with trusted subnet, CLIENT out of subnet",
+			handler:    New(memstore.New(), logger, WithTrustedSubnet("192.168.1.0/24")),
+			realIP:     "10.0.0.1",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Success request: SERVER with trusted subnet, CLIENT without X-Real-IP from subnet address",
+			handler:    New(memstore.New(), logger, WithTrustedSubnet("192.0.2.0/24")),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Success request: SERVER with trusted IPv6 subnet, CLIENT in subnet",
+			handler:    New(memstore.New(), logger, WithTrustedSubnet("2001:db8::/32")),
+			realIP:     "2001:db8::1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Error request: SERVER with trusted subnet, CLIENT with malformed X-Real-IP",
+			handler:    New(memstore.New(), logger, WithTrustedSubnet("192.168.1.0/24")),
+			realIP:     "192.168.1.not-ip",
+			wantStatus: http.StatusForbidden,
+		},
 	}
 
 	for _, tt := range tests {
@@ -101,7 +147,7 @@ func TestTrustedIP(t *testing.T) {
 				Delta: randInt64(),
 			}
 
-			errUpsert := tt.handler.store.Upsert(metric)
+			errUpsert := tt.handler.store.Upsert(context.Background(), metric)
 			require.NoError(t, errUpsert)
 
 			nextHandler := tt.handler.GetAsText()
@@ -141,8 +187,8 @@ func TestGetJSON(t *testing.T) {
 	require.NoError(t, errSign)
 	counterMetric.Hash = signCounter
 
-	require.NoError(t, st.Upsert(gaugeMetric))
-	require.NoError(t, st.Upsert(counterMetric))
+	require.NoError(t, st.Upsert(context.Background(), gaugeMetric))
+	require.NoError(t, st.Upsert(context.Background(), counterMetric))
 
 	tests := []struct {
 		name          string
@@ -261,7 +307,7 @@ func TestGetJSON(t *testing.T) {
 			encode, _ := json.Marshal(tt.requestMetric)
 
 			nextHandler := handlers.GetAsJSON()
-			middleware := handlers.DecompressRequest(nextHandler)
+			middleware := handlers.Compress(nextHandler)
 
 			request := httptest.NewRequest(tt.method, "/value/", bytes.NewReader(encode))
 
@@ -303,10 +349,10 @@ func TestGetMetric(t *testing.T) {
 	st := memstore.New()
 	handlers := New(st, logger)
 
-	errUpsert := st.Upsert(gauge)
+	errUpsert := st.Upsert(context.Background(), gauge)
 	require.NoError(t, errUpsert)
 
-	errUpsert = st.Upsert(counter)
+	errUpsert = st.Upsert(context.Background(), counter)
 	require.NoError(t, errUpsert)
 
 	type metricData struct {
@@ -452,6 +498,51 @@ func TestGetMetric(t *testing.T) {
 	}
 }
 
+// TestGetMetricETag Повторный запрос метрики с тем же ETag возвращает 304,
+// пока значение метрики не изменится
+func TestGetMetricETag(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/value/gauge/testGauge", nil)
+		if len(etag) != 0 {
+			request.Header.Set(IfNoneMatch, etag)
+		}
+
+		w := httptest.NewRecorder()
+		handlers.GetAsText().ServeHTTP(w, request)
+		return w
+	}
+
+	first := get("")
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "1.5", first.Body.String())
+
+	etag := first.Header().Get(ETag)
+	require.True(t, strings.HasPrefix(etag, `W/"`), etag)
+
+	cached := get(etag)
+	assert.Equal(t, http.StatusNotModified, cached.Code)
+	assert.Empty(t, cached.Body.String())
+	assert.Equal(t, etag, cached.Header().Get(ETag))
+
+	assert.Equal(t, http.StatusNotModified, get(`"other", `+strings.TrimPrefix(etag, "W/")).Code)
+	assert.Equal(t, http.StatusOK, get(`W/"other"`).Code)
+
+	gauge, _ = metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(2.5))
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	updated := get(etag)
+	require.Equal(t, http.StatusOK, updated.Code)
+	assert.Equal(t, "2.5", updated.Body.String())
+	assert.NotEqual(t, etag, updated.Header().Get(ETag))
+}
+
 func TestUpdateMetricURL(t *testing.T) {
 
 	logger := logpack.NewLogger()
@@ -553,9 +644,1873 @@ func TestUpdateMetricURL(t *testing.T) {
 				require.Equal(t, tt.contentType, response.Header.Get("Content-Type"))
 
 				metric, _ := metricPkg.CreateMetric(tt.metric.MType, tt.metric.ID)
-				_, err := memoryStorage.Get(metric)
+				_, err := memoryStorage.Get(context.Background(), metric)
 				assert.NoError(t, err)
 			}
 		})
 	}
 }
+
+func TestNegotiateEncoding(t *testing.T) {
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: Identity},
+		{header: "gzip", want: GZip},
+		{header: "gzip, br", want: Brotli},
+		{header: "gzip;q=1.0, br;q=0.5", want: GZip},
+		{header: "br;q=0, gzip;q=0.1", want: GZip},
+		{header: "deflate, identity", want: Identity},
+		{header: "*", want: Brotli},
+		{header: "*;q=0.5, br;q=0", want: Zstd},
+		{header: "gzip, zstd", want: Zstd},
+		{header: "zstd;q=0.5, gzip", want: GZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.header))
+		})
+	}
+}
+
+// TestCompressBrotli Тело запроса сжато brotli, ответ ожидается в сжатом brotli виде
+func TestCompressBrotli(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger(), WithCompressMinSize(0))
+
+	gauge := NewGaugeMetric()
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	encode, err := json.Marshal(metricPkg.Metric{ID: gauge.ID, MType: gauge.MType})
+	require.NoError(t, err)
+
+	body := bytes.Buffer{}
+	writer := brotli.NewWriter(&body)
+	_, err = writer.Write(encode)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	request := httptest.NewRequest(http.MethodPost, "/value/", &body)
+	request.Header.Set(ContentType, ApplicationJSON)
+	request.Header.Set(ContentEncoding, Brotli)
+	request.Header.Set(AcceptEncoding, "gzip;q=0.5, br")
+
+	w := httptest.NewRecorder()
+	handlers.Compress(handlers.GetAsJSON()).ServeHTTP(w, request)
+
+	response := w.Result()
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, Brotli, response.Header.Get(ContentEncoding))
+
+	data, err := io.ReadAll(brotli.NewReader(response.Body))
+	require.NoError(t, err)
+
+	var metric metricPkg.Metric
+	require.NoError(t, json.Unmarshal(data, &metric))
+	assert.Equal(t, gauge, metric)
+}
+
+// TestCompressZstd Тело запроса сжато brotli, ответ ожидается в сжатом zstd виде
+func TestCompressZstd(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger(), WithCompressMinSize(0))
+
+	gauge := NewGaugeMetric()
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	encode, err := json.Marshal(metricPkg.Metric{ID: gauge.ID, MType: gauge.MType})
+	require.NoError(t, err)
+
+	body := bytes.Buffer{}
+	writer := brotli.NewWriter(&body)
+	_, err = writer.Write(encode)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	request := httptest.NewRequest(http.MethodPost, "/value/", &body)
+	request.Header.Set(ContentType, ApplicationJSON)
+	request.Header.Set(ContentEncoding, Brotli)
+	request.Header.Set(AcceptEncoding, "gzip;q=0.5, zstd")
+
+	w := httptest.NewRecorder()
+	handlers.Compress(handlers.GetAsJSON()).ServeHTTP(w, request)
+
+	response := w.Result()
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, Zstd, response.Header.Get(ContentEncoding))
+
+	reader, err := zstd.NewReader(response.Body)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	var metric metricPkg.Metric
+	require.NoError(t, json.Unmarshal(data, &metric))
+	assert.Equal(t, gauge, metric)
+}
+
+// TestCompressLevel Ответ должен сжиматься с уровнем, заданным в конфигурации
+func TestCompressLevel(t *testing.T) {
+
+	payload := bytes.Repeat([]byte("metrics-and-alerting "), 1000)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(payload)
+		require.NoError(t, err)
+	})
+
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression} {
+		t.Run(fmt.Sprintf("level %d", level), func(t *testing.T) {
+
+			handlers := New(memstore.New(), logpack.NewLogger(), WithCompressLevel(level))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set(AcceptEncoding, GZip)
+
+			w := httptest.NewRecorder()
+			handlers.Compress(next).ServeHTTP(w, request)
+
+			want := bytes.Buffer{}
+			writer, err := gzip.NewWriterLevel(&want, level)
+			require.NoError(t, err)
+			_, err = writer.Write(payload)
+			require.NoError(t, err)
+			require.NoError(t, writer.Close())
+
+			assert.Equal(t, want.Bytes(), w.Body.Bytes())
+		})
+	}
+}
+
+// TestCompressMinSize Маленькие и уже сжатые ответы передаются без сжатия
+func TestCompressMinSize(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		contentType  string
+		size         int
+		wantEncoding string
+	}{
+		{
+			name:        "Small JSON response -> IDENTITY",
+			contentType: ApplicationJSON,
+			size:        100,
+		},
+		{
+			name:         "Large JSON response -> GZIP",
+			contentType:  ApplicationJSON,
+			size:         10 * DefaultCompressMinSize,
+			wantEncoding: GZip,
+		},
+		{
+			name:        "Large image response -> IDENTITY",
+			contentType: "image/png",
+			size:        10 * DefaultCompressMinSize,
+		},
+	}
+
+	handlers := New(memstore.New(), logpack.NewLogger())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			payload := bytes.Repeat([]byte("a"), tt.size)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(ContentType, tt.contentType)
+				w.WriteHeader(http.StatusCreated)
+				_, err := w.Write(payload)
+				require.NoError(t, err)
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set(AcceptEncoding, GZip)
+
+			w := httptest.NewRecorder()
+			handlers.Compress(next).ServeHTTP(w, request)
+
+			response := w.Result()
+			defer response.Body.Close()
+
+			require.Equal(t, http.StatusCreated, response.StatusCode)
+			require.Equal(t, tt.wantEncoding, response.Header.Get(ContentEncoding))
+
+			var body io.Reader = response.Body
+			if tt.wantEncoding == GZip {
+				reader, err := gzip.NewReader(response.Body)
+				require.NoError(t, err)
+				body = reader
+			}
+
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, payload, data)
+		})
+	}
+}
+
+func TestDeleteMetric(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		opts        []OptionsHandler
+		wantCode    int
+	}{
+		{
+			name:        "Delete existing gauge -> OK",
+			target:      "/value/gauge/testGauge",
+			contentType: TextPlain,
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Delete gauge with query string -> OK",
+			target:      "/value/gauge/testGauge?source=ui",
+			contentType: TextPlain,
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Delete missing gauge -> NOT FOUND",
+			target:      "/value/gauge/unknownGauge",
+			contentType: TextPlain,
+			wantCode:    http.StatusNotFound,
+		},
+		{
+			name:        "Delete metric with unknown type -> NOT FOUND",
+			target:      "/value/histogram/testGauge",
+			contentType: TextPlain,
+			wantCode:    http.StatusNotFound,
+		},
+		{
+			name:        "Delete without content-type -> ERROR",
+			target:      "/value/gauge/testGauge",
+			contentType: "",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Delete without content-type when it is optional -> OK",
+			target:      "/value/gauge/testGauge",
+			contentType: "",
+			opts:        []OptionsHandler{WithOptionalContentType(true)},
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Delete with mismatched content-type when it is optional -> ERROR",
+			target:      "/value/gauge/testGauge",
+			contentType: ApplicationJSON,
+			opts:        []OptionsHandler{WithOptionalContentType(true)},
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			st := memstore.New()
+			handlers := New(st, logger, tt.opts...)
+
+			gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+			require.NoError(t, st.Upsert(context.Background(), gauge))
+
+			request := httptest.NewRequest(http.MethodDelete, tt.target, nil)
+			request.Header.Set(ContentType, tt.contentType)
+
+			w := httptest.NewRecorder()
+			handlers.DeleteMetric().ServeHTTP(w, request)
+
+			response := w.Result()
+			defer response.Body.Close()
+
+			require.Equal(t, tt.wantCode, response.StatusCode)
+
+			_, err := st.Get(context.Background(), gauge)
+			if tt.wantCode == http.StatusOK {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	t.Run("Metrics page with known gauge -> OK", func(t *testing.T) {
+		st := memstore.New()
+		handlers := New(st, logger)
+
+		gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(100.023))
+		// Имя в обход CreateMetric, чтобы проверить экранирование в шаблоне
+		delta := int64(1)
+		counter := metricPkg.Metric{ID: "<script>", MType: metricPkg.CounterType, Delta: &delta}
+		require.NoError(t, st.Upsert(context.Background(), gauge))
+		require.NoError(t, st.Upsert(context.Background(), counter))
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handlers.GetMetrics().ServeHTTP(w, request)
+
+		response := w.Result()
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, TextHTML, response.Header.Get(ContentType))
+
+		data, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		page := string(data)
+		assert.Contains(t, page, "<tr><td>testGauge</td><td>gauge</td><td>100.023</td></tr>")
+		assert.Contains(t, page, "&lt;script&gt;")
+		assert.NotContains(t, page, "<script>")
+		assert.Less(t, strings.Index(page, "counter"), strings.Index(page, "gauge"))
+	})
+
+	t.Run("Metrics with same id and different labels -> OK", func(t *testing.T) {
+		st := memstore.New()
+		handlers := New(st, logger)
+
+		for _, host := range []string{"b", "a"} {
+			gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1),
+				metricPkg.WithLabels(metricPkg.Labels{"host": host}))
+			require.NoError(t, st.Upsert(context.Background(), gauge))
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handlers.GetMetrics().ServeHTTP(w, request)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		page := w.Body.String()
+		rowA := "<td>testGauge{host=&#34;a&#34;}</td>"
+		rowB := "<td>testGauge{host=&#34;b&#34;}</td>"
+		require.Contains(t, page, rowA)
+		require.Contains(t, page, rowB)
+		assert.Less(t, strings.Index(page, rowA), strings.Index(page, rowB))
+	})
+
+	t.Run("Metrics page without metrics -> OK", func(t *testing.T) {
+		handlers := New(memstore.New(), logger)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handlers.GetMetrics().ServeHTTP(w, request)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "No metrics")
+	})
+}
+
+// TestRSADecrypt Тело запроса, зашифрованное открытым ключом агента, расшифровывается до обработчика
+func TestRSADecrypt(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	privateKey, err := rsa.GenerateKey(cryptoRand.Reader, 2048)
+	require.NoError(t, err)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	report := reporter.NewReporter("", nil, logger, reporter.WithKey(publicPEM))
+
+	st := memstore.New()
+	handlers := New(st, logger, WithKey(string(privatePEM)))
+	require.NotNil(t, handlers.privateKey)
+
+	gauge := NewGaugeMetric()
+	data, err := json.Marshal(gauge)
+	require.NoError(t, err)
+
+	encrypted, err := report.Encrypt(data)
+	require.NoError(t, err)
+	require.NotEqual(t, data, encrypted)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		wantCode int
+	}{
+		{
+			name:     "Encrypted body -> OK",
+			body:     encrypted,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Not encrypted body -> BAD REQUEST",
+			body:     data,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(tt.body))
+			request.Header.Set(ContentType, ApplicationJSON)
+
+			w := httptest.NewRecorder()
+			handlers.RSADecrypt(handlers.UpdateJSON()).ServeHTTP(w, request)
+
+			require.Equal(t, tt.wantCode, w.Code)
+
+			if tt.wantCode == http.StatusOK {
+				stored, err := st.Get(context.Background(), gauge)
+				require.NoError(t, err)
+				assert.Equal(t, gauge, stored)
+			}
+		})
+	}
+}
+
+// TestUpdateHistogram Наблюдения гистограммы, отправленные через URL и JSON, учитываются в интервалах
+func TestUpdateHistogram(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	histogram, err := metricPkg.CreateMetric(metricPkg.HistogramType, "latency",
+		metricPkg.WithBuckets([]float64{0.1, 1}),
+		metricPkg.WithValueFloat(0.05))
+	require.NoError(t, err)
+
+	body, err := json.Marshal(histogram)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
+	request.Header.Set(ContentType, ApplicationJSON)
+	w := httptest.NewRecorder()
+	handlers.UpdateJSON().ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, value := range []string{"0.5", "3"} {
+		request := httptest.NewRequest(http.MethodPost, "/update/histogram/latency/"+value, nil)
+		w := httptest.NewRecorder()
+		handlers.UpdateURL().ServeHTTP(w, request)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/value/",
+		strings.NewReader(`{"id":"latency","type":"histogram"}`))
+	request.Header.Set(ContentType, ApplicationJSON)
+	w = httptest.NewRecorder()
+	handlers.GetAsJSON().ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var got metricPkg.Metric
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, []float64{0.1, 1}, got.Buckets)
+	assert.Equal(t, []uint64{1, 1, 1}, got.Counts)
+	assert.InDelta(t, 3.55, *got.Sum, 1e-9)
+}
+
+// TestUpdateMetricName Имя метрики должно соответствовать шаблону и не превышать максимальную длину
+func TestUpdateMetricName(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{
+			name:     "Valid name -> OK",
+			id:       "Alloc_total2",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Over-long name -> BAD REQUEST",
+			id:       strings.Repeat("a", metricPkg.DefaultNameMaxLen+1),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Name with illegal characters -> BAD REQUEST",
+			id:       "cpu-load",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Name starting with digit -> BAD REQUEST",
+			id:       "1cpu",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			handlers := New(memstore.New(), logpack.NewLogger())
+
+			request := httptest.NewRequest(http.MethodPost, "/update/gauge/"+tt.id+"/1.5", nil)
+			w := httptest.NewRecorder()
+			handlers.UpdateURL().ServeHTTP(w, request)
+			require.Equal(t, tt.wantCode, w.Code)
+
+			body := fmt.Sprintf(`{"id":%q,"type":"gauge","value":1.5}`, tt.id)
+			request = httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+			request.Header.Set(ContentType, ApplicationJSON)
+			w = httptest.NewRecorder()
+			handlers.UpdateJSON().ServeHTTP(w, request)
+			require.Equal(t, tt.wantCode, w.Code)
+
+			if tt.wantCode != http.StatusOK {
+				assert.Contains(t, w.Body.String(), `field "id"`)
+			}
+		})
+	}
+
+	t.Run("Name rules from config -> OK", func(t *testing.T) {
+		require.NoError(t, metricPkg.SetNameRules(`^[a-z-]+$`, 8))
+		t.Cleanup(func() {
+			require.NoError(t, metricPkg.SetNameRules(metricPkg.DefaultNamePattern, metricPkg.DefaultNameMaxLen))
+		})
+
+		handlers := New(memstore.New(), logpack.NewLogger())
+
+		for id, wantCode := range map[string]int{"cpu-load": http.StatusOK, "cpu-loads": http.StatusBadRequest} {
+			body := fmt.Sprintf(`[{"id":%q,"type":"gauge","value":1.5}]`, id)
+			request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+			request.Header.Set(ContentType, ApplicationJSON)
+			w := httptest.NewRecorder()
+			handlers.UpdateDataJSON().ServeHTTP(w, request)
+			require.Equal(t, wantCode, w.Code, id)
+		}
+	})
+}
+
+// fakeRepository Хранилище с заданным результатом проверки доступности
+type fakeRepository struct {
+	*memstore.Storage
+	healthy bool
+}
+
+func (repo fakeRepository) Health() bool {
+	return repo.healthy
+}
+
+func TestPing(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		healthy  bool
+		wantCode int
+	}{
+		{
+			name:     "Healthy storage -> OK",
+			healthy:  true,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Failing storage -> INTERNAL SERVER ERROR",
+			healthy:  false,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			handlers := New(fakeRepository{Storage: memstore.New(), healthy: tt.healthy}, logpack.NewLogger())
+
+			request := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			w := httptest.NewRecorder()
+			handlers.Ping().ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Empty(t, w.Body.String())
+		})
+	}
+}
+
+func TestLogging(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger := logpack.New(&buf, io.Discard)
+
+	handlers := New(memstore.New(), logger)
+
+	var gotID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = RequestID(r.Context())
+		http.NotFound(w, r)
+	})
+
+	t.Run("Not found request -> logged with status 404", func(t *testing.T) {
+		buf.Reset()
+
+		request := httptest.NewRequest(http.MethodGet, "/unknown?x=1", nil)
+		w := httptest.NewRecorder()
+		handlers.Logging(next).ServeHTTP(w, request)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.NotEmpty(t, gotID)
+		require.Equal(t, gotID, w.Header().Get(XRequestID))
+
+		line := buf.String()
+		assert.Contains(t, line, "request_id="+gotID)
+		assert.Contains(t, line, "method=GET")
+		assert.Contains(t, line, "uri=/unknown?x=1")
+		assert.Contains(t, line, "status=404")
+		assert.Contains(t, line, fmt.Sprintf("size=%d", w.Body.Len()))
+
+		idx := strings.Index(line, "duration=")
+		require.NotEqual(t, -1, idx)
+
+		duration, err := time.ParseDuration(strings.TrimSpace(line[idx+len("duration="):]))
+		require.NoError(t, err)
+		assert.Greater(t, duration, time.Duration(0))
+	})
+
+	t.Run("Request id from header -> propagated", func(t *testing.T) {
+		buf.Reset()
+
+		request := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+		request.Header.Set(XRequestID, "test-request-id")
+		w := httptest.NewRecorder()
+		handlers.Logging(next).ServeHTTP(w, request)
+
+		assert.Equal(t, "test-request-id", gotID)
+		assert.Contains(t, buf.String(), "request_id=test-request-id")
+	})
+}
+
+func TestRecover(t *testing.T) {
+
+	handlers := New(memstore.New(), logpack.NewLogger())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("test panic")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(handlers.Recover(handlers.Logging(handlers.Compress(mux))))
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL + "/panic")
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	assert.NotContains(t, string(body), "test panic")
+
+	response, err = http.Get(ts.URL + "/ok")
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	t.Run("Abort handler panic -> not recovered", func(t *testing.T) {
+		abort := handlers.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+
+	handlers := New(memstore.New(), logpack.NewLogger(), WithRateLimit(10, 2))
+	limited := handlers.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/update/gauge/testGauge/1", nil)
+		request.Header.Set(XRealIP, ip)
+		w := httptest.NewRecorder()
+		limited.ServeHTTP(w, request)
+
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, send("192.168.1.10").Code)
+	require.Equal(t, http.StatusOK, send("192.168.1.10").Code)
+
+	w := send("192.168.1.10")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// Ограничение действует для каждого клиента отдельно
+	require.Equal(t, http.StatusOK, send("192.168.1.11").Code)
+
+	// Через 1/rps секунд клиенту снова доступен запрос
+	time.Sleep(150 * time.Millisecond)
+	require.Equal(t, http.StatusOK, send("192.168.1.10").Code)
+}
+
+// TestUpdateTypeMismatch Обновление метрики с ID, который уже хранится с другим типом, возвращает 409
+func TestUpdateTypeMismatch(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "requests", metricPkg.WithValueInt(1))
+	require.NoError(t, err)
+	require.NoError(t, st.Upsert(context.Background(), counter))
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		handler http.HandlerFunc
+	}{
+		{
+			name: "URL",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/update/gauge/requests/1.5", nil)
+			},
+			handler: handlers.UpdateURL(),
+		},
+		{
+			name: "JSON",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodPost, "/update/",
+					strings.NewReader(`{"id":"requests","type":"gauge","value":1.5}`))
+				request.Header.Set(ContentType, ApplicationJSON)
+				return request
+			},
+			handler: handlers.UpdateJSON(),
+		},
+		{
+			name: "Batch",
+			request: func() *http.Request {
+				request := httptest.NewRequest(http.MethodPost, "/updates/",
+					strings.NewReader(`[{"id":"other","type":"gauge","value":1},{"id":"requests","type":"gauge","value":1.5}]`))
+				request.Header.Set(ContentType, ApplicationJSON)
+				return request
+			},
+			handler: handlers.UpdateDataJSON(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, tt.request())
+
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Contains(t, w.Body.String(), "already stored as counter")
+		})
+	}
+
+	got, err := st.Get(context.Background(), metricPkg.Metric{ID: "requests", MType: metricPkg.CounterType})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *got.Delta)
+
+	_, err = st.Get(context.Background(), metricPkg.Metric{ID: "requests", MType: metricPkg.GaugeType})
+	assert.Error(t, err)
+}
+
+// TestUpdateJSONFields Неверный тип полей и недопустимое сочетание полей метрики
+func TestUpdateJSONFields(t *testing.T) {
+
+	handlers := New(memstore.New(), logpack.NewLogger())
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Delta is a string",
+			body:       `{"id":"testCounter","type":"counter","delta":"5"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "delta must be an integer",
+		},
+		{
+			name:       "Delta is a float",
+			body:       `{"id":"testCounter","type":"counter","delta":1.5}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "delta must be an integer",
+		},
+		{
+			name:       "Value is a string",
+			body:       `{"id":"testGauge","type":"gauge","value":"1.5"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "value must be a number",
+		},
+		{
+			name:       "Type is a number",
+			body:       `{"id":"testGauge","type":1,"value":1.5}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `field "type" must be a string`,
+		},
+		{
+			name:       "Value on counter",
+			body:       `{"id":"testCounter","type":"counter","value":1.5}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "counter must not have field value",
+		},
+		{
+			name:       "Delta on gauge",
+			body:       `{"id":"testGauge","type":"gauge","delta":5}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "gauge must not have field delta",
+		},
+		{
+			name:       "Both delta and value",
+			body:       `{"id":"testGauge","type":"gauge","delta":5,"value":1.5}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "both fields delta and value",
+		},
+		{
+			name:       "Malformed JSON",
+			body:       `{"id":"testGauge",`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can't convert data JSON to metric",
+		},
+		{
+			name:       "Null value on counter",
+			body:       `{"id":"testCounter","type":"counter","delta":5,"value":null}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			for _, target := range []string{"/update/", "/updates/"} {
+
+				body, handler := tt.body, handlers.UpdateJSON()
+				if target == "/updates/" {
+					body, handler = "["+tt.body+"]", handlers.UpdateDataJSON()
+				}
+
+				request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+				request.Header.Set(ContentType, ApplicationJSON)
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, request)
+
+				assert.Equal(t, tt.wantStatus, w.Code, target)
+				assert.Contains(t, w.Body.String(), tt.wantBody, target)
+			}
+		})
+	}
+}
+
+// TestLimitBody Тело запроса больше допустимого, в том числе после распаковки, отклоняется
+func TestLimitBody(t *testing.T) {
+
+	const limit = 1024
+
+	handlers := New(memstore.New(), logpack.NewLogger(), WithMaxBodyBytes(limit))
+	chain := handlers.LimitBody(handlers.Compress(handlers.UpdateDataJSON()))
+
+	batch := func(count int) []byte {
+		metrics := make([]metricPkg.Metric, 0, count)
+		for i := 0; i < count; i++ {
+			metrics = append(metrics, NewGaugeMetric())
+		}
+
+		data, err := json.Marshal(metrics)
+		require.NoError(t, err)
+		return data
+	}
+
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		return buf.Bytes()
+	}
+
+	small, large := batch(2), batch(100)
+	require.Less(t, len(small), limit)
+	require.Greater(t, len(large), limit)
+
+	bomb := compress(append([]byte(`[`), bytes.Repeat([]byte(" "), 10*limit)...))
+	require.Less(t, len(bomb), limit)
+
+	tests := []struct {
+		name       string
+		body       []byte
+		gzip       bool
+		wantStatus int
+	}{
+		{
+			name:       "Under limit",
+			body:       small,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Over limit",
+			body:       large,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Gzip under limit",
+			body:       compress(small),
+			gzip:       true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Gzip over limit after decompression",
+			body:       bomb,
+			gzip:       true,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			request.Header.Set(ContentType, ApplicationJSON)
+			if tt.gzip {
+				request.Header.Set(ContentEncoding, GZip)
+			}
+
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+// TestDecompressChained Тело запроса распаковывается в порядке, обратном перечислению в Content-Encoding
+func TestDecompressChained(t *testing.T) {
+
+	handlers := New(memstore.New(), logpack.NewLogger())
+	chain := handlers.Compress(handlers.UpdateDataJSON())
+
+	data, err := json.Marshal([]metricPkg.Metric{NewGaugeMetric()})
+	require.NoError(t, err)
+
+	gz := func(data []byte) []byte {
+		var buf bytes.Buffer
+
+		writer := gzip.NewWriter(&buf)
+		_, err := writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return buf.Bytes()
+	}
+
+	br := func(data []byte) []byte {
+		var buf bytes.Buffer
+
+		writer := brotli.NewWriter(&buf)
+		_, err := writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return buf.Bytes()
+	}
+
+	zst := func(data []byte) []byte {
+		var buf bytes.Buffer
+
+		writer, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name       string
+		encodings  []string
+		body       []byte
+		wantStatus int
+	}{
+		{
+			name:       "Single encoding",
+			encodings:  []string{GZip},
+			body:       gz(data),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Zstd encoding",
+			encodings:  []string{Zstd},
+			body:       zst(data),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Zstd and gzip chained",
+			encodings:  []string{"zstd, gzip"},
+			body:       gz(zst(data)),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Malformed zstd body",
+			encodings:  []string{Zstd},
+			body:       gz(data),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Two chained encodings",
+			encodings:  []string{"br, gzip"},
+			body:       gz(br(data)),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Chained encodings in separate headers",
+			encodings:  []string{GZip, Brotli},
+			body:       br(gz(data)),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Identity is skipped",
+			encodings:  []string{"identity, gzip"},
+			body:       gz(data),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Unknown encoding",
+			encodings:  []string{"gzip, deflate"},
+			body:       gz(data),
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "Too many encodings",
+			encodings:  []string{"gzip, gzip, gzip"},
+			body:       gz(gz(gz(data))),
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "Malformed compressed body",
+			encodings:  []string{"br, gzip"},
+			body:       br(data),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			request.Header.Set(ContentType, ApplicationJSON)
+			for _, encoding := range tt.encodings {
+				request.Header.Add(ContentEncoding, encoding)
+			}
+
+			w := httptest.NewRecorder()
+			chain.ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+// TestIdempotencyCache Ключи запоминаются после успешного выполнения, устаревают через ttl
+// и вытесняются, начиная с давно использованных
+func TestIdempotencyCache(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cache := newIdempotencyCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	calls := 0
+	apply := func() error {
+		calls++
+		return nil
+	}
+
+	do := func(key string) bool {
+		applied, err := cache.do(key, apply)
+		require.NoError(t, err)
+		return applied
+	}
+
+	require.True(t, do("a"))
+	require.False(t, do("a"))
+	require.Equal(t, 1, calls)
+
+	// Неудачное выполнение не запоминается
+	applied, err := cache.do("failed", func() error { return errors.New("failed") })
+	require.True(t, applied)
+	require.Error(t, err)
+	require.True(t, do("failed"))
+
+	// "a" использован раньше "failed", поэтому вытесняется при добавлении "b"
+	require.True(t, do("b"))
+	require.True(t, do("a"))
+
+	// Ключ устаревает через ttl
+	require.False(t, do("b"))
+	now = now.Add(2 * time.Minute)
+	require.True(t, do("b"))
+}
+
+// TestGetJSONFlat Плоский вид метрики по заголовку Accept и прежний вид по умолчанию
+func TestGetJSONFlat(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	gauge, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, err)
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(42))
+	require.NoError(t, err)
+	require.NoError(t, st.Upsert(context.Background(), counter))
+
+	tests := []struct {
+		name            string
+		accept          string
+		body            string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Gauge legacy shape",
+			body:            `{"id":"testGauge","type":"gauge"}`,
+			wantContentType: ApplicationJSON,
+			wantBody:        `{"id":"testGauge","type":"gauge","value":1.5}`,
+		},
+		{
+			name:            "Counter legacy shape",
+			accept:          ApplicationJSON,
+			body:            `{"id":"testCounter","type":"counter"}`,
+			wantContentType: ApplicationJSON,
+			wantBody:        `{"id":"testCounter","type":"counter","delta":42}`,
+		},
+		{
+			name:            "Gauge flat shape",
+			accept:          ApplicationMetricsJSON,
+			body:            `{"id":"testGauge","type":"gauge"}`,
+			wantContentType: ApplicationMetricsJSON,
+			wantBody:        `{"id":"testGauge","type":"gauge","value":1.5}`,
+		},
+		{
+			name:            "Counter flat shape",
+			accept:          ApplicationJSON + ";q=0.5, " + ApplicationMetricsJSON,
+			body:            `{"id":"testCounter","type":"counter"}`,
+			wantContentType: ApplicationMetricsJSON,
+			wantBody:        `{"id":"testCounter","type":"counter","value":42}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(tt.body))
+			request.Header.Set(ContentType, ApplicationJSON)
+			if len(tt.accept) != 0 {
+				request.Header.Set(Accept, tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			handlers.GetAsJSON().ServeHTTP(w, request)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, tt.wantContentType, w.Header().Get(ContentType))
+			assert.JSONEq(t, tt.wantBody, w.Body.String())
+
+			// Значение в плоском виде всегда число
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+			if tt.wantContentType == ApplicationMetricsJSON {
+				assert.IsType(t, float64(0), decoded["value"])
+			}
+		})
+	}
+}
+
+func TestGetBatchJSON(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	gauge, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, err)
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:        "Known and unknown metrics -> OK",
+			contentType: ApplicationJSON,
+			body:        `[{"id":"testGauge","type":"gauge"},{"id":"unknown","type":"counter"}]`,
+			wantCode:    http.StatusOK,
+			wantBody:    `[{"id":"testGauge","type":"gauge","value":1.5},{"id":"unknown","type":"counter","error":"metric not found"}]`,
+		},
+		{
+			name:        "Empty batch -> OK",
+			contentType: ApplicationJSON,
+			body:        `[]`,
+			wantCode:    http.StatusOK,
+			wantBody:    `[]`,
+		},
+		{
+			name:        "Malformed JSON -> BAD REQUEST",
+			contentType: ApplicationJSON,
+			body:        `{"id":"testGauge"`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Unsupported Content-Type -> UNSUPPORTED MEDIA TYPE",
+			contentType: TextPlain,
+			body:        `[]`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(tt.body))
+			request.Header.Set(ContentType, tt.contentType)
+			w := httptest.NewRecorder()
+			handlers.GetBatchJSON().ServeHTTP(w, request)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			if len(tt.wantBody) != 0 {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestRandValues Значения, полученные подряд, должны различаться
+func TestRandValues(t *testing.T) {
+
+	const count = 1000
+
+	floats := make(map[float64]struct{}, count)
+	ints := make(map[int64]struct{}, count)
+
+	for i := 0; i < count; i++ {
+		floats[*randFloat64()] = struct{}{}
+		ints[*randInt64()] = struct{}{}
+	}
+
+	assert.Greater(t, len(floats), 1)
+	assert.Greater(t, len(ints), 1)
+}
+
+func TestSearch(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	for _, id := range []string{"cpuUser", "CPUSystem", "memFree"} {
+		gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(1))
+		require.NoError(t, st.Upsert(context.Background(), gauge))
+	}
+
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "cpuTicks", metricPkg.WithValueInt(5),
+		metricPkg.WithLabels(metricPkg.Labels{"core": "0"}))
+	require.NoError(t, st.Upsert(context.Background(), counter))
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantTotal int
+		wantKeys  []string
+	}{
+		{
+			name:      "Substring only",
+			target:    "/metrics/search?q=Cpu",
+			wantCode:  http.StatusOK,
+			wantTotal: 3,
+			wantKeys:  []string{`counter:cpuTicks{core="0"}`, "gauge:CPUSystem", "gauge:cpuUser"},
+		},
+		{
+			name:      "Type only",
+			target:    "/metrics/search?type=gauge",
+			wantCode:  http.StatusOK,
+			wantTotal: 3,
+			wantKeys:  []string{"gauge:CPUSystem", "gauge:cpuUser", "gauge:memFree"},
+		},
+		{
+			name:      "Substring and type",
+			target:    "/metrics/search?q=cpu&type=counter",
+			wantCode:  http.StatusOK,
+			wantTotal: 1,
+			wantKeys:  []string{`counter:cpuTicks{core="0"}`},
+		},
+		{
+			name:      "Limit",
+			target:    "/metrics/search?q=cpu&limit=2",
+			wantCode:  http.StatusOK,
+			wantTotal: 3,
+			wantKeys:  []string{`counter:cpuTicks{core="0"}`, "gauge:CPUSystem"},
+		},
+		{
+			name:      "Empty result",
+			target:    "/metrics/search?q=disk&type=gauge",
+			wantCode:  http.StatusOK,
+			wantTotal: 0,
+			wantKeys:  []string{},
+		},
+		{
+			name:     "Unknown type",
+			target:   "/metrics/search?type=summary",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Incorrect limit",
+			target:   "/metrics/search?limit=0",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+			handlers.Search().ServeHTTP(w, request)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var result searchResult
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			require.Equal(t, tt.wantTotal, result.Total)
+
+			keys := make([]string, 0, len(result.Metrics))
+			for _, summary := range result.Metrics {
+				keys = append(keys, metricPkg.Metric{ID: summary.ID, MType: summary.MType, Labels: summary.Labels}.Key())
+			}
+			assert.Equal(t, tt.wantKeys, keys)
+		})
+	}
+}
+
+func TestGetMetricsPrefix(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	for _, id := range []string{"cpuUser", "cpuSystem", "memFree"} {
+		gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(1))
+		require.NoError(t, st.Upsert(context.Background(), gauge))
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		wantIDs     []string
+		wantMissing []string
+	}{
+		{
+			name:        "Matching prefix",
+			target:      "/?prefix=cpu",
+			wantIDs:     []string{"cpuUser", "cpuSystem"},
+			wantMissing: []string{"memFree"},
+		},
+		{
+			name:        "Not matching prefix",
+			target:      "/?prefix=disk",
+			wantMissing: []string{"cpuUser", "cpuSystem", "memFree"},
+		},
+		{
+			name:    "Empty prefix",
+			target:  "/?prefix=",
+			wantIDs: []string{"cpuUser", "cpuSystem", "memFree"},
+		},
+		{
+			name:    "Without prefix",
+			target:  "/",
+			wantIDs: []string{"cpuUser", "cpuSystem", "memFree"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+			handlers.GetMetrics().ServeHTTP(w, request)
+
+			require.Equal(t, http.StatusOK, w.Code)
+
+			page := w.Body.String()
+			for _, id := range tt.wantIDs {
+				assert.Contains(t, page, "<td>"+id+"</td>")
+			}
+			for _, id := range tt.wantMissing {
+				assert.NotContains(t, page, "<td>"+id+"</td>")
+			}
+			if len(tt.wantIDs) == 0 {
+				assert.Contains(t, page, "No metrics")
+			}
+		})
+	}
+}
+
+// TestUpdateMetricURLValue Значение, которое не удалось разобрать, - 400, отсутствующий сегмент пути - 404
+func TestUpdateMetricURLValue(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+	}{
+		{
+			name:     "Non-numeric gauge value -> BAD REQUEST",
+			target:   "/update/gauge/testGauge/not_a_number",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-integer counter value -> BAD REQUEST",
+			target:   "/update/counter/testCounter/1.5",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Empty value -> NOT FOUND",
+			target:   "/update/gauge/testGauge/",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Empty name -> NOT FOUND",
+			target:   "/update/gauge//1.5",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Without value -> NOT FOUND",
+			target:   "/update/gauge/testGauge",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Query string is not a part of value -> OK",
+			target:   "/update/gauge/testGauge/1.5?source=agent",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			handlers := New(memstore.New(), logpack.NewLogger())
+
+			request := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			w := httptest.NewRecorder()
+			handlers.UpdateURL().ServeHTTP(w, request)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	handlers := New(memstore.New(), logpack.NewLogger())
+	server := handlers.Tracing(handlers.UpdateURL())
+
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	updates := []string{
+		"/update/gauge/Alloc/1.5",
+		"/update/counter/PollCount/3",
+		"/update/gauge/Alloc/2.5",
+	}
+
+	for _, target := range updates {
+		request := httptest.NewRequest(http.MethodPost, target, nil)
+		request.Header.Set("traceparent", traceParent)
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, request)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, len(updates))
+
+	wantTypes := []string{metricPkg.GaugeType, metricPkg.CounterType, metricPkg.GaugeType}
+	for i, span := range spans {
+		assert.Equal(t, "handler.UpdateURL", span.Name)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+		assert.Contains(t, span.Attributes, tracing.AttrMetricType.String(wantTypes[i]))
+	}
+}
+
+func TestExportImport(t *testing.T) {
+
+	source := memstore.New()
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "Alloc", metricPkg.WithValueFloat(12.5))
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "PollCount", metricPkg.WithValueInt(7))
+	labeled, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "CPU", metricPkg.WithValueFloat(0.25),
+		metricPkg.WithLabels(metricPkg.Labels{"core": "1"}))
+	require.NoError(t, source.UpsertBatch(context.Background(), []metricPkg.Metric{gauge, counter, labeled}))
+
+	for i := 0; i < 2*exportFlushLines; i++ {
+		m, _ := metricPkg.CreateMetric(metricPkg.CounterType, fmt.Sprintf("Counter%d", i), metricPkg.WithValueInt(int64(i)))
+		require.NoError(t, source.Upsert(context.Background(), m))
+	}
+
+	w := httptest.NewRecorder()
+	New(source, logpack.NewLogger()).Export().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ApplicationNDJSON, w.Header().Get(ContentType))
+	assert.True(t, w.Flushed)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3+2*exportFlushLines)
+
+	target := memstore.New()
+
+	request := httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(w.Body.Bytes()))
+	request.Header.Set(ContentType, ApplicationNDJSON)
+
+	w = httptest.NewRecorder()
+	New(target, logpack.NewLogger()).Import().ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	want, err := source.GetBatch(context.Background())
+	require.NoError(t, err)
+
+	got, err := target.GetBatch(context.Background())
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, want, got)
+}
+
+func TestImportErrors(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{
+			name:        "Unsupported Content-Type",
+			target:      "/import",
+			contentType: ApplicationJSON,
+			body:        `{"id":"Alloc","type":"gauge","value":1}`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Unknown mode",
+			target:      "/import?mode=merge",
+			contentType: ApplicationNDJSON,
+			body:        `{"id":"Alloc","type":"gauge","value":1}`,
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			store := memstore.New()
+
+			request := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			request.Header.Set(ContentType, tt.contentType)
+
+			w := httptest.NewRecorder()
+			New(store, logpack.NewLogger()).Import().ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+
+			metrics, err := store.GetBatch(context.Background())
+			require.NoError(t, err)
+			assert.Empty(t, metrics)
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	store := memstore.New()
+
+	changedGauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "Alloc", metricPkg.WithValueFloat(12.5))
+	changedCounter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "PollCount", metricPkg.WithValueInt(10))
+	same, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "CPU", metricPkg.WithValueFloat(0.25),
+		metricPkg.WithLabels(metricPkg.Labels{"core": "1"}))
+	added, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "Frees", metricPkg.WithValueFloat(1))
+	require.NoError(t, store.UpsertBatch(context.Background(), []metricPkg.Metric{changedGauge, changedCounter, same, added}))
+
+	snapshot := `{"id":"Alloc","type":"gauge","value":10}
+{"id":"PollCount","type":"counter","delta":7}
+{"id":"CPU","type":"gauge","value":0.25,"labels":{"core":"1"}}
+{"id":"CPU","type":"gauge","value":0.5,"labels":{"core":"2"}}
+`
+
+	request := httptest.NewRequest(http.MethodPost, "/diff", strings.NewReader(snapshot))
+	request.Header.Set(ContentType, ApplicationNDJSON)
+
+	w := httptest.NewRecorder()
+	New(store, logpack.NewLogger()).Diff().ServeHTTP(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ApplicationJSON, w.Header().Get(ContentType))
+
+	var diff snapshotDiff
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+
+	delta, difference := 2.5, int64(3)
+	assert.Equal(t, []metricDiff{
+		{
+			metricRef:  metricRef{ID: "PollCount", MType: metricPkg.CounterType},
+			Current:    "10",
+			Snapshot:   "7",
+			Difference: &difference,
+		},
+		{
+			metricRef: metricRef{ID: "Alloc", MType: metricPkg.GaugeType},
+			Current:   "12.5",
+			Snapshot:  "10",
+			Delta:     &delta,
+		},
+	}, diff.Changed)
+	assert.Equal(t, 1, diff.Unchanged)
+	assert.Equal(t, []metricRef{{ID: "Frees", MType: metricPkg.GaugeType}}, diff.Added)
+	assert.Equal(t, []metricRef{{ID: "CPU", MType: metricPkg.GaugeType, Labels: metricPkg.Labels{"core": "2"}}}, diff.Removed)
+}
+
+func TestDiffErrors(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{
+			name:        "Unsupported content type",
+			contentType: ApplicationJSON,
+			body:        `{"id":"Alloc","type":"gauge","value":1}`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Malformed line",
+			contentType: ApplicationNDJSON,
+			body:        "{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":1}\n{\"id\":",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Invalid metric",
+			contentType: ApplicationNDJSON,
+			body:        `{"id":"Alloc","type":"unknown","value":1}`,
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/diff", strings.NewReader(tt.body))
+			request.Header.Set(ContentType, tt.contentType)
+
+			w := httptest.NewRecorder()
+			New(memstore.New(), logpack.NewLogger()).Diff().ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	for _, host := range []string{"b", "a"} {
+		gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "cpuUser", metricPkg.WithValueFloat(1.5),
+			metricPkg.WithLabels(metricPkg.Labels{"host": host}))
+		require.NoError(t, st.Upsert(context.Background(), gauge))
+	}
+
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "pollCount", metricPkg.WithValueInt(7))
+	require.NoError(t, st.Upsert(context.Background(), counter))
+
+	tests := []struct {
+		name       string
+		target     string
+		wantCode   int
+		wantStatus string
+		wantSeries []map[string]string
+		wantValues []string
+	}{
+		{
+			name:       "Known metric",
+			target:     "/api/v1/query?query=pollCount",
+			wantCode:   http.StatusOK,
+			wantStatus: "success",
+			wantSeries: []map[string]string{{"__name__": "pollCount"}},
+			wantValues: []string{"7"},
+		},
+		{
+			name:       "Known metric with labels",
+			target:     "/api/v1/query?query=cpuUser",
+			wantCode:   http.StatusOK,
+			wantStatus: "success",
+			wantSeries: []map[string]string{
+				{"__name__": "cpuUser", "host": "a"},
+				{"__name__": "cpuUser", "host": "b"},
+			},
+			wantValues: []string{"1.5", "1.5"},
+		},
+		{
+			name:       "Unknown metric",
+			target:     "/api/v1/query?query=memFree",
+			wantCode:   http.StatusOK,
+			wantStatus: "success",
+			wantSeries: []map[string]string{},
+			wantValues: []string{},
+		},
+		{
+			name:       "Without query",
+			target:     "/api/v1/query",
+			wantCode:   http.StatusBadRequest,
+			wantStatus: "error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+			handlers.Query().ServeHTTP(w, request)
+
+			require.Equal(t, tt.wantCode, w.Code)
+
+			var response struct {
+				Status string `json:"status"`
+				Data   struct {
+					ResultType string `json:"resultType"`
+					Result     []struct {
+						Metric map[string]string `json:"metric"`
+						Value  []interface{}     `json:"value"`
+					} `json:"result"`
+				} `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, tt.wantStatus, response.Status)
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, "vector", response.Data.ResultType)
+			require.NotNil(t, response.Data.Result)
+
+			series := make([]map[string]string, 0, len(response.Data.Result))
+			values := make([]string, 0, len(response.Data.Result))
+			for _, sample := range response.Data.Result {
+				require.Len(t, sample.Value, 2)
+				require.IsType(t, float64(0), sample.Value[0])
+
+				series = append(series, sample.Metric)
+				values = append(values, sample.Value[1].(string))
+			}
+
+			assert.Equal(t, tt.wantSeries, series)
+			assert.Equal(t, tt.wantValues, values)
+		})
+	}
+}
+
+func TestContentTypeParameters(t *testing.T) {
+
+	st := memstore.New()
+	handlers := New(st, logpack.NewLogger())
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, st.Upsert(context.Background(), gauge))
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantCode    int
+	}{
+		{
+			name:        "Bare media type",
+			contentType: "application/json",
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Media type with charset",
+			contentType: "application/json; charset=utf-8",
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Media type in upper case",
+			contentType: "Application/JSON",
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "Mismatched media type",
+			contentType: "text/plain; charset=utf-8",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Media type as prefix",
+			contentType: "application/jsonp",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Without content-type",
+			contentType: "",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/value", strings.NewReader(`{"id":"testGauge","type":"gauge"}`))
+			request.Header.Set(ContentType, tt.contentType)
+
+			w := httptest.NewRecorder()
+			handlers.GetAsJSON().ServeHTTP(w, request)
+
+			require.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
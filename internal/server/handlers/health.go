@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"metrics-and-alerting/internal/storage"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// Состояние сервера в ответе /healthz
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// storageNames Названия видов хранилища в ответе /healthz
+var storageNames = map[string]string{
+	storage.KindMemory:   "memory",
+	storage.KindFile:     "file",
+	storage.KindDatabase: "postgres",
+}
+
+type (
+	// FlushReporter Сведения о сохранении метрик хранилищем
+	FlushReporter interface {
+		SyncFlush() bool
+		LastFlush() time.Time
+	}
+
+	// PoolReporter Статистика пула соединений хранилища с базой данных
+	PoolReporter interface {
+		PoolStats() (sql.DBStats, bool)
+	}
+
+	// healthStatus Подробное состояние сервера
+	healthStatus struct {
+		Status    string         `json:"status"`
+		Storage   string         `json:"storage"`
+		SyncStore bool           `json:"sync_store"`
+		LastSave  *time.Time     `json:"last_save"`
+		Metrics   map[string]int `json:"metrics"`
+		Pool      *poolStatus    `json:"pool,omitempty"`
+	}
+
+	// poolStatus Статистика пула соединений с базой данных
+	poolStatus struct {
+		MaxOpen           int    `json:"max_open"`
+		Open              int    `json:"open"`
+		InUse             int    `json:"in_use"`
+		Idle              int    `json:"idle"`
+		WaitCount         int64  `json:"wait_count"`
+		WaitDuration      string `json:"wait_duration"`
+		MaxIdleClosed     int64  `json:"max_idle_closed"`
+		MaxIdleTimeClosed int64  `json:"max_idle_time_closed"`
+		MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+	}
+)
+
+// WithStorageKind Вид хранилища (storage.KindMemory, storage.KindFile, storage.KindDatabase) для ответа /healthz
+func WithStorageKind(kind string) OptionsHandler {
+	return func(h *Handler) {
+		h.storageKind = kind
+	}
+}
+
+// Healthz Подробное состояние сервера в формате JSON: вид хранилища, режим сохранения,
+// время последнего успешного сохранения, количество метрик каждого типа,
+// а для базы данных - статистика пула соединений.
+// Если хранилище недоступно, возвращается статус 503
+func (h *Handler) Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		status := healthStatus{
+			Status:  HealthOK,
+			Storage: storageNames[h.storageKind],
+			Metrics: make(map[string]int, len(metricPkg.Types)),
+		}
+
+		code := http.StatusOK
+		if !h.store.Health() {
+			status.Status = HealthUnavailable
+			code = http.StatusServiceUnavailable
+		}
+
+		for _, typeMetric := range metricPkg.Types {
+			count, err := h.store.Count(r.Context(), typeMetric)
+			if err != nil {
+				h.logger.Err.Printf("could not count %s metrics: %v\n", typeMetric, err)
+				continue
+			}
+
+			status.Metrics[typeMetric] = count
+		}
+
+		if flush, ok := h.store.(FlushReporter); ok {
+			status.SyncStore = flush.SyncFlush()
+
+			if last := flush.LastFlush(); !last.IsZero() {
+				status.LastSave = &last
+			}
+		}
+
+		if pool, ok := h.store.(PoolReporter); ok {
+			if stats, ok := pool.PoolStats(); ok {
+				status.Pool = newPoolStatus(stats)
+			}
+		}
+
+		encode, errEncode := json.Marshal(status)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode health status to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+		w.WriteHeader(code)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// newPoolStatus Статистика пула соединений для ответа /healthz
+func newPoolStatus(stats sql.DBStats) *poolStatus {
+	return &poolStatus{
+		MaxOpen:           stats.MaxOpenConnections,
+		Open:              stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDuration:      stats.WaitDuration.String(),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"metrics-and-alerting/internal/tenant"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+const (
+	// IdempotencyKey Заголовок с ключом, по которому повтор запроса обновления не применяется повторно
+	IdempotencyKey = "Idempotency-Key"
+
+	// DefaultIdempotencySize Количество запоминаемых ключей идемпотентности по умолчанию
+	DefaultIdempotencySize = 10000
+	// DefaultIdempotencyTTL Время хранения ключа идемпотентности по умолчанию
+	DefaultIdempotencyTTL = 10 * time.Minute
+)
+
+type (
+	// idempotencyCache Недавно примененные ключи идемпотентности.
+	// Хранится не более size ключей, давно использованные вытесняются первыми,
+	// ключ забывается через ttl после применения
+	idempotencyCache struct {
+		mu      sync.Mutex
+		size    int
+		ttl     time.Duration
+		now     func() time.Time
+		order   *list.List               // ключи от недавно использованных к давно использованным
+		seen    map[string]*list.Element // элемент order по ключу
+		pending map[string]chan struct{} // ключи, запросы с которыми выполняются
+	}
+
+	idempotencyEntry struct {
+		key     string
+		expires time.Time
+	}
+)
+
+// WithIdempotency Количество запоминаемых ключей идемпотентности и время их хранения.
+// 0 в любом из параметров отключает учет ключей
+func WithIdempotency(size int, ttl time.Duration) OptionsHandler {
+	return func(h *Handler) {
+
+		if size <= 0 || ttl <= 0 {
+			h.idempotency = nil
+			return
+		}
+
+		h.idempotency = newIdempotencyCache(size, ttl)
+	}
+}
+
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		seen:    make(map[string]*list.Element),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+// do Выполнение apply, если ключ key еще не был применен.
+// Ключ запоминается только после успешного выполнения, поэтому неудачный запрос можно повторить.
+// Одновременный запрос с тем же ключом ожидает завершения первого.
+// Возвращается признак выполнения apply и ее ошибка
+func (c *idempotencyCache) do(key string, apply func() error) (bool, error) {
+
+	for {
+		c.mu.Lock()
+
+		if c.applied(key) {
+			c.mu.Unlock()
+			return false, nil
+		}
+
+		wait, ok := c.pending[key]
+		if !ok {
+			break
+		}
+
+		c.mu.Unlock()
+		<-wait
+	}
+
+	done := make(chan struct{})
+	c.pending[key] = done
+	c.mu.Unlock()
+
+	err := apply()
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	if err == nil {
+		c.remember(key)
+	}
+	close(done)
+	c.mu.Unlock()
+
+	return true, err
+}
+
+// applied Проверка, что ключ применен и еще не устарел, без блокировки
+func (c *idempotencyCache) applied(key string) bool {
+
+	elem, ok := c.seen[key]
+	if !ok {
+		return false
+	}
+
+	if c.now().After(elem.Value.(idempotencyEntry).expires) {
+		c.order.Remove(elem)
+		delete(c.seen, key)
+		return false
+	}
+
+	c.order.MoveToFront(elem)
+	return true
+}
+
+// remember Запоминание примененного ключа без блокировки.
+// Устаревшие и лишние ключи вытесняются
+func (c *idempotencyCache) remember(key string) {
+
+	now := c.now()
+	c.seen[key] = c.order.PushFront(idempotencyEntry{key: key, expires: now.Add(c.ttl)})
+
+	for c.order.Len() > 0 {
+		oldest := c.order.Back()
+		entry := oldest.Value.(idempotencyEntry)
+
+		if c.order.Len() <= c.size && !now.After(entry.expires) {
+			break
+		}
+
+		c.order.Remove(oldest)
+		delete(c.seen, entry.key)
+	}
+}
+
+// applyOnce Выполнение обновления apply с учетом заголовка Idempotency-Key.
+// Ключ действует в пределах арендатора и scope - набора метрик запроса. Если обновление с тем же
+// ключом для тех же метрик уже применено, apply не выполняется и ошибка не возвращается
+func (h Handler) applyOnce(r *http.Request, scope string, apply func() error) error {
+
+	key := strings.TrimSpace(r.Header.Get(IdempotencyKey))
+	if h.idempotency == nil || len(key) == 0 {
+		return apply()
+	}
+
+	applied, err := h.idempotency.do(tenant.FromContext(r.Context())+"\x00"+scope+"\x00"+key, apply)
+	if !applied {
+		h.logger.Debug.Printf("skip repeated update %s with idempotency key %s\n", scope, key)
+	}
+
+	return err
+}
+
+// batchScope Область действия ключа идемпотентности для набора метрик
+func batchScope(metrics []metricPkg.Metric) string {
+
+	keys := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		keys = append(keys, metric.Key())
+	}
+	sort.Strings(keys)
+
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// DefaultMaxBodyBytes Максимальный размер тела запроса по умолчанию
+const DefaultMaxBodyBytes = 1 << 20
+
+// ErrBodyTooLarge Тело запроса, в том числе после распаковки, больше допустимого размера
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// limitedBody Чтение тела запроса не больше remaining байт.
+// При попытке прочитать больше возвращается ErrBodyTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// limitBody Ограничение размера тела запроса limit байтами
+func limitBody(body io.ReadCloser, limit int64) io.ReadCloser {
+	return &limitedBody{ReadCloser: body, remaining: limit}
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+
+	if body.remaining <= 0 {
+		// Допустимый размер прочитан: тело должно на этом закончиться
+		var probe [1]byte
+
+		n, err := body.ReadCloser.Read(probe[:])
+		if n > 0 || (err != nil && err != io.EOF) {
+			return 0, ErrBodyTooLarge
+		}
+
+		return 0, err
+	}
+
+	if int64(len(p)) > body.remaining {
+		p = p[:body.remaining]
+	}
+
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+
+	return n, err
+}
+
+// WithMaxBodyBytes Максимальный размер тела запроса в байтах, в том числе после распаковки
+func WithMaxBodyBytes(size int64) OptionsHandler {
+	return func(h *Handler) {
+		if size > 0 {
+			h.maxBodyBytes = size
+		}
+	}
+}
+
+// LimitBody Middleware Ограничение размера тела запроса.
+// Если тело больше допустимого, при его чтении возвращается ErrBodyTooLarge,
+// а соединение закрывается после ответа
+func (h Handler) LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		r.Body = limitBody(http.MaxBytesReader(w, r.Body, h.maxBodyBytes), h.maxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyErrorStatus HTTP код ответа на ошибку чтения тела запроса
+func bodyErrorStatus(err error) int {
+
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedEncoding), errors.Is(err, ErrTooManyEncodings):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"metrics-and-alerting/pkg/logpack"
+)
+
+// loggingWriter Сохранение кода ответа и размера тела ответа
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *loggingWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *loggingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+
+	return n, err
+}
+
+// Flush Отправка буферизованных данных клиенту, если это поддерживает исходный writer
+func (w *loggingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack Передача соединения обработчику, например для WebSocket
+func (w *loggingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return hijacker.Hijack()
+}
+
+// RequestID Идентификатор запроса из контекста.
+// Если идентификатор не задан, возвращается пустая строка
+func RequestID(ctx context.Context) string {
+	return logpack.RequestID(ctx)
+}
+
+// newRequestID Генерация случайного идентификатора запроса
+func newRequestID() string {
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// Logging Middleware Логирование метода, URI, кода ответа, размера ответа и времени обработки запроса.
+// Идентификатор запроса берется из заголовка X-Request-Id или генерируется,
+// передается дальше через контекст запроса и возвращается в заголовке ответа
+func (h Handler) Logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		id := r.Header.Get(XRequestID)
+		if len(id) == 0 {
+			id = newRequestID()
+		}
+
+		w.Header().Set(XRequestID, id)
+		lw := &loggingWriter{ResponseWriter: w}
+
+		next.ServeHTTP(lw, r.WithContext(logpack.WithRequestID(r.Context(), id)))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		h.logger.Info.Printf("request_id=%s method=%s uri=%s status=%d size=%d duration=%s\n",
+			id, r.Method, r.RequestURI, lw.status, lw.size, time.Since(start))
+	})
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// WithOptionalContentType Прием запросов без заголовка Content-Type обработчиками,
+// которые получают метрику из пути URL, а не из тела запроса
+func WithOptionalContentType(optional bool) OptionsHandler {
+	return func(h *Handler) {
+		h.optionalCType = optional
+	}
+}
+
+// hasContentType Проверка, что тип из заголовка Content-Type запроса совпадает с mediaType.
+// Сравнивается только основной тип без учета регистра, параметры вроде charset не учитываются
+func hasContentType(r *http.Request, mediaType string) bool {
+
+	base, _, err := mime.ParseMediaType(r.Header.Get(ContentType))
+	if err != nil && !errors.Is(err, mime.ErrInvalidMediaParameter) {
+		return false
+	}
+
+	return base == mediaType
+}
+
+// hasURLContentType Проверка заголовка Content-Type для обработчиков, получающих метрику из пути URL.
+// С WithOptionalContentType запрос без заголовка Content-Type принимается
+func (h Handler) hasURLContentType(r *http.Request, mediaType string) bool {
+
+	if h.optionalCType && len(r.Header.Get(ContentType)) == 0 {
+		return true
+	}
+
+	return hasContentType(r, mediaType)
+}
@@ -1,15 +1,26 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"html/template"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 
+	"metrics-and-alerting/internal/tracing"
 	"metrics-and-alerting/pkg/errs"
 	metricPkg "metrics-and-alerting/pkg/metric"
 )
 
+// exportFlushLines Количество строк выгрузки, после которого данные отправляются клиенту
+const exportFlushLines = 100
+
+// QuerySign Параметр запроса значения метрики по URL: при sign=1 значение возвращается с подписью
+const QuerySign = "sign"
+
 func (h Handler) GetAsText() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -24,7 +35,7 @@ func (h Handler) GetAsText() http.HandlerFunc {
 		// затем разбиваем на массив:
 		// [0] - Тип метрики
 		// [1] - Название метрики
-		dataURL := strings.ReplaceAll(r.URL.String(), "/value/", "")
+		dataURL := strings.ReplaceAll(r.URL.Path, "/value/", "")
 		partsURL := strings.Split(dataURL, "/")
 
 		if len(partsURL) != partsGetURL {
@@ -41,13 +52,29 @@ func (h Handler) GetAsText() http.HandlerFunc {
 			return
 		}
 
-		metric, err = h.store.Get(metric)
+		ctx, span := tracing.Start(r.Context(), "handler.GetAsText", tracing.AttrMetricType.String(metric.MType))
+		metric, err = h.store.Get(ctx, metric)
+		tracing.End(span, err)
+
 		if err != nil {
 			h.logger.Err.Printf("error read metric from storage: %v\n", err)
 			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
 
+		if r.URL.Query().Get(QuerySign) == "1" {
+			h.writeSignedValue(w, metric)
+			return
+		}
+
+		etag := metricETag(metric)
+		w.Header().Set(ETag, etag)
+
+		if notModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		if _, err := w.Write([]byte(metric.StringValue())); err != nil {
 			h.logger.Err.Printf("error write data in response body: %v\n", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -55,10 +82,101 @@ func (h Handler) GetAsText() http.HandlerFunc {
 	}
 }
 
+// signedValue Значение метрики с подписью для чтения по URL с параметром sign=1
+type signedValue struct {
+	ID    string `json:"id"`
+	MType string `json:"type"`
+	Value string `json:"value"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// writeSignedValue Запись значения метрики с подписью в формате JSON.
+// Если ключ подписи на сервере не задан, подпись не передается
+func (h Handler) writeSignedValue(w http.ResponseWriter, metric metricPkg.Metric) {
+
+	data, err := json.Marshal(signedValue{
+		ID:    metric.ID,
+		MType: metric.MType,
+		Value: metric.StringValue(),
+		Hash:  metric.Hash,
+	})
+
+	if err != nil {
+		h.logger.Err.Printf("error encode signed value to JSON: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(ContentType, ApplicationJSON)
+
+	if _, err := w.Write(data); err != nil {
+		h.logger.Err.Printf("error write data in response body: %v\n", err)
+	}
+}
+
+// flatMetric Метрика в плоском виде для клиентов, запросивших application/vnd.metrics+json.
+// Value всегда число: целое для counter, дробное для gauge, сумма наблюдений для histogram
+type flatMetric struct {
+	ID     string           `json:"id"`
+	MType  string           `json:"type"`
+	Value  json.Number      `json:"value"`
+	Labels metricPkg.Labels `json:"labels,omitempty"`
+	Hash   string           `json:"hash,omitempty"`
+}
+
+// newFlatMetric Метрика в плоском виде
+func newFlatMetric(metric metricPkg.Metric) flatMetric {
+
+	flat := flatMetric{
+		ID:     metric.ID,
+		MType:  metric.MType,
+		Value:  "0",
+		Labels: metric.Labels,
+		Hash:   metric.Hash,
+	}
+
+	switch {
+	case metric.MType == metricPkg.CounterType && metric.Delta != nil:
+		flat.Value = json.Number(strconv.FormatInt(*metric.Delta, 10))
+
+	case metric.MType == metricPkg.HistogramType && metric.Sum != nil:
+		flat.Value = json.Number(metricPkg.FormatFloat(*metric.Sum))
+
+	case metric.Value != nil:
+		flat.Value = json.Number(metricPkg.FormatFloat(*metric.Value))
+	}
+
+	return flat
+}
+
+// acceptsMediaType Признак того, что тип mediaType явно указан в заголовке Accept.
+// Параметры типов, в том числе вес q, не учитываются
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+
+	for _, header := range r.Header.Values(Accept) {
+		for _, accepted := range strings.Split(header, ",") {
+
+			if idx := strings.Index(accepted, ";"); idx >= 0 {
+				accepted = accepted[:idx]
+			}
+
+			if strings.EqualFold(strings.TrimSpace(accepted), mediaType) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetAsJSON Получение метрики по JSON запросу {id, type}.
+// По умолчанию метрика возвращается в том же виде, в котором сохраняется.
+// Если в заголовке Accept указан application/vnd.metrics+json, метрика возвращается
+// в плоском виде {id, type, value}, если application/msgpack - в формате MessagePack
 func (h Handler) GetAsJSON() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		if r.Header.Get(ContentType) != ApplicationJSON {
+		if !hasContentType(r, ApplicationJSON) {
 			h.logger.Err.Printf("request with unsupported Content-Type: %s\n", r.Header.Get(ContentType))
 			w.WriteHeader(http.StatusUnsupportedMediaType)
 			return
@@ -75,7 +193,7 @@ func (h Handler) GetAsJSON() http.HandlerFunc {
 		reader, errReader := BodyReader(r)
 		if errReader != nil {
 			h.logger.Err.Printf("error get body reader: %v\n", errReader)
-			http.Error(w, errReader.Error(), http.StatusBadRequest)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
 			return
 		}
 		defer func() {
@@ -87,7 +205,7 @@ func (h Handler) GetAsJSON() http.HandlerFunc {
 		data, errBody := io.ReadAll(reader)
 		if errBody != nil {
 			h.logger.Err.Printf("error read body: %v\n", errBody)
-			http.Error(w, errBody.Error(), http.StatusBadRequest)
+			http.Error(w, errBody.Error(), bodyErrorStatus(errBody))
 			return
 		}
 
@@ -98,16 +216,28 @@ func (h Handler) GetAsJSON() http.HandlerFunc {
 			return
 		}
 
-		metric, errStorage := h.store.Get(metric)
+		ctx, span := tracing.Start(r.Context(), "handler.GetAsJSON", tracing.AttrMetricType.String(metric.MType))
+		metric, errStorage := h.store.Get(ctx, metric)
+		tracing.End(span, errStorage)
+
 		if errStorage != nil {
 			h.logger.Err.Printf("could not get metric from storage: %v\n", errStorage)
 			http.Error(w, errStorage.Error(), errs.ErrorHTTP(errStorage))
 			return
 		}
 
-		encode, errEncode := json.Marshal(&metric)
+		marshal := responseMarshal(w, r)
+
+		var body interface{} = &metric
+		if acceptsMediaType(r, ApplicationMetricsJSON) {
+			w.Header().Set(ContentType, ApplicationMetricsJSON)
+			body = newFlatMetric(metric)
+			marshal = json.Marshal
+		}
+
+		encode, errEncode := marshal(body)
 		if errEncode != nil {
-			h.logger.Err.Printf("error encode metric to JSON: %v\n", errStorage)
+			h.logger.Err.Printf("error encode metric: %v\n", errEncode)
 			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -119,26 +249,230 @@ func (h Handler) GetAsJSON() http.HandlerFunc {
 	}
 }
 
+// valueResult Метрика в ответе на запрос набора метрик.
+// Если метрику получить не удалось, заполняются только ID, тип и текст ошибки
+type valueResult struct {
+	metricPkg.Metric
+	Error string `json:"error,omitempty"`
+}
+
+// GetBatchJSON Получение набора метрик по JSON массиву запросов {id, type}.
+// Отсутствующие метрики возвращаются с ошибкой, не прерывая обработку остальных.
+// Если в заголовке Accept указан application/msgpack, ответ кодируется в MessagePack
+func (h Handler) GetBatchJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !hasContentType(r, ApplicationJSON) {
+			h.logger.Err.Printf("request with unsupported Content-Type: %s\n", r.Header.Get(ContentType))
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				h.logger.Err.Printf("error close body: %v\n", err)
+			}
+		}()
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
+		defer func() {
+			if err := reader.Close(); err != nil {
+				h.logger.Err.Printf("error close reader: %v\n", err)
+			}
+		}()
+
+		data, errBody := io.ReadAll(reader)
+		if errBody != nil {
+			h.logger.Err.Printf("error read body: %v\n", errBody)
+			http.Error(w, errBody.Error(), bodyErrorStatus(errBody))
+			return
+		}
+
+		var requests []metricPkg.Metric
+		if err := json.Unmarshal(data, &requests); err != nil {
+			h.logger.Err.Printf("error decode body to JSON: %v\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results := make([]valueResult, 0, len(requests))
+
+		for _, request := range requests {
+
+			metric, err := h.store.Get(r.Context(), metricPkg.Metric{ID: request.ID, MType: request.MType, Labels: request.Labels})
+			if err != nil {
+				results = append(results, valueResult{
+					Metric: metricPkg.Metric{ID: request.ID, MType: request.MType, Labels: request.Labels},
+					Error:  err.Error(),
+				})
+				continue
+			}
+
+			results = append(results, valueResult{Metric: metric})
+		}
+
+		encode, errEncode := responseMarshal(w, r)(results)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode metrics: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// GetCount Количество метрик каждого типа и общее количество в формате JSON:
+// {"gauge": N, "counter": M, "histogram": K, "total": N+M+K}
+func (h Handler) GetCount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		counts := make(map[string]int, len(metricPkg.Types)+1)
+		total := 0
+
+		for _, typeMetric := range metricPkg.Types {
+			count, err := h.store.Count(r.Context(), typeMetric)
+			if err != nil {
+				h.logger.Err.Printf("could not count metrics %s: %v\n", typeMetric, err)
+				http.Error(w, err.Error(), errs.ErrorHTTP(err))
+				return
+			}
+
+			counts[typeMetric] = count
+			total += count
+		}
+
+		counts["total"] = total
+
+		encode, errEncode := json.Marshal(counts)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode metrics count to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// metricsTemplate Шаблон HTML страницы со списком метрик
+var metricsTemplate = template.Must(template.New("metrics").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Metrics</title></head>
+<body>
+{{- if . }}
+<table>
+<tr><th>Name</th><th>Type</th><th>Value</th></tr>
+{{- range . }}
+<tr><td>{{ .ID }}{{ .Labels }}</td><td>{{ .MType }}</td><td>{{ .StringValue }}</td></tr>
+{{- end }}
+</table>
+{{- else }}
+<p>No metrics</p>
+{{- end }}
+</body>
+</html>
+`))
+
+// GetMetrics Список всех метрик в виде HTML таблицы, отсортированной по типу и имени.
+// Параметр запроса prefix оставляет только метрики, имя которых начинается с него
 func (h Handler) GetMetrics() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set(ContentType, TextHTML)
 
-		metrics, err := h.store.GetBatch()
+		metrics, err := h.store.GetBatch(r.Context())
 		if err != nil {
 			h.logger.Err.Printf("could not get all metrics from storage: %v\n", err)
 			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
 
-		html := ""
-		for _, metric := range metrics {
-			html += metric.ShotString() + "<br/>"
+		metrics = filterByPrefix(metrics, r.URL.Query().Get("prefix"))
+
+		sort.Slice(metrics, func(i, j int) bool {
+			if metrics[i].MType != metrics[j].MType {
+				return metrics[i].MType < metrics[j].MType
+			}
+
+			if metrics[i].ID != metrics[j].ID {
+				return metrics[i].ID < metrics[j].ID
+			}
+
+			return metrics[i].Labels.String() < metrics[j].Labels.String()
+		})
+
+		page := bytes.Buffer{}
+		if err := metricsTemplate.Execute(&page, metrics); err != nil {
+			h.logger.Err.Printf("could not render metrics page: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		if _, err := w.Write([]byte(html)); err != nil {
+		if _, err := w.Write(page.Bytes()); err != nil {
 			h.logger.Err.Printf("error write data in response body: %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// filterByPrefix Отбор метрик, имя которых начинается с prefix, без выделения памяти.
+// Пустой prefix оставляет все метрики
+func filterByPrefix(metrics []metricPkg.Metric, prefix string) []metricPkg.Metric {
+
+	if len(prefix) == 0 {
+		return metrics
+	}
+
+	filtered := metrics[:0]
+	for _, metric := range metrics {
+		if strings.HasPrefix(metric.ID, prefix) {
+			filtered = append(filtered, metric)
+		}
+	}
+
+	return filtered
+}
+
+// Export Выгрузка всех метрик в формате NDJSON: одна метрика в формате JSON на строку.
+// Метрики пишутся в ответ по мере кодирования, данные отправляются клиенту каждые exportFlushLines строк
+func (h Handler) Export() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		metrics, err := h.store.GetBatch(r.Context())
+		if err != nil {
+			h.logger.Err.Printf("could not get all metrics from storage: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationNDJSON)
+
+		flusher, _ := w.(http.Flusher)
+		encoder := json.NewEncoder(w)
+
+		for i, metric := range metrics {
+			if err := encoder.Encode(metric); err != nil {
+				h.logger.Err.Printf("error write metric %s in response body: %v\n", metric.ShotString(), err)
+				return
+			}
+
+			if flusher != nil && (i+1)%exportFlushLines == 0 {
+				flusher.Flush()
+			}
 		}
 	}
 }
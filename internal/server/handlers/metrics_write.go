@@ -1,16 +1,40 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"strings"
 
+	"metrics-and-alerting/internal/tracing"
 	"metrics-and-alerting/pkg/errs"
 	metricPkg "metrics-and-alerting/pkg/metric"
 )
 
+const (
+	// maxImportLine Максимальная длина строки NDJSON при загрузке метрик
+	maxImportLine = 1 << 20
+
+	// ImportModeAdd Режим загрузки, в котором counter добавляется к сохраненному значению
+	ImportModeAdd = "add"
+	// ImportModeReplace Режим загрузки, в котором counter заменяет сохраненное значение
+	ImportModeReplace = "replace"
+
+	// GaugeIncType Тип метрики в URL обновления, при котором значение добавляется к gauge
+	GaugeIncType = metricPkg.GaugeType + "-" + metricPkg.OpInc
+)
+
+// Replacer Запись набора метрик, в котором counter заменяют сохраненные значения, а не добавляются к ним
+type Replacer interface {
+	ReplaceBatch(ctx context.Context, metrics []metricPkg.Metric) error
+}
+
+// UpdateURL Обновление метрики по URL вида /update/<ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>/<ЗНАЧЕНИЕ_МЕТРИКИ>.
+// Повтор запроса с тем же заголовком Idempotency-Key для той же метрики не применяется
 func (h Handler) UpdateURL() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -21,35 +45,47 @@ func (h Handler) UpdateURL() http.HandlerFunc {
 
 		w.Header().Set(ContentType, TextPlain)
 
-		// оставляем из url только <ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>/<ЗНАЧЕНИЕ_МЕТРИКИ>
+		// оставляем из пути только <ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>/<ЗНАЧЕНИЕ_МЕТРИКИ>
 		// затем разбиваем на массив:
 		// [0] - Тип метрики
 		// [1] - Название метрики
 		// [2] - Значение метрики
-		dataURL := strings.ReplaceAll(r.URL.String(), "/update/", "")
+		dataURL := strings.TrimPrefix(r.URL.Path, "/update/")
 		partsURL := strings.Split(dataURL, "/")
 
-		if len(partsURL) != partsUpdateURL {
+		// Отсутствующее имя или значение - 404, значение, которое не удалось разобрать, - 400
+		if len(partsURL) != partsUpdateURL || len(partsURL[idxName]) == 0 || len(partsURL[idxValue]) == 0 {
 
 			err := fmt.Errorf("invalid URL: %s", r.URL.String())
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 
+		typeMetric, op := partsURL[idxType], ""
+		if typeMetric == GaugeIncType {
+			typeMetric, op = metricPkg.GaugeType, metricPkg.OpInc
+		}
+
 		metric, err := metricPkg.CreateMetric(
-			partsURL[idxType],
+			typeMetric,
 			partsURL[idxName],
 			metricPkg.WithValue(partsURL[idxValue]),
 		)
 
 		if err != nil {
-			log.Printf("error create metric: %v\n", err)
+			h.logger.Err.Printf("error create metric: %v\n", err)
 			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
 
-		if err := h.store.Upsert(metric); err != nil {
-			log.Printf("error upsert metric: %v\n", err)
+		metric.Op = op
+
+		ctx, span := tracing.Start(r.Context(), "handler.UpdateURL", tracing.AttrMetricType.String(metric.MType))
+		err = h.applyOnce(r, metric.Key(), func() error { return h.store.Upsert(ctx, metric) })
+		tracing.End(span, err)
+
+		if err != nil {
+			h.logger.Err.Printf("error upsert metric: %v\n", err)
 			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
@@ -58,6 +94,8 @@ func (h Handler) UpdateURL() http.HandlerFunc {
 	}
 }
 
+// UpdateJSON Обновление метрики в формате JSON или MessagePack (Content-Type: application/msgpack).
+// Повтор запроса с тем же заголовком Idempotency-Key для той же метрики не применяется
 func (h Handler) UpdateJSON() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -68,40 +106,50 @@ func (h Handler) UpdateJSON() http.HandlerFunc {
 			return
 		}
 
-		if r.Header.Get(ContentType) != ApplicationJSON {
+		if !hasMetricsContentType(r) {
 			w.WriteHeader(http.StatusUnsupportedMediaType)
 			return
 		}
 
 		defer func() {
 			if err := r.Body.Close(); err != nil {
-				log.Printf("error close body in handler UpdateJSON: %v\n", err)
+				h.logger.Err.Printf("error close body in handler UpdateJSON: %v\n", err)
 			}
 		}()
 
 		reader, errReader := BodyReader(r)
 		if errReader != nil {
-			log.Printf("error get body reader: %v\n", errReader)
-			http.Error(w, errReader.Error(), http.StatusBadRequest)
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
 			return
 		}
 
-		data, err := h.Decrypt(reader)
+		data, err := io.ReadAll(reader)
 		if err != nil {
-			log.Printf("error read body request: %v\n", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			h.logger.Err.Printf("error read body request: %v\n", err)
+			http.Error(w, err.Error(), bodyErrorStatus(err))
 			return
 		}
 
-		var metric metricPkg.Metric
-		if err := json.Unmarshal(data, &metric); err != nil {
-			log.Printf("error decode JSON body: %v\n", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		metric, err := decodeMetric(r, data)
+		if err != nil {
+			h.logger.Err.Printf("error decode body: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		if err := metricPkg.ValidateName(metric.ID); err != nil {
+			h.logger.Err.Printf("error validate metric: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
 
-		if err := h.store.Upsert(metric); err != nil {
-			log.Printf("error update metric: %v\n", err)
+		ctx, span := tracing.Start(r.Context(), "handler.UpdateJSON", tracing.AttrMetricType.String(metric.MType))
+		err = h.applyOnce(r, metric.Key(), func() error { return h.store.Upsert(ctx, metric) })
+		tracing.End(span, err)
+
+		if err != nil {
+			h.logger.Err.Printf("error update metric: %v\n", err)
 			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
@@ -110,6 +158,8 @@ func (h Handler) UpdateJSON() http.HandlerFunc {
 	}
 }
 
+// UpdateDataJSON Обновление набора метрик в формате JSON или MessagePack (Content-Type: application/msgpack).
+// Повтор запроса с тем же заголовком Idempotency-Key для того же набора метрик не применяется
 func (h Handler) UpdateDataJSON() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -118,40 +168,52 @@ func (h Handler) UpdateDataJSON() http.HandlerFunc {
 			return
 		}
 
-		if r.Header.Get(ContentType) != ApplicationJSON {
+		if !hasMetricsContentType(r) {
 			w.WriteHeader(http.StatusUnsupportedMediaType)
 			return
 		}
 
 		defer func() {
 			if err := r.Body.Close(); err != nil {
-				log.Printf("error close body in handler UpdateDataJSON: %v\n", err)
+				h.logger.Err.Printf("error close body in handler UpdateDataJSON: %v\n", err)
 			}
 		}()
 
 		reader, errReader := BodyReader(r)
 		if errReader != nil {
-			log.Printf("error get body reader: %v\n", errReader)
-			http.Error(w, errReader.Error(), http.StatusBadRequest)
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
 			return
 		}
 
-		data, err := h.Decrypt(reader)
+		data, err := io.ReadAll(reader)
 		if err != nil {
-			log.Printf("error read body request: %v\n", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			h.logger.Err.Printf("error read body request: %v\n", err)
+			http.Error(w, err.Error(), bodyErrorStatus(err))
 			return
 		}
 
-		var metrics []metricPkg.Metric
-		if err := json.Unmarshal(data, &metrics); err != nil {
-			log.Printf("error decode JSON body: %v\n", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		metrics, err := decodeMetrics(r, data)
+		if err != nil {
+			h.logger.Err.Printf("error decode body: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
 
-		if err := h.store.UpsertBatch(metrics); err != nil {
-			log.Printf("error update metric: %v\n", err)
+		for _, metric := range metrics {
+			if err := metricPkg.ValidateName(metric.ID); err != nil {
+				h.logger.Err.Printf("error validate metric: %v\n", err)
+				http.Error(w, err.Error(), errs.ErrorHTTP(err))
+				return
+			}
+		}
+
+		ctx, span := tracing.Start(r.Context(), "handler.UpdateDataJSON", tracing.MetricTypes(metrics)...)
+		err = h.applyOnce(r, batchScope(metrics), func() error { return h.store.UpsertBatch(ctx, metrics) })
+		tracing.End(span, err)
+
+		if err != nil {
+			h.logger.Err.Printf("error update metric: %v\n", err)
 			http.Error(w, err.Error(), errs.ErrorHTTP(err))
 			return
 		}
@@ -159,3 +221,271 @@ func (h Handler) UpdateDataJSON() http.HandlerFunc {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// ResetCounter Сброс значения counter или float_counter в ноль
+// по URL вида /reset/<ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>
+func (h Handler) ResetCounter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Set(ContentType, TextPlain)
+
+		partsURL := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/reset/"), "/", partsGetURL)
+		if len(partsURL) != partsGetURL ||
+			!metricPkg.IsCounter(partsURL[idxType]) ||
+			len(partsURL[idxName]) == 0 ||
+			strings.Contains(partsURL[idxName], "/") {
+
+			h.logger.Err.Printf("request endpoint %s with invalid URL\n", r.URL.String())
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		metric := metricPkg.Metric{ID: partsURL[idxName], MType: partsURL[idxType]}
+		if err := h.store.Reset(r.Context(), metric); err != nil {
+			h.logger.Err.Printf("could not reset counter: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// DeleteMetric Удаление метрики по URL вида /value/<ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>
+func (h Handler) DeleteMetric() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !h.hasURLContentType(r, TextPlain) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		w.Header().Set(ContentType, TextPlain)
+
+		// оставляем из url только <ТИП_МЕТРИКИ>/<ИМЯ_МЕТРИКИ>
+		// затем разбиваем на массив:
+		// [0] - Тип метрики
+		// [1] - Название метрики
+		dataURL := strings.ReplaceAll(r.URL.Path, "/value/", "")
+		partsURL := strings.Split(dataURL, "/")
+
+		if len(partsURL) != partsGetURL {
+			h.logger.Err.Printf("request endpoint %s with invalid URL\n", r.URL.String())
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		metric, err := metricPkg.CreateMetric(partsURL[idxType], partsURL[idxName])
+		if err != nil {
+			h.logger.Err.Printf("could not create metric: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		if metric.MType != metricPkg.GaugeType &&
+			metric.MType != metricPkg.CounterType &&
+			metric.MType != metricPkg.FloatCounterType &&
+			metric.MType != metricPkg.HistogramType {
+			h.logger.Err.Printf("request delete metric with unknown type: %s\n", metric.MType)
+			http.Error(w, errs.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err := h.store.Delete(r.Context(), metric); err != nil {
+			h.logger.Err.Printf("could not delete metric: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// QueryPrefix Параметр запроса с префиксом имени метрики
+const QueryPrefix = "prefix"
+
+// deleteSummary Итог удаления набора метрик
+type deleteSummary struct {
+	Deleted int `json:"deleted"`
+}
+
+// DeleteMetrics Удаление всех метрик с префиксом имени и/или типом:
+// /metrics?prefix=<ПРЕФИКС>&type=<ТИП_МЕТРИКИ>.
+// Нужен хотя бы один из фильтров, при обоих удаляются метрики, подходящие под оба.
+// Возвращается количество удаленных метрик в формате JSON
+func (h Handler) DeleteMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		query := r.URL.Query()
+		prefix := query.Get(QueryPrefix)
+		typeMetric := query.Get(QueryType)
+
+		if len(prefix) == 0 && len(typeMetric) == 0 {
+			h.logger.Warn.Println("request delete metrics without filters")
+			http.Error(w, "prefix or type is required", http.StatusBadRequest)
+			return
+		}
+
+		if len(typeMetric) != 0 && !knownType(typeMetric) {
+			h.logger.Warn.Printf("request delete metrics with unknown type %q\n", typeMetric)
+			http.Error(w, errs.ErrUnknownType.Error(), http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := h.store.DeleteWhere(r.Context(), func(metric metricPkg.Metric) bool {
+			return strings.HasPrefix(metric.ID, prefix) && (len(typeMetric) == 0 || metric.MType == typeMetric)
+		})
+		if err != nil {
+			h.logger.Err.Printf("could not delete metrics: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		h.logger.Info.Printf("deleted %d metrics with prefix %q and type %q\n", deleted, prefix, typeMetric)
+
+		encode, errEncode := json.Marshal(deleteSummary{Deleted: deleted})
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode delete result to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// importSummary Итог загрузки метрик: количество загруженных и пропущенных строк
+type importSummary struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// Import Загрузка метрик в формате NDJSON, в котором их выгружает Export.
+// Тело запроса может быть сжато. Метрики записываются наборами по importBatchSize штук.
+// Counter и float_counter добавляются к сохраненному значению, а с параметром mode=replace заменяют его.
+// Строки, которые не удалось разобрать, пропускаются и учитываются в ответе {imported, skipped}
+func (h Handler) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !hasContentType(r, ApplicationNDJSON) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		replace := false
+		switch mode := r.URL.Query().Get("mode"); mode {
+		case "", ImportModeAdd:
+		case ImportModeReplace:
+			replace = true
+		default:
+			http.Error(w, fmt.Sprintf("unknown import mode: %s", mode), http.StatusBadRequest)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				h.logger.Err.Printf("error close body in handler Import: %v\n", err)
+			}
+		}()
+
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxImportLine)
+
+		var summary importSummary
+		batch := make([]metricPkg.Metric, 0, h.importBatchSize)
+
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+
+			if err := h.importBatch(r.Context(), batch, replace); err != nil {
+				return err
+			}
+
+			summary.Imported += len(batch)
+			batch = batch[:0]
+			return nil
+		}
+
+		for line := 1; scanner.Scan(); line++ {
+
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+
+			var metric metricPkg.Metric
+			if err := json.Unmarshal(data, &metric); err != nil {
+				h.logger.Warn.Printf("import: skip line %d: %v\n", line, err)
+				summary.Skipped++
+				continue
+			}
+
+			if err := metric.Validate(); err != nil {
+				h.logger.Warn.Printf("import: skip line %d: %v\n", line, err)
+				summary.Skipped++
+				continue
+			}
+
+			batch = append(batch, metric)
+			if len(batch) < h.importBatchSize {
+				continue
+			}
+
+			if err := flush(); err != nil {
+				h.logger.Err.Printf("error import metrics: %v\n", err)
+				http.Error(w, err.Error(), errs.ErrorHTTP(err))
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			h.logger.Err.Printf("error read body request: %v\n", err)
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+
+		if err := flush(); err != nil {
+			h.logger.Err.Printf("error import metrics: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		encode, errEncode := json.Marshal(summary)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode import summary to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// importBatch Запись набора загружаемых метрик.
+// В режиме замены набор записывается через Replacer, если хранилище его поддерживает.
+// Остальные хранилища сами сохраняют переданные значения, а из повторов остается последнее
+func (h Handler) importBatch(ctx context.Context, batch []metricPkg.Metric, replace bool) error {
+
+	if replacer, ok := h.store.(Replacer); ok && replace {
+		return replacer.ReplaceBatch(ctx, batch)
+	}
+
+	return h.store.UpsertBatch(ctx, batch)
+}
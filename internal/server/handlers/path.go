@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NormalizePath Middleware Нормализация пути запроса до маршрутизации:
+// повторяющиеся слэши схлопываются, один завершающий слэш удаляется.
+// Корневой путь "/" не изменяется. Пустые сегменты внутри пути не заполняются,
+// поэтому путь без имени или значения метрики по-прежнему не проходит проверку обработчика
+func (h Handler) NormalizePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		path := normalizePath(r.URL.Path)
+		if path != r.URL.Path {
+			h.logger.Debug.Printf("request path %q normalized to %q\n", r.URL.Path, path)
+
+			r.URL.Path = path
+			if len(r.URL.RawPath) != 0 {
+				r.URL.RawPath = normalizePath(r.URL.RawPath)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// normalizePath Схлопывание повторяющихся слэшей и удаление одного завершающего слэша
+func normalizePath(path string) string {
+
+	if !strings.Contains(path, "//") && (len(path) <= 1 || !strings.HasSuffix(path, "/")) {
+		return path
+	}
+
+	builder := strings.Builder{}
+	builder.Grow(len(path))
+
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+
+		builder.WriteByte(path[i])
+	}
+
+	normalized := builder.String()
+	if len(normalized) > 1 {
+		normalized = strings.TrimSuffix(normalized, "/")
+	}
+
+	return normalized
+}
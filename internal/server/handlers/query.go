@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// QueryPromQuery Параметр запроса /api/v1/query с именем метрики
+const QueryPromQuery = "query"
+
+// Значения полей ответа API запросов Prometheus
+const (
+	promStatusSuccess = "success"
+	promStatusError   = "error"
+	promResultVector  = "vector"
+	promErrorBadData  = "bad_data"
+)
+
+type (
+	// promQueryResponse Ответ API запросов Prometheus
+	promQueryResponse struct {
+		Status    string          `json:"status"`
+		Data      *promVectorData `json:"data,omitempty"`
+		ErrorType string          `json:"errorType,omitempty"`
+		Error     string          `json:"error,omitempty"`
+	}
+
+	// promVectorData Результат запроса типа vector
+	promVectorData struct {
+		ResultType string             `json:"resultType"`
+		Result     []promVectorSample `json:"result"`
+	}
+
+	// promVectorSample Значение одного временного ряда: метки и пара [время в секундах, значение строкой]
+	promVectorSample struct {
+		Metric map[string]string `json:"metric"`
+		Value  [2]interface{}    `json:"value"`
+	}
+)
+
+// Query Текущее значение метрики в формате API запросов Prometheus:
+// /api/v1/query?query=<ИМЯ_МЕТРИКИ>. Поддерживается только имя метрики, а не выражения PromQL.
+// Каждая метрика с этим именем (разных типов и меток) возвращается отдельным рядом с меткой __name__.
+// Гистограммы не имеют одного значения и пропускаются.
+// Для неизвестной метрики возвращается пустой результат
+func (h Handler) Query() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		name := r.FormValue(QueryPromQuery)
+		if len(name) == 0 {
+			h.logger.Warn.Println("prometheus query without metric name")
+			h.writeQueryResponse(w, http.StatusBadRequest, promQueryResponse{
+				Status:    promStatusError,
+				ErrorType: promErrorBadData,
+				Error:     errs.ErrInvalidID.Error(),
+			})
+			return
+		}
+
+		metrics, err := h.store.GetBatch(r.Context())
+		if err != nil {
+			h.logger.Err.Printf("could not get metrics from storage: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		now := float64(time.Now().UnixNano()/int64(time.Millisecond)) / 1000
+		data := &promVectorData{
+			ResultType: promResultVector,
+			Result:     []promVectorSample{},
+		}
+
+		for _, metric := range metrics {
+			if metric.ID != name || metric.MType == metricPkg.HistogramType {
+				continue
+			}
+
+			labels := make(map[string]string, len(metric.Labels)+1)
+			for label, value := range metric.Labels {
+				labels[label] = value
+			}
+			labels[promNameLabel] = metric.ID
+
+			data.Result = append(data.Result, promVectorSample{
+				Metric: labels,
+				Value:  [2]interface{}{now, metric.StringValue()},
+			})
+		}
+
+		sort.Slice(data.Result, func(i, j int) bool {
+			return metricPkg.Labels(data.Result[i].Metric).String() < metricPkg.Labels(data.Result[j].Metric).String()
+		})
+
+		h.writeQueryResponse(w, http.StatusOK, promQueryResponse{Status: promStatusSuccess, Data: data})
+	}
+}
+
+// writeQueryResponse Запись ответа API запросов Prometheus
+func (h Handler) writeQueryResponse(w http.ResponseWriter, status int, response promQueryResponse) {
+
+	encode, errEncode := json.Marshal(response)
+	if errEncode != nil {
+		h.logger.Err.Printf("error encode query result to JSON: %v\n", errEncode)
+		http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(ContentType, ApplicationJSON)
+	w.WriteHeader(status)
+
+	if _, err := w.Write(encode); err != nil {
+		h.logger.Err.Printf("error write data in response body: %v\n", err)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitClients Максимальное количество клиентов, для которых хранятся ограничители
+const rateLimitClients = 10000
+
+// rateLimiter Ограничение частоты запросов для каждого IP адреса клиента
+type rateLimiter struct {
+	limit    rate.Limit
+	burst    int
+	limiters *lru.Cache
+}
+
+// WithRateLimit Ограничение частоты запросов rps в секунду с допустимым всплеском burst для каждого клиента.
+// При rps <= 0 частота запросов не ограничивается
+func WithRateLimit(rps float64, burst int) OptionsHandler {
+	return func(h *Handler) {
+
+		if rps <= 0 {
+			return
+		}
+
+		if burst < 1 {
+			burst = 1
+		}
+
+		limiters, err := lru.New(rateLimitClients)
+		if err != nil {
+			h.logger.Err.Printf("failed create rate limiters cache: %v\n", err)
+			return
+		}
+
+		h.rateLimiter = &rateLimiter{
+			limit:    rate.Limit(rps),
+			burst:    burst,
+			limiters: limiters,
+		}
+	}
+}
+
+// limiter Ограничитель частоты запросов клиента
+func (rl *rateLimiter) limiter(client string) *rate.Limiter {
+
+	if cached, ok := rl.limiters.Get(client); ok {
+		return cached.(*rate.Limiter)
+	}
+
+	limiter := rate.NewLimiter(rl.limit, rl.burst)
+	if prev, ok, _ := rl.limiters.PeekOrAdd(client, limiter); ok {
+		return prev.(*rate.Limiter)
+	}
+
+	return limiter
+}
+
+// RateLimit Middleware Ограничение частоты запросов по IP адресу клиента.
+// При превышении отправляется ответ 429 с заголовком Retry-After
+func (h Handler) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if h.rateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := "unknown"
+		if ip := ClientIP(r); ip != nil {
+			client = ip.String()
+		}
+
+		reservation := h.rateLimiter.limiter(client).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
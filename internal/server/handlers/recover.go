@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover Middleware Перехват паники в обработчиках и middleware с ответом 500.
+// Паника http.ErrAbortHandler передается дальше, чтобы сервер прервал ответ
+func (h Handler) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			h.logger.Err.Printf("panic in handler %s %s: %v\n%s", r.Method, r.RequestURI, rec, debug.Stack())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
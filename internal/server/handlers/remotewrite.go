@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"metrics-and-alerting/internal/tracing"
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	ApplicationProtobuf = "application/x-protobuf"
+	Snappy              = "snappy"
+
+	// promNameLabel Метка Prometheus с именем метрики
+	promNameLabel = "__name__"
+
+	// maxSnappyDecodedLen Максимальный размер тела запроса после распаковки snappy.
+	// Размер проверяется до распаковки, так как блок snappy распаковывается целиком
+	maxSnappyDecodedLen = 64 << 20
+)
+
+// Номера полей сообщений remote write Prometheus (prompb)
+const (
+	fieldWriteTimeSeries protowire.Number = 1 // WriteRequest.timeseries
+	fieldSeriesLabels    protowire.Number = 1 // TimeSeries.labels
+	fieldSeriesSamples   protowire.Number = 2 // TimeSeries.samples
+	fieldLabelName       protowire.Number = 1 // Label.name
+	fieldLabelValue      protowire.Number = 2 // Label.value
+	fieldSampleValue     protowire.Number = 1 // Sample.value
+	fieldSampleTimestamp protowire.Number = 2 // Sample.timestamp
+)
+
+type (
+	// promSeries Временной ряд Prometheus: метки и значения
+	promSeries struct {
+		labels  map[string]string
+		samples []promSample
+	}
+
+	// promSample Значение временного ряда Prometheus
+	promSample struct {
+		value     float64
+		timestamp int64
+	}
+
+	// snappyReader Распаковка тела, сжатого блоком snappy, при первом чтении
+	snappyReader struct {
+		src     io.Reader
+		decoded *bytes.Reader
+	}
+)
+
+// RemoteWrite Прием метрик по протоколу remote write Prometheus: сообщение WriteRequest
+// в формате protobuf, сжатое snappy.
+// Каждый временной ряд сохраняется как gauge с именем из метки __name__ и остальными метками,
+// значение берется из последнего по времени отсчета ряда.
+// Ряды без имени или отсчетов, с некорректным именем и значения NaN и Inf пропускаются,
+// как и не поддерживаемые гистограммы и exemplars
+func (h Handler) RemoteWrite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !hasContentType(r, ApplicationProtobuf) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				h.logger.Err.Printf("error close body in handler RemoteWrite: %v\n", err)
+			}
+		}()
+
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			h.logger.Err.Printf("error read body request: %v\n", err)
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+
+		series, err := decodeWriteRequest(data)
+		if err != nil {
+			h.logger.Err.Printf("error decode remote write request: %v\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		metrics := make([]metricPkg.Metric, 0, len(series))
+		for _, s := range series {
+
+			metric, err := s.gauge()
+			if err != nil {
+				h.logger.Debug.Printf("remote write: skip series %v: %v\n", s.labels, err)
+				continue
+			}
+
+			metrics = append(metrics, metric)
+		}
+
+		if len(metrics) != 0 {
+			ctx, span := tracing.Start(r.Context(), "handler.RemoteWrite", tracing.MetricTypes(metrics)...)
+			err = h.store.UpsertBatch(ctx, metrics)
+			tracing.End(span, err)
+
+			if err != nil {
+				h.logger.Err.Printf("error update metrics: %v\n", err)
+				http.Error(w, err.Error(), errs.ErrorHTTP(err))
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// gauge Gauge с именем и метками временного ряда и значением последнего по времени отсчета
+func (s promSeries) gauge() (metricPkg.Metric, error) {
+
+	name := s.labels[promNameLabel]
+	if len(name) == 0 {
+		return metricPkg.Metric{}, fmt.Errorf("%w: label %s is empty", errs.ErrInvalidID, promNameLabel)
+	}
+
+	if len(s.samples) == 0 {
+		return metricPkg.Metric{}, errs.ErrInvalidValue
+	}
+
+	last := s.samples[0]
+	for _, sample := range s.samples[1:] {
+		if sample.timestamp >= last.timestamp {
+			last = sample
+		}
+	}
+
+	if math.IsNaN(last.value) || math.IsInf(last.value, 0) {
+		return metricPkg.Metric{}, errs.ErrInvalidValue
+	}
+
+	labels := make(metricPkg.Labels, len(s.labels)-1)
+	for labelName, value := range s.labels {
+		if labelName != promNameLabel {
+			labels[labelName] = value
+		}
+	}
+
+	metric, err := metricPkg.CreateMetric(metricPkg.GaugeType, name,
+		metricPkg.WithValueFloat(last.value),
+		metricPkg.WithLabels(labels))
+	if err != nil {
+		return metricPkg.Metric{}, err
+	}
+
+	if err := metric.Validate(); err != nil {
+		return metricPkg.Metric{}, err
+	}
+
+	return metric, nil
+}
+
+// decodeWriteRequest Разбор сообщения WriteRequest. Неизвестные поля пропускаются
+func decodeWriteRequest(data []byte) ([]promSeries, error) {
+
+	series := make([]promSeries, 0)
+
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+
+		if num != fieldWriteTimeSeries || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+
+		s, err := decodeSeries(msg)
+		if err != nil {
+			return 0, err
+		}
+
+		series = append(series, s)
+		return n, nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decode WriteRequest: %w", err)
+	}
+
+	return series, nil
+}
+
+// decodeSeries Разбор сообщения TimeSeries
+func decodeSeries(data []byte) (promSeries, error) {
+
+	s := promSeries{labels: make(map[string]string)}
+
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+
+		if typ != protowire.BytesType || (num != fieldSeriesLabels && num != fieldSeriesSamples) {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+
+		if num == fieldSeriesLabels {
+			name, value, err := decodeLabel(msg)
+			if err != nil {
+				return 0, err
+			}
+
+			s.labels[name] = value
+			return n, nil
+		}
+
+		sample, err := decodeSample(msg)
+		if err != nil {
+			return 0, err
+		}
+
+		s.samples = append(s.samples, sample)
+		return n, nil
+	})
+
+	return s, err
+}
+
+// decodeLabel Разбор сообщения Label
+func decodeLabel(data []byte) (name, value string, err error) {
+
+	err = consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+
+		if typ != protowire.BytesType || (num != fieldLabelName && num != fieldLabelValue) {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		v, n := protowire.ConsumeString(b)
+		if num == fieldLabelName {
+			name = v
+		} else {
+			value = v
+		}
+
+		return n, nil
+	})
+
+	return name, value, err
+}
+
+// decodeSample Разбор сообщения Sample
+func decodeSample(data []byte) (promSample, error) {
+
+	var sample promSample
+
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+
+		switch {
+		case num == fieldSampleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			sample.value = math.Float64frombits(v)
+			return n, nil
+
+		case num == fieldSampleTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sample.timestamp = int64(v)
+			return n, nil
+
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+
+	return sample, err
+}
+
+// consumeFields Перебор полей сообщения protobuf.
+// Функция field разбирает значение поля и возвращает количество прочитанных байт
+// или отрицательный код ошибки protowire
+func consumeFields(data []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+
+	for len(data) > 0 {
+
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// newSnappyReader Распаковка тела, сжатого блоком snappy, как того требует remote write Prometheus
+func newSnappyReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(&snappyReader{src: r}), nil
+}
+
+func (r *snappyReader) Read(p []byte) (int, error) {
+
+	if r.decoded == nil {
+
+		data, err := io.ReadAll(r.src)
+		if err != nil {
+			return 0, err
+		}
+
+		size, err := snappy.DecodedLen(data)
+		if err != nil {
+			return 0, fmt.Errorf("could not decode snappy block: %w", err)
+		}
+
+		if size > maxSnappyDecodedLen {
+			return 0, fmt.Errorf("%w: snappy block of %d bytes", ErrBodyTooLarge, size)
+		}
+
+		decoded, err := snappy.Decode(nil, data)
+		if err != nil {
+			return 0, fmt.Errorf("could not decode snappy block: %w", err)
+		}
+
+		r.decoded = bytes.NewReader(decoded)
+	}
+
+	return r.decoded.Read(p)
+}
@@ -0,0 +1,28 @@
+package handler
+
+import "net/http"
+
+// RequestCounter Учет обработанных запросов
+type RequestCounter interface {
+	CountRequest()
+}
+
+// WithRequestCounter Учет каждого запроса к серверу в counter
+func WithRequestCounter(counter RequestCounter) OptionsHandler {
+	return func(h *Handler) {
+		h.requests = counter
+	}
+}
+
+// CountRequests Учет запроса перед его обработкой
+func (h Handler) CountRequests(next http.Handler) http.Handler {
+
+	if h.requests == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.requests.CountRequest()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// Параметры запроса поиска метрик
+const (
+	QuerySearch = "q"
+	QueryType   = "type"
+	QueryLimit  = "limit"
+)
+
+// DefaultSearchLimit Количество метрик в ответе на поиск по умолчанию
+const DefaultSearchLimit = 100
+
+type (
+	// metricSummary Краткие сведения о метрике в результате поиска
+	metricSummary struct {
+		ID     string           `json:"id"`
+		MType  string           `json:"type"`
+		Labels metricPkg.Labels `json:"labels,omitempty"`
+		Value  string           `json:"value"`
+	}
+
+	// searchResult Результат поиска: общее количество найденных метрик и первые limit из них
+	searchResult struct {
+		Total   int             `json:"total"`
+		Metrics []metricSummary `json:"metrics"`
+	}
+)
+
+// Search Поиск метрик по подстроке имени без учета регистра и типу:
+// /metrics/search?q=<ПОДСТРОКА>&type=<ТИП_МЕТРИКИ>&limit=<КОЛИЧЕСТВО>.
+// Оба фильтра необязательны. Метрики упорядочены по типу, имени и меткам,
+// возвращается не больше limit метрик (по умолчанию DefaultSearchLimit).
+// Значения форматируются только для метрик, попавших в ответ
+func (h Handler) Search() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		query := r.URL.Query()
+
+		limit, errLimit := searchLimit(query.Get(QueryLimit))
+		if errLimit != nil {
+			h.logger.Warn.Printf("search with incorrect limit: %v\n", errLimit)
+			http.Error(w, errLimit.Error(), http.StatusBadRequest)
+			return
+		}
+
+		typeMetric := query.Get(QueryType)
+		if len(typeMetric) != 0 && !knownType(typeMetric) {
+			h.logger.Warn.Printf("search with unknown metric type %q\n", typeMetric)
+			http.Error(w, errs.ErrUnknownType.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var metrics []metricPkg.Metric
+		var err error
+
+		if len(typeMetric) != 0 {
+			metrics, err = h.store.GetByType(r.Context(), typeMetric)
+		} else {
+			metrics, err = h.store.GetBatch(r.Context())
+		}
+
+		if err != nil {
+			h.logger.Err.Printf("could not get metrics from storage: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		metrics = filterBySubstring(metrics, query.Get(QuerySearch))
+
+		sort.Slice(metrics, func(i, j int) bool {
+			if metrics[i].MType != metrics[j].MType {
+				return metrics[i].MType < metrics[j].MType
+			}
+
+			if metrics[i].ID != metrics[j].ID {
+				return metrics[i].ID < metrics[j].ID
+			}
+
+			return metrics[i].Labels.String() < metrics[j].Labels.String()
+		})
+
+		result := searchResult{
+			Total:   len(metrics),
+			Metrics: make([]metricSummary, 0, minInt(limit, len(metrics))),
+		}
+
+		for _, metric := range metrics[:minInt(limit, len(metrics))] {
+			result.Metrics = append(result.Metrics, metricSummary{
+				ID:     metric.ID,
+				MType:  metric.MType,
+				Labels: metric.Labels,
+				Value:  metric.StringValue(),
+			})
+		}
+
+		encode, errEncode := json.Marshal(result)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode search result to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// searchLimit Разбор параметра limit. Пустое значение соответствует DefaultSearchLimit
+func searchLimit(value string) (int, error) {
+
+	if len(value) == 0 {
+		return DefaultSearchLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("limit %q must be a positive integer", value)
+	}
+
+	return limit, nil
+}
+
+// knownType Признак поддерживаемого типа метрики
+func knownType(typeMetric string) bool {
+
+	for _, known := range metricPkg.Types {
+		if typeMetric == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterBySubstring Отбор метрик, имя которых содержит substr без учета регистра, без выделения памяти.
+// Пустая substr оставляет все метрики
+func filterBySubstring(metrics []metricPkg.Metric, substr string) []metricPkg.Metric {
+
+	if len(substr) == 0 {
+		return metrics
+	}
+
+	filtered := metrics[:0]
+	for _, metric := range metrics {
+		if containsFold(metric.ID, substr) {
+			filtered = append(filtered, metric)
+		}
+	}
+
+	return filtered
+}
+
+// containsFold Поиск подстроки без учета регистра без преобразования строк.
+// Сравниваются участки s длиной substr в байтах, что верно для имен метрик в ASCII
+func containsFold(s, substr string) bool {
+
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// StatsReader Статистика по последним значениям gauge
+type StatsReader interface {
+	GaugeStats(ctx context.Context, metric metricPkg.Metric) (metricPkg.Stats, error)
+}
+
+// GaugeStats Статистика gauge по URL вида /value/gauge/<ИМЯ_МЕТРИКИ>/stats
+// в формате JSON {last, min, max, avg, count}.
+// Если статистика не считается или значений метрики еще не было, возвращается 404
+func (h Handler) GaugeStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/value/gauge/"), "/stats")
+		if len(id) == 0 || strings.Contains(id, "/") {
+			h.logger.Err.Printf("request endpoint %s with invalid URL\n", r.URL.String())
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		reader, ok := h.store.(StatsReader)
+		if !ok {
+			http.Error(w, errs.ErrNoStats.Error(), http.StatusNotFound)
+			return
+		}
+
+		stats, err := reader.GaugeStats(r.Context(), metricPkg.Metric{ID: id, MType: metricPkg.GaugeType})
+		if err != nil {
+			h.logger.Debug.Printf("could not get gauge stats: %v\n", err)
+			http.Error(w, err.Error(), errs.ErrorHTTP(err))
+			return
+		}
+
+		encode, errEncode := json.Marshal(stats)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode gauge stats to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"metrics-and-alerting/internal/pubsub"
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/pkg/errs"
+
+	"github.com/gorilla/websocket"
+)
+
+// Параметры потока обновлений метрик
+const (
+	// streamWriteWait Время на отправку одного обновления клиенту
+	streamWriteWait = 10 * time.Second
+
+	// streamPingPeriod Период проверки соединения с клиентом
+	streamPingPeriod = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// WithBroker Источник обновлений метрик для потока /stream
+func WithBroker(broker *pubsub.Broker) OptionsHandler {
+	return func(h *Handler) {
+		h.broker = broker
+	}
+}
+
+// Stream Поток обновлений метрик через WebSocket: /stream?type=<ТИП_МЕТРИКИ>.
+// Каждое сохраненное обновление отправляется сообщением JSON с id, type и value.
+// Фильтр по типу необязателен. Отправляются только обновления метрик арендатора запроса.
+// Клиент, не успевающий читать обновления, отключается, чтобы не задерживать запись метрик
+func (h Handler) Stream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if h.broker == nil {
+			http.Error(w, "stream is not enabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		typeMetric := r.URL.Query().Get(QueryType)
+		if len(typeMetric) != 0 && !knownType(typeMetric) {
+			h.logger.Warn.Printf("stream with unknown metric type %q\n", typeMetric)
+			http.Error(w, errs.ErrUnknownType.Error(), http.StatusBadRequest)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade сам отвечает клиенту при ошибке
+			h.logger.Err.Printf("could not upgrade connection to websocket: %v\n", err)
+			return
+		}
+
+		defer func() {
+			if err := conn.Close(); err != nil {
+				h.logger.Debug.Printf("error close websocket connection: %v\n", err)
+			}
+		}()
+
+		owner := tenant.FromContext(r.Context())
+
+		sub := h.broker.Subscribe(typeMetric, pubsub.DefaultBufferSize)
+		defer h.broker.Unsubscribe(sub)
+
+		closed := make(chan struct{})
+		go discardMessages(conn, closed)
+
+		ping := time.NewTicker(streamPingPeriod)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+					h.logger.Debug.Printf("stream ping failed: %v\n", err)
+					return
+				}
+
+			case metric, ok := <-sub.C:
+				if !ok {
+					h.logger.Warn.Printf("stream client %s is too slow, disconnecting\n", r.RemoteAddr)
+					message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow")
+					_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(streamWriteWait))
+					return
+				}
+
+				// Обновления метрик других арендаторов не отправляются
+				if metric, ok = tenant.Unscope(owner, metric); !ok {
+					continue
+				}
+
+				if err := conn.SetWriteDeadline(time.Now().Add(streamWriteWait)); err != nil {
+					return
+				}
+
+				if err := conn.WriteJSON(newFlatMetric(metric)); err != nil {
+					h.logger.Debug.Printf("could not send metric to stream: %v\n", err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// discardMessages Чтение и отбрасывание сообщений клиента до закрытия соединения.
+// Чтение нужно для обработки управляющих сообщений и обнаружения отключения клиента
+func discardMessages(conn *websocket.Conn, closed chan<- struct{}) {
+
+	defer close(closed)
+
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
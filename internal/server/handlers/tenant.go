@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"metrics-and-alerting/internal/tenant"
+)
+
+// Tenant Middleware Арендатор запроса из заголовка X-Tenant-Id.
+// Метрики разных арендаторов хранятся в разных пространствах имен,
+// поэтому одинаковые имена метрик разных арендаторов не пересекаются.
+// Запрос без заголовка относится к арендатору tenant.Default,
+// запрос с некорректным идентификатором отклоняется с кодом 400
+func (h Handler) Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id := strings.TrimSpace(r.Header.Get(XTenantID))
+		if len(id) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := tenant.Validate(id); err != nil {
+			h.logger.Warn.Printf("request with incorrect tenant: %v\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), id)))
+	})
+}
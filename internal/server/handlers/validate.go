@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+type (
+	// Checker Проверка метрики так же, как при ее обновлении, без сохранения
+	Checker interface {
+		Check(metric metricPkg.Metric) error
+	}
+
+	// validationResult Результат проверки одной метрики набора
+	validationResult struct {
+		ID    string `json:"id"`
+		Valid bool   `json:"valid"`
+		Error string `json:"error,omitempty"`
+	}
+)
+
+// Validate Проверка набора метрик в формате /updates/ без сохранения.
+// Для каждой метрики выполняются те же проверки полей, имени и подписи, что и при обновлении.
+// Возвращается результат {id, valid, error} для каждой метрики в порядке набора
+func (h Handler) Validate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !hasContentType(r, ApplicationJSON) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		defer func() {
+			if err := r.Body.Close(); err != nil {
+				h.logger.Err.Printf("error close body in handler Validate: %v\n", err)
+			}
+		}()
+
+		reader, errReader := BodyReader(r)
+		if errReader != nil {
+			h.logger.Err.Printf("error get body reader: %v\n", errReader)
+			http.Error(w, errReader.Error(), bodyErrorStatus(errReader))
+			return
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			h.logger.Err.Printf("error read body request: %v\n", err)
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			h.logger.Err.Printf("error decode JSON body: %v\n", err)
+			http.Error(w, errs.ErrInvalidJSON.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results := make([]validationResult, 0, len(items))
+		for _, item := range items {
+			results = append(results, h.validateItem(item))
+		}
+
+		encode, errEncode := json.Marshal(results)
+		if errEncode != nil {
+			h.logger.Err.Printf("error encode validation result to JSON: %v\n", errEncode)
+			http.Error(w, errEncode.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(ContentType, ApplicationJSON)
+
+		if _, err := w.Write(encode); err != nil {
+			h.logger.Err.Printf("error write data in response body: %v\n", err)
+		}
+	}
+}
+
+// validateItem Проверка одной метрики набора.
+// Если метрику не удалось разобрать, ID берется из поля id, если оно есть
+func (h Handler) validateItem(item json.RawMessage) validationResult {
+
+	metric, err := metricPkg.DecodeJSON(item)
+	if err != nil {
+		var named struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(item, &named)
+
+		return validationResult{ID: named.ID, Error: err.Error()}
+	}
+
+	if err := metricPkg.ValidateName(metric.ID); err != nil {
+		return validationResult{ID: metric.ID, Error: err.Error()}
+	}
+
+	if checker, ok := h.store.(Checker); ok {
+		err = checker.Check(metric)
+	} else {
+		err = metric.Validate()
+	}
+
+	if err != nil {
+		return validationResult{ID: metric.ID, Error: err.Error()}
+	}
+
+	return validationResult{ID: metric.ID, Valid: true}
+}
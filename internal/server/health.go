@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"metrics-and-alerting/internal/storage"
+)
+
+// flushStorage Сохранение метрик хранилищем с учетом времени последнего успешного сохранения
+func (manager MetricsManager) flushStorage(ctx context.Context) error {
+
+	if err := manager.storage.Flush(ctx); err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(manager.lastFlush, manager.clock.Now().UnixNano())
+	return nil
+}
+
+// SyncFlush Сохраняются ли метрики при каждом изменении
+func (manager MetricsManager) SyncFlush() bool {
+	return manager.intervalFlush == 0
+}
+
+// LastFlush Время последнего успешного сохранения метрик.
+// Если метрики еще не сохранялись, возвращается нулевое время
+func (manager MetricsManager) LastFlush() time.Time {
+
+	nano := atomic.LoadInt64(manager.lastFlush)
+	if nano == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nano)
+}
+
+// PoolStats Статистика пула соединений хранилища, если хранилище работает с базой данных
+func (manager MetricsManager) PoolStats() (sql.DBStats, bool) {
+
+	pool, ok := storage.Unwrap(manager.storage).(interface{ Stats() sql.DBStats })
+	if !ok {
+		return sql.DBStats{}, false
+	}
+
+	return pool.Stats(), true
+}
+
+// Save Немедленное сохранение метрик независимо от интервала сохранения.
+// Возвращается количество сохраненных метрик
+func (manager MetricsManager) Save(ctx context.Context) (int, error) {
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	metrics, err := manager.storage.GetBatch(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not save metrics: %w", err)
+	}
+
+	if err := manager.flushStorage(ctx); err != nil {
+		return 0, fmt.Errorf("could not save metrics: %w", err)
+	}
+
+	return len(metrics), nil
+}
@@ -2,60 +2,235 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
 
 	handler "metrics-and-alerting/internal/server/handlers"
 
 	"github.com/go-chi/chi"
 )
 
-type OptionsServer func(*MetricsServer)
+// Тайм-ауты HTTP сервера по умолчанию
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 10 * time.Second
+	DefaultWriteTimeout      = 10 * time.Second
+	DefaultIdleTimeout       = 60 * time.Second
+)
+
+type (
+	OptionsServer func(*MetricsServer)
+
+	// Timeouts Тайм-ауты HTTP сервера. 0 - тайм-аут не ограничен
+	Timeouts struct {
+		ReadHeader time.Duration // чтение заголовков запроса
+		Read       time.Duration // чтение всего запроса
+		Write      time.Duration // запись ответа
+		Idle       time.Duration // ожидание следующего запроса в keep-alive соединении
+	}
+
+	MetricsServer struct {
+		HTTP       *http.Server
+		privateKey []byte
+		profiling  bool
+		certFile   string
+		keyFile    string
+		tlsConfig  *tls.Config
+		timeouts   Timeouts
+		basePath   string // префикс пути всех маршрутов, пустой - маршруты от корня
+	}
+)
+
+// DefaultTimeouts Тайм-ауты HTTP сервера по умолчанию
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		ReadHeader: DefaultReadHeaderTimeout,
+		Read:       DefaultReadTimeout,
+		Write:      DefaultWriteTimeout,
+		Idle:       DefaultIdleTimeout,
+	}
+}
+
+// WithProfiling Регистрация обработчиков net/http/pprof по пути /debug/pprof/
+func WithProfiling(enable bool) OptionsServer {
+	return func(serv *MetricsServer) {
+		serv.profiling = enable
+	}
+}
+
+// WithTLS Обслуживание запросов по HTTPS с сертификатом certFile и закрытым ключом keyFile.
+// Если сертификат или ключ не заданы, сервер работает по HTTP
+func WithTLS(certFile, keyFile string, config *tls.Config) OptionsServer {
+	return func(serv *MetricsServer) {
+		serv.certFile = certFile
+		serv.keyFile = keyFile
+		serv.tlsConfig = config
+	}
+}
+
+// WithTimeouts Тайм-ауты HTTP сервера, ограничивающие медленных клиентов.
+// Без опции используются DefaultTimeouts
+func WithTimeouts(timeouts Timeouts) OptionsServer {
+	return func(serv *MetricsServer) {
+		serv.timeouts = timeouts
+	}
+}
 
-type MetricsServer struct {
-	HTTP       *http.Server
-	privateKey []byte
+// WithBasePath Префикс пути всех маршрутов сервера, например /metrics-server,
+// когда сервер доступен через общий ingress. Запросы без префикса отклоняются с кодом 404
+func WithBasePath(basePath string) OptionsServer {
+	return func(serv *MetricsServer) {
+		serv.basePath = NormalizeBasePath(basePath)
+	}
 }
 
-func NewHTTPServer(addr string, h *handler.Handler) *MetricsServer {
+// NormalizeBasePath Приведение префикса пути к виду /<путь> без завершающего слэша.
+// Пустой префикс и "/" означают маршруты от корня
+func NormalizeBasePath(basePath string) string {
+
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if len(basePath) == 0 {
+		return ""
+	}
+
+	return "/" + basePath
+}
+
+func NewHTTPServer(addr string, h *handler.Handler, opts ...OptionsServer) *MetricsServer {
+
+	serv := &MetricsServer{timeouts: DefaultTimeouts()}
+	for _, opt := range opts {
+		opt(serv)
+	}
 
 	r := chi.NewRouter()
-	r.Use(h.DecompressRequest)
+	r.Use(h.Recover)
+	r.Use(h.NormalizePath)
+	r.Use(h.CountRequests)
+	r.Use(h.Logging)
+	r.Use(h.Tracing)
+	r.Use(h.Tenant)
+	r.Use(h.RateLimit)
+	r.Use(h.LimitBody)
+	r.Use(h.Compress)
 	r.Use(h.Trust)
-	//r.Use(middleware.Logger)
 
 	r.Get("/ping", h.Ping())
 	r.Get("/ping/", h.Ping())
+	r.Get("/healthz", h.Healthz())
 
 	r.Get("/", h.GetMetrics())
+	r.Get("/metrics/count", h.GetCount())
+	r.Get("/metrics/search", h.Search())
+	r.With(h.AdminTrust).Delete("/metrics", h.DeleteMetrics())
+	r.Get("/value/gauge/{id}/stats", h.GaugeStats())
 	r.Get("/value/*", h.GetAsText())
+	r.Delete("/value/*", h.DeleteMetric())
 	r.Post("/value", h.GetAsJSON())
 	r.Post("/value/", h.GetAsJSON())
+	r.Post("/values", h.GetBatchJSON())
+	r.Post("/values/", h.GetBatchJSON())
+	r.Get("/export", h.Export())
+	r.Get("/stream", h.Stream())
+	r.Post("/diff", h.Diff())
 
 	r.Post("/update/*", h.UpdateURL())
-	r.Post("/update", h.UpdateJSON())
-	r.Post("/update/", h.UpdateJSON())
-	r.Post("/updates", h.UpdateDataJSON())
-	r.Post("/updates/", h.UpdateDataJSON())
+	r.Post("/reset/counter/*", h.ResetCounter())
+	r.Post("/reset/float_counter/*", h.ResetCounter())
+	r.Post("/import", h.Import())
+	r.Post("/api/v1/write", h.RemoteWrite())
+	r.Get("/api/v1/query", h.Query())
+	r.Post("/api/v1/query", h.Query())
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(h.AdminTrust)
+
+		r.Post("/flush", h.AdminFlush())
+		r.Post("/rename", h.AdminRename())
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(h.RSADecrypt)
 
-	serv := &MetricsServer{
-		HTTP: &http.Server{
-			Addr:    addr,
-			Handler: r,
-		},
+		r.Post("/update", h.UpdateJSON())
+		r.Post("/update/", h.UpdateJSON())
+		r.Post("/updates", h.UpdateDataJSON())
+		r.Post("/updates/", h.UpdateDataJSON())
+		r.Post("/validate", h.Validate())
+	})
+
+	if serv.profiling {
+		r.HandleFunc("/debug/pprof", pprof.Index)
+		r.HandleFunc("/debug/pprof/", pprof.Index)
+		r.HandleFunc("/debug/pprof/*", pprof.Index)
+		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	var root http.Handler = r
+	if len(serv.basePath) != 0 {
+		// Маршруты и обработчики работают с путем без префикса
+		root = h.NormalizePath(http.StripPrefix(serv.basePath, r))
+	}
+
+	serv.HTTP = &http.Server{
+		Addr:              addr,
+		Handler:           root,
+		TLSConfig:         serv.tlsConfig,
+		ReadHeaderTimeout: serv.timeouts.ReadHeader,
+		ReadTimeout:       serv.timeouts.Read,
+		WriteTimeout:      serv.timeouts.Write,
+		IdleTimeout:       serv.timeouts.Idle,
 	}
 
 	return serv
 }
 
+// Start Запуск сервера в отдельной горутине.
+// Если заданы сертификат и ключ, запросы обслуживаются по HTTPS
 func (serv *MetricsServer) Start() {
 	go func() {
+		if serv.TLSEnabled() {
+			if err := serv.HTTP.ListenAndServeTLS(serv.certFile, serv.keyFile); err != http.ErrServerClosed {
+				fmt.Printf("HTTP server ListenAndServeTLS: %v\n", err)
+			}
+			return
+		}
+
 		if err := serv.HTTP.ListenAndServe(); err != http.ErrServerClosed {
 			fmt.Printf("HTTP server ListenAndServe: %v\n", err)
 		}
 	}()
 }
 
+// TLSEnabled Признак обслуживания запросов по HTTPS
+func (serv *MetricsServer) TLSEnabled() bool {
+	return len(serv.certFile) != 0 && len(serv.keyFile) != 0
+}
+
 func (serv *MetricsServer) Shutdown(ctx context.Context) error {
 	return serv.HTTP.Shutdown(ctx)
 }
+
+// Stop Завершение работы сервера: новые запросы не принимаются, обрабатываемые запросы
+// завершаются в пределах ctx, затем метрики сохраняются и хранилище закрывается
+func Stop(ctx context.Context, serv *MetricsServer, manager *MetricsManager) error {
+
+	errShutdown := serv.Shutdown(ctx)
+
+	if err := manager.Shutdown(); err != nil {
+		return fmt.Errorf("could not stop metrics manager: %w", err)
+	}
+
+	if errShutdown != nil {
+		return fmt.Errorf("could not shutdown HTTP server: %w", errShutdown)
+	}
+
+	return nil
+}
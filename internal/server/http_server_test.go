@@ -0,0 +1,1741 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/internal/pubsub"
+	handler "metrics-and-alerting/internal/server/handlers"
+	"metrics-and-alerting/internal/storage"
+	"metrics-and-alerting/internal/storage/filestorage"
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/pkg/clock"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/golang/snappy"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const signKey = "KeySignMetric"
+
+// newTestServer Сервер с хранилищем в памяти и подписью метрик
+func newTestServer(t *testing.T, opts ...OptionsManager) (*httptest.Server, *MetricsManager) {
+
+	logger := logpack.NewLogger()
+	manager := New(memstore.New(), logger, opts...)
+	t.Cleanup(manager.cancel)
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger))
+	ts := httptest.NewServer(serv.HTTP.Handler)
+	t.Cleanup(ts.Close)
+
+	return ts, manager
+}
+
+// signedMetric Подписанная метрика
+func signedMetric(t *testing.T, typeMetric, id string, value float64) metricPkg.Metric {
+
+	m, err := metricPkg.CreateMetric(typeMetric, id, metricPkg.WithValueFloat(value))
+	require.NoError(t, err)
+
+	m.Hash, err = m.Sign([]byte(signKey))
+	require.NoError(t, err)
+
+	return m
+}
+
+// postJSON Отправка JSON на сервер
+func postJSON(t *testing.T, url string, body interface{}) *http.Response {
+
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	response, err := http.Post(url, handler.ApplicationJSON, bytes.NewReader(data))
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response
+}
+
+func TestUpdates(t *testing.T) {
+
+	t.Run("Mixed gauge and counter batch -> OK", func(t *testing.T) {
+		ts, manager := newTestServer(t, WithSignKey([]byte(signKey)))
+
+		batch := []metricPkg.Metric{
+			signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5),
+			signedMetric(t, metricPkg.CounterType, "testCounter", 3),
+			signedMetric(t, metricPkg.CounterType, "testCounter", 4),
+		}
+
+		response := postJSON(t, ts.URL+"/updates/", batch)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		gauge, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+		require.NoError(t, err)
+		require.Equal(t, 1.5, *gauge.Value)
+
+		counter, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+		require.NoError(t, err)
+		require.Equal(t, int64(7), *counter.Delta)
+	})
+
+	t.Run("Batch with invalid signature -> BAD REQUEST", func(t *testing.T) {
+		ts, manager := newTestServer(t, WithSignKey([]byte(signKey)))
+
+		invalid := signedMetric(t, metricPkg.CounterType, "testCounter", 4)
+		invalid.Hash = "invalid"
+
+		batch := []metricPkg.Metric{
+			signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5),
+			invalid,
+		}
+
+		response := postJSON(t, ts.URL+"/updates/", batch)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		metrics, err := manager.GetBatch(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, metrics)
+	})
+
+	t.Run("Batch with metric without value -> BAD REQUEST", func(t *testing.T) {
+		ts, manager := newTestServer(t)
+
+		batch := []metricPkg.Metric{
+			{ID: "testGauge", MType: metricPkg.GaugeType},
+		}
+
+		response := postJSON(t, ts.URL+"/updates/", batch)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		metrics, err := manager.GetBatch(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, metrics)
+	})
+
+	t.Run("Batch with incorrect label name -> BAD REQUEST", func(t *testing.T) {
+		ts, manager := newTestServer(t)
+
+		value := 1.5
+		batch := []metricPkg.Metric{
+			{ID: "testGauge", MType: metricPkg.GaugeType, Value: &value, Labels: metricPkg.Labels{`a="1",b`: "2"}},
+		}
+
+		response := postJSON(t, ts.URL+"/updates/", batch)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		metrics, err := manager.GetBatch(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, metrics)
+	})
+
+	t.Run("Gauge NaN and Inf by URL -> BAD REQUEST", func(t *testing.T) {
+		ts, manager := newTestServer(t)
+
+		for _, value := range []string{"NaN", "+Inf", "-Inf", "Infinity"} {
+			response, err := http.Post(ts.URL+"/update/gauge/testGauge/"+value, handler.TextPlain, nil)
+			require.NoError(t, err)
+			require.NoError(t, response.Body.Close())
+			require.Equal(t, http.StatusBadRequest, response.StatusCode, value)
+		}
+
+		metrics, err := manager.GetBatch(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, metrics)
+	})
+}
+
+// TestValues Набор метрик возвращается с подписью, отсутствующие метрики - с ошибкой
+func TestValues(t *testing.T) {
+
+	ts, manager := newTestServer(t, WithSignKey([]byte(signKey)))
+
+	require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{
+		signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5),
+		signedMetric(t, metricPkg.CounterType, "testCounter", 3),
+	}))
+
+	requests := []metricPkg.Metric{
+		{ID: "testGauge", MType: metricPkg.GaugeType},
+		{ID: "unknownGauge", MType: metricPkg.GaugeType},
+		{ID: "testCounter", MType: metricPkg.CounterType},
+	}
+
+	response := postJSON(t, ts.URL+"/values/", requests)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, handler.ApplicationJSON, response.Header.Get(handler.ContentType))
+
+	var got []struct {
+		metricPkg.Metric
+		Error string `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+	require.Len(t, got, 3)
+
+	require.Empty(t, got[0].Error)
+	require.Equal(t, 1.5, *got[0].Value)
+	require.Equal(t, signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5).Hash, got[0].Hash)
+
+	require.Equal(t, "unknownGauge", got[1].ID)
+	require.Equal(t, metricPkg.GaugeType, got[1].MType)
+	require.Nil(t, got[1].Value)
+	require.Contains(t, got[1].Error, "not found")
+
+	require.Empty(t, got[2].Error)
+	require.Equal(t, int64(3), *got[2].Delta)
+	require.NotEmpty(t, got[2].Hash)
+}
+
+// TestMetricsCount Количество метрик по типам учитывает удаление метрик
+func TestMetricsCount(t *testing.T) {
+
+	ts, manager := newTestServer(t)
+
+	require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{
+		signedMetric(t, metricPkg.GaugeType, "gauge1", 1),
+		signedMetric(t, metricPkg.GaugeType, "gauge2", 2),
+		signedMetric(t, metricPkg.GaugeType, "gauge3", 3),
+		signedMetric(t, metricPkg.CounterType, "counter1", 1),
+		signedMetric(t, metricPkg.CounterType, "counter2", 2),
+	}))
+
+	count := func() map[string]int {
+		response, err := http.Get(ts.URL + "/metrics/count")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, handler.ApplicationJSON, response.Header.Get(handler.ContentType))
+
+		var got map[string]int
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		return got
+	}
+
+	got := count()
+	require.Equal(t, 3, got[metricPkg.GaugeType])
+	require.Equal(t, 2, got[metricPkg.CounterType])
+	require.Equal(t, 5, got["total"])
+
+	require.NoError(t, manager.Delete(context.Background(), metricPkg.Metric{ID: "gauge2", MType: metricPkg.GaugeType}))
+
+	got = count()
+	require.Equal(t, 2, got[metricPkg.GaugeType])
+	require.Equal(t, 2, got[metricPkg.CounterType])
+	require.Equal(t, 4, got["total"])
+}
+
+// TestHealthz Подробное состояние сервера с хранилищем в памяти
+func TestHealthz(t *testing.T) {
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	logger := logpack.NewLogger()
+	manager := New(memstore.New(), logger, WithSignKey([]byte(signKey)), WithClock(clock.NewFake(now)))
+	t.Cleanup(manager.cancel)
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithStorageKind(storage.KindMemory)))
+	ts := httptest.NewServer(serv.HTTP.Handler)
+	t.Cleanup(ts.Close)
+
+	health := func() map[string]interface{} {
+		response, err := http.Get(ts.URL + "/healthz")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, handler.ApplicationJSON, response.Header.Get(handler.ContentType))
+
+		var got map[string]interface{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		return got
+	}
+
+	got := health()
+	require.Equal(t, "ok", got["status"])
+	require.Equal(t, "memory", got["storage"])
+	require.Equal(t, true, got["sync_store"])
+	require.Nil(t, got["last_save"])
+	require.NotContains(t, got, "pool")
+
+	require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{
+		signedMetric(t, metricPkg.GaugeType, "gauge1", 1),
+		signedMetric(t, metricPkg.GaugeType, "gauge2", 2),
+		signedMetric(t, metricPkg.CounterType, "counter1", 1),
+	}))
+
+	got = health()
+	lastSave, err := time.Parse(time.RFC3339Nano, got["last_save"].(string))
+	require.NoError(t, err)
+	require.True(t, now.Equal(lastSave))
+	require.Equal(t, map[string]interface{}{
+		metricPkg.GaugeType:        float64(2),
+		metricPkg.CounterType:      float64(1),
+		metricPkg.HistogramType:    float64(0),
+		metricPkg.FloatCounterType: float64(0),
+	}, got["metrics"])
+}
+
+// TestGetValueSigned Значение метрики по URL с параметром sign=1 возвращается с подписью
+func TestGetValueSigned(t *testing.T) {
+
+	gauge := signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5)
+
+	get := func(t *testing.T, url string) (string, string) {
+		response, err := http.Get(url)
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		return response.Header.Get(handler.ContentType), string(body)
+	}
+
+	tests := []struct {
+		name     string
+		opts     []OptionsManager
+		query    string
+		wantType string
+		wantHash bool
+	}{
+		{
+			name:     "Signed read",
+			opts:     []OptionsManager{WithSignKey([]byte(signKey))},
+			query:    "?sign=1",
+			wantType: handler.ApplicationJSON,
+			wantHash: true,
+		},
+		{
+			name:     "Unsigned read",
+			opts:     []OptionsManager{WithSignKey([]byte(signKey))},
+			wantType: handler.TextPlain,
+		},
+		{
+			name:     "Signed read without secret key",
+			query:    "?sign=1",
+			wantType: handler.ApplicationJSON,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ts, manager := newTestServer(t, tt.opts...)
+			require.NoError(t, manager.Upsert(context.Background(), gauge))
+
+			contentType, body := get(t, ts.URL+"/value/gauge/testGauge"+tt.query)
+			require.Equal(t, tt.wantType, contentType)
+
+			if tt.wantType == handler.TextPlain {
+				require.Equal(t, "1.5", body)
+				return
+			}
+
+			var got struct {
+				ID    string `json:"id"`
+				MType string `json:"type"`
+				Value string `json:"value"`
+				Hash  string `json:"hash"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(body), &got))
+
+			require.Equal(t, "testGauge", got.ID)
+			require.Equal(t, metricPkg.GaugeType, got.MType)
+			require.Equal(t, "1.5", got.Value)
+
+			if !tt.wantHash {
+				require.Empty(t, got.Hash)
+				return
+			}
+
+			// Подпись проверяется по значению, прочитанному из ответа
+			read, err := metricPkg.CreateMetric(got.MType, got.ID, metricPkg.WithValue(got.Value))
+			require.NoError(t, err)
+
+			hash, err := read.Sign([]byte(signKey))
+			require.NoError(t, err)
+			require.Equal(t, hash, got.Hash)
+		})
+	}
+}
+
+// TestResetCounter После сброса counter накапливается с нуля
+func TestResetCounter(t *testing.T) {
+
+	ts, manager := newTestServer(t)
+
+	post := func(target string) int {
+		response, err := http.Post(ts.URL+target, handler.TextPlain, nil)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		return response.StatusCode
+	}
+
+	counter := func() int64 {
+		got, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+		require.NoError(t, err)
+
+		return *got.Delta
+	}
+
+	require.Equal(t, http.StatusOK, post("/update/counter/testCounter/3"))
+	require.Equal(t, http.StatusOK, post("/update/counter/testCounter/4"))
+	require.Equal(t, int64(7), counter())
+
+	require.Equal(t, http.StatusOK, post("/reset/counter/testCounter"))
+	require.Equal(t, int64(0), counter())
+
+	require.Equal(t, http.StatusOK, post("/update/counter/testCounter/5"))
+	require.Equal(t, int64(5), counter())
+
+	require.Equal(t, http.StatusNotFound, post("/reset/counter/unknownCounter"))
+	require.Equal(t, http.StatusNotFound, post("/reset/counter/"))
+
+	floatCounter := func() float64 {
+		got, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testSeconds", MType: metricPkg.FloatCounterType})
+		require.NoError(t, err)
+
+		return *got.Value
+	}
+
+	require.Equal(t, http.StatusOK, post("/update/float_counter/testSeconds/0.25"))
+	require.Equal(t, http.StatusOK, post("/update/float_counter/testSeconds/1.5"))
+	require.Equal(t, 1.75, floatCounter())
+
+	require.Equal(t, http.StatusOK, post("/reset/float_counter/testSeconds"))
+	require.Equal(t, float64(0), floatCounter())
+
+	require.Equal(t, http.StatusBadRequest, post("/update/float_counter/testSeconds/-1"))
+}
+
+// TestIdempotencyKey Повтор приращения counter с тем же Idempotency-Key не применяется
+func TestIdempotencyKey(t *testing.T) {
+
+	ts, manager := newTestServer(t)
+
+	post := func(target, contentType, key, body string) int {
+		request, err := http.NewRequest(http.MethodPost, ts.URL+target, strings.NewReader(body))
+		require.NoError(t, err)
+
+		request.Header.Set(handler.ContentType, contentType)
+		if len(key) != 0 {
+			request.Header.Set(handler.IdempotencyKey, key)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		return response.StatusCode
+	}
+
+	counter := func(id string) int64 {
+		got, err := manager.Get(context.Background(), metricPkg.Metric{ID: id, MType: metricPkg.CounterType})
+		require.NoError(t, err)
+
+		return *got.Delta
+	}
+
+	// Повтор по URL с тем же ключом
+	require.Equal(t, http.StatusOK, post("/update/counter/first/5", handler.TextPlain, "key-1", ""))
+	require.Equal(t, http.StatusOK, post("/update/counter/first/5", handler.TextPlain, "key-1", ""))
+	require.Equal(t, int64(5), counter("first"))
+
+	// Ключ действует в пределах метрики
+	require.Equal(t, http.StatusOK, post("/update/counter/second/2", handler.TextPlain, "key-1", ""))
+	require.Equal(t, int64(2), counter("second"))
+
+	// Новый ключ применяется
+	require.Equal(t, http.StatusOK, post("/update/counter/first/5", handler.TextPlain, "key-2", ""))
+	require.Equal(t, int64(10), counter("first"))
+
+	// Без ключа каждый запрос применяется
+	require.Equal(t, http.StatusOK, post("/update/counter/second/2", handler.TextPlain, "", ""))
+	require.Equal(t, http.StatusOK, post("/update/counter/second/2", handler.TextPlain, "", ""))
+	require.Equal(t, int64(6), counter("second"))
+
+	// Повтор в формате JSON
+	body := `{"id":"third","type":"counter","delta":3}`
+	require.Equal(t, http.StatusOK, post("/update/", handler.ApplicationJSON, "key-3", body))
+	require.Equal(t, http.StatusOK, post("/update/", handler.ApplicationJSON, "key-3", body))
+	require.Equal(t, int64(3), counter("third"))
+
+	// Повтор набора метрик
+	batch := `[{"id":"third","type":"counter","delta":1},{"id":"fourth","type":"counter","delta":1}]`
+	require.Equal(t, http.StatusOK, post("/updates/", handler.ApplicationJSON, "key-4", batch))
+	require.Equal(t, http.StatusOK, post("/updates/", handler.ApplicationJSON, "key-4", batch))
+	require.Equal(t, int64(4), counter("third"))
+	require.Equal(t, int64(1), counter("fourth"))
+}
+
+// promTimeSeries Сообщение TimeSeries remote write Prometheus с одним отсчетом
+func promTimeSeries(labels [][2]string, value float64, timestamp int64) []byte {
+
+	var series []byte
+	for _, label := range labels {
+		var msg []byte
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, label[0])
+		msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+		msg = protowire.AppendString(msg, label[1])
+
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, msg)
+	}
+
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(timestamp))
+
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+
+	return series
+}
+
+// TestRemoteWrite Временные ряды remote write Prometheus сохраняются как gauge с метками
+func TestRemoteWrite(t *testing.T) {
+
+	ts, manager := newTestServer(t)
+
+	var request []byte
+	for _, series := range [][]byte{
+		promTimeSeries([][2]string{{"__name__", "node_load1"}, {"instance", "host:9100"}}, 1.25, 1000),
+		promTimeSeries([][2]string{{"instance", "host:9100"}}, 7, 1000),
+		promTimeSeries([][2]string{{"__name__", "node_stale"}}, math.NaN(), 1000),
+	} {
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+
+	// Метаданные не поддерживаются и пропускаются
+	request = protowire.AppendTag(request, 3, protowire.BytesType)
+	request = protowire.AppendBytes(request, []byte{})
+
+	post := func(body []byte, encoding string) int {
+		r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/write", bytes.NewReader(body))
+		require.NoError(t, err)
+		r.Header.Set(handler.ContentType, handler.ApplicationProtobuf)
+		r.Header.Set(handler.ContentEncoding, encoding)
+
+		response, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		return response.StatusCode
+	}
+
+	require.Equal(t, http.StatusNoContent, post(snappy.Encode(nil, request), handler.Snappy))
+
+	got, err := manager.Get(context.Background(), metricPkg.Metric{
+		ID:     "node_load1",
+		MType:  metricPkg.GaugeType,
+		Labels: metricPkg.Labels{"instance": "host:9100"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1.25, *got.Value)
+
+	metrics, err := manager.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	require.Equal(t, http.StatusBadRequest, post(snappy.Encode(nil, []byte{0xff}), handler.Snappy))
+	require.Equal(t, http.StatusBadRequest, post([]byte("not snappy"), handler.Snappy))
+}
+
+// TestIncrementGauge Приращение gauge добавляется к сохраненному значению,
+// обычное обновление заменяет его
+func TestIncrementGauge(t *testing.T) {
+
+	ts, manager := newTestServer(t)
+
+	post := func(target string) int {
+		response, err := http.Post(ts.URL+target, handler.TextPlain, nil)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		return response.StatusCode
+	}
+
+	gauge := func(id string) float64 {
+		got, err := manager.Get(context.Background(), metricPkg.Metric{ID: id, MType: metricPkg.GaugeType})
+		require.NoError(t, err)
+		require.Empty(t, got.Op)
+
+		return *got.Value
+	}
+
+	inc := func(id string, value float64) metricPkg.Metric {
+		m := signedMetric(t, metricPkg.GaugeType, id, value)
+		m.Op = metricPkg.OpInc
+		return m
+	}
+
+	// Отсутствующий gauge увеличивается от нуля
+	require.Equal(t, http.StatusOK, post("/update/gauge-inc/testGauge/1.5"))
+	require.Equal(t, 1.5, gauge("testGauge"))
+
+	require.Equal(t, http.StatusOK, post("/update/gauge-inc/testGauge/2"))
+	require.Equal(t, 3.5, gauge("testGauge"))
+
+	require.Equal(t, http.StatusOK, post("/update/gauge/testGauge/2"))
+	require.Equal(t, 2.0, gauge("testGauge"))
+
+	require.Equal(t, http.StatusOK, post("/update/gauge-inc/testGauge/-0.5"))
+	require.Equal(t, 1.5, gauge("testGauge"))
+
+	require.Equal(t, http.StatusBadRequest, post("/update/gauge-inc/testGauge/none"))
+
+	response := postJSON(t, ts.URL+"/update/", inc("testGauge", 1))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, 2.5, gauge("testGauge"))
+
+	response = postJSON(t, ts.URL+"/update/", signedMetric(t, metricPkg.GaugeType, "testGauge", 1))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, 1.0, gauge("testGauge"))
+
+	// Приращения в наборе накапливаются, замена в наборе сбрасывает накопленное значение
+	batch := []metricPkg.Metric{
+		inc("batchGauge", 1),
+		inc("batchGauge", 2),
+		signedMetric(t, metricPkg.GaugeType, "otherGauge", 7),
+		inc("otherGauge", 3),
+	}
+
+	response = postJSON(t, ts.URL+"/updates/", batch)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, 3.0, gauge("batchGauge"))
+	require.Equal(t, 10.0, gauge("otherGauge"))
+
+	counter := signedMetric(t, metricPkg.CounterType, "testCounter", 1)
+	counter.Op = metricPkg.OpInc
+
+	response = postJSON(t, ts.URL+"/update/", counter)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+// TestResetCounterTrustedSubnet Сброс counter доступен только из доверенной подсети
+func TestAdminFlush(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "metrics.json")
+
+	manager := New(filestorage.New(fileName, logger), logger, WithFlush(time.Hour))
+	t.Cleanup(manager.cancel)
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, manager.Upsert(context.Background(), gauge))
+	require.NoFileExists(t, fileName)
+
+	flush := func(serv *MetricsServer, realIP string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/admin/flush", nil)
+		request.Header.Set(handler.XRealIP, realIP)
+		w := httptest.NewRecorder()
+		serv.HTTP.Handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	open := NewHTTPServer(":0", handler.New(manager, logger))
+	require.Equal(t, http.StatusForbidden, flush(open, "10.0.0.1").Code)
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithTrustedSubnet("10.0.0.0/8")))
+	require.Equal(t, http.StatusForbidden, flush(serv, "192.168.0.1").Code)
+	require.NoFileExists(t, fileName)
+
+	w := flush(serv, "10.0.0.1")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result struct {
+		Metrics  int    `json:"metrics"`
+		Duration string `json:"duration"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.Equal(t, 1, result.Metrics)
+	_, err := time.ParseDuration(result.Duration)
+	require.NoError(t, err)
+
+	restored := filestorage.New(fileName, logger)
+	require.NoError(t, restored.Restore(context.Background()))
+	got, err := restored.Get(context.Background(), gauge)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *got.Value)
+
+	// Ошибка сохранения возвращается с кодом 500
+	broken := New(filestorage.New(filepath.Join(dir, "missing", "metrics.json"), logger), logger, WithFlush(time.Hour))
+	t.Cleanup(broken.cancel)
+
+	brokenServ := NewHTTPServer(":0", handler.New(broken, logger, handler.WithTrustedSubnet("10.0.0.0/8")))
+	require.Equal(t, http.StatusInternalServerError, flush(brokenServ, "10.0.0.1").Code)
+}
+
+func TestAdminRename(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	manager := New(filestorage.New(fileName, logger), logger, WithSignKey([]byte(signKey)))
+	t.Cleanup(manager.cancel)
+
+	for _, id := range []string{"oldName", "taken"} {
+		require.NoError(t, manager.Upsert(context.Background(), signedMetric(t, metricPkg.GaugeType, id, 1.5)))
+	}
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithTrustedSubnet("10.0.0.0/8")))
+
+	rename := func(realIP, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/admin/rename", strings.NewReader(body))
+		request.Header.Set(handler.ContentType, handler.ApplicationJSON)
+		request.Header.Set(handler.XRealIP, realIP)
+		w := httptest.NewRecorder()
+		serv.HTTP.Handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	require.Equal(t, http.StatusForbidden,
+		rename("192.168.0.1", `{"id":"oldName","type":"gauge","new_id":"newName"}`).Code)
+	require.Equal(t, http.StatusConflict,
+		rename("10.0.0.1", `{"id":"oldName","type":"gauge","new_id":"taken"}`).Code)
+	require.Equal(t, http.StatusNotFound,
+		rename("10.0.0.1", `{"id":"missing","type":"gauge","new_id":"newName"}`).Code)
+	require.Equal(t, http.StatusBadRequest,
+		rename("10.0.0.1", `{"id":"oldName","type":"gauge","new_id":"new name"}`).Code)
+
+	w := rename("10.0.0.1", `{"id":"oldName","type":"gauge","new_id":"newName"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var renamed metricPkg.Metric
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &renamed))
+	require.Equal(t, "newName", renamed.ID)
+	require.Equal(t, 1.5, *renamed.Value)
+
+	// Подпись вычислена для нового ID
+	hash, err := signedMetric(t, metricPkg.GaugeType, "newName", 1.5).Sign([]byte(signKey))
+	require.NoError(t, err)
+	require.Equal(t, hash, renamed.Hash)
+
+	// Переименование сохраняется в файл сразу, так как интервал сохранения не задан
+	restored := filestorage.New(fileName, logger)
+	require.NoError(t, restored.Restore(context.Background()))
+
+	_, err = restored.Get(context.Background(), metricPkg.Metric{ID: "oldName", MType: metricPkg.GaugeType})
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	got, err := restored.Get(context.Background(), metricPkg.Metric{ID: "newName", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *got.Value)
+}
+
+func TestResetCounterTrustedSubnet(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	manager := New(memstore.New(), logger)
+	t.Cleanup(manager.cancel)
+
+	counter := signedMetric(t, metricPkg.CounterType, "testCounter", 3)
+	require.NoError(t, manager.Upsert(context.Background(), counter))
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithTrustedSubnet("10.0.0.0/8")))
+
+	tests := []struct {
+		name     string
+		realIP   string
+		wantCode int
+	}{
+		{
+			name:     "Untrusted ip -> FORBIDDEN",
+			realIP:   "192.168.0.1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Trusted ip -> OK",
+			realIP:   "10.0.0.1",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/reset/counter/testCounter", nil)
+			request.Header.Set(handler.XRealIP, tt.realIP)
+			w := httptest.NewRecorder()
+			serv.HTTP.Handler.ServeHTTP(w, request)
+
+			require.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+// TestStop При завершении работы сервера последние метрики сохраняются в файл
+func TestImport(t *testing.T) {
+
+	const body = `{"id":"Alloc","type":"gauge","value":1.5}
+{"id":"PollCount","type":"counter","delta":4}
+
+{"id":"PollCount","type":"counter","delta":6}
+{"id":"Broken","type":"gauge","value":
+{"id":"Unknown","type":"unknown","value":1}
+{"id":"Frees","type":"counter","delta":2}
+`
+
+	gzipBody := func() []byte {
+		buf := bytes.Buffer{}
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		body        []byte
+		encoding    string
+		wantSummary string
+		wantCounter int64
+	}{
+		{
+			name:        "Add counters",
+			body:        []byte(body),
+			wantSummary: `{"imported":4,"skipped":2}`,
+			wantCounter: 13,
+		},
+		{
+			name:        "Gzip body",
+			body:        gzipBody(),
+			encoding:    handler.GZip,
+			wantSummary: `{"imported":4,"skipped":2}`,
+			wantCounter: 13,
+		},
+		{
+			name:        "Replace counters",
+			query:       "?mode=replace",
+			body:        []byte(body),
+			wantSummary: `{"imported":4,"skipped":2}`,
+			wantCounter: 6,
+		},
+		{
+			name:        "Only malformed lines",
+			body:        []byte("{\n[1,2]\n"),
+			wantSummary: `{"imported":0,"skipped":2}`,
+			wantCounter: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			logger := logpack.NewLogger()
+			manager := New(memstore.New(), logger)
+			t.Cleanup(manager.cancel)
+
+			serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithImportBatchSize(2)))
+			ts := httptest.NewServer(serv.HTTP.Handler)
+			t.Cleanup(ts.Close)
+
+			counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "PollCount", metricPkg.WithValueInt(3))
+			require.NoError(t, err)
+			require.NoError(t, manager.Upsert(context.Background(), counter))
+
+			request, err := http.NewRequest(http.MethodPost, ts.URL+"/import"+tt.query, bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			request.Header.Set(handler.ContentType, handler.ApplicationNDJSON)
+			if len(tt.encoding) != 0 {
+				request.Header.Set(handler.ContentEncoding, tt.encoding)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+			defer response.Body.Close()
+
+			data, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusOK, response.StatusCode, string(data))
+			require.JSONEq(t, tt.wantSummary, string(data))
+
+			got, err := manager.Get(context.Background(), metricPkg.Metric{ID: "PollCount", MType: metricPkg.CounterType})
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCounter, *got.Delta)
+		})
+	}
+}
+
+// TestImportReplaceFailure Ошибка записи набора в режиме замены не меняет сохраненные counter
+func TestImportReplaceFailure(t *testing.T) {
+
+	ndjson := func(metrics ...metricPkg.Metric) []byte {
+		buf := bytes.Buffer{}
+		for _, m := range metrics {
+			data, err := json.Marshal(m)
+			require.NoError(t, err)
+			buf.Write(append(data, '\n'))
+		}
+
+		return buf.Bytes()
+	}
+
+	badSign := signedMetric(t, metricPkg.CounterType, "Frees", 1)
+	badSign.Hash = "invalid"
+
+	tests := []struct {
+		name     string
+		opts     []OptionsManager
+		body     []byte
+		wantCode int
+	}{
+		{
+			name: "Capacity exceeded",
+			opts: []OptionsManager{WithCapacity(2, CapacityReject, false)},
+			body: ndjson(
+				signedMetric(t, metricPkg.CounterType, "PollCount", 6),
+				signedMetric(t, metricPkg.CounterType, "Frees", 1),
+				signedMetric(t, metricPkg.GaugeType, "Alloc", 1.5),
+			),
+			wantCode: http.StatusInsufficientStorage,
+		},
+		{
+			name: "Sign verification failed",
+			opts: []OptionsManager{WithSignKey([]byte(signKey))},
+			body: ndjson(
+				signedMetric(t, metricPkg.CounterType, "PollCount", 6),
+				badSign,
+			),
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			logger := logpack.NewLogger()
+			manager := New(memstore.New(), logger, tt.opts...)
+			t.Cleanup(manager.cancel)
+
+			serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithImportBatchSize(3)))
+			ts := httptest.NewServer(serv.HTTP.Handler)
+			t.Cleanup(ts.Close)
+
+			require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{
+				signedMetric(t, metricPkg.CounterType, "PollCount", 3),
+				signedMetric(t, metricPkg.CounterType, "Frees", 2),
+			}))
+
+			request, err := http.NewRequest(http.MethodPost, ts.URL+"/import?mode=replace", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			request.Header.Set(handler.ContentType, handler.ApplicationNDJSON)
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+			defer response.Body.Close()
+
+			require.Equal(t, tt.wantCode, response.StatusCode)
+
+			for id, want := range map[string]int64{"PollCount": 3, "Frees": 2} {
+				got, err := manager.Get(context.Background(), metricPkg.Metric{ID: id, MType: metricPkg.CounterType})
+				require.NoError(t, err)
+				require.Equal(t, want, *got.Delta, id)
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	// Интервал сохранения больше времени теста: метрика попадет в файл только при завершении
+	manager := New(filestorage.New(fileName, logger), logger, WithFlush(time.Hour))
+	serv := NewHTTPServer("127.0.0.1:0", handler.New(manager, logger))
+	serv.Start()
+
+	gauge := signedMetric(t, metricPkg.GaugeType, "testGauge", 42.5)
+	require.NoError(t, manager.Upsert(context.Background(), gauge))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, Stop(ctx, serv, manager))
+
+	restored := filestorage.New(fileName, logger)
+	require.NoError(t, restored.Restore(context.Background()))
+
+	got, err := restored.Get(context.Background(), gauge)
+	require.NoError(t, err)
+	require.Equal(t, 42.5, *got.Value)
+}
+
+// TestTimeouts Тайм-ауты HTTP сервера задаются конфигурацией, 0 отключает тайм-аут
+func TestTimeouts(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	h := handler.New(memstore.New(), logger)
+
+	serv := NewHTTPServer(":0", h)
+	require.Equal(t, DefaultReadHeaderTimeout, serv.HTTP.ReadHeaderTimeout)
+	require.Equal(t, DefaultReadTimeout, serv.HTTP.ReadTimeout)
+	require.Equal(t, DefaultWriteTimeout, serv.HTTP.WriteTimeout)
+	require.Equal(t, DefaultIdleTimeout, serv.HTTP.IdleTimeout)
+
+	cfg, err := load([]string{"-header-timeout", "1s", "-read-timeout", "2s", "-write-timeout", "0", "-idle-timeout", "4s"})
+	require.NoError(t, err)
+
+	serv = NewHTTPServer(":0", h, WithTimeouts(cfg.HTTPTimeouts()))
+	require.Equal(t, time.Second, serv.HTTP.ReadHeaderTimeout)
+	require.Equal(t, 2*time.Second, serv.HTTP.ReadTimeout)
+	require.Zero(t, serv.HTTP.WriteTimeout)
+	require.Equal(t, 4*time.Second, serv.HTTP.IdleTimeout)
+}
+
+func TestProfiling(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		profiling bool
+		wantCode  int
+	}{
+		{
+			name:      "Profiling enabled -> OK",
+			profiling: true,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "Profiling disabled -> NOT FOUND",
+			profiling: false,
+			wantCode:  http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			logger := logpack.NewLogger()
+			manager := New(memstore.New(), logger)
+			t.Cleanup(manager.cancel)
+
+			serv := NewHTTPServer(":0", handler.New(manager, logger), WithProfiling(tt.profiling))
+
+			for _, target := range []string{"/debug/pprof/heap", "/debug/pprof/goroutine"} {
+				request := httptest.NewRequest(http.MethodGet, target, nil)
+				w := httptest.NewRecorder()
+				serv.HTTP.Handler.ServeHTTP(w, request)
+
+				require.Equal(t, tt.wantCode, w.Code, target)
+			}
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	broker := pubsub.NewBroker()
+
+	manager := New(memstore.New(), logger, WithBroker(broker))
+	t.Cleanup(manager.cancel)
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithBroker(broker)))
+	ts := httptest.NewServer(serv.HTTP.Handler)
+	t.Cleanup(ts.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/stream"
+
+	t.Run("Unknown type", func(t *testing.T) {
+		_, response, err := websocket.DefaultDialer.Dial(wsURL+"?type=unknown", nil)
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+		require.NoError(t, response.Body.Close())
+	})
+
+	t.Run("Receive updates", func(t *testing.T) {
+
+		conn, response, err := websocket.DefaultDialer.Dial(wsURL+"?type=gauge", nil)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		defer conn.Close()
+
+		require.Eventually(t, func() bool { return broker.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+		response, err = http.Post(ts.URL+"/update/counter/testCounter/1", handler.TextPlain, nil)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		response, err = http.Post(ts.URL+"/update/gauge/testGauge/2.5", handler.TextPlain, nil)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		var event map[string]interface{}
+		require.NoError(t, conn.ReadJSON(&event))
+		require.Equal(t, map[string]interface{}{"id": "testGauge", "type": "gauge", "value": 2.5}, event)
+	})
+
+	require.Eventually(t, func() bool { return broker.Subscribers() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestDeleteMetrics(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	tests := []struct {
+		name        string
+		query       string
+		realIP      string
+		wantCode    int
+		wantDeleted int
+		wantKept    []string
+	}{
+		{
+			name:        "By prefix",
+			query:       "?prefix=foo_",
+			realIP:      "10.0.0.1",
+			wantCode:    http.StatusOK,
+			wantDeleted: 3,
+			wantKept:    []string{"bar_load", "bar_requests"},
+		},
+		{
+			name:        "By type",
+			query:       "?type=gauge",
+			realIP:      "10.0.0.1",
+			wantCode:    http.StatusOK,
+			wantDeleted: 3,
+			wantKept:    []string{"bar_requests", "foo_requests"},
+		},
+		{
+			name:        "By prefix and type",
+			query:       "?prefix=foo_&type=gauge",
+			realIP:      "10.0.0.1",
+			wantCode:    http.StatusOK,
+			wantDeleted: 2,
+			wantKept:    []string{"bar_load", "bar_requests", "foo_requests"},
+		},
+		{
+			name:     "Without filters",
+			realIP:   "10.0.0.1",
+			wantCode: http.StatusBadRequest,
+			wantKept: []string{"bar_load", "bar_requests", "foo_load", "foo_memory", "foo_requests"},
+		},
+		{
+			name:     "Unknown type",
+			query:    "?type=unknown",
+			realIP:   "10.0.0.1",
+			wantCode: http.StatusBadRequest,
+			wantKept: []string{"bar_load", "bar_requests", "foo_load", "foo_memory", "foo_requests"},
+		},
+		{
+			name:     "Untrusted subnet",
+			query:    "?prefix=foo_",
+			realIP:   "192.168.0.1",
+			wantCode: http.StatusForbidden,
+			wantKept: []string{"bar_load", "bar_requests", "foo_load", "foo_memory", "foo_requests"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fileName := filepath.Join(t.TempDir(), "metrics.json")
+			manager := New(filestorage.New(fileName, logger), logger)
+			t.Cleanup(manager.cancel)
+
+			for _, id := range []string{"foo_load", "foo_memory", "bar_load"} {
+				gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(1))
+				require.NoError(t, manager.Upsert(context.Background(), gauge))
+			}
+
+			for _, id := range []string{"foo_requests", "bar_requests"} {
+				counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, id, metricPkg.WithValueInt(1))
+				require.NoError(t, manager.Upsert(context.Background(), counter))
+			}
+
+			serv := NewHTTPServer(":0", handler.New(manager, logger, handler.WithTrustedSubnet("10.0.0.0/8")))
+
+			request := httptest.NewRequest(http.MethodDelete, "/metrics"+tt.query, nil)
+			request.Header.Set(handler.XRealIP, tt.realIP)
+			w := httptest.NewRecorder()
+			serv.HTTP.Handler.ServeHTTP(w, request)
+			require.Equal(t, tt.wantCode, w.Code)
+
+			if tt.wantCode == http.StatusOK {
+				require.JSONEq(t, fmt.Sprintf(`{"deleted":%d}`, tt.wantDeleted), w.Body.String())
+			}
+
+			// Удаление сохраняется в файл сразу, так как интервал сохранения не задан
+			restored := filestorage.New(fileName, logger)
+			require.NoError(t, restored.Restore(context.Background()))
+
+			metrics, err := restored.GetBatch(context.Background())
+			require.NoError(t, err)
+
+			kept := make([]string, 0, len(metrics))
+			for _, m := range metrics {
+				kept = append(kept, m.ID)
+			}
+			require.ElementsMatch(t, tt.wantKept, kept)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	ts, manager := newTestServer(t, WithSignKey([]byte(signKey)))
+
+	validate := func(body interface{}) []map[string]interface{} {
+		response := postJSON(t, ts.URL+"/validate", body)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, handler.ApplicationJSON, response.Header.Get(handler.ContentType))
+
+		var results []map[string]interface{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+
+		return results
+	}
+
+	t.Run("All valid", func(t *testing.T) {
+		metrics := []metricPkg.Metric{
+			signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5),
+			signedMetric(t, metricPkg.CounterType, "testCounter", 3),
+		}
+
+		require.Equal(t, []map[string]interface{}{
+			{"id": "testGauge", "valid": true},
+			{"id": "testCounter", "valid": true},
+		}, validate(metrics))
+	})
+
+	t.Run("Bad signatures and malformed values", func(t *testing.T) {
+		good := signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5)
+
+		badSign := signedMetric(t, metricPkg.GaugeType, "testLoad", 2)
+		badSign.Hash = strings.Repeat("0", len(badSign.Hash))
+
+		items := []interface{}{
+			good,
+			badSign,
+			map[string]interface{}{"id": "testCounter", "type": "counter", "delta": "ten"},
+			map[string]interface{}{"id": "testSeconds", "type": "float_counter", "value": -1},
+			map[string]interface{}{"id": "1bad", "type": "gauge", "value": 1},
+		}
+		results := validate(items)
+		require.Len(t, results, len(items))
+
+		for i, id := range []string{"testGauge", "testLoad", "testCounter", "testSeconds", "1bad"} {
+			require.Equal(t, id, results[i]["id"])
+			require.Equal(t, i == 0, results[i]["valid"], id)
+			require.Equal(t, i != 0, results[i]["error"] != nil, id)
+		}
+	})
+
+	t.Run("Not an array", func(t *testing.T) {
+		response := postJSON(t, ts.URL+"/validate", map[string]string{"id": "testGauge"})
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	// Проверка не изменяет хранилище
+	metrics, err := manager.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, metrics)
+}
+
+func TestNormalizePath(t *testing.T) {
+
+	ts, _ := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Correctly formed update",
+			method:   http.MethodPost,
+			path:     "/update/gauge/testGauge/1",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Update with trailing slash",
+			method:   http.MethodPost,
+			path:     "/update/gauge/testGauge/2/",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Update with doubled slashes",
+			method:   http.MethodPost,
+			path:     "//update//gauge//testGauge//3",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Correctly formed value",
+			method:   http.MethodGet,
+			path:     "/value/gauge/testGauge",
+			wantCode: http.StatusOK,
+			wantBody: "3",
+		},
+		{
+			name:     "Value with doubled slashes",
+			method:   http.MethodGet,
+			path:     "//value//gauge//testGauge",
+			wantCode: http.StatusOK,
+			wantBody: "3",
+		},
+		{
+			name:     "Value with trailing slash",
+			method:   http.MethodGet,
+			path:     "/value/gauge/testGauge/",
+			wantCode: http.StatusOK,
+			wantBody: "3",
+		},
+		{
+			name:     "Update without value",
+			method:   http.MethodPost,
+			path:     "/update/gauge/testGauge/",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Update with empty name",
+			method:   http.MethodPost,
+			path:     "/update/gauge//1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Root",
+			method:   http.MethodGet,
+			path:     "/",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, err := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			require.NoError(t, err)
+			request.Header.Set(handler.ContentType, handler.TextPlain)
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+			defer response.Body.Close()
+
+			require.Equal(t, tt.wantCode, response.StatusCode)
+
+			if len(tt.wantBody) != 0 {
+				body, err := io.ReadAll(response.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
+
+func TestRequestIDInStorageLogs(t *testing.T) {
+
+	var errOut bytes.Buffer
+	logger := logpack.New(io.Discard, &errOut)
+
+	manager := New(memstore.New(), logger)
+	t.Cleanup(manager.cancel)
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger))
+	ts := httptest.NewServer(serv.HTTP.Handler)
+	t.Cleanup(ts.Close)
+
+	gauge, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testMetric", metricPkg.WithValueFloat(1))
+	require.NoError(t, err)
+	require.NoError(t, manager.Upsert(context.Background(), gauge))
+
+	// Метрика с тем же именем, но другим типом, отклоняется хранилищем
+	counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "testMetric", metricPkg.WithValueInt(1))
+	require.NoError(t, err)
+
+	data, err := json.Marshal([]metricPkg.Metric{counter})
+	require.NoError(t, err)
+
+	request, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(data))
+	require.NoError(t, err)
+	request.Header.Set(handler.ContentType, handler.ApplicationJSON)
+	request.Header.Set(handler.XRequestID, "test-request-id")
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+
+	require.NotEqual(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, errOut.String(), "request_id=test-request-id")
+}
+
+func TestGaugeStats(t *testing.T) {
+
+	ts, _ := newTestServer(t, WithGaugeStats(10))
+
+	for _, value := range []string{"4", "1", "7"} {
+		request, err := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/load/"+value, nil)
+		require.NoError(t, err)
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	response, err := http.Get(ts.URL + "/value/gauge/load/stats")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"last":7,"min":1,"max":7,"avg":4,"count":3}`, string(body))
+
+	// Значение метрики по-прежнему доступно по URL
+	value, err := http.Get(ts.URL + "/value/gauge/load")
+	require.NoError(t, err)
+	require.NoError(t, value.Body.Close())
+	require.Equal(t, http.StatusOK, value.StatusCode)
+
+	unknown, err := http.Get(ts.URL + "/value/gauge/unknown/stats")
+	require.NoError(t, err)
+	require.NoError(t, unknown.Body.Close())
+	require.Equal(t, http.StatusNotFound, unknown.StatusCode)
+}
+
+func TestBasePath(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	manager := New(memstore.New(), logger)
+	t.Cleanup(manager.cancel)
+
+	serv := NewHTTPServer(":0", handler.New(manager, logger), WithBasePath("metrics-server/"))
+	ts := httptest.NewServer(serv.HTTP.Handler)
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Update under base path",
+			method:   http.MethodPost,
+			path:     "/metrics-server/update/gauge/testGauge/1.5",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Get under base path",
+			method:   http.MethodGet,
+			path:     "/metrics-server/value/gauge/testGauge",
+			wantCode: http.StatusOK,
+			wantBody: "1.5",
+		},
+		{
+			name:     "Get under base path with trailing slash",
+			method:   http.MethodGet,
+			path:     "/metrics-server/value/gauge/testGauge/",
+			wantCode: http.StatusOK,
+			wantBody: "1.5",
+		},
+		{
+			name:     "Ping under base path",
+			method:   http.MethodGet,
+			path:     "/metrics-server/ping",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Update without base path",
+			method:   http.MethodPost,
+			path:     "/update/gauge/testGauge/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Get without base path",
+			method:   http.MethodGet,
+			path:     "/value/gauge/testGauge",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request, err := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			require.NoError(t, err)
+			request.Header.Set(handler.ContentType, handler.TextPlain)
+
+			response, err := ts.Client().Do(request)
+			require.NoError(t, err)
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.wantCode, response.StatusCode)
+			if len(tt.wantBody) != 0 {
+				require.Equal(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+
+	ts, _ := newTestServer(t)
+
+	send := func(t *testing.T, method, path, owner string) (int, string) {
+		request, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		request.Header.Set(handler.ContentType, handler.TextPlain)
+		if len(owner) != 0 {
+			request.Header.Set(handler.XTenantID, owner)
+		}
+
+		response, err := ts.Client().Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		return response.StatusCode, string(body)
+	}
+
+	// Одинаковое имя метрики у разных арендаторов
+	for owner, value := range map[string]string{"": "1", "team-a": "2", "team-b": "3"} {
+		code, _ := send(t, http.MethodPost, "/update/gauge/cpu/"+value, owner)
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	code, _ := send(t, http.MethodPost, "/update/counter/requests/5", "team-a")
+	require.Equal(t, http.StatusOK, code)
+
+	tests := []struct {
+		name     string
+		owner    string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Default tenant",
+			path:     "/value/gauge/cpu",
+			wantCode: http.StatusOK,
+			wantBody: "1",
+		},
+		{
+			name:     "Explicit default tenant",
+			owner:    tenant.Default,
+			path:     "/value/gauge/cpu",
+			wantCode: http.StatusOK,
+			wantBody: "1",
+		},
+		{
+			name:     "Tenant team-a",
+			owner:    "team-a",
+			path:     "/value/gauge/cpu",
+			wantCode: http.StatusOK,
+			wantBody: "2",
+		},
+		{
+			name:     "Tenant team-b",
+			owner:    "team-b",
+			path:     "/value/gauge/cpu",
+			wantCode: http.StatusOK,
+			wantBody: "3",
+		},
+		{
+			name:     "Metric of another tenant",
+			owner:    "team-b",
+			path:     "/value/counter/requests",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Count of tenant team-a",
+			owner:    "team-a",
+			path:     "/metrics/count",
+			wantCode: http.StatusOK,
+			wantBody: `"total":2`,
+		},
+		{
+			name:     "Count of tenant team-b",
+			owner:    "team-b",
+			path:     "/metrics/count",
+			wantCode: http.StatusOK,
+			wantBody: `"total":1`,
+		},
+		{
+			name:     "Incorrect tenant",
+			owner:    "team/a",
+			path:     "/value/gauge/cpu",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			code, body := send(t, http.MethodGet, tt.path, tt.owner)
+			require.Equal(t, tt.wantCode, code)
+			require.Contains(t, body, tt.wantBody)
+		})
+	}
+}
+
+// TestTenantIsolationNamePattern Шаблон имени, допускающий '/', не открывает арендатору
+// по умолчанию метрики других арендаторов
+func TestTenantIsolationNamePattern(t *testing.T) {
+
+	require.NoError(t, metricPkg.SetNameRules(`^[a-z/-]+$`, metricPkg.DefaultNameMaxLen))
+	t.Cleanup(func() {
+		require.NoError(t, metricPkg.SetNameRules(metricPkg.DefaultNamePattern, metricPkg.DefaultNameMaxLen))
+	})
+
+	ts, manager := newTestServer(t)
+
+	owner := tenant.WithTenant(context.Background(), "acme")
+	cpu, err := metricPkg.CreateMetric(metricPkg.GaugeType, "cpu", metricPkg.WithValueFloat(1))
+	require.NoError(t, err)
+	require.NoError(t, manager.Upsert(owner, cpu))
+
+	value := 2.0
+	response := postJSON(t, ts.URL+"/update/", metricPkg.Metric{ID: "acme/cpu", MType: metricPkg.GaugeType, Value: &value})
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	response = postJSON(t, ts.URL+"/value/", metricPkg.Metric{ID: "acme/cpu", MType: metricPkg.GaugeType})
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	foreign := metricPkg.Metric{ID: "acme/cpu", MType: metricPkg.GaugeType}
+	require.ErrorIs(t, manager.Delete(context.Background(), foreign), errs.ErrNotFound)
+	require.ErrorIs(t, manager.Rename(context.Background(), foreign, "stolen"), errs.ErrNotFound)
+
+	got, err := manager.Get(owner, cpu)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *got.Value)
+}
+
+// TestCodecs Обновление и получение метрик в JSON и MessagePack.
+// Подпись метрики не зависит от кодирования
+func TestCodecs(t *testing.T) {
+
+	codecs := []struct {
+		name        string
+		contentType string
+		marshal     func(v interface{}) ([]byte, error)
+		decode      func(data []byte) (metricPkg.Metric, error)
+		decodeBatch func(data []byte) ([]metricPkg.Metric, error)
+	}{
+		{
+			name:        "JSON",
+			contentType: handler.ApplicationJSON,
+			marshal:     json.Marshal,
+			decode:      metricPkg.DecodeJSON,
+			decodeBatch: metricPkg.DecodeJSONBatch,
+		},
+		{
+			name:        "MessagePack",
+			contentType: handler.ApplicationMsgpack,
+			marshal:     metricPkg.EncodeMsgpack,
+			decode:      metricPkg.DecodeMsgpack,
+			decodeBatch: metricPkg.DecodeMsgpackBatch,
+		},
+	}
+
+	for _, codec := range codecs {
+		t.Run(codec.name, func(t *testing.T) {
+
+			ts, _ := newTestServer(t, WithSignKey([]byte(signKey)))
+
+			send := func(t *testing.T, path string, body []byte, contentType string) []byte {
+				request, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+				require.NoError(t, err)
+				request.Header.Set(handler.ContentType, contentType)
+				request.Header.Set(handler.Accept, codec.contentType)
+
+				response, err := ts.Client().Do(request)
+				require.NoError(t, err)
+				defer response.Body.Close()
+
+				data, err := io.ReadAll(response.Body)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, response.StatusCode, string(data))
+
+				return data
+			}
+
+			gauge := signedMetric(t, metricPkg.GaugeType, "testGauge", 0.1)
+			counter := signedMetric(t, metricPkg.CounterType, "testCounter", 7)
+
+			body, err := codec.marshal(gauge)
+			require.NoError(t, err)
+			send(t, "/update/", body, codec.contentType)
+
+			body, err = codec.marshal([]metricPkg.Metric{counter})
+			require.NoError(t, err)
+			send(t, "/updates/", body, codec.contentType)
+
+			// Запрос метрики всегда в JSON, ответ - в формате из Accept
+			request, err := json.Marshal(metricPkg.Metric{ID: gauge.ID, MType: gauge.MType})
+			require.NoError(t, err)
+
+			got, err := codec.decode(send(t, "/value/", request, handler.ApplicationJSON))
+			require.NoError(t, err)
+			require.Equal(t, *gauge.Value, *got.Value)
+			require.Equal(t, gauge.Hash, got.Hash)
+
+			request, err = json.Marshal([]metricPkg.Metric{
+				{ID: gauge.ID, MType: gauge.MType},
+				{ID: counter.ID, MType: counter.MType},
+			})
+			require.NoError(t, err)
+
+			batch, err := codec.decodeBatch(send(t, "/values/", request, handler.ApplicationJSON))
+			require.NoError(t, err)
+			require.Len(t, batch, 2)
+			require.Equal(t, gauge.Hash, batch[0].Hash)
+			require.Equal(t, counter.Hash, batch[1].Hash)
+			require.Equal(t, *counter.Delta, *batch[1].Delta)
+		})
+	}
+
+	t.Run("Malformed MessagePack", func(t *testing.T) {
+
+		ts, _ := newTestServer(t)
+
+		response, err := http.Post(ts.URL+"/update/", handler.ApplicationMsgpack, strings.NewReader("not msgpack"))
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
@@ -3,9 +3,13 @@ package server
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"metrics-and-alerting/internal/pubsub"
 	"metrics-and-alerting/internal/storage"
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/pkg/clock"
 	"metrics-and-alerting/pkg/errs"
 	"metrics-and-alerting/pkg/logpack"
 	metricPkg "metrics-and-alerting/pkg/metric"
@@ -13,21 +17,57 @@ import (
 
 type OptionsManager func(*MetricsManager)
 
+// Идентификаторы фоновых задач, которые выводятся в лог вместо идентификатора запроса
+const (
+	BgSave     = "bg-save"
+	BgCompact  = "bg-compact"
+	BgSweep    = "bg-sweep"
+	BgRestore  = "bg-restore"
+	BgShutdown = "bg-shutdown"
+	BgMonitor  = "bg-monitor"
+)
+
+var _ storage.Repository = (*MetricsManager)(nil)
+
 type MetricsManager struct {
-	storage       storage.Repository
-	logger        *logpack.LogPack
-	intervalFlush time.Duration
-	restore       bool
-	signKey       []byte
-	ctx           context.Context
-	cancel        context.CancelFunc
+	storage         storage.Repository
+	logger          *logpack.LogPack
+	intervalFlush   time.Duration
+	restore         bool
+	signKey         []byte
+	prevSignKeys    [][]byte // ключи, подписи которыми еще принимаются после смены ключа
+	hashAlgo        string
+	signs           *signCache
+	ttl             time.Duration
+	ttlCounters     bool
+	monitorInterval time.Duration
+	compactInterval time.Duration
+	gaugeBounds     *gaugeBounds
+	rejectNegative  bool
+	broker          *pubsub.Broker
+	capacity        *capacity
+	stats           *gaugeStats
+	saveTimeout     time.Duration // 0 - сохранение при завершении работы не ограничено по времени
+	clock           clock.Clock
+	mu              *sync.Mutex // сериализация изменения метрик и удаления устаревших метрик
+	requests        *int64      // количество запросов с последнего сбора метрик сервера
+	lastFlush       *int64      // время последнего успешного сохранения в наносекундах Unix
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wg              *sync.WaitGroup // фоновые задачи сохранения, сжатия, удаления устаревших метрик и сбора метрик сервера
 }
 
 func New(storage storage.Repository, logger *logpack.LogPack, opts ...OptionsManager) *MetricsManager {
 
 	manager := &MetricsManager{
-		storage: storage,
-		logger:  logger,
+		storage:   storage,
+		logger:    logger,
+		clock:     clock.New(),
+		mu:        &sync.Mutex{},
+		wg:        &sync.WaitGroup{},
+		requests:  new(int64),
+		lastFlush: new(int64),
+		signs:     newSignCache(),
 	}
 
 	manager.ctx, manager.cancel = context.WithCancel(context.Background())
@@ -36,46 +76,182 @@ func New(storage storage.Repository, logger *logpack.LogPack, opts ...OptionsMan
 		opt(manager)
 	}
 
+	ctx := logpack.WithRequestID(context.Background(), BgRestore)
+
 	if manager.restore {
-		if errRestore := storage.Restore(); errRestore != nil {
-			logger.Err.Printf("Could not restore: %v\n", errRestore)
+		if errRestore := storage.Restore(ctx); errRestore != nil {
+			logger.Ctx(ctx).Err.Printf("Could not restore: %v\n", errRestore)
 		}
 	}
 
+	if errCapacity := manager.loadCapacity(ctx); errCapacity != nil {
+		logger.Ctx(ctx).Err.Println(errCapacity)
+	}
+
+	// Тикеры создаются до запуска фоновых задач, чтобы время часов
+	// можно было переводить сразу после создания менеджера
 	if manager.intervalFlush > 0 {
-		go manager.flushByTick(manager.ctx)
+		manager.wg.Add(1)
+		go manager.flushByTick(manager.ctx, manager.clock.NewTicker(manager.intervalFlush))
+	}
+
+	if manager.compactInterval > 0 {
+		manager.wg.Add(1)
+		go manager.compactByTick(manager.ctx, manager.clock.NewTicker(manager.compactInterval))
+	}
+
+	if manager.ttl > 0 {
+		manager.wg.Add(1)
+		go manager.sweepByTick(manager.ctx, manager.clock.NewTicker(manager.ttl))
+	}
+
+	if manager.monitorInterval > 0 {
+		manager.wg.Add(1)
+		go manager.selfMonitorByTick(manager.ctx, manager.clock.NewTicker(manager.monitorInterval))
 	}
 
 	return manager
 }
 
+// stop Остановка фоновых задач с ожиданием их завершения.
+// Повторный вызов ничего не делает
+func (manager MetricsManager) stop() {
+	manager.cancel()
+	manager.wg.Wait()
+}
+
 func WithSignKey(signKey []byte) OptionsManager {
 	return func(manager *MetricsManager) {
 		manager.signKey = signKey
 	}
 }
 
+// WithPreviousSignKeys Предыдущие ключи подписи. Метрики подписываются только ключом WithSignKey,
+// а подпись любым из предыдущих ключей тоже принимается, чтобы агенты переходили на новый ключ постепенно
+func WithPreviousSignKeys(keys ...[]byte) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.prevSignKeys = keys
+	}
+}
+
+// WithHashAlgo Алгоритм хеширования для подписи метрик
+func WithHashAlgo(algo string) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.hashAlgo = algo
+	}
+}
+
 func WithFlush(interval time.Duration) OptionsManager {
 	return func(manager *MetricsManager) {
 		manager.intervalFlush = interval
 	}
 }
 
+// WithClock Часы для фоновых задач и времени обновления метрик
+func WithClock(c clock.Clock) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.clock = c
+	}
+}
+
+// WithCompact Интервал удаления повторов метрик из файла хранилища. 0 - не выполнять.
+// Используется только хранилищем, которое поддерживает сжатие
+func WithCompact(interval time.Duration) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.compactInterval = interval
+	}
+}
+
+// WithBroker Публикация сохраненных метрик подписчикам на обновления
+func WithBroker(broker *pubsub.Broker) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.broker = broker
+	}
+}
+
+// DefaultShutdownSaveTimeout Максимальное время сохранения метрик при завершении работы по умолчанию
+const DefaultShutdownSaveTimeout = 10 * time.Second
+
+// WithShutdownSave Максимальное время сохранения метрик и закрытия хранилища при завершении работы.
+// Если сохранение не укладывается в timeout, работа завершается без него. 0 - время не ограничено
+func WithShutdownSave(timeout time.Duration) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.saveTimeout = timeout
+	}
+}
+
 func WithRestore(restore bool) OptionsManager {
 	return func(manager *MetricsManager) {
 		manager.restore = restore
 	}
 }
 
-func (manager MetricsManager) flushByTick(ctx context.Context) {
+// WithTTL Время жизни метрики без обновлений.
+// Устаревшие метрики gauge удаляются, counter - только при counters = true
+func WithTTL(ttl time.Duration, counters bool) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.ttl = ttl
+		manager.ttlCounters = counters
+	}
+}
+
+func (manager MetricsManager) flushByTick(ctx context.Context, ticker clock.Ticker) {
+	defer manager.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			bg := logpack.WithRequestID(context.Background(), BgSave)
+			if err := manager.flushStorage(bg); err != nil {
+				manager.logger.Ctx(bg).Err.Printf("could not flush metrics: %v\n", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (manager MetricsManager) compactByTick(ctx context.Context, ticker clock.Ticker) {
+	defer manager.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			bg := logpack.WithRequestID(context.Background(), BgCompact)
+			if err := manager.compact(bg); err != nil {
+				manager.logger.Ctx(bg).Err.Printf("could not compact storage: %v\n", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// compact Удаление повторов метрик из хранилища, если оно это поддерживает
+func (manager MetricsManager) compact(ctx context.Context) error {
 
-	ticker := time.NewTicker(manager.intervalFlush)
+	compactor, ok := storage.Unwrap(manager.storage).(interface{ Compact(context.Context) error })
+	if !ok {
+		return nil
+	}
+
+	return compactor.Compact(ctx)
+}
+
+func (manager MetricsManager) sweepByTick(ctx context.Context, ticker clock.Ticker) {
+	defer manager.wg.Done()
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := manager.storage.Flush(); err != nil {
-				manager.logger.Err.Printf("could not flush metrics: %v\n", err)
+		case <-ticker.C():
+			bg := logpack.WithRequestID(context.Background(), BgSweep)
+			if err := manager.sweep(bg); err != nil {
+				manager.logger.Ctx(bg).Err.Printf("could not delete stale metrics: %v\n", err)
 			}
 
 		case <-ctx.Done():
@@ -84,12 +260,52 @@ func (manager MetricsManager) flushByTick(ctx context.Context) {
 	}
 }
 
-func (manager MetricsManager) accumulateCounter(metric *metricPkg.Metric) {
-	if metric.MType != metricPkg.CounterType {
+// sweep Удаление метрик, которые не обновлялись дольше ttl.
+// Метрики с неизвестным временем обновления не удаляются
+func (manager MetricsManager) sweep(ctx context.Context) error {
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	metrics, err := manager.storage.GetBatch(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := manager.clock.Now().Add(-manager.ttl)
+
+	for _, m := range metrics {
+		if metricPkg.IsCounter(m.MType) && !manager.ttlCounters {
+			continue
+		}
+
+		if m.LastUpdate.IsZero() || m.LastUpdate.After(deadline) {
+			continue
+		}
+
+		if err := manager.storage.Delete(ctx, m); err != nil {
+			return fmt.Errorf("could not delete metric %s: %w", m.ShotString(), err)
+		}
+		manager.signs.drop(m)
+		manager.trackDelete(m)
+
+		manager.logger.Ctx(ctx).Info.Printf("stale metric deleted: %s\n", m.ShotString())
+	}
+
+	return nil
+}
+
+func (manager MetricsManager) accumulateCounter(ctx context.Context, metric *metricPkg.Metric) {
+	if metric.MType == metricPkg.FloatCounterType {
+		manager.accumulateFloatCounter(ctx, metric)
 		return
 	}
 
-	knownCounter, err := manager.storage.Get(*metric)
+	if metric.MType != metricPkg.CounterType || metric.Delta == nil {
+		return
+	}
+
+	knownCounter, err := manager.storage.Get(ctx, *metric)
 	if err != nil {
 		return
 	}
@@ -99,37 +315,156 @@ func (manager MetricsManager) accumulateCounter(metric *metricPkg.Metric) {
 
 }
 
-// verifySign - Проверка подписи метрики
+// accumulateFloatCounter Сложение приращения float_counter с сохраненным значением
+func (manager MetricsManager) accumulateFloatCounter(ctx context.Context, metric *metricPkg.Metric) {
+	if metric.Value == nil {
+		return
+	}
+
+	knownCounter, err := manager.storage.Get(ctx, *metric)
+	if err != nil || knownCounter.Value == nil {
+		return
+	}
+
+	accum := *metric.Value + *knownCounter.Value
+	metric.Value = &accum
+}
+
+// incrementGauge Приращение gauge с операцией inc к сохраненному значению.
+// Если gauge еще не сохранен, приращение выполняется от нуля
+func (manager MetricsManager) incrementGauge(ctx context.Context, metric *metricPkg.Metric) {
+	if metric.MType != metricPkg.GaugeType || metric.Op != metricPkg.OpInc {
+		return
+	}
+
+	metric.Op = ""
+
+	knownGauge, err := manager.storage.Get(ctx, *metric)
+	if err != nil || knownGauge.Value == nil {
+		return
+	}
+
+	accum := *metric.Value + *knownGauge.Value
+	metric.Value = &accum
+}
+
+// unchanged Признак того, что метрика уже сохранена с тем же значением.
+// Значение гистограммы всегда считается измененным
+func (manager MetricsManager) unchanged(ctx context.Context, metric metricPkg.Metric) bool {
+
+	known, err := manager.storage.Get(ctx, metric)
+	if err != nil {
+		return false
+	}
+
+	switch metric.MType {
+	case metricPkg.GaugeType, metricPkg.FloatCounterType:
+		return known.Value != nil && metric.Value != nil && *known.Value == *metric.Value
+	case metricPkg.CounterType:
+		return known.Delta != nil && metric.Delta != nil && *known.Delta == *metric.Delta
+	default:
+		return false
+	}
+}
+
+// skipFlush Признак того, что сохранение после обновления не нужно: значения не изменились,
+// а время обновления сохранено недавно. Иначе с неизменным значением в хранилище оставалось бы
+// старое время обновления, и после перезапуска живая метрика удалилась бы как устаревшая,
+// поэтому при заданном ttl метрики сохраняются не реже чем раз в половину ttl
+func (manager MetricsManager) skipFlush(unchanged bool) bool {
+
+	if !unchanged {
+		return false
+	}
+
+	if manager.ttl <= 0 {
+		return true
+	}
+
+	return manager.clock.Now().Sub(manager.LastFlush()) < manager.ttl/2
+}
+
+// verifySign - Проверка подписи метрики текущим или одним из предыдущих ключей
 func (manager MetricsManager) verifySign(metric metricPkg.Metric) error {
 	if len(manager.signKey) == 0 {
 		return nil
 	}
 
-	hash, err := metric.Sign(manager.signKey)
+	hash, err := metric.SignWith(manager.hashAlgo, manager.signKey)
 	if err != nil {
 		return err
 	}
 
-	if hash != metric.Hash {
-		return errs.ErrSignFailed
+	if hash == metric.Hash {
+		return nil
 	}
 
-	return nil
+	for _, key := range manager.prevSignKeys {
+		if len(key) == 0 {
+			continue
+		}
+
+		if hash, err = metric.SignWith(manager.hashAlgo, key); err == nil && hash == metric.Hash {
+			return nil
+		}
+	}
+
+	return errs.ErrSignFailed
 }
 
-func (manager MetricsManager) Upsert(metric metricPkg.Metric) error {
+// Check Проверка полей, подписи и приращения метрики так же, как при обновлении, без сохранения
+func (manager MetricsManager) Check(metric metricPkg.Metric) error {
+
+	if err := metric.Validate(); err != nil {
+		return err
+	}
 
 	if err := manager.verifySign(metric); err != nil {
+		return err
+	}
+
+	return manager.checkDelta(metric)
+}
+
+// Upsert Обновление метрики. Если значение метрики не изменилось, хранилище не перезаписывается,
+// пока не пора сохранить время обновления (см. skipFlush)
+func (manager MetricsManager) Upsert(ctx context.Context, metric metricPkg.Metric) error {
+
+	if err := manager.Check(metric); err != nil {
 		return fmt.Errorf("could not upsert metric: %w", err)
 	}
 
-	manager.accumulateCounter(&metric)
+	metric = scope(ctx, metric)
 
-	err := manager.storage.Upsert(metric)
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.accumulateCounter(ctx, &metric)
+	manager.incrementGauge(ctx, &metric)
+	if err := manager.boundGauge(&metric); err != nil {
+		return fmt.Errorf("could not upsert metric: %w", err)
+	}
+
+	if err := manager.admit(ctx, []metricPkg.Metric{metric}); err != nil {
+		return fmt.Errorf("could not upsert metric: %w", err)
+	}
+
+	unchanged := manager.unchanged(ctx, metric)
+	metric.LastUpdate = manager.clock.Now()
+
+	err := manager.storage.Upsert(ctx, metric)
 
 	if err == nil {
-		if err = manager.Flush(); err != nil {
-			manager.logger.Err.Printf("Could not flush metrics after upsert: %v\n", err)
+		manager.trackUpdate(metric)
+		manager.publish(metric)
+
+		// Если значение не изменилось, хранилище не перезаписывается
+		if manager.skipFlush(unchanged) {
+			return nil
+		}
+
+		if err = manager.Flush(ctx); err != nil {
+			manager.logger.Ctx(ctx).Err.Printf("Could not flush metrics after upsert: %v\n", err)
 		}
 
 		return nil
@@ -138,38 +473,153 @@ func (manager MetricsManager) Upsert(metric metricPkg.Metric) error {
 	return err
 }
 
-func (manager MetricsManager) UpsertBatch(metrics []metricPkg.Metric) error {
+// UpsertBatch Обновление набора метрик одним обращением к хранилищу.
+// Значения counter, float_counter и приращения gauge с одинаковыми ID и метками внутри набора накапливаются.
+// Если значения всех метрик набора не изменились, хранилище не перезаписывается, как и в Upsert
+func (manager MetricsManager) UpsertBatch(ctx context.Context, metrics []metricPkg.Metric) error {
 
-	for i, m := range metrics {
-		if err := manager.verifySign(m); err != nil {
+	for _, m := range metrics {
+		if err := manager.Check(m); err != nil {
 			return fmt.Errorf("could not upsert metrics %s: %w", m, err)
 		}
+	}
+
+	for i, m := range metrics {
+		metrics[i] = scope(ctx, m)
+	}
+
+	return manager.upsertBatch(ctx, metrics, false)
+}
+
+// ReplaceBatch Обновление набора метрик, в котором counter и float_counter заменяют сохраненные значения,
+// а из повторов одного counter в наборе остается последний.
+// Набор проверяется до записи целиком, поэтому при ошибке сохраненные значения не меняются
+func (manager MetricsManager) ReplaceBatch(ctx context.Context, metrics []metricPkg.Metric) error {
+
+	for _, m := range metrics {
+		if err := manager.Check(m); err != nil {
+			return fmt.Errorf("could not replace metrics %s: %w", m, err)
+		}
+	}
+
+	for i, m := range metrics {
+		metrics[i] = scope(ctx, m)
+	}
+
+	return manager.upsertBatch(ctx, metrics, true)
+}
+
+// upsertBatch Обновление проверенного набора метрик.
+// С replace значения counter и float_counter не накапливаются, а заменяют сохраненные
+func (manager MetricsManager) upsertBatch(ctx context.Context, metrics []metricPkg.Metric, replace bool) error {
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	counters := make(map[string]int64)
+	floatCounters := make(map[string]float64)
+	gauges := make(map[string]float64)
+	unchanged := true
+	now := manager.clock.Now()
 
-		manager.accumulateCounter(&m)
-		metrics[i].Delta = m.Delta
+	for i, m := range metrics {
+		metrics[i].LastUpdate = now
+
+		switch {
+		case m.MType == metricPkg.CounterType && m.Delta != nil && !replace:
+			if known, ok := counters[m.Key()]; ok {
+				accum := known + *m.Delta
+				metrics[i].Delta = &accum
+			} else {
+				manager.accumulateCounter(ctx, &metrics[i])
+			}
+
+			counters[m.Key()] = *metrics[i].Delta
+
+		case m.MType == metricPkg.FloatCounterType && m.Value != nil && !replace:
+			if known, ok := floatCounters[m.Key()]; ok {
+				accum := known + *m.Value
+				metrics[i].Value = &accum
+			} else {
+				manager.accumulateFloatCounter(ctx, &metrics[i])
+			}
+
+			floatCounters[m.Key()] = *metrics[i].Value
+
+		case m.MType == metricPkg.GaugeType && m.Value != nil:
+			if known, ok := gauges[m.Key()]; ok && m.Op == metricPkg.OpInc {
+				accum := known + *m.Value
+				metrics[i].Value = &accum
+				metrics[i].Op = ""
+			} else {
+				manager.incrementGauge(ctx, &metrics[i])
+			}
+
+			if err := manager.boundGauge(&metrics[i]); err != nil {
+				return fmt.Errorf("could not update metrics: %w", err)
+			}
 
-		if err := manager.storage.Upsert(m); err != nil {
-			err = fmt.Errorf("could not update metric %s: %w", m.ShotString(), err)
-			manager.logger.Err.Println(err)
-			return err
+			gauges[m.Key()] = *metrics[i].Value
 		}
+
+		unchanged = unchanged && manager.unchanged(ctx, metrics[i])
+	}
+
+	if err := manager.admit(ctx, metrics); err != nil {
+		return fmt.Errorf("could not update metrics: %w", err)
+	}
+
+	if err := manager.storage.UpsertBatch(ctx, metrics); err != nil {
+		err = fmt.Errorf("could not update metrics: %w", err)
+		manager.logger.Ctx(ctx).Err.Println(err)
+		return err
+	}
+
+	manager.trackUpdate(metrics...)
+	manager.publish(metrics...)
+
+	if manager.skipFlush(unchanged) {
+		return nil
 	}
 
-	if err := manager.Flush(); err != nil {
-		manager.logger.Err.Printf("Could not flush metrics after upsert: batch %v\n", err)
+	if err := manager.Flush(ctx); err != nil {
+		manager.logger.Ctx(ctx).Err.Printf("Could not flush metrics after upsert: batch %v\n", err)
 	}
 
 	return nil
 }
 
-func (manager MetricsManager) Get(metric metricPkg.Metric) (metricPkg.Metric, error) {
+// publish Публикация сохраненных метрик подписчикам без подписи
+func (manager MetricsManager) publish(metrics ...metricPkg.Metric) {
+
+	if manager.broker == nil {
+		return
+	}
+
+	published := make([]metricPkg.Metric, len(metrics))
+	for i, m := range metrics {
+		m.Hash = ""
+		published[i] = m
+	}
+
+	manager.broker.Publish(published...)
+}
+
+func (manager MetricsManager) Get(ctx context.Context, metric metricPkg.Metric) (metricPkg.Metric, error) {
 
-	m, err := manager.storage.Get(metric)
+	scoped, err := scopeStored(ctx, metric)
 	if err != nil {
 		return metricPkg.Metric{}, err
 	}
 
-	if hash, err := m.Sign(manager.signKey); err == nil {
+	m, err := manager.storage.Get(ctx, scoped)
+	if err != nil {
+		return metricPkg.Metric{}, err
+	}
+
+	m.ID = metric.ID
+
+	if hash, err := manager.signs.sign(m, manager.hashAlgo, manager.signKey); err == nil {
 		m.Hash = hash
 	} else {
 		manager.logger.Err.Printf("could not get hash metric: %v\n", err)
@@ -178,15 +628,36 @@ func (manager MetricsManager) Get(metric metricPkg.Metric) (metricPkg.Metric, er
 	return m, nil
 }
 
-func (manager MetricsManager) GetBatch() ([]metricPkg.Metric, error) {
+func (manager MetricsManager) GetBatch(ctx context.Context) ([]metricPkg.Metric, error) {
+
+	metrics, err := manager.storage.GetBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics = unscope(ctx, metrics)
+	manager.signMetrics(metrics)
+	return metrics, nil
+}
+
+// GetByType Получение подписанных метрик типа typeMetric, отсортированных по ID
+func (manager MetricsManager) GetByType(ctx context.Context, typeMetric string) ([]metricPkg.Metric, error) {
 
-	metrics, err := manager.storage.GetBatch()
+	metrics, err := manager.storage.GetByType(ctx, typeMetric)
 	if err != nil {
 		return nil, err
 	}
 
+	metrics = unscope(ctx, metrics)
+	manager.signMetrics(metrics)
+	return metrics, nil
+}
+
+// signMetrics Подпись набора метрик
+func (manager MetricsManager) signMetrics(metrics []metricPkg.Metric) {
+
 	for i, m := range metrics {
-		hash, err := m.Sign(manager.signKey)
+		hash, err := manager.signs.sign(m, manager.hashAlgo, manager.signKey)
 		if err != nil {
 			manager.logger.Err.Printf("could not get hash metric: %v\n", err)
 			continue
@@ -194,17 +665,71 @@ func (manager MetricsManager) GetBatch() ([]metricPkg.Metric, error) {
 
 		metrics[i].Hash = hash
 	}
+}
 
-	return metrics, nil
+// Reset Сброс значения counter в ноль.
+// Подпись метрики вычисляется заново при чтении
+func (manager MetricsManager) Reset(ctx context.Context, metric metricPkg.Metric) error {
+
+	metric, err := scopeStored(ctx, metric)
+	if err != nil {
+		return fmt.Errorf("could not reset metric: %w", err)
+	}
+
+	manager.mu.Lock()
+	metric.LastUpdate = manager.clock.Now()
+	err = manager.storage.Reset(ctx, metric)
+	manager.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("could not reset metric: %w", err)
+	}
+
+	if err := manager.Flush(ctx); err != nil {
+		manager.logger.Ctx(ctx).Err.Printf("Could not flush metrics after reset: %v\n", err)
+	}
+
+	return nil
 }
 
-func (manager MetricsManager) Delete(metric metricPkg.Metric) error {
+// Count Количество метрик типа typeMetric арендатора запроса.
+// Если хранилище ведет количество метрик по арендаторам, метрики не перебираются
+func (manager MetricsManager) Count(ctx context.Context, typeMetric string) (int, error) {
+
+	counter, ok := storage.Unwrap(manager.storage).(interface {
+		CountTenant(ctx context.Context, owner, typeMetric string) (int, error)
+	})
+	if ok {
+		return counter.CountTenant(ctx, tenant.FromContext(ctx), typeMetric)
+	}
+
+	metrics, err := manager.storage.GetByType(ctx, typeMetric)
+	if err != nil {
+		return 0, err
+	}
 
-	err := manager.storage.Delete(metric)
+	return len(unscope(ctx, metrics)), nil
+}
 
+func (manager MetricsManager) Delete(ctx context.Context, metric metricPkg.Metric) error {
+
+	scoped, err := scopeStored(ctx, metric)
+	if err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+	err = manager.storage.Delete(ctx, scoped)
 	if err == nil {
-		if err = manager.Flush(); err != nil {
-			manager.logger.Err.Printf("Could not flush metrics after delete: %v\n", err)
+		manager.trackDelete(scoped)
+	}
+	manager.mu.Unlock()
+
+	manager.signs.drop(metric)
+
+	if err == nil {
+		if err = manager.Flush(ctx); err != nil {
+			manager.logger.Ctx(ctx).Err.Printf("Could not flush metrics after delete: %v\n", err)
 		}
 
 		return nil
@@ -213,20 +738,163 @@ func (manager MetricsManager) Delete(metric metricPkg.Metric) error {
 	return err
 }
 
-func (manager MetricsManager) Flush() error {
+// DeleteWhere Удаление всех метрик, для которых match возвращает true, одним обращением к хранилищу.
+// Возвращается количество удаленных метрик
+func (manager MetricsManager) DeleteWhere(ctx context.Context, match func(metricPkg.Metric) bool) (int, error) {
+
+	owner := tenant.FromContext(ctx)
+	deleted := make([]metricPkg.Metric, 0)
+	unscoped := make([]metricPkg.Metric, 0)
+
+	manager.mu.Lock()
+	count, err := manager.storage.DeleteWhere(ctx, func(metric metricPkg.Metric) bool {
+		m, ok := tenant.Unscope(owner, metric)
+		if ok && match(m) {
+			deleted = append(deleted, metric)
+			unscoped = append(unscoped, m)
+			return true
+		}
+
+		return false
+	})
+	manager.trackDelete(deleted...)
+	manager.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, metric := range unscoped {
+		manager.signs.drop(metric)
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	if err = manager.Flush(ctx); err != nil {
+		manager.logger.Ctx(ctx).Err.Printf("Could not flush metrics after delete: %v\n", err)
+	}
+
+	return count, nil
+}
+
+// Rename Переименование метрики с сохранением типа, меток и значения.
+// Подпись метрики с новым ID вычисляется заново при чтении
+func (manager MetricsManager) Rename(ctx context.Context, metric metricPkg.Metric, newID string) error {
+
+	if err := metricPkg.ValidateName(newID); err != nil {
+		return fmt.Errorf("could not rename metric: %w", err)
+	}
+
+	scoped, err := scopeStored(ctx, metric)
+	if err != nil {
+		return fmt.Errorf("could not rename metric: %w", err)
+	}
+
+	renamed := metric
+	renamed.ID = newID
+	renamed = scope(ctx, renamed)
+
+	manager.mu.Lock()
+	err = manager.storage.Rename(ctx, scoped, renamed.ID)
+	if err == nil {
+		manager.renameStats(scoped, renamed)
+		manager.trackDelete(scoped)
+		manager.trackUpdate(renamed)
+	}
+	manager.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("could not rename metric: %w", err)
+	}
+
+	manager.signs.drop(metric)
+
+	if err := manager.Flush(ctx); err != nil {
+		manager.logger.Ctx(ctx).Err.Printf("Could not flush metrics after rename: %v\n", err)
+	}
+
+	return nil
+}
+
+func (manager MetricsManager) Flush(ctx context.Context) error {
 
 	if manager.intervalFlush == 0 {
-		return manager.storage.Flush()
+		return manager.flushStorage(ctx)
 	}
 
 	return nil
 }
 
-func (manager MetricsManager) Restore() error {
-	return manager.storage.Restore()
+func (manager MetricsManager) Restore(ctx context.Context) error {
+
+	if err := manager.storage.Restore(ctx); err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return manager.loadCapacity(ctx)
+}
+
+// Shutdown Остановка фоновых задач, сохранение метрик и закрытие хранилища.
+// Хранилище закрывается, даже если сохранить метрики не удалось.
+// Если сохранение не завершилось за время WithShutdownSave, например из-за зависшего
+// сетевого диска, выводится предупреждение и возвращается ошибка без ожидания сохранения
+func (manager MetricsManager) Shutdown() error {
+
+	manager.stop()
+
+	ctx := logpack.WithRequestID(context.Background(), BgShutdown)
+	if manager.saveTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, manager.saveTimeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.saveAndClose(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-ctx.Done():
+		manager.logger.Ctx(ctx).Warn.Printf("metrics were not saved on shutdown within %s, exiting without saving\n",
+			manager.saveTimeout)
+		return fmt.Errorf("could not save metrics on shutdown: %w", ctx.Err())
+	}
+}
+
+// saveAndClose Сохранение метрик и закрытие хранилища
+func (manager MetricsManager) saveAndClose(ctx context.Context) error {
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	errFlush := manager.flushStorage(ctx)
+	if errFlush != nil {
+		manager.logger.Ctx(ctx).Err.Printf("could not flush metrics on shutdown: %v\n", errFlush)
+	}
+
+	if err := manager.storage.Close(); err != nil {
+		return fmt.Errorf("could not close storage: %w", err)
+	}
+
+	if errFlush != nil {
+		return fmt.Errorf("could not flush metrics: %w", errFlush)
+	}
+
+	return nil
 }
 
+// Close Остановка фоновых задач и закрытие хранилища
 func (manager MetricsManager) Close() error {
+	manager.stop()
 	return manager.storage.Close()
 }
 
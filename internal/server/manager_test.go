@@ -0,0 +1,890 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/internal/storage/filestorage"
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/clock"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// TestMetricsManager_UpsertBatch Значения counter с одинаковым ID в наборе должны накапливаться
+func TestMetricsManager_UpsertBatch(t *testing.T) {
+
+	manager := New(memstore.New(), logpack.NewLogger())
+
+	first, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(5))
+	require.NoError(t, manager.Upsert(context.Background(), first))
+
+	batch := make([]metricPkg.Metric, 0, 3)
+	for _, delta := range []int64{1, 2, 3} {
+		m, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(delta))
+		batch = append(batch, m)
+	}
+
+	require.NoError(t, manager.UpsertBatch(context.Background(), batch))
+
+	got, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+	require.NoError(t, err)
+	require.Equal(t, int64(11), *got.Delta)
+}
+
+// TestMetricsManager_UpsertBatchLarge Значения counter накапливаются в наборе,
+// который хранилище обновляет по частям
+func TestMetricsManager_UpsertBatchLarge(t *testing.T) {
+
+	const count = 1000
+
+	manager := New(memstore.New(), logpack.NewLogger())
+
+	batch := make([]metricPkg.Metric, 0, 2*count)
+	for i := 0; i < count; i++ {
+		counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(2))
+		gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge_"+strconv.Itoa(i), metricPkg.WithValueInt(int64(i)))
+		batch = append(batch, counter, gauge)
+	}
+
+	require.NoError(t, manager.UpsertBatch(context.Background(), batch))
+	require.NoError(t, manager.UpsertBatch(context.Background(), batch[:2]))
+
+	got, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+	require.NoError(t, err)
+	require.Equal(t, int64(2*count+2), *got.Delta)
+
+	gauges, err := manager.Count(context.Background(), metricPkg.GaugeType)
+	require.NoError(t, err)
+	require.Equal(t, count, gauges)
+}
+
+// TestMetricsManager_ConcurrentUpsert Обновление метрик из нескольких горутин во время сохранения по таймеру
+func TestMetricsManager_ConcurrentUpsert(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	store := filestorage.New(filepath.Join(t.TempDir(), "metrics.json"), logger)
+	manager := New(store, logger, WithFlush(time.Millisecond))
+	defer manager.cancel()
+
+	const (
+		workers = 10
+		updates = 100
+	)
+
+	// require нельзя вызывать вне горутины теста, поэтому ошибки проверяются после завершения обновлений
+	failures := make(chan error, workers)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < updates; i++ {
+				m, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge_"+strconv.Itoa(w), metricPkg.WithValueInt(int64(i)))
+				if err := manager.Upsert(context.Background(), m); err != nil {
+					failures <- err
+					return
+				}
+
+				if _, err := manager.GetBatch(context.Background()); err != nil {
+					failures <- err
+					return
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(failures)
+
+	for err := range failures {
+		require.NoError(t, err)
+	}
+
+	metrics, err := manager.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, workers)
+}
+
+// TestMetricsManager_HashAlgo Подпись SHA512 проверяется только менеджером с тем же алгоритмом
+func TestMetricsManager_HashAlgo(t *testing.T) {
+
+	key := []byte("secret")
+
+	m, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, err)
+
+	m.Hash, err = m.SignWith(metricPkg.HashSHA512, key)
+	require.NoError(t, err)
+
+	sha256Manager := New(memstore.New(), logpack.NewLogger(), WithSignKey(key))
+	require.ErrorIs(t, sha256Manager.Upsert(context.Background(), m), errs.ErrSignFailed)
+
+	sha512Manager := New(memstore.New(), logpack.NewLogger(), WithSignKey(key), WithHashAlgo(metricPkg.HashSHA512))
+	require.NoError(t, sha512Manager.Upsert(context.Background(), m))
+
+	got, err := sha512Manager.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, m.Hash, got.Hash)
+}
+
+// TestMetricsManager_PreviousSignKeys Подпись предыдущим ключом принимается,
+// а метрики подписываются только текущим ключом
+func TestMetricsManager_PreviousSignKeys(t *testing.T) {
+
+	oldKey, newKey := []byte("old-secret"), []byte("new-secret")
+	manager := New(memstore.New(), logpack.NewLogger(), WithSignKey(newKey), WithPreviousSignKeys(oldKey))
+
+	tests := []struct {
+		name    string
+		key     []byte
+		value   float64
+		wantErr error
+	}{
+		{name: "Current key", key: newKey, value: 1},
+		{name: "Previous key", key: oldKey, value: 2},
+		{name: "Unknown key", key: []byte("other-secret"), value: 3, wantErr: errs.ErrSignFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			m, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(tt.value))
+			require.NoError(t, err)
+
+			m.Hash, err = m.Sign(tt.key)
+			require.NoError(t, err)
+
+			err = manager.Upsert(context.Background(), m)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			got, err := manager.Get(context.Background(), m)
+			require.NoError(t, err)
+
+			want, err := got.Sign(newKey)
+			require.NoError(t, err)
+			require.Equal(t, want, got.Hash)
+		})
+	}
+}
+
+// TestMetricsManager_SignLabels Подпись учитывает метки и не зависит от порядка их задания
+func TestMetricsManager_SignLabels(t *testing.T) {
+
+	key := []byte("secret")
+	manager := New(memstore.New(), logpack.NewLogger(), WithSignKey(key))
+
+	m, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "cpu",
+		metricPkg.WithValueFloat(0.5),
+		metricPkg.WithLabels(metricPkg.Labels{"host": "a", "core": "1"}))
+
+	plain := m
+	plain.Labels = nil
+
+	plainHash, err := plain.Sign(key)
+	require.NoError(t, err)
+
+	m.Hash = plainHash
+	require.ErrorIs(t, manager.Upsert(context.Background(), m), errs.ErrSignFailed)
+
+	same := metricPkg.Labels{}
+	same["core"] = "1"
+	same["host"] = "a"
+
+	signed := m
+	signed.Labels = same
+	signed.Hash, err = signed.Sign(key)
+	require.NoError(t, err)
+
+	require.NoError(t, manager.Upsert(context.Background(), signed))
+
+	got, err := manager.Get(context.Background(), m)
+	require.NoError(t, err)
+	require.Equal(t, signed.Hash, got.Hash)
+	require.NotEqual(t, plainHash, got.Hash)
+}
+
+// TestMetricsManager_LabelNames Метки с именем, содержащим кавычки и запятую, отклоняются:
+// в каноническом виде они совпали бы с другим набором меток, а значит, и ключ, и подпись метрики
+func TestMetricsManager_LabelNames(t *testing.T) {
+
+	key := []byte("secret")
+	manager := New(memstore.New(), logpack.NewLogger(), WithSignKey(key))
+
+	value := 0.5
+	forged := metricPkg.Metric{ID: "cpu", MType: metricPkg.GaugeType, Value: &value,
+		Labels: metricPkg.Labels{`a="1",b`: "2"}}
+	pair := metricPkg.Metric{ID: "cpu", MType: metricPkg.GaugeType, Value: &value,
+		Labels: metricPkg.Labels{"a": "1", "b": "2"}}
+
+	require.Equal(t, pair.Key(), forged.Key())
+
+	var err error
+	forged.Hash, err = forged.Sign(key)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, manager.Upsert(context.Background(), forged), errs.ErrInvalidLabel)
+	require.ErrorIs(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{forged}), errs.ErrInvalidLabel)
+
+	_, err = metricPkg.CreateMetric(metricPkg.GaugeType, "cpu", metricPkg.WithValueFloat(value),
+		metricPkg.WithLabels(forged.Labels))
+	require.ErrorIs(t, err, errs.ErrInvalidLabel)
+
+	_, err = manager.Get(context.Background(), pair)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+}
+
+// TestMetricsManager_Sweep Устаревший gauge удаляется, свежий gauge и counter остаются
+func TestMetricsManager_Sweep(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		ttlCounters bool
+		wantCounter bool
+	}{
+		{
+			name:        "Counters exempt -> counter survives",
+			ttlCounters: false,
+			wantCounter: true,
+		},
+		{
+			name:        "Counters with ttl -> counter removed",
+			ttlCounters: true,
+			wantCounter: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+			manager := New(memstore.New(), logpack.NewLogger(), WithClock(fake))
+			manager.ttl = time.Minute
+			manager.ttlCounters = tt.ttlCounters
+
+			stale, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "staleGauge", metricPkg.WithValueFloat(1))
+			counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "staleCounter", metricPkg.WithValueInt(1))
+			require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{stale, counter}))
+
+			fake.Advance(50 * time.Second)
+
+			fresh, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "freshGauge", metricPkg.WithValueFloat(2))
+			require.NoError(t, manager.Upsert(context.Background(), fresh))
+
+			fake.Advance(20 * time.Second)
+			require.NoError(t, manager.sweep(context.Background()))
+
+			_, err := manager.Get(context.Background(), stale)
+			require.ErrorIs(t, err, errs.ErrNotFound)
+
+			_, err = manager.Get(context.Background(), fresh)
+			require.NoError(t, err)
+
+			_, err = manager.Get(context.Background(), counter)
+			if tt.wantCounter {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, errs.ErrNotFound)
+			}
+		})
+	}
+}
+
+// TestMetricsManager_Restore Метрики загружаются из файла только при включенном восстановлении
+func TestMetricsManager_Restore(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	saved := filepath.Join(t.TempDir(), "metrics.json")
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+
+	store := filestorage.New(saved, logger)
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+	require.NoError(t, store.Flush(context.Background()))
+
+	tests := []struct {
+		name     string
+		fileName string
+		restore  bool
+		wantLen  int
+	}{
+		{
+			name:     "Restore existing file",
+			fileName: saved,
+			restore:  true,
+			wantLen:  1,
+		},
+		{
+			name:     "Restore missing file",
+			fileName: filepath.Join(t.TempDir(), "missing.json"),
+			restore:  true,
+			wantLen:  0,
+		},
+		{
+			name:     "Restore disabled",
+			fileName: saved,
+			restore:  false,
+			wantLen:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			manager := New(filestorage.New(tt.fileName, logger), logger, WithRestore(tt.restore))
+			defer manager.cancel()
+
+			metrics, err := manager.GetBatch(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, tt.wantLen)
+		})
+	}
+}
+
+// TestMetricsManager_SignRoundTrip Подпись gauge с высокой точностью остается верной после сохранения и восстановления
+func TestMetricsManager_SignRoundTrip(t *testing.T) {
+
+	key := []byte("secret")
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(0.1234567891234))
+	hash, err := gauge.Sign(key)
+	require.NoError(t, err)
+	gauge.Hash = hash
+
+	// Значения, совпадающие в первых шести знаках, подписываются по-разному
+	near, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(0.1234567))
+	nearHash, err := near.Sign(key)
+	require.NoError(t, err)
+	require.NotEqual(t, hash, nearHash)
+
+	manager := New(filestorage.New(fileName, logger), logger, WithSignKey(key))
+	require.NoError(t, manager.Upsert(context.Background(), gauge))
+	require.NoError(t, manager.Flush(context.Background()))
+	manager.cancel()
+
+	restored := New(filestorage.New(fileName, logger), logger, WithSignKey(key), WithRestore(true))
+	defer restored.cancel()
+
+	got, err := restored.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, *gauge.Value, *got.Value)
+	require.Equal(t, hash, got.Hash)
+
+	// Восстановленная метрика проходит проверку подписи при повторной отправке
+	require.NoError(t, restored.Upsert(context.Background(), got))
+}
+
+// TestMetricsManager_FloatCounter Приращения float_counter складываются как float64,
+// подпись накопленного значения сохраняется после восстановления из файла
+func TestMetricsManager_FloatCounter(t *testing.T) {
+
+	key := []byte("secret")
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	floatCounter := func(value float64) metricPkg.Metric {
+		m, err := metricPkg.CreateMetric(metricPkg.FloatCounterType, "testBytes", metricPkg.WithValueFloat(value))
+		require.NoError(t, err)
+
+		m.Hash, err = m.Sign(key)
+		require.NoError(t, err)
+
+		return m
+	}
+
+	manager := New(filestorage.New(fileName, logger), logger, WithSignKey(key))
+	require.NoError(t, manager.Upsert(context.Background(), floatCounter(0.1)))
+	require.NoError(t, manager.Upsert(context.Background(), floatCounter(0.2)))
+	require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{floatCounter(1e-9), floatCounter(1e-9)}))
+
+	// Сумма считается в float64 по шагам, а не константным выражением с точной арифметикой
+	want := 0.1
+	for _, delta := range []float64{0.2, 1e-9, 1e-9} {
+		want += delta
+	}
+
+	got, err := manager.Get(context.Background(), metricPkg.Metric{ID: "testBytes", MType: metricPkg.FloatCounterType})
+	require.NoError(t, err)
+	require.Nil(t, got.Delta)
+	require.Equal(t, want, *got.Value)
+
+	hash, err := got.Sign(key)
+	require.NoError(t, err)
+	require.Equal(t, hash, got.Hash)
+
+	// Приращение монотонного счетчика не может быть отрицательным
+	require.ErrorIs(t, manager.Upsert(context.Background(), floatCounter(-1)), errs.ErrInvalidValue)
+
+	require.NoError(t, manager.Flush(context.Background()))
+	manager.cancel()
+
+	restored := New(filestorage.New(fileName, logger), logger, WithSignKey(key), WithRestore(true))
+	defer restored.cancel()
+
+	got, err = restored.Get(context.Background(), metricPkg.Metric{ID: "testBytes", MType: metricPkg.FloatCounterType})
+	require.NoError(t, err)
+	require.Equal(t, want, *got.Value)
+	require.Equal(t, hash, got.Hash)
+
+	require.NoError(t, restored.Reset(context.Background(), got))
+	got, err = restored.Get(context.Background(), got)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), *got.Value)
+}
+
+// TestMetricsManager_Capacity Предельное количество метрик с отклонением новых метрик и с вытеснением
+func TestMetricsManager_Capacity(t *testing.T) {
+
+	gauge := func(id string) metricPkg.Metric {
+		m, _ := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(1))
+		return m
+	}
+
+	counter := func(id string) metricPkg.Metric {
+		m, _ := metricPkg.CreateMetric(metricPkg.CounterType, id, metricPkg.WithValueInt(1))
+		return m
+	}
+
+	ids := func(manager *MetricsManager) []string {
+		metrics, err := manager.GetBatch(context.Background())
+		require.NoError(t, err)
+
+		result := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			result = append(result, m.ID)
+		}
+
+		return result
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		manager := New(memstore.New(), logpack.NewLogger(), WithCapacity(2, CapacityReject, false))
+		defer manager.cancel()
+
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g1")))
+		require.NoError(t, manager.Upsert(context.Background(), counter("c1")))
+
+		err := manager.Upsert(context.Background(), gauge("g2"))
+		require.ErrorIs(t, err, errs.ErrCapacity)
+		require.Equal(t, http.StatusInsufficientStorage, errs.ErrorHTTP(err))
+
+		err = manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge("g1"), gauge("g2")})
+		require.ErrorIs(t, err, errs.ErrCapacity)
+
+		// Обновление известных метрик не ограничивается
+		require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge("g1"), counter("c1")}))
+
+		require.NoError(t, manager.Delete(context.Background(), gauge("g1")))
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g2")))
+		require.ElementsMatch(t, []string{"c1", "g2"}, ids(manager))
+	})
+
+	t.Run("Evict least recently updated gauge", func(t *testing.T) {
+		manager := New(memstore.New(), logpack.NewLogger(), WithCapacity(3, CapacityEvict, false))
+		defer manager.cancel()
+
+		require.NoError(t, manager.Upsert(context.Background(), counter("c1")))
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g1")))
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g2")))
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g1")))
+
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g3")))
+		require.ElementsMatch(t, []string{"c1", "g1", "g3"}, ids(manager))
+
+		require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge("g3"), gauge("g4")}))
+		require.ElementsMatch(t, []string{"c1", "g3", "g4"}, ids(manager))
+
+		require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{counter("c2"), counter("c3")}))
+		require.ElementsMatch(t, []string{"c1", "c2", "c3"}, ids(manager))
+
+		// Counter не вытесняются
+		require.ErrorIs(t, manager.Upsert(context.Background(), gauge("g5")), errs.ErrCapacity)
+		require.ElementsMatch(t, []string{"c1", "c2", "c3"}, ids(manager))
+	})
+
+	t.Run("Evict counters", func(t *testing.T) {
+		manager := New(memstore.New(), logpack.NewLogger(), WithCapacity(2, CapacityEvict, true))
+		defer manager.cancel()
+
+		require.NoError(t, manager.Upsert(context.Background(), counter("c1")))
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g1")))
+		require.NoError(t, manager.Upsert(context.Background(), gauge("g2")))
+		require.ElementsMatch(t, []string{"g1", "g2"}, ids(manager))
+	})
+
+	t.Run("Stored metrics are counted", func(t *testing.T) {
+		store := memstore.New()
+		old, fresh := gauge("old"), gauge("fresh")
+		old.LastUpdate = time.Unix(100, 0)
+		fresh.LastUpdate = time.Unix(200, 0)
+		require.NoError(t, store.UpsertBatch(context.Background(), []metricPkg.Metric{fresh, old}))
+
+		manager := New(store, logpack.NewLogger(), WithCapacity(2, CapacityEvict, false))
+		defer manager.cancel()
+
+		require.NoError(t, manager.Upsert(context.Background(), gauge("new")))
+		require.ElementsMatch(t, []string{"fresh", "new"}, ids(manager))
+	})
+}
+
+// TestMetricsManager_Bounds Отклонение NaN и бесконечностей, приведение gauge к границам
+// и отклонение отрицательных приращений counter
+func TestMetricsManager_Bounds(t *testing.T) {
+
+	manager := New(memstore.New(), logpack.NewLogger(),
+		WithGaugeBounds(-10, 100),
+		WithRejectNegativeCounter(true))
+	defer manager.cancel()
+
+	gauge := func(value float64) metricPkg.Metric {
+		return metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType, Value: &value}
+	}
+
+	get := func() float64 {
+		got, err := manager.Get(context.Background(), gauge(0))
+		require.NoError(t, err)
+
+		return *got.Value
+	}
+
+	require.ErrorIs(t, manager.Upsert(context.Background(), gauge(math.NaN())), errs.ErrInvalidValue)
+	require.ErrorIs(t, manager.Upsert(context.Background(), gauge(math.Inf(1))), errs.ErrInvalidValue)
+	require.ErrorIs(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge(math.Inf(-1))}), errs.ErrInvalidValue)
+
+	require.NoError(t, manager.Upsert(context.Background(), gauge(50)))
+	require.Equal(t, float64(50), get())
+
+	require.NoError(t, manager.Upsert(context.Background(), gauge(1000)))
+	require.Equal(t, float64(100), get())
+
+	require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge(-1000)}))
+	require.Equal(t, float64(-10), get())
+
+	// Приращение приводится к границам после сложения с сохраненным значением
+	inc := gauge(200)
+	inc.Op = metricPkg.OpInc
+	require.NoError(t, manager.Upsert(context.Background(), inc))
+	require.Equal(t, float64(100), get())
+
+	negative := int64(-1)
+	counter := metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType, Delta: &negative}
+	require.ErrorIs(t, manager.Upsert(context.Background(), counter), errs.ErrInvalidValue)
+	require.ErrorIs(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{counter}), errs.ErrInvalidValue)
+
+	// Без WithRejectNegativeCounter отрицательное приращение допустимо
+	permissive := New(memstore.New(), logpack.NewLogger())
+	defer permissive.cancel()
+	require.NoError(t, permissive.Upsert(context.Background(), counter))
+}
+
+// TestMetricsManager_CloseStopsTickers Close останавливает фоновые задачи сохранения и удаления устаревших метрик
+func TestMetricsManager_CloseStopsTickers(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	logger := logpack.NewLogger()
+	store := filestorage.New(filepath.Join(t.TempDir(), "metrics.json"), logger)
+
+	manager := New(store, logger, WithFlush(time.Millisecond), WithTTL(time.Millisecond, false), WithSelfMonitor(time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	require.NoError(t, manager.Close())
+	require.NoError(t, manager.Close())
+}
+
+// TestMetricsManager_SelfMonitor Метрики сервера записываются в хранилище
+// одновременно с сохранением по таймеру и не требуют подписи
+func TestMetricsManager_SelfMonitor(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	logger := logpack.NewLogger()
+	store := filestorage.New(filepath.Join(t.TempDir(), "metrics.json"), logger)
+
+	manager := New(store, logger,
+		WithSignKey([]byte("key")),
+		WithFlush(time.Millisecond),
+		WithSelfMonitor(5*time.Millisecond))
+	defer manager.Close()
+
+	for i := 0; i < 3; i++ {
+		manager.CountRequest()
+	}
+
+	require.Eventually(t, func() bool {
+		got, err := manager.Get(context.Background(), metricPkg.Metric{ID: SelfGoroutines, MType: metricPkg.GaugeType})
+		return err == nil && *got.Value > 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		got, err := manager.Get(context.Background(), metricPkg.Metric{ID: SelfRequestsTotal, MType: metricPkg.CounterType})
+		return err == nil && *got.Delta == 3
+	}, time.Second, 5*time.Millisecond)
+
+	_, err := manager.Get(context.Background(), metricPkg.Metric{ID: SelfHeapAlloc, MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+
+	require.NoError(t, manager.Close())
+}
+
+// TestMetricsManager_UnchangedSkipsFlush Обновление метрики тем же значением не перезаписывает файл хранилища,
+// counter с нулевым приращением создается и сохраняется
+func TestMetricsManager_UnchangedSkipsFlush(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	manager := New(filestorage.New(path, logger), logger, WithSignKey([]byte(signKey)))
+	defer manager.Close()
+
+	gauge := signedMetric(t, metricPkg.GaugeType, "testGauge", 1.5)
+	require.NoError(t, manager.Upsert(context.Background(), gauge))
+	require.FileExists(t, path)
+
+	require.NoError(t, os.Remove(path))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, manager.Upsert(context.Background(), gauge))
+		require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge}))
+	}
+	require.NoFileExists(t, path)
+
+	got, err := manager.Get(context.Background(), gauge)
+	require.NoError(t, err)
+	require.Equal(t, gauge.Hash, got.Hash)
+
+	// Новый counter с нулевым приращением - изменение хранилища
+	zero := signedMetric(t, metricPkg.CounterType, "testCounter", 0)
+	require.NoError(t, manager.Upsert(context.Background(), zero))
+	require.FileExists(t, path)
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, manager.Upsert(context.Background(), zero))
+	require.NoFileExists(t, path)
+
+	counter, err := manager.Get(context.Background(), zero)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), *counter.Delta)
+
+	require.NoError(t, manager.Upsert(context.Background(), signedMetric(t, metricPkg.GaugeType, "testGauge", 2.5)))
+	require.FileExists(t, path)
+}
+
+// TestMetricsManager_SweepRestored Метрики, восстановленные из файла, устаревают
+// по времени обновления, сохраненному до перезапуска
+func TestMetricsManager_SweepRestored(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fake := clock.NewFake(time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC))
+
+	data := `{"id":"staleGauge","type":"gauge","value":1,"last_update":"2022-01-01T00:00:00Z"}
+{"id":"freshGauge","type":"gauge","value":2,"last_update":"2022-01-01T00:59:30Z"}
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+
+	manager := New(filestorage.New(path, logger), logger, WithClock(fake), WithRestore(true))
+	manager.ttl = time.Minute
+
+	require.NoError(t, manager.sweep(context.Background()))
+
+	_, err := manager.Get(context.Background(), metricPkg.Metric{ID: "staleGauge", MType: metricPkg.GaugeType})
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	_, err = manager.Get(context.Background(), metricPkg.Metric{ID: "freshGauge", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+}
+
+// TestMetricsManager_SweepUnchangedAfterRestart Gauge, который продолжает приходить с тем же значением,
+// не удаляется как устаревший после аварийного перезапуска: время его обновления сохраняется,
+// хотя значение не меняется
+func TestMetricsManager_SweepUnchangedAfterRestart(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	manager := New(filestorage.New(path, logger), logger, WithClock(fake))
+	manager.ttl = time.Minute
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, manager.Upsert(context.Background(), gauge))
+		fake.Advance(20 * time.Second)
+	}
+
+	// Аварийное завершение: метрики не сохраняются при остановке
+	manager.cancel()
+
+	restarted := New(filestorage.New(path, logger), logger, WithClock(fake), WithRestore(true))
+	restarted.ttl = time.Minute
+	defer restarted.Close()
+
+	require.NoError(t, restarted.sweep(context.Background()))
+
+	_, err := restarted.Get(context.Background(), gauge)
+	require.NoError(t, err)
+}
+
+// TestMetricsManager_FakeClock Сохранение и удаление устаревших метрик по тикам часов,
+// время которых переводится в тесте
+func TestMetricsManager_FakeClock(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	manager := New(filestorage.New(path, logger), logger,
+		WithClock(fake),
+		WithFlush(10*time.Second),
+		WithTTL(time.Minute, false))
+	defer manager.Close()
+
+	require.Equal(t, 2, fake.Tickers())
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1))
+	require.NoError(t, manager.Upsert(context.Background(), gauge))
+
+	fake.Advance(9 * time.Second)
+	require.NoFileExists(t, path)
+
+	fake.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	fake.Advance(time.Minute)
+	require.Eventually(t, func() bool {
+		_, err := manager.Get(context.Background(), gauge)
+		return errors.Is(err, errs.ErrNotFound)
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, manager.Close())
+	require.Equal(t, 0, fake.Tickers())
+}
+
+// TestMetricsManager_Compact Удаление повторов из файла хранилища по тикам часов
+func TestMetricsManager_Compact(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	data := `{"id":"testGauge","type":"gauge","value":1}
+{"id":"testGauge","type":"gauge","value":2}
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+
+	manager := New(filestorage.New(path, logger), logger,
+		WithClock(fake),
+		WithCompact(time.Minute))
+	defer manager.Close()
+
+	require.Equal(t, 1, fake.Tickers())
+
+	fake.Advance(time.Minute)
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(path)
+		return err == nil && string(content) == `{"id":"testGauge","type":"gauge","value":2}`+"\n"
+	}, time.Second, time.Millisecond)
+}
+
+func TestMetricsManager_GaugeStats(t *testing.T) {
+
+	gauge := func(id string, value float64) metricPkg.Metric {
+		m, _ := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(value))
+		return m
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		manager := New(memstore.New(), logpack.NewLogger())
+		defer manager.cancel()
+
+		require.NoError(t, manager.Upsert(context.Background(), gauge("load", 1)))
+
+		_, err := manager.GaugeStats(context.Background(), gauge("load", 0))
+		require.ErrorIs(t, err, errs.ErrNoStats)
+	})
+
+	t.Run("Window of recent values", func(t *testing.T) {
+		manager := New(memstore.New(), logpack.NewLogger(), WithGaugeStats(3))
+		defer manager.cancel()
+
+		require.NoError(t, manager.Upsert(context.Background(), gauge("load", 1)))
+
+		stats, err := manager.GaugeStats(context.Background(), gauge("load", 0))
+		require.NoError(t, err)
+		require.Equal(t, metricPkg.Stats{Last: 1, Min: 1, Max: 1, Avg: 1, Count: 1}, stats)
+
+		// Значения набора учитываются по порядку, значение 1 вытесняется из окна
+		require.NoError(t, manager.Upsert(context.Background(), gauge("load", 6)))
+		require.NoError(t, manager.UpsertBatch(context.Background(), []metricPkg.Metric{gauge("load", 3), gauge("load", 0)}))
+
+		stats, err = manager.GaugeStats(context.Background(), gauge("load", 0))
+		require.NoError(t, err)
+		require.Equal(t, metricPkg.Stats{Last: 0, Min: 0, Max: 6, Avg: 3, Count: 3}, stats)
+
+		_, err = manager.GaugeStats(context.Background(), gauge("unknown", 0))
+		require.ErrorIs(t, err, errs.ErrNoStats)
+
+		require.NoError(t, manager.Rename(context.Background(), gauge("load", 0), "cpuLoad"))
+		stats, err = manager.GaugeStats(context.Background(), gauge("cpuLoad", 0))
+		require.NoError(t, err)
+		require.Equal(t, 3, stats.Count)
+
+		require.NoError(t, manager.Delete(context.Background(), gauge("cpuLoad", 0)))
+		_, err = manager.GaugeStats(context.Background(), gauge("cpuLoad", 0))
+		require.ErrorIs(t, err, errs.ErrNoStats)
+	})
+}
+
+// blockingStorage Хранилище, сохранение которого не завершается до закрытия release
+type blockingStorage struct {
+	*memstore.Storage
+	release chan struct{}
+}
+
+func (store blockingStorage) Flush(ctx context.Context) error {
+	<-store.release
+	return nil
+}
+
+// TestMetricsManager_ShutdownSaveTimeout Завершение работы не ждет зависшего сохранения дольше таймаута
+func TestMetricsManager_ShutdownSaveTimeout(t *testing.T) {
+
+	store := blockingStorage{Storage: memstore.New(), release: make(chan struct{})}
+	t.Cleanup(func() { close(store.release) })
+
+	manager := New(store, logpack.NewLogger(), WithFlush(time.Hour), WithShutdownSave(50*time.Millisecond))
+
+	start := time.Now()
+	err := manager.Shutdown()
+	elapsed := time.Since(start)
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, elapsed, time.Second)
+
+	// Без зависания хранилище сохраняется и закрывается в пределах таймаута
+	fast := New(memstore.New(), logpack.NewLogger(), WithShutdownSave(time.Second))
+	require.NoError(t, fast.Shutdown())
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"metrics-and-alerting/pkg/clock"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// Метрики, которые сервер собирает о себе
+const (
+	SelfGoroutines    = "server_goroutines"
+	SelfHeapAlloc     = "server_heap_alloc"
+	SelfGCPauseTotal  = "server_gc_pause_total_ns"
+	SelfGCCount       = "server_gc_count"
+	SelfRequestsTotal = "server_requests_total"
+)
+
+// DefaultSelfMonitorInterval Интервал сбора метрик сервера по умолчанию
+const DefaultSelfMonitorInterval = 10 * time.Second
+
+// WithSelfMonitor Периодическая запись метрик сервера в хранилище: количество горутин,
+// размер кучи, паузы сборщика мусора и количество обработанных запросов.
+// При interval = 0 метрики сервера не собираются
+func WithSelfMonitor(interval time.Duration) OptionsManager {
+	return func(manager *MetricsManager) {
+		manager.monitorInterval = interval
+	}
+}
+
+// CountRequest Учет обработанного запроса в метрике server_requests_total.
+// Если метрики сервера не собираются, запрос не учитывается
+func (manager MetricsManager) CountRequest() {
+	if manager.monitorInterval <= 0 {
+		return
+	}
+
+	atomic.AddInt64(manager.requests, 1)
+}
+
+func (manager MetricsManager) selfMonitorByTick(ctx context.Context, ticker clock.Ticker) {
+	defer manager.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			bg := logpack.WithRequestID(ctx, BgMonitor)
+			if err := manager.selfMonitor(bg); err != nil {
+				manager.logger.Ctx(bg).Err.Printf("could not store server metrics: %v\n", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// selfMonitor Запись метрик сервера в хранилище.
+// Метрики сервера не подписываются клиентом, поэтому подпись не проверяется
+func (manager MetricsManager) selfMonitor(ctx context.Context) error {
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	requests := atomic.SwapInt64(manager.requests, 0)
+
+	metrics := []metricPkg.Metric{
+		selfGauge(SelfGoroutines, float64(runtime.NumGoroutine())),
+		selfGauge(SelfHeapAlloc, float64(stats.HeapAlloc)),
+		selfGauge(SelfGCPauseTotal, float64(stats.PauseTotalNs)),
+		selfGauge(SelfGCCount, float64(stats.NumGC)),
+		{ID: SelfRequestsTotal, MType: metricPkg.CounterType, Delta: &requests},
+	}
+
+	if err := manager.upsertBatch(ctx, metrics, false); err != nil {
+		atomic.AddInt64(manager.requests, requests)
+		return err
+	}
+
+	return nil
+}
+
+// selfGauge Метрика gauge сервера
+func selfGauge(id string, value float64) metricPkg.Metric {
+	return metricPkg.Metric{ID: id, MType: metricPkg.GaugeType, Value: &value}
+}
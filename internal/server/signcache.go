@@ -0,0 +1,61 @@
+package server
+
+import (
+	"sync"
+
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+type (
+	// signCache Подписи последних подписанных значений метрик по ключу метрики.
+	// Подпись вычисляется заново, только если значение метрики изменилось
+	signCache struct {
+		mu      sync.Mutex
+		entries map[string]signEntry
+	}
+
+	signEntry struct {
+		value string
+		hash  string
+	}
+)
+
+func newSignCache() *signCache {
+	return &signCache{entries: make(map[string]signEntry)}
+}
+
+// sign Подпись метрики алгоритмом algo и ключом key
+func (cache *signCache) sign(metric metricPkg.Metric, algo string, key []byte) (string, error) {
+
+	if len(key) == 0 {
+		return ``, nil
+	}
+
+	mKey, value := metric.Key(), metric.StringValue()
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[mKey]
+	cache.mu.Unlock()
+
+	if ok && entry.value == value {
+		return entry.hash, nil
+	}
+
+	hash, err := metric.SignWith(algo, key)
+	if err != nil {
+		return ``, err
+	}
+
+	cache.mu.Lock()
+	cache.entries[mKey] = signEntry{value: value, hash: hash}
+	cache.mu.Unlock()
+
+	return hash, nil
+}
+
+// drop Удаление подписи метрики
+func (cache *signCache) drop(metric metricPkg.Metric) {
+	cache.mu.Lock()
+	delete(cache.entries, metric.Key())
+	cache.mu.Unlock()
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// DefaultStatsWindow Количество последних значений gauge, по которым считается статистика, по умолчанию
+const DefaultStatsWindow = 100
+
+type (
+	// gaugeStats Последние значения gauge для статистики. Для каждой метрики хранится
+	// не больше window значений, поэтому память на метрику ограничена
+	gaugeStats struct {
+		mu      sync.Mutex
+		window  int
+		samples map[string]*statsWindow // по ключу метрики <type>:<id>{labels}
+	}
+
+	// statsWindow Кольцевой буфер последних значений одной метрики
+	statsWindow struct {
+		values []float64
+		next   int // позиция следующего значения
+		count  int // количество значений в буфере
+	}
+)
+
+// WithGaugeStats Подсчет статистики min/max/avg по последним window значениям каждого gauge.
+// 0 - статистика не считается
+func WithGaugeStats(window int) OptionsManager {
+	return func(manager *MetricsManager) {
+
+		if window <= 0 {
+			manager.stats = nil
+			return
+		}
+
+		manager.stats = &gaugeStats{
+			window:  window,
+			samples: make(map[string]*statsWindow),
+		}
+	}
+}
+
+// add Добавление значения в буфер с вытеснением самого старого
+func (w *statsWindow) add(value float64) {
+
+	w.values[w.next] = value
+	w.next = (w.next + 1) % len(w.values)
+
+	if w.count < len(w.values) {
+		w.count++
+	}
+}
+
+// stats Статистика по значениям буфера
+func (w *statsWindow) stats() metricPkg.Stats {
+
+	last := w.values[(w.next-1+len(w.values))%len(w.values)]
+	stats := metricPkg.Stats{Last: last, Min: math.Inf(1), Max: math.Inf(-1), Count: w.count}
+
+	sum := 0.0
+	for _, value := range w.values[:w.count] {
+		stats.Min = math.Min(stats.Min, value)
+		stats.Max = math.Max(stats.Max, value)
+		sum += value
+	}
+	stats.Avg = sum / float64(w.count)
+
+	return stats
+}
+
+// record Учет нового значения gauge
+func (s *gaugeStats) record(metric metricPkg.Metric) {
+
+	if metric.MType != metricPkg.GaugeType || metric.Value == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := metric.Key()
+	w, ok := s.samples[key]
+	if !ok {
+		w = &statsWindow{values: make([]float64, s.window)}
+		s.samples[key] = w
+	}
+
+	w.add(*metric.Value)
+}
+
+// forget Удаление значений метрики
+func (s *gaugeStats) forget(metric metricPkg.Metric) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.samples, metric.Key())
+}
+
+// rename Перенос значений метрики на новый ключ при переименовании
+func (s *gaugeStats) rename(from, to metricPkg.Metric) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.samples[from.Key()]; ok {
+		delete(s.samples, from.Key())
+		s.samples[to.Key()] = w
+	}
+}
+
+// renameStats Перенос статистики gauge на новый ID метрики
+func (manager MetricsManager) renameStats(from, to metricPkg.Metric) {
+
+	if manager.stats != nil {
+		manager.stats.rename(from, to)
+	}
+}
+
+// GaugeStats Статистика по последним значениям gauge: последнее, минимальное, максимальное,
+// среднее значение и количество значений. Если статистика не считается или
+// значений с запуска сервера еще не было, возвращается errs.ErrNoStats
+func (manager MetricsManager) GaugeStats(ctx context.Context, metric metricPkg.Metric) (metricPkg.Stats, error) {
+
+	if manager.stats == nil {
+		return metricPkg.Stats{}, fmt.Errorf("%w: tracking is disabled", errs.ErrNoStats)
+	}
+
+	metric.MType = metricPkg.GaugeType
+	scoped, err := scopeStored(ctx, metric)
+	if err != nil {
+		return metricPkg.Stats{}, fmt.Errorf("%w: gauge %s", errs.ErrNoStats, metric.ID)
+	}
+	metric = scoped
+
+	manager.stats.mu.Lock()
+	defer manager.stats.mu.Unlock()
+
+	w, ok := manager.stats.samples[metric.Key()]
+	if !ok {
+		return metricPkg.Stats{}, fmt.Errorf("%w: gauge %s", errs.ErrNoStats, metric.ID)
+	}
+
+	return w.stats(), nil
+}
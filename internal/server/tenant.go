@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+// scope Метрика в пространстве имен арендатора запроса.
+// В хранилище, учете вытеснения и статистике gauge метрики хранятся с пространством имен,
+// а подписываются и возвращаются клиенту без него
+func scope(ctx context.Context, metric metricPkg.Metric) metricPkg.Metric {
+	return tenant.Scope(tenant.FromContext(ctx), metric)
+}
+
+// scopeStored Сохраненная метрика в пространстве имен арендатора запроса.
+// Имя с разделителем пространства имен указывало бы на метрику другого арендатора,
+// поэтому такой метрики у арендатора запроса нет
+func scopeStored(ctx context.Context, metric metricPkg.Metric) (metricPkg.Metric, error) {
+
+	if strings.Contains(metric.ID, tenant.Separator) {
+		return metricPkg.Metric{}, fmt.Errorf("%w: %s", errs.ErrNotFound, metric.ShotString())
+	}
+
+	return scope(ctx, metric), nil
+}
+
+// unscope Метрики арендатора запроса с именами без пространства имен.
+// Метрики других арендаторов отбрасываются
+func unscope(ctx context.Context, metrics []metricPkg.Metric) []metricPkg.Metric {
+
+	owner := tenant.FromContext(ctx)
+
+	owned := metrics[:0]
+	for _, m := range metrics {
+		if m, ok := tenant.Unscope(owner, m); ok {
+			owned = append(owned, m)
+		}
+	}
+
+	return owned
+}
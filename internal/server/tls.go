@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultTLSMinVersion Минимальная версия TLS по умолчанию
+const DefaultTLSMinVersion = "1.2"
+
+// tlsVersions Версии TLS по названию в конфигурации
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion Версия TLS по названию: 1.0, 1.1, 1.2 или 1.3
+func ParseTLSVersion(name string) (uint16, error) {
+
+	version, ok := tlsVersions[strings.TrimSpace(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q: use 1.0, 1.1, 1.2 or 1.3", name)
+	}
+
+	return version, nil
+}
+
+// NewTLSConfig Настройки TLS сервера с минимальной версией minVersion.
+// Если задан clientCAFile, то клиент должен предъявить сертификат, подписанный одним из
+// сертификатов этого файла (mTLS)
+func NewTLSConfig(minVersion, clientCAFile string) (*tls.Config, error) {
+
+	version, err := ParseTLSVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{MinVersion: version}
+
+	if len(clientCAFile) == 0 {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client CA file %s has no PEM certificates", clientCAFile)
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return config, nil
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	handler "metrics-and-alerting/internal/server/handlers"
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/logpack"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert Сертификат, подписанный parent, или самоподписанный, если parent не задан
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert Создание сертификата и запись его и ключа в PEM файлы в директории dir
+func newTestCert(t *testing.T, dir, name string, parent *testCert, isCA bool) *testCert {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+
+	require.NoError(t, os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return tc
+}
+
+// freeAddr Свободный адрес на localhost
+func freeAddr(t *testing.T) string {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	return addr
+}
+
+// startTLSServer Запуск HTTPS сервера с сертификатом serverCert
+func startTLSServer(t *testing.T, serverCert *testCert, clientCAFile string) string {
+
+	tlsConfig, err := NewTLSConfig(DefaultTLSMinVersion, clientCAFile)
+	require.NoError(t, err)
+
+	logger := logpack.NewLogger()
+	manager := New(memstore.New(), logger)
+	t.Cleanup(manager.cancel)
+
+	addr := freeAddr(t)
+	serv := NewHTTPServer(addr, handler.New(manager, logger),
+		WithTLS(serverCert.certFile, serverCert.keyFile, tlsConfig))
+	require.True(t, serv.TLSEnabled())
+
+	serv.Start()
+	t.Cleanup(func() { serv.HTTP.Close() })
+
+	return addr
+}
+
+// getPing Запрос /ping, ожидающий запуска сервера
+func getPing(client *http.Client, addr string) (*http.Response, error) {
+
+	var (
+		response *http.Response
+		err      error
+	)
+
+	for i := 0; i < 50; i++ {
+		response, err = client.Get(fmt.Sprintf("https://%s/ping", addr))
+
+		var opErr *net.OpError
+		if err == nil || !errors.As(err, &opErr) || opErr.Op != "dial" {
+			return response, err
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return response, err
+}
+
+func TestTLSServer(t *testing.T) {
+
+	dir := t.TempDir()
+	serverCert := newTestCert(t, dir, "server", nil, true)
+
+	addr := startTLSServer(t, serverCert, "")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.cert)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	response, err := getPing(client, addr)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	assert.NotNil(t, response.TLS)
+	assert.GreaterOrEqual(t, response.TLS.Version, uint16(tls.VersionTLS12))
+
+	plain, err := http.Get(fmt.Sprintf("http://%s/ping", addr))
+	if err == nil {
+		defer plain.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, plain.StatusCode)
+	}
+
+	legacy := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    roots,
+		MaxVersion: tls.VersionTLS11,
+	}}}
+
+	_, err = legacy.Get(fmt.Sprintf("https://%s/ping", addr))
+	assert.Error(t, err)
+}
+
+func TestTLSServerClientCert(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, true)
+	serverCert := newTestCert(t, dir, "server", ca, false)
+	clientCert := newTestCert(t, dir, "client", ca, false)
+
+	addr := startTLSServer(t, serverCert, ca.certFile)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	_, err := getPing(anonymous, addr)
+	assert.Error(t, err)
+
+	pair, err := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{pair},
+	}}}
+
+	response, err := getPing(client, addr)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestParseTLSVersion(t *testing.T) {
+
+	version, err := ParseTLSVersion("1.3")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = ParseTLSVersion("1.4")
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"metrics-and-alerting/pkg/metric"
+)
+
+// DefaultReadCacheTTL Время жизни метрики в кэше чтения по умолчанию
+const DefaultReadCacheTTL = time.Second
+
+type (
+	// ReadCache Кэш чтения перед хранилищем метрик.
+	// Get обслуживается из памяти, пока не истекло время жизни записи.
+	// Запись выполняется в хранилище, после чего кэш обновляется сохраненным значением.
+	// Значение counter накапливается на уровне MetricsManager, поэтому в кэш попадает уже накопленное значение
+	ReadCache struct {
+		Repository
+
+		mu      sync.Mutex
+		ttl     time.Duration
+		entries map[string]cacheEntry
+		now     func() time.Time
+	}
+
+	// cacheEntry Метрика в кэше и момент, до которого она считается актуальной
+	cacheEntry struct {
+		metric  metric.Metric
+		expires time.Time
+	}
+)
+
+// NewReadCache Кэш чтения перед хранилищем repo с временем жизни записи ttl.
+// При ttl <= 0 используется DefaultReadCacheTTL
+func NewReadCache(repo Repository, ttl time.Duration) *ReadCache {
+
+	if ttl <= 0 {
+		ttl = DefaultReadCacheTTL
+	}
+
+	return &ReadCache{
+		Repository: repo,
+		ttl:        ttl,
+		entries:    make(map[string]cacheEntry),
+		now:        time.Now,
+	}
+}
+
+// Unwrap Хранилище под кэшем чтения. Нужно для проверки дополнительных возможностей хранилища,
+// например статистики пула соединений с базой данных
+func Unwrap(repo Repository) Repository {
+
+	for {
+		cache, ok := repo.(*ReadCache)
+		if !ok {
+			return repo
+		}
+
+		repo = cache.Repository
+	}
+}
+
+// Upsert Сохранение метрики в хранилище и обновление кэша.
+// Если хранилище вернуло ошибку, метрика удаляется из кэша
+func (cache *ReadCache) Upsert(ctx context.Context, m metric.Metric) error {
+
+	if err := cache.Repository.Upsert(ctx, m); err != nil {
+		cache.invalidate(m)
+		return err
+	}
+
+	cache.put(m)
+	return nil
+}
+
+// UpsertBatch Сохранение набора метрик в хранилище и обновление кэша.
+// Для повторяющейся в наборе метрики в кэше остается последнее значение, как и в хранилище
+func (cache *ReadCache) UpsertBatch(ctx context.Context, metrics []metric.Metric) error {
+
+	if err := cache.Repository.UpsertBatch(ctx, metrics); err != nil {
+		cache.invalidate(metrics...)
+		return err
+	}
+
+	cache.put(metrics...)
+	return nil
+}
+
+// Get Получение метрики из кэша, а если ее нет или время жизни истекло - из хранилища
+func (cache *ReadCache) Get(ctx context.Context, m metric.Metric) (metric.Metric, error) {
+
+	key := m.Key()
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	cache.mu.Unlock()
+
+	if ok && cache.now().Before(entry.expires) {
+		return entry.metric, nil
+	}
+
+	found, err := cache.Repository.Get(ctx, m)
+	if err != nil {
+		cache.invalidate(m)
+		return metric.Metric{}, err
+	}
+
+	cache.put(found)
+	return found, nil
+}
+
+// Delete Удаление метрики из хранилища и кэша
+func (cache *ReadCache) Delete(ctx context.Context, m metric.Metric) error {
+
+	defer cache.invalidate(m)
+	return cache.Repository.Delete(ctx, m)
+}
+
+// DeleteWhere Удаление метрик по условию из хранилища. Кэш очищается полностью
+func (cache *ReadCache) DeleteWhere(ctx context.Context, match func(metric.Metric) bool) (int, error) {
+
+	defer cache.clear()
+	return cache.Repository.DeleteWhere(ctx, match)
+}
+
+// Rename Переименование метрики в хранилище. Из кэша удаляются метрика со старым и с новым именем
+func (cache *ReadCache) Rename(ctx context.Context, m metric.Metric, newID string) error {
+
+	renamed := m
+	renamed.ID = newID
+
+	defer cache.invalidate(m, renamed)
+	return cache.Repository.Rename(ctx, m, newID)
+}
+
+// Reset Сброс значения метрики в хранилище и удаление ее из кэша
+func (cache *ReadCache) Reset(ctx context.Context, m metric.Metric) error {
+
+	defer cache.invalidate(m)
+	return cache.Repository.Reset(ctx, m)
+}
+
+// Restore Восстановление метрик хранилища. Кэш очищается полностью
+func (cache *ReadCache) Restore(ctx context.Context) error {
+
+	defer cache.clear()
+	return cache.Repository.Restore(ctx)
+}
+
+// put Добавление метрик в кэш с новым временем жизни
+func (cache *ReadCache) put(metrics ...metric.Metric) {
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	expires := cache.now().Add(cache.ttl)
+	for _, m := range metrics {
+		cache.entries[m.Key()] = cacheEntry{metric: m, expires: expires}
+	}
+}
+
+// invalidate Удаление метрик из кэша
+func (cache *ReadCache) invalidate(metrics ...metric.Metric) {
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, m := range metrics {
+		delete(cache.entries, m.Key())
+	}
+}
+
+// clear Удаление всех метрик из кэша
+func (cache *ReadCache) clear() {
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = make(map[string]cacheEntry)
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/internal/storage/memstore"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errUnavailable = errors.New("database unavailable")
+
+// failingRepository Хранилище, чтение из которого можно сломать. Считает обращения к Get
+type failingRepository struct {
+	*memstore.Storage
+	fail bool
+	gets int
+}
+
+func (repo *failingRepository) Get(ctx context.Context, metric metricPkg.Metric) (metricPkg.Metric, error) {
+
+	repo.gets++
+	if repo.fail {
+		return metricPkg.Metric{}, errUnavailable
+	}
+
+	return repo.Storage.Get(ctx, metric)
+}
+
+func TestReadCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	gauge, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, err)
+
+	newCache := func() (*ReadCache, *failingRepository, *time.Time) {
+		repo := &failingRepository{Storage: memstore.New()}
+		cache := NewReadCache(repo, time.Second)
+
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		return cache, repo, &now
+	}
+
+	t.Run("Cached read does not hit failing database within TTL", func(t *testing.T) {
+		cache, repo, _ := newCache()
+		require.NoError(t, repo.Storage.Upsert(ctx, gauge))
+
+		got, err := cache.Get(ctx, gauge)
+		require.NoError(t, err)
+		require.Equal(t, 1.5, *got.Value)
+		require.Equal(t, 1, repo.gets)
+
+		repo.fail = true
+
+		got, err = cache.Get(ctx, gauge)
+		require.NoError(t, err)
+		require.Equal(t, 1.5, *got.Value)
+		require.Equal(t, 1, repo.gets)
+	})
+
+	t.Run("Written metric is read from cache", func(t *testing.T) {
+		cache, repo, _ := newCache()
+		require.NoError(t, cache.Upsert(ctx, gauge))
+
+		repo.fail = true
+
+		got, err := cache.Get(ctx, gauge)
+		require.NoError(t, err)
+		require.Equal(t, 1.5, *got.Value)
+		require.Equal(t, 0, repo.gets)
+	})
+
+	t.Run("Expired entry is read from database", func(t *testing.T) {
+		cache, repo, now := newCache()
+		require.NoError(t, cache.Upsert(ctx, gauge))
+
+		repo.fail = true
+		*now = now.Add(time.Second)
+
+		_, err := cache.Get(ctx, gauge)
+		require.ErrorIs(t, err, errUnavailable)
+		require.Equal(t, 1, repo.gets)
+	})
+
+	t.Run("Accumulated counter replaces cached value", func(t *testing.T) {
+		cache, repo, _ := newCache()
+
+		for _, delta := range []int64{5, 12} {
+			counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(delta))
+			require.NoError(t, err)
+			require.NoError(t, cache.Upsert(ctx, counter))
+		}
+
+		repo.fail = true
+
+		got, err := cache.Get(ctx, metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+		require.NoError(t, err)
+		require.Equal(t, int64(12), *got.Delta)
+	})
+
+	t.Run("Delete invalidates entry", func(t *testing.T) {
+		cache, repo, _ := newCache()
+		require.NoError(t, cache.Upsert(ctx, gauge))
+		require.NoError(t, cache.Delete(ctx, gauge))
+
+		_, err := cache.Get(ctx, gauge)
+		require.Error(t, err)
+		require.Equal(t, 1, repo.gets)
+	})
+
+	t.Run("Unwrap returns underlying storage", func(t *testing.T) {
+		cache, repo, _ := newCache()
+		require.Same(t, repo, Unwrap(cache))
+		require.Same(t, repo, Unwrap(repo))
+	})
+}
@@ -0,0 +1,24 @@
+package dbstore
+
+import (
+	"database/sql"
+
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/logpack"
+)
+
+// NewWithDB Хранилище поверх открытого соединения db с примененными миграциями
+func NewWithDB(db *sql.DB, logger *logpack.LogPack) (*Storage, error) {
+
+	store := &Storage{
+		db:     db,
+		logger: logger,
+		memory: memstore.New(),
+	}
+
+	if err := store.applyMigrations(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
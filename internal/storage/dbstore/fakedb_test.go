@@ -0,0 +1,464 @@
+package dbstore_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriverName Имя драйвера базы данных в памяти
+const fakeDriverName = "fakepg"
+
+// fakeKey Столбцы первичного ключа таблицы metrics
+var fakeKey = []string{"id", "mtype", "labels"}
+
+var (
+	reColumnLimit = regexp.MustCompile(`\b(\w+) (?:TYPE )?CHARACTER VARYING\((\d+)\)`)
+	reColumnText  = regexp.MustCompile(`\b(\w+) TYPE TEXT\b`)
+	reLike        = regexp.MustCompile(`^\s*(\w+) (NOT )?LIKE (\$\d+|'[^']*')\s*$`)
+)
+
+func init() {
+	sql.Register(fakeDriverName, &fakeDriver{dbs: make(map[string]*fakeDB)})
+}
+
+// openFakeDB Отдельная база данных в памяти для теста t.
+// Драйвер понимает только запросы хранилища к таблицам metrics и schema_migrations: вставку с ON CONFLICT,
+// выборку, удаление и изменение по равенству столбцов и шаблону LIKE. Из миграций учитывается только
+// длина строковых столбцов, чтобы слишком длинные значения отклонялись, как в PostgreSQL
+func openFakeDB(t *testing.T) *sql.DB {
+
+	db, err := sql.Open(fakeDriverName, t.Name())
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+type (
+	fakeDriver struct {
+		mu  sync.Mutex
+		dbs map[string]*fakeDB
+	}
+
+	fakeDB struct {
+		mu       sync.Mutex
+		limits   map[string]int // длина строковых столбцов
+		rows     map[string]fakeRow
+		versions []int64 // примененные версии схемы из schema_migrations
+	}
+
+	fakeRow map[string]driver.Value
+
+	fakeConn struct{ db *fakeDB }
+
+	fakeTx struct{}
+
+	fakeStmt struct {
+		db    *fakeDB
+		query string
+	}
+
+	fakeRows struct {
+		columns []string
+		values  [][]driver.Value
+		pos     int
+	}
+)
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	db, ok := d.dbs[name]
+	if !ok {
+		db = &fakeDB{limits: make(map[string]int), rows: make(map[string]fakeRow)}
+		d.dbs[name] = db
+	}
+
+	return fakeConn{db: db}, nil
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: c.db, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+
+	rows, err := s.db.exec(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(len(rows.values)), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.db.exec(s.query, args)
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+
+	copy(dest, r.values[r.pos])
+	r.pos++
+
+	return nil
+}
+
+// exec Выполнение запроса. Для запросов без RETURNING возвращаются затронутые строки без столбцов
+func (db *fakeDB) exec(query string, args []driver.Value) (*fakeRows, error) {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"), strings.HasPrefix(query, "LOCK TABLE"):
+		return &fakeRows{}, nil
+
+	case strings.HasPrefix(query, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"):
+		var version int64
+		for _, v := range db.versions {
+			if v > version {
+				version = v
+			}
+		}
+
+		return &fakeRows{columns: []string{"version"}, values: [][]driver.Value{{version}}}, nil
+
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		version, ok := args[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("fake database: schema version %v is not integer", args[0])
+		}
+
+		db.versions = append(db.versions, version)
+		return &fakeRows{values: make([][]driver.Value, 1)}, nil
+
+	case strings.HasPrefix(query, "CREATE TABLE"), strings.HasPrefix(query, "ALTER TABLE"), strings.HasPrefix(query, "DO "):
+		for _, match := range reColumnLimit.FindAllStringSubmatch(query, -1) {
+			db.limits[match[1]], _ = strconv.Atoi(match[2])
+		}
+		for _, match := range reColumnText.FindAllStringSubmatch(query, -1) {
+			delete(db.limits, match[1])
+		}
+
+		return &fakeRows{}, nil
+
+	case strings.HasPrefix(query, "INSERT INTO metrics"):
+		return db.insert(query, args)
+
+	case strings.HasPrefix(query, "SELECT COUNT(*) FROM metrics"):
+		matched, err := db.where(query, args)
+		if err != nil {
+			return nil, err
+		}
+
+		return &fakeRows{columns: []string{"count"}, values: [][]driver.Value{{int64(len(matched))}}}, nil
+
+	case strings.HasPrefix(query, "SELECT"):
+		matched, err := db.where(query, args)
+		if err != nil {
+			return nil, err
+		}
+
+		columns := strings.Split(between(query, "SELECT ", " FROM"), ",")
+		return db.result(columns, matched), nil
+
+	case strings.HasPrefix(query, "DELETE FROM metrics"):
+		matched, err := db.where(query, args)
+		if err != nil {
+			return nil, err
+		}
+
+		deleted := db.returning(query, matched)
+		for _, key := range matched {
+			delete(db.rows, key)
+		}
+
+		return deleted, nil
+
+	case strings.HasPrefix(query, "UPDATE metrics"):
+		return db.update(query, args)
+	}
+
+	return nil, fmt.Errorf("fake database: unsupported query %q", query)
+}
+
+// insert Вставка строки, а при совпадении первичного ключа - изменение остальных переданных столбцов
+func (db *fakeDB) insert(query string, args []driver.Value) (*fakeRows, error) {
+
+	columns := strings.Split(between(query, "(", ")"), ",")
+	if len(columns) != len(args) {
+		return nil, fmt.Errorf("fake database: %d columns, %d arguments", len(columns), len(args))
+	}
+
+	row := make(fakeRow, len(columns))
+	for i, column := range columns {
+		if err := db.check(column, args[i]); err != nil {
+			return nil, err
+		}
+
+		row[column] = args[i]
+	}
+
+	key := row.key()
+	if known, ok := db.rows[key]; ok {
+		for column, value := range row {
+			known[column] = value
+		}
+	} else {
+		db.rows[key] = row
+	}
+
+	return db.returning(query, []string{key}), nil
+}
+
+// update Изменение строк: в SET поддерживаются параметры $N и пустая строка
+func (db *fakeDB) update(query string, args []driver.Value) (*fakeRows, error) {
+
+	matched, err := db.where(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := make(fakeRow)
+	for _, assignment := range strings.Split(between(query, " SET ", " WHERE "), ",") {
+		column, value, err := operand(assignment, args)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := db.check(column, value); err != nil {
+			return nil, err
+		}
+
+		assignments[column] = value
+	}
+
+	keys := make([]string, 0, len(matched))
+	for _, key := range matched {
+		row := db.rows[key]
+		delete(db.rows, key)
+
+		for column, value := range assignments {
+			row[column] = value
+		}
+
+		if _, ok := db.rows[row.key()]; ok {
+			return nil, fmt.Errorf("fake database: duplicate key %s", row.key())
+		}
+
+		db.rows[row.key()] = row
+		keys = append(keys, row.key())
+	}
+
+	return db.returning(query, keys), nil
+}
+
+// where Ключи строк, удовлетворяющих условиям равенства WHERE, отсортированные по id и меткам
+func (db *fakeDB) where(query string, args []driver.Value) ([]string, error) {
+
+	conditions := make([]func(row fakeRow) bool, 0)
+	if i := strings.Index(query, " WHERE "); i >= 0 {
+		clause := query[i+len(" WHERE "):]
+		for _, end := range []string{" ORDER BY", " RETURNING", ";"} {
+			if j := strings.Index(clause, end); j >= 0 {
+				clause = clause[:j]
+			}
+		}
+
+		for _, expr := range strings.Split(clause, " AND ") {
+			condition, err := condition(expr, args)
+			if err != nil {
+				return nil, err
+			}
+
+			conditions = append(conditions, condition)
+		}
+	}
+
+	matched := make([]string, 0)
+	for key, row := range db.rows {
+		ok := true
+		for _, condition := range conditions {
+			ok = ok && condition(row)
+		}
+
+		if ok {
+			matched = append(matched, key)
+		}
+	}
+
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// returning Строки с ключами keys в столбцах RETURNING запроса
+func (db *fakeDB) returning(query string, keys []string) *fakeRows {
+
+	i := strings.Index(query, " RETURNING ")
+	if i < 0 {
+		return &fakeRows{values: make([][]driver.Value, len(keys))}
+	}
+
+	columns := strings.Split(strings.TrimSuffix(query[i+len(" RETURNING "):], ";"), ",")
+	return db.result(columns, keys)
+}
+
+// result Значения столбцов columns строк с ключами keys
+func (db *fakeDB) result(columns []string, keys []string) *fakeRows {
+
+	values := make([][]driver.Value, 0, len(keys))
+	for _, key := range keys {
+		row := db.rows[key]
+
+		value := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			value[i] = row[column]
+		}
+
+		values = append(values, value)
+	}
+
+	return &fakeRows{columns: columns, values: values}
+}
+
+// check Проверка длины строкового значения столбца
+func (db *fakeDB) check(column string, value driver.Value) error {
+
+	s, ok := value.(string)
+	if limit, limited := db.limits[column]; ok && limited && len(s) > limit {
+		return fmt.Errorf("fake database: value too long for type character varying(%d)", limit)
+	}
+
+	return nil
+}
+
+func (row fakeRow) key() string {
+
+	parts := make([]string, len(fakeKey))
+	for i, column := range fakeKey {
+		parts[i] = fmt.Sprint(row[column])
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+// condition Условие WHERE: равенство column=$N или сравнение column [NOT] LIKE с шаблоном
+func condition(expr string, args []driver.Value) (func(row fakeRow) bool, error) {
+
+	if match := reLike.FindStringSubmatch(expr); match != nil {
+		pattern := strings.Trim(match[3], "'")
+		if n, err := strconv.Atoi(strings.TrimPrefix(match[3], "$")); err == nil && n >= 1 && n <= len(args) {
+			pattern = fmt.Sprint(args[n-1])
+		}
+
+		like, err := likePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		column, negate := match[1], len(match[2]) != 0
+		return func(row fakeRow) bool {
+			return like.MatchString(fmt.Sprint(row[column])) != negate
+		}, nil
+	}
+
+	column, value, err := operand(expr, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row fakeRow) bool {
+		return fmt.Sprint(row[column]) == fmt.Sprint(value)
+	}, nil
+}
+
+// likePattern Регулярное выражение шаблона LIKE: '%', '_' и экранирование обратной косой чертой
+func likePattern(pattern string) (*regexp.Regexp, error) {
+
+	var builder strings.Builder
+	builder.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '%':
+			builder.WriteString(".*")
+		case '_':
+			builder.WriteString(".")
+		case '\\':
+			if i++; i == len(pattern) {
+				return nil, fmt.Errorf("fake database: LIKE pattern %q ends with escape", pattern)
+			}
+			builder.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+// operand Столбец и значение выражения column=$N или присваивания пустой строки
+func operand(expr string, args []driver.Value) (string, driver.Value, error) {
+
+	parts := strings.SplitN(strings.TrimSpace(expr), "=", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("fake database: unsupported expression %q", expr)
+	}
+
+	column, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if value == "''" {
+		return column, "", nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(value, "$"))
+	if err != nil || n < 1 || n > len(args) {
+		return "", nil, fmt.Errorf("fake database: unsupported value %q", value)
+	}
+
+	return column, args[n-1], nil
+}
+
+// between Подстрока query между первыми вхождениями from и to
+func between(query, from, to string) string {
+
+	i := strings.Index(query, from)
+	if i < 0 {
+		return ""
+	}
+
+	rest := query[i+len(from):]
+	if j := strings.Index(rest, to); j >= 0 {
+		rest = rest[:j]
+	}
+
+	return rest
+}
@@ -1,244 +1,894 @@
 package dbstore
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 
 	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/internal/tracing"
+	"metrics-and-alerting/pkg/errs"
 	"metrics-and-alerting/pkg/logpack"
 	metricPkg "metrics-and-alerting/pkg/metric"
 )
 
+// driverName Имя драйвера, зарегистрированного пакетом github.com/lib/pq
+const driverName = "postgres"
+
+// DefaultMaxIdleConns Количество простаивающих соединений в пуле по умолчанию, как в database/sql
+const DefaultMaxIdleConns = 2
+
+// healthTimeout Максимальное время ожидания ответа базы данных при проверке доступности
+const healthTimeout = time.Second
+
+// DefaultCloseTimeout Максимальное время ожидания завершения запросов при закрытии соединения по умолчанию
+const DefaultCloseTimeout = 5 * time.Second
+
 const (
-	queryChangeGauge = `INSERT INTO runtimeMetrics (name,type,value)
-                         VALUES ($1,$2,$3)
-                         ON CONFLICT (name)
+	// querySchemaMigrations Версии схемы, уже примененные к базе данных
+	querySchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+                               version    INTEGER NOT NULL PRIMARY KEY,
+                               applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now() );`
+
+	// queryLockMigrations Серверы, запущенные одновременно, применяют миграции по очереди
+	queryLockMigrations = `LOCK TABLE schema_migrations IN SHARE ROW EXCLUSIVE MODE;`
+
+	querySchemaVersion = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`
+
+	queryAddSchemaVersion = `INSERT INTO schema_migrations (version) VALUES ($1);`
+
+	queryMigration = `CREATE TABLE IF NOT EXISTS metrics (
+                        id     CHARACTER VARYING(256) NOT NULL,
+                        mtype  CHARACTER VARYING(50)  NOT NULL,
+                        delta  BIGINT,
+                        value  DOUBLE PRECISION,
+                        hash   CHARACTER VARYING(64),
+                        PRIMARY KEY (id, mtype) );`
+
+	// queryMigrationLabels Метки метрики входят в первичный ключ.
+	// Первичный ключ перестраивается под исключительной блокировкой таблицы,
+	// поэтому только если метки в него еще не входят
+	queryMigrationLabels = `ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT '';
+                            DO $$
+                            BEGIN
+                                IF NOT EXISTS (
+                                    SELECT 1 FROM pg_constraint c
+                                    JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+                                    WHERE c.conrelid = 'metrics'::regclass AND c.contype = 'p' AND a.attname = 'labels'
+                                ) THEN
+                                    ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+                                    ALTER TABLE metrics ADD PRIMARY KEY (id, mtype, labels);
+                                END IF;
+                            END $$;`
+
+	// queryMigrationTenants Имя метрики хранится с пространством имен арендатора:
+	// <арендатор>/<имя>, поэтому столбец id длиннее имени на tenant.MaxLen+1 символ.
+	// Тип столбца меняется, только если он еще короче
+	queryMigrationTenants = `DO $$
+                             BEGIN
+                                 IF (SELECT character_maximum_length FROM information_schema.columns
+                                     WHERE table_schema = current_schema() AND table_name = 'metrics' AND column_name = 'id') < 321 THEN
+                                     ALTER TABLE metrics ALTER COLUMN id TYPE CHARACTER VARYING(321);
+                                 END IF;
+                             END $$;`
+
+	// queryMigrationHash Подпись sha512 в шестнадцатеричном виде занимает 128 символов.
+	// Тип столбца меняется, только если он еще короче
+	queryMigrationHash = `DO $$
+                          BEGIN
+                              IF (SELECT character_maximum_length FROM information_schema.columns
+                                  WHERE table_schema = current_schema() AND table_name = 'metrics' AND column_name = 'hash') < 128 THEN
+                                  ALTER TABLE metrics ALTER COLUMN hash TYPE CHARACTER VARYING(128);
+                              END IF;
+                          END $$;`
+
+	// queryMigrationLastUpdate Время последнего обновления метрики, по которому удаляются устаревшие метрики.
+	// Метрикам, сохраненным до миграции, назначается время миграции
+	queryMigrationLastUpdate = `ALTER TABLE metrics ADD COLUMN IF NOT EXISTS last_update TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();`
+
+	// queryMigrationHistogram Состояние histogram: интервалы, количество наблюдений в них и сумма в виде JSON
+	queryMigrationHistogram = `ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram TEXT NOT NULL DEFAULT '';`
+
+	queryChangeGauge = `INSERT INTO metrics (id,mtype,value,hash,labels,last_update)
+                         VALUES ($1,$2,$3,$4,$5,$6)
+                         ON CONFLICT (id,mtype,labels)
                          DO UPDATE
-                         SET name=$1,type=$2,value=$3;`
+                         SET value=EXCLUDED.value,hash=EXCLUDED.hash,last_update=EXCLUDED.last_update;`
 
-	queryChangeCounter = `INSERT INTO runtimeMetrics (name,type,delta)
-                           VALUES ($1,$2,$3)
-                           ON CONFLICT (name)
+	queryChangeCounter = `INSERT INTO metrics (id,mtype,delta,hash,labels,last_update)
+                           VALUES ($1,$2,$3,$4,$5,$6)
+                           ON CONFLICT (id,mtype,labels)
                            DO UPDATE
-                           SET name=$1,type=$2,delta=$3;`
+                           SET delta=EXCLUDED.delta,hash=EXCLUDED.hash,last_update=EXCLUDED.last_update;`
+
+	queryChangeHistogram = `INSERT INTO metrics (id,mtype,histogram,hash,labels,last_update)
+                             VALUES ($1,$2,$3,$4,$5,$6)
+                             ON CONFLICT (id,mtype,labels)
+                             DO UPDATE
+                             SET histogram=EXCLUDED.histogram,hash=EXCLUDED.hash,last_update=EXCLUDED.last_update;`
+
+	queryGetMetrics = `SELECT id,mtype,delta,value,hash,labels,last_update,histogram
+                       FROM metrics`
+
+	queryGetMetricsByType = `SELECT id,mtype,delta,value,hash,labels,last_update,histogram
+                             FROM metrics
+                             WHERE mtype=$1
+                             ORDER BY id,labels`
+
+	queryGetMetric = `SELECT id,mtype,delta,value,hash,labels,last_update,histogram
+                      FROM metrics
+                      WHERE id=$1 AND mtype=$2 AND labels=$3`
+
+	queryCountMetrics = `SELECT COUNT(*) FROM metrics WHERE mtype=$1`
+
+	// Метрики арендатора по умолчанию хранятся без пространства имен
+	queryCountDefault = `SELECT COUNT(*) FROM metrics WHERE mtype=$1 AND id NOT LIKE '%/%'`
+	queryCountTenant  = `SELECT COUNT(*) FROM metrics WHERE mtype=$1 AND id LIKE $2`
+
+	queryDeleteMetric = `DELETE FROM metrics WHERE id=$1 AND mtype=$2 AND labels=$3;`
 
-	queryGetMetrics = `SELECT name,type,delta, value
-                       FROM runtimeMetrics`
+	queryRenameMetric = `UPDATE metrics SET id=$1,hash=''
+                         WHERE id=$2 AND mtype=$3 AND labels=$4
+                         RETURNING id,mtype,delta,value,hash,labels,last_update,histogram;`
 )
 
+type OptionsStorage func(*Storage)
+
+// histogramState Состояние histogram, хранимое в базе данных в виде JSON
+type histogramState struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Sum     *float64  `json:"sum,omitempty"`
+}
+
 type Storage struct {
-	db     *sql.DB
-	logger *logpack.LogPack
-	memory *memstore.Storage
+	db              *sql.DB
+	logger          *logpack.LogPack
+	memory          *memstore.Storage
+	maxOpenConns    int           // 0 - не ограничено
+	maxIdleConns    int           // 0 - простаивающие соединения закрываются
+	connMaxLifetime time.Duration // 0 - не ограничено
+	closeTimeout    time.Duration // 0 - не ограничено
 }
 
-func New(dsn string, logger *logpack.LogPack) (*Storage, error) {
+// New Подключение к базе данных и применение миграций.
+// Метрики из базы данных загружаются в память вызовом Restore
+func New(dsn string, logger *logpack.LogPack, opts ...OptionsStorage) (*Storage, error) {
+
+	if len(dsn) == 0 {
+		return nil, errs.ErrInvalidDSN
+	}
 
-	driver, errConnect := sql.Open("postgres", dsn)
+	driver, errConnect := sql.Open(driverName, dsn)
 	if errConnect != nil {
 		logger.Err.Printf("Could not connect to database: %v\n", errConnect)
-		return nil, errConnect
+		return nil, fmt.Errorf("could not open database with driver '%s': %w", driverName, errConnect)
 	}
 
 	dbStore := &Storage{
-		db:     driver,
-		logger: logger,
-		memory: memstore.New(),
+		db:           driver,
+		logger:       logger,
+		memory:       memstore.New(),
+		maxIdleConns: DefaultMaxIdleConns,
+		closeTimeout: DefaultCloseTimeout,
 	}
 
+	for _, opt := range opts {
+		opt(dbStore)
+	}
+
+	dbStore.configurePool()
+
 	if errMigrate := dbStore.applyMigrations(); errMigrate != nil {
 		logger.Err.Printf("could not apply migration: %v\n", errMigrate)
 
 		if errClose := driver.Close(); errClose != nil {
 			logger.Err.Printf("could not close database connection: %v\n", errClose)
 		}
-	}
 
-	if errRestore := dbStore.Restore(); errRestore != nil {
-		logger.Err.Printf("could not restore metrics from database: %v\n", errRestore)
+		return nil, fmt.Errorf("could not prepare database: %w", errMigrate)
 	}
 
 	return dbStore, nil
 }
 
-func (store *Storage) Upsert(metric metricPkg.Metric) error {
+// WithPool Ограничения пула соединений с базой данных:
+// максимальное количество открытых и простаивающих соединений и время жизни соединения
+func WithPool(maxOpenConns, maxIdleConns int, connMaxLifetime time.Duration) OptionsStorage {
+	return func(store *Storage) {
+		store.maxOpenConns = maxOpenConns
+		store.maxIdleConns = maxIdleConns
+		store.connMaxLifetime = connMaxLifetime
+	}
+}
+
+// WithCloseTimeout Максимальное время ожидания завершения запросов при закрытии соединения.
+// 0 - время не ограничено
+func WithCloseTimeout(timeout time.Duration) OptionsStorage {
+	return func(store *Storage) {
+		store.closeTimeout = timeout
+	}
+}
 
-	return store.memory.Upsert(metric)
+// configurePool Применение ограничений пула соединений
+func (store *Storage) configurePool() {
+	store.db.SetMaxOpenConns(store.maxOpenConns)
+	store.db.SetMaxIdleConns(store.maxIdleConns)
+	store.db.SetConnMaxLifetime(store.connMaxLifetime)
 }
 
-func (store *Storage) UpsertBatch(metrics []metricPkg.Metric) error {
+// encodeLabels Метки метрики в виде JSON для хранения в базе данных.
+// Ключи JSON объекта сортируются, поэтому одинаковые наборы меток кодируются одинаково
+func encodeLabels(labels metricPkg.Labels) (string, error) {
+
+	if len(labels) == 0 {
+		return ``, nil
+	}
 
-	return store.memory.UpsertBatch(metrics)
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return ``, fmt.Errorf("could not encode labels: %w", err)
+	}
+
+	return string(data), nil
 }
 
-func (store Storage) Get(metric metricPkg.Metric) (metricPkg.Metric, error) {
+// decodeLabels Метки метрики из JSON, хранимого в базе данных
+func decodeLabels(data string) (metricPkg.Labels, error) {
+
+	if len(data) == 0 {
+		return nil, nil
+	}
 
-	return store.memory.Get(metric)
+	var labels metricPkg.Labels
+	if err := json.Unmarshal([]byte(data), &labels); err != nil {
+		return nil, fmt.Errorf("could not decode labels: %w", err)
+	}
+
+	return labels, nil
 }
 
-func (store Storage) GetBatch() ([]metricPkg.Metric, error) {
+// encodeHistogram Состояние histogram в виде JSON для хранения в базе данных
+func encodeHistogram(metric metricPkg.Metric) (string, error) {
+
+	data, err := json.Marshal(histogramState{Buckets: metric.Buckets, Counts: metric.Counts, Sum: metric.Sum})
+	if err != nil {
+		return ``, fmt.Errorf("could not encode histogram: %w", err)
+	}
 
-	return store.memory.GetBatch()
+	return string(data), nil
 }
 
-func (store *Storage) Delete(metric metricPkg.Metric) error {
+// decodeHistogram Заполнение состояния histogram из JSON, хранимого в базе данных
+func decodeHistogram(data string, metric *metricPkg.Metric) error {
 
-	if err := store.memory.Delete(metric); err != nil {
-		return err
+	if len(data) == 0 {
+		return nil
 	}
 
-	query := `DELETE FROM runtimeMetrics WHERE name=$1 AND type=$2;`
-	if _, err := store.db.Exec(query, metric.ID, metric.MType); err != nil {
-		return fmt.Errorf("could not delete metric from database: %w", err)
+	var state histogramState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return fmt.Errorf("could not decode histogram: %w", err)
 	}
 
+	metric.Buckets = state.Buckets
+	metric.Counts = state.Counts
+	metric.Sum = state.Sum
+
 	return nil
 }
 
-func (store Storage) Flush() error {
+// upsertQuery Запрос и его аргументы для обновления метрики в базе данных.
+// Histogram записывается полным состоянием: наблюдение должно быть уже учтено в observe
+func upsertQuery(metric metricPkg.Metric) (string, []interface{}, error) {
 
-	tx, err := store.db.Begin()
+	labels, err := encodeLabels(metric.Labels)
 	if err != nil {
-		return fmt.Errorf("could not flush metrics to database: %w", err)
+		return ``, nil, err
 	}
-	defer func() {
-		if errRollBack := tx.Rollback(); errRollBack != nil {
-			if !errors.Is(errRollBack, sql.ErrTxDone) {
-				store.logger.Err.Printf("error rollback: %v\n", errRollBack)
-			}
+
+	// Метрика без времени обновления считается обновленной в момент записи
+	lastUpdate := metric.LastUpdate
+	if lastUpdate.IsZero() {
+		lastUpdate = time.Now()
+	}
+
+	switch metric.MType {
+	case metricPkg.GaugeType, metricPkg.FloatCounterType:
+		if metric.Value == nil {
+			return ``, nil, errs.ErrInvalidValue
 		}
-	}()
 
-	stmtGauge, err := tx.Prepare(queryChangeGauge)
+		return queryChangeGauge, []interface{}{metric.ID, metric.MType, *metric.Value, metric.Hash, labels, lastUpdate}, nil
+
+	case metricPkg.CounterType:
+		if metric.Delta == nil {
+			return ``, nil, errs.ErrInvalidValue
+		}
+
+		return queryChangeCounter, []interface{}{metric.ID, metric.MType, *metric.Delta, metric.Hash, labels, lastUpdate}, nil
+
+	case metricPkg.HistogramType:
+		if metric.Value != nil {
+			return ``, nil, errs.ErrInvalidValue
+		}
+
+		histogram, err := encodeHistogram(metric)
+		if err != nil {
+			return ``, nil, err
+		}
+
+		return queryChangeHistogram, []interface{}{metric.ID, metric.MType, histogram, metric.Hash, labels, lastUpdate}, nil
+
+	default:
+		return ``, nil, errs.ErrUnknownType
+	}
+}
+
+// Upsert Обновление значения метрики в базе данных, или добавление метрики, если ранее её не существовало.
+// Значение counter должно быть уже накоплено на уровне MetricsManager
+func (store *Storage) Upsert(ctx context.Context, metric metricPkg.Metric) (err error) {
+
+	ctx, span := tracing.Start(ctx, "postgres.Upsert", tracing.AttrMetricType.String(metric.MType))
+	defer func() { tracing.End(span, err) }()
+
+	observed, err := store.observe(ctx, []metricPkg.Metric{metric})
 	if err != nil {
-		return fmt.Errorf("error prepare statement 'gauge' : %w", err)
+		return fmt.Errorf("could not upsert metric: %w", err)
 	}
-	defer func() {
-		if errClose := stmtGauge.Close(); errClose != nil {
-			store.logger.Err.Printf("error close gauge statement: %v\n", errClose)
-		}
-	}()
+	metric = observed[0]
 
-	stmtCounter, err := tx.Prepare(queryChangeCounter)
+	query, args, err := upsertQuery(metric)
 	if err != nil {
-		return fmt.Errorf("error prepare statement 'counter': %w", err)
+		return fmt.Errorf("could not upsert metric: %w", err)
+	}
+
+	if _, err := store.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("could not upsert metric in database: %w", err)
 	}
-	defer func() {
-		if errClose := stmtCounter.Close(); errClose != nil {
-			store.logger.Err.Printf("error close counter statement: %v\n", errClose)
-		}
-	}()
 
-	metrics, err := store.memory.GetBatch()
+	return store.memory.Upsert(ctx, metric)
+}
+
+// UpsertBatch Обновление набора метрик в базе данных в одной транзакции
+func (store *Storage) UpsertBatch(ctx context.Context, metrics []metricPkg.Metric) (err error) {
+
+	ctx, span := tracing.Start(ctx, "postgres.UpsertBatch", tracing.MetricTypes(metrics)...)
+	defer func() { tracing.End(span, err) }()
+
+	metrics, err = store.observe(ctx, metrics)
 	if err != nil {
-		return fmt.Errorf("could not flush metrics to database: %w", err)
+		return fmt.Errorf("could not upsert metrics: %w", err)
 	}
 
-	for _, metric := range metrics {
+	if err := store.upsertTx(ctx, metrics); err != nil {
+		return fmt.Errorf("could not upsert metrics in database: %w", err)
+	}
 
-		var errExec error
+	return store.memory.UpsertBatch(ctx, metrics)
+}
 
-		switch metric.MType {
-		case metricPkg.GaugeType:
-			if metric.Value == nil {
-				store.logger.Err.Printf("could not flush metric without value: %s\n", metric.ShotString())
-				continue
-			}
+// observe Копия набора, в которой наблюдения histogram учтены в ее состоянии, как в памяти:
+// наблюдение добавляется к состоянию из базы данных или из предыдущих метрик набора,
+// а полное состояние заменяет хранимое
+func (store *Storage) observe(ctx context.Context, metrics []metricPkg.Metric) ([]metricPkg.Metric, error) {
+
+	observed := make([]metricPkg.Metric, len(metrics))
+	states := make(map[string]metricPkg.Metric)
 
-			_, errExec = stmtGauge.Exec(metric.ID, metric.MType, *metric.Value)
+	for i, metric := range metrics {
+		observed[i] = metric
 
-		case metricPkg.CounterType:
-			if metric.Delta == nil {
-				store.logger.Err.Printf("could not flush metric without delta: %s\n", metric.ShotString())
-				continue
+		if metric.MType != metricPkg.HistogramType {
+			continue
+		}
+
+		histogram := metric
+		if metric.Value != nil {
+			known, ok := states[metric.Key()]
+			if !ok {
+				found, err := store.Get(ctx, metric)
+				if err != nil && !errors.Is(err, errs.ErrNotFound) {
+					return nil, err
+				}
+
+				known, ok = found, err == nil
 			}
 
-			_, errExec = stmtCounter.Exec(metric.ID, metric.MType, *metric.Delta)
+			if ok {
+				histogram = known
+			}
+		}
 
-		default:
-			store.logger.Err.Printf("could not flush metric with unknown type: %s\n", metric.ShotString())
+		histogram.Hash = metric.Hash
+		histogram.LastUpdate = metric.LastUpdate
+		histogram.Value = nil
+
+		if metric.Value != nil {
+			if err := histogram.Observe(*metric.Value); err != nil {
+				return nil, err
+			}
 		}
 
-		if errExec != nil {
-			errExec = fmt.Errorf("could not flush metric: %w", errExec)
-			return errExec
+		states[metric.Key()] = histogram
+		observed[i] = histogram
+	}
+
+	return observed, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMetric Чтение метрики из строки результата запроса
+func scanMetric(row rowScanner) (metricPkg.Metric, error) {
+
+	var (
+		id         sql.NullString
+		mtype      sql.NullString
+		delta      sql.NullInt64
+		value      sql.NullFloat64
+		hash       sql.NullString
+		labels     sql.NullString
+		lastUpdate sql.NullTime
+		histogram  sql.NullString
+	)
+
+	if err := row.Scan(&id, &mtype, &delta, &value, &hash, &labels, &lastUpdate, &histogram); err != nil {
+		return metricPkg.Metric{}, err
+	}
+
+	// Имя не проверяется: в базе данных оно хранится с пространством имен арендатора
+	metric := metricPkg.Metric{
+		ID:         id.String,
+		MType:      mtype.String,
+		Hash:       hash.String,
+		LastUpdate: lastUpdate.Time,
+	}
+
+	var err error
+	if metric.Labels, err = decodeLabels(labels.String); err != nil {
+		return metricPkg.Metric{}, fmt.Errorf("invalid metric [type: %s], [id: %s]: %w", mtype.String, id.String, err)
+	}
+
+	switch metric.MType {
+	case metricPkg.GaugeType, metricPkg.FloatCounterType:
+		if value.Valid {
+			metric.Value = &value.Float64
+		}
+	case metricPkg.CounterType:
+		if delta.Valid {
+			metric.Delta = &delta.Int64
+		}
+	case metricPkg.HistogramType:
+		if err := decodeHistogram(histogram.String, &metric); err != nil {
+			return metricPkg.Metric{}, fmt.Errorf("invalid metric [type: %s], [id: %s]: %w", mtype.String, id.String, err)
 		}
 	}
 
-	if errCommit := tx.Commit(); errCommit != nil {
-		errCommit = fmt.Errorf("could not commit flush transaction: %w", errCommit)
-		store.logger.Err.Println(errCommit)
-		return errCommit
+	return metric, nil
+}
+
+// Get - Получение полностью заполненной метрики из базы данных
+func (store Storage) Get(ctx context.Context, metric metricPkg.Metric) (_ metricPkg.Metric, err error) {
+
+	ctx, span := tracing.Start(ctx, "postgres.Get", tracing.AttrMetricType.String(metric.MType))
+	defer func() { tracing.End(span, err) }()
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return metricPkg.Metric{}, fmt.Errorf("could not get metric from database: %w", err)
 	}
 
-	return nil
+	row := store.db.QueryRowContext(ctx, queryGetMetric, metric.ID, metric.MType, labels)
+
+	found, err := scanMetric(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return metricPkg.Metric{}, errs.ErrNotFound
+		}
+
+		return metricPkg.Metric{}, fmt.Errorf("could not get metric from database: %w", err)
+	}
+
+	return found, nil
+}
+
+// GetBatch Получение всех метрик из базы данных в виде слайса
+func (store Storage) GetBatch(ctx context.Context) (_ []metricPkg.Metric, err error) {
+
+	ctx, span := tracing.Start(ctx, "postgres.GetBatch")
+	defer func() { tracing.End(span, err) }()
+
+	return store.queryMetrics(ctx, queryGetMetrics)
+}
+
+// GetByType Получение метрик типа typeMetric из базы данных одним запросом, отсортированных по ID
+func (store Storage) GetByType(ctx context.Context, typeMetric string) (_ []metricPkg.Metric, err error) {
+
+	ctx, span := tracing.Start(ctx, "postgres.GetByType", tracing.AttrMetricType.String(typeMetric))
+	defer func() { tracing.End(span, err) }()
+
+	return store.queryMetrics(ctx, queryGetMetricsByType, typeMetric)
 }
 
-func (store *Storage) Restore() error {
+// queryMetrics Чтение метрик, возвращаемых запросом query.
+// Строки, которые не удалось разобрать, пропускаются
+func (store Storage) queryMetrics(ctx context.Context, query string, args ...interface{}) ([]metricPkg.Metric, error) {
 
-	rows, errQuery := store.db.Query(queryGetMetrics)
+	rows, errQuery := store.db.QueryContext(ctx, query, args...)
 	if errQuery != nil {
-		return fmt.Errorf("could not load metrics from database: %w", errQuery)
+		return nil, fmt.Errorf("could not load metrics from database: %w", errQuery)
 	}
 
 	defer func() {
 		if err := rows.Close(); err != nil {
-			store.logger.Err.Printf("could not close rows: %v\n", err)
+			store.logger.Ctx(ctx).Err.Printf("could not close rows: %v\n", err)
 		}
 	}()
 
-	for rows.Next() {
+	metrics := make([]metricPkg.Metric, 0)
 
-		var (
-			id    sql.NullString
-			mtype sql.NullString
-			delta sql.NullInt64
-			value sql.NullFloat64
-		)
+	for rows.Next() {
 
-		if err := rows.Scan(&id, &mtype, &delta, &value); err != nil {
-			store.logger.Err.Printf("error scan: %v\n", err)
+		metric, err := scanMetric(rows)
+		if err != nil {
+			store.logger.Ctx(ctx).Warn.Printf("could not read metric: %v\n", err)
 			continue
 		}
 
-		metric, err := metricPkg.CreateMetric(mtype.String, id.String)
+		metrics = append(metrics, metric)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not load metrics from database: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// Reset Сброс значения counter или float_counter в ноль в базе данных и в памяти
+func (store *Storage) Reset(ctx context.Context, metric metricPkg.Metric) error {
+
+	if !metricPkg.IsCounter(metric.MType) {
+		return fmt.Errorf("could not reset metric %s: %w", metric.ID, errs.ErrInvalidType)
+	}
+
+	if _, err := store.Get(ctx, metric); err != nil {
+		return err
+	}
+
+	if metric.MType == metricPkg.FloatCounterType {
+		var zero float64
+		metric.Value = &zero
+	} else {
+		var zero int64
+		metric.Delta = &zero
+	}
+	metric.Hash = ``
+
+	return store.Upsert(ctx, metric)
+}
+
+// Count Количество метрик типа typeMetric в базе данных
+func (store *Storage) Count(ctx context.Context, typeMetric string) (int, error) {
+
+	var count int
+	if err := store.db.QueryRowContext(ctx, queryCountMetrics, typeMetric).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not count metrics in database: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountTenant Количество метрик типа typeMetric арендатора owner в базе данных
+func (store *Storage) CountTenant(ctx context.Context, owner, typeMetric string) (int, error) {
+
+	query, args := queryCountDefault, []interface{}{typeMetric}
+	if owner != tenant.Default {
+		// '_' в идентификаторе арендатора - шаблон LIKE, поэтому экранируется
+		prefix := strings.ReplaceAll(owner, "_", `\_`) + tenant.Separator + "%"
+		query, args = queryCountTenant, append(args, prefix)
+	}
+
+	var count int
+	if err := store.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("could not count metrics in database: %w", err)
+	}
+
+	return count, nil
+}
+
+// Delete Удаление метрики из базы данных, а затем из памяти.
+// Наличие метрики определяется базой данных: в памяти ее может не быть, если метрики не восстанавливались
+func (store *Storage) Delete(ctx context.Context, metric metricPkg.Metric) error {
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return fmt.Errorf("could not delete metric from database: %w", err)
+	}
+
+	result, err := store.db.ExecContext(ctx, queryDeleteMetric, metric.ID, metric.MType, labels)
+	if err != nil {
+		return fmt.Errorf("could not delete metric from database: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not delete metric from database: %w", err)
+	}
+
+	if deleted == 0 {
+		return errs.ErrNotFound
+	}
+
+	if err := store.memory.Delete(ctx, metric); err != nil && !errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteWhere Удаление всех метрик базы данных, для которых match возвращает true, в одной транзакции, а затем из памяти.
+// Возвращается количество метрик, удаленных из базы данных
+func (store *Storage) DeleteWhere(ctx context.Context, match func(metricPkg.Metric) bool) (int, error) {
+
+	metrics, err := store.GetBatch(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete metrics: %w", err)
+	}
+
+	keys := make(map[string]struct{})
+	matched := make([]metricPkg.Metric, 0)
+	for _, metric := range metrics {
+		if match(metric) {
+			keys[metric.Key()] = struct{}{}
+			matched = append(matched, metric)
+		}
+	}
+
+	if len(matched) == 0 {
+		return 0, nil
+	}
+
+	deleted, err := store.deleteTx(ctx, matched)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete metrics from database: %w", err)
+	}
+
+	if _, err := store.memory.DeleteWhere(ctx, func(metric metricPkg.Metric) bool {
+		_, ok := keys[metric.Key()]
+		return ok
+	}); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// Rename Переименование метрики в базе данных, а затем в памяти.
+// Метрика в памяти заменяется переименованной строкой базы данных
+func (store *Storage) Rename(ctx context.Context, metric metricPkg.Metric, newID string) error {
+
+	renamed := metric
+	renamed.ID = newID
+
+	if _, err := store.Get(ctx, renamed); err == nil {
+		return fmt.Errorf("%w: %s", errs.ErrExists, renamed.Key())
+	} else if !errors.Is(err, errs.ErrNotFound) {
+		return fmt.Errorf("could not rename metric in database: %w", err)
+	}
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return fmt.Errorf("could not rename metric in database: %w", err)
+	}
+
+	row := store.db.QueryRowContext(ctx, queryRenameMetric, newID, metric.ID, metric.MType, labels)
+
+	if renamed, err = scanMetric(row); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errs.ErrNotFound
+		}
+
+		return fmt.Errorf("could not rename metric in database: %w", err)
+	}
+
+	if err := store.memory.Delete(ctx, metric); err != nil && !errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+
+	return store.memory.Upsert(ctx, renamed)
+}
+
+// deleteTx Удаление набора метрик из базы данных в одной транзакции.
+// Возвращается количество удаленных строк
+func (store Storage) deleteTx(ctx context.Context, metrics []metricPkg.Metric) (int, error) {
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if errRollBack := tx.Rollback(); errRollBack != nil {
+			if !errors.Is(errRollBack, sql.ErrTxDone) {
+				store.logger.Ctx(ctx).Err.Printf("error rollback: %v\n", errRollBack)
+			}
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, queryDeleteMetric)
+	if err != nil {
+		return 0, fmt.Errorf("error prepare statement: %w", err)
+	}
+	defer func() {
+		if errClose := stmt.Close(); errClose != nil {
+			store.logger.Ctx(ctx).Err.Printf("error close statement: %v\n", errClose)
+		}
+	}()
+
+	var deleted int64
+	for _, metric := range metrics {
+
+		labels, err := encodeLabels(metric.Labels)
 		if err != nil {
-			store.logger.Err.Printf("could not restore metric: [type: %s], [id: %s]\n", mtype.String, id.String)
-			continue
+			return 0, fmt.Errorf("could not delete metric %s: %w", metric.ShotString(), err)
+		}
+
+		result, err := stmt.ExecContext(ctx, metric.ID, metric.MType, labels)
+		if err != nil {
+			return 0, fmt.Errorf("could not delete metric %s: %w", metric.ShotString(), err)
 		}
 
-		switch metric.MType {
-		case metricPkg.GaugeType:
-			if value.Valid {
-				metric.Value = &value.Float64
+		count, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("could not delete metric %s: %w", metric.ShotString(), err)
+		}
+
+		deleted += count
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return int(deleted), nil
+}
+
+// Flush Запись всех метрик из памяти в базу данных
+func (store Storage) Flush(ctx context.Context) error {
+
+	metrics, err := store.memory.GetBatch(ctx)
+	if err != nil {
+		return fmt.Errorf("could not flush metrics to database: %w", err)
+	}
+
+	if err := store.upsertTx(ctx, metrics); err != nil {
+		err = fmt.Errorf("could not flush metrics to database: %w", err)
+		store.logger.Ctx(ctx).Err.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// upsertTx Запись набора метрик в базу данных в одной транзакции.
+// Запрос для каждого типа метрики подготавливается один раз
+func (store Storage) upsertTx(ctx context.Context, metrics []metricPkg.Metric) error {
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() {
+		if errRollBack := tx.Rollback(); errRollBack != nil {
+			if !errors.Is(errRollBack, sql.ErrTxDone) {
+				store.logger.Ctx(ctx).Err.Printf("error rollback: %v\n", errRollBack)
 			}
-		case metricPkg.CounterType:
-			if delta.Valid {
-				metric.Delta = &delta.Int64
+		}
+	}()
+
+	statements := make(map[string]*sql.Stmt, 2)
+	defer func() {
+		for _, stmt := range statements {
+			if errClose := stmt.Close(); errClose != nil {
+				store.logger.Ctx(ctx).Err.Printf("error close statement: %v\n", errClose)
 			}
 		}
+	}()
 
-		if errMem := store.memory.Upsert(metric); errMem != nil {
-			store.logger.Err.Printf("could not restore metric: %s. %v\n", metric.ShotString(), errMem)
+	for _, metric := range metrics {
+
+		query, args, err := upsertQuery(metric)
+		if err != nil {
+			return fmt.Errorf("could not upsert metric %s: %w", metric.ShotString(), err)
+		}
+
+		stmt, ok := statements[query]
+		if !ok {
+			if stmt, err = tx.PrepareContext(ctx, query); err != nil {
+				return fmt.Errorf("error prepare statement '%s': %w", metric.MType, err)
+			}
+
+			statements[query] = stmt
+		}
+
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("could not upsert metric %s: %w", metric.ShotString(), err)
 		}
 	}
 
-	if err := rows.Err(); err != nil {
-		store.logger.Err.Printf("could not restore metric: %v\n", err)
-		return err
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Restore Загрузка метрик из базы данных в память.
+// Значения из базы данных заменяют значения в памяти, в том числе для counter:
+// Upsert сразу записывает накопленное значение в базу данных, поэтому
+// приращения, принятые до загрузки, в ней уже учтены
+func (store *Storage) Restore(ctx context.Context) error {
+
+	metrics, err := store.GetBatch(ctx)
+	if err != nil {
+		return fmt.Errorf("could not restore metrics: %w", err)
+	}
+
+	for _, metric := range metrics {
+		if errMem := store.memory.Upsert(ctx, metric); errMem != nil {
+			store.logger.Ctx(ctx).Warn.Printf("could not restore metric: %s. %v\n", metric.ShotString(), errMem)
+		}
 	}
 
 	return nil
 }
 
+// Close Закрытие соединения с базой данных.
+// Если выполняемые запросы не завершились за время closeTimeout, ожидание прекращается с ошибкой.
+// Повторный вызов Close не возвращает ошибку
 func (store *Storage) Close() error {
-	return store.db.Close()
+	if store.db == nil {
+		return nil
+	}
+
+	if store.closeTimeout <= 0 {
+		return store.db.Close()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- store.db.Close()
+	}()
+
+	timer := time.NewTimer(store.closeTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
 
+	case <-timer.C:
+		return fmt.Errorf("could not close database within %s", store.closeTimeout)
+	}
+}
+
+// Stats Статистика пула соединений с базой данных
+func (store *Storage) Stats() sql.DBStats {
+	return store.db.Stats()
 }
 
+// Health Проверка доступности базы данных.
+// Ожидание ответа ограничено healthTimeout
 func (store Storage) Health() bool {
 
 	if store.db == nil {
@@ -246,7 +896,10 @@ func (store Storage) Health() bool {
 		return false
 	}
 
-	err := store.db.Ping()
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	err := store.db.PingContext(ctx)
 	if err != nil {
 		store.logger.Err.Printf("ping driver returned error: %v\n", err)
 		return false
@@ -255,18 +908,78 @@ func (store Storage) Health() bool {
 	return true
 }
 
+// migration Шаг миграции схемы базы данных
+type migration struct {
+	version int
+	name    string // действие шага для сообщения об ошибке
+	query   string
+}
+
+// migrations Шаги миграции в порядке применения. Новый шаг добавляется в конец со следующей версией
+var migrations = []migration{
+	{version: 1, name: "create table metrics", query: queryMigration},
+	{version: 2, name: "add labels to table metrics", query: queryMigrationLabels},
+	{version: 3, name: "widen id in table metrics", query: queryMigrationTenants},
+	{version: 4, name: "widen hash in table metrics", query: queryMigrationHash},
+	{version: 5, name: "add last_update to table metrics", query: queryMigrationLastUpdate},
+	{version: 6, name: "add histogram to table metrics", query: queryMigrationHistogram},
+}
+
+// applyMigrations Применение шагов миграции, версии которых еще нет в таблице schema_migrations.
+// Миграция выполняется в одной транзакции: при ошибке не применяется ни один шаг
 func (store Storage) applyMigrations() error {
 
-	query := `CREATE TABLE IF NOT EXISTS runtimeMetrics (
-              id     SERIAL,
-		      name   CHARACTER VARYING(50) PRIMARY KEY,
-		      type   CHARACTER VARYING(50),
-		      delta  BIGINT,
-		      value  DOUBLE PRECISION );`
+	tx, err := store.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin migration transaction: %w", err)
+	}
+	defer func() {
+		if errRollBack := tx.Rollback(); errRollBack != nil {
+			if !errors.Is(errRollBack, sql.ErrTxDone) {
+				store.logger.Err.Printf("error rollback migration: %v\n", errRollBack)
+			}
+		}
+	}()
 
-	if _, err := store.db.Exec(query); err != nil {
-		return err
+	if _, err := tx.Exec(querySchemaMigrations); err != nil {
+		return fmt.Errorf("could not create table schema_migrations: %w", err)
+	}
+
+	if _, err := tx.Exec(queryLockMigrations); err != nil {
+		return fmt.Errorf("could not lock table schema_migrations: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRow(querySchemaVersion).Scan(&version); err != nil {
+		return fmt.Errorf("could not get schema version: %w", err)
+	}
+
+	applied := version
+	for _, step := range migrations {
+		if step.version <= version {
+			continue
+		}
+
+		if _, err := tx.Exec(step.query); err != nil {
+			return fmt.Errorf("could not %s: %w", step.name, err)
+		}
+
+		if _, err := tx.Exec(queryAddSchemaVersion, step.version); err != nil {
+			return fmt.Errorf("could not save schema version %d: %w", step.version, err)
+		}
+
+		applied = step.version
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit migration transaction: %w", err)
+	}
+
+	if applied == version {
+		store.logger.Info.Printf("database schema is up to date: version %d\n", version)
+		return nil
 	}
 
+	store.logger.Info.Printf("database migration applied: version %d -> %d\n", version, applied)
 	return nil
 }
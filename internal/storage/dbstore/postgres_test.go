@@ -0,0 +1,636 @@
+package dbstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func newMockStorage(t *testing.T) (*Storage, sqlmock.Sqlmock) {
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	store := &Storage{
+		db:     db,
+		logger: logpack.NewLogger(),
+		memory: memstore.New(),
+	}
+
+	return store, mock
+}
+
+// TestStorage_Close Повторное закрытие хранилища не должно возвращать ошибку
+func TestStorage_Close(t *testing.T) {
+
+	store, mock := newMockStorage(t)
+	mock.ExpectClose()
+
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Close())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestNew Создание хранилища с недоступной базой данных должно возвращать ошибку
+func TestNew(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	tests := []struct {
+		name    string
+		dsn     string
+		wantErr error
+	}{
+		{
+			name:    "Empty DSN -> ERROR",
+			dsn:     "",
+			wantErr: errs.ErrInvalidDSN,
+		},
+		{
+			name: "Unreachable DSN -> ERROR",
+			dsn:  "host=127.0.0.1 port=1 user=user dbname=metrics sslmode=disable connect_timeout=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			store, err := New(tt.dsn, logger)
+			require.Error(t, err)
+			require.Nil(t, store)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestStorage_configurePool Ограничения пула соединений применяются к sql.DB
+func TestStorage_configurePool(t *testing.T) {
+
+	store, mock := newMockStorage(t)
+	WithPool(7, 3, time.Minute)(store)
+	store.configurePool()
+
+	require.Equal(t, 7, store.db.Stats().MaxOpenConnections)
+	require.Equal(t, 3, store.maxIdleConns)
+	require.Equal(t, time.Minute, store.connMaxLifetime)
+
+	mock.ExpectClose()
+	require.NoError(t, store.Close())
+}
+
+// TestStorage_applyMigrations Миграция должна выполняться в транзакции
+// и применять только шаги, версий которых еще нет в schema_migrations
+func TestStorage_applyMigrations(t *testing.T) {
+
+	steps := []string{
+		"CREATE TABLE IF NOT EXISTS metrics",
+		// Первичный ключ и типы столбцов меняются, только если схема еще не соответствует шагу
+		`ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels .* IF NOT EXISTS \(.*pg_constraint.*a\.attname = 'labels' \) THEN .*ADD PRIMARY KEY \(id, mtype, labels\)`,
+		`column_name = 'id'\) < 321 THEN ALTER TABLE metrics ALTER COLUMN id TYPE CHARACTER VARYING\(321\)`,
+		`column_name = 'hash'\) < 128 THEN ALTER TABLE metrics ALTER COLUMN hash TYPE CHARACTER VARYING\(128\)`,
+		"ALTER TABLE metrics ADD COLUMN IF NOT EXISTS last_update",
+		"ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram",
+	}
+	require.Len(t, migrations, len(steps))
+
+	expectVersion := func(mock sqlmock.Sqlmock, version int) {
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("LOCK TABLE schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM schema_migrations`).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	}
+
+	tests := []struct {
+		name    string
+		version int
+	}{
+		{name: "New database -> all steps", version: 0},
+		{name: "Database of version 4 -> steps 5 and 6", version: 4},
+		{name: "Up to date database -> no steps", version: len(steps)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStorage(t)
+
+			expectVersion(mock, tt.version)
+			for i, step := range steps[tt.version:] {
+				mock.ExpectExec(step).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`INSERT INTO schema_migrations \(version\) VALUES \(\$1\)`).
+					WithArgs(tt.version + i + 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+
+			require.NoError(t, store.applyMigrations())
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Failed migration -> ROLLBACK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		expectVersion(mock, 0)
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS metrics").WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		require.ErrorIs(t, store.applyMigrations(), sql.ErrConnDone)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorage_Upsert(t *testing.T) {
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(10))
+	labeled, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter",
+		metricPkg.WithValueInt(10),
+		metricPkg.WithLabels(metricPkg.Labels{"method": "GET", "code": "200"}))
+
+	tests := []struct {
+		name      string
+		metric    metricPkg.Metric
+		wantQuery string
+		wantArgs  []driver.Value
+		wantErr   error
+	}{
+		{
+			name:      "Upsert gauge -> OK",
+			metric:    gauge,
+			wantQuery: "INSERT INTO metrics \\(id,mtype,value,hash,labels,last_update\\)",
+			wantArgs:  []driver.Value{gauge.ID, gauge.MType, *gauge.Value, gauge.Hash, "", sqlmock.AnyArg()},
+		},
+		{
+			name:      "Upsert counter -> OK",
+			metric:    counter,
+			wantQuery: "INSERT INTO metrics \\(id,mtype,delta,hash,labels,last_update\\)",
+			wantArgs:  []driver.Value{counter.ID, counter.MType, *counter.Delta, counter.Hash, "", sqlmock.AnyArg()},
+		},
+		{
+			name:      "Upsert counter with labels -> OK",
+			metric:    labeled,
+			wantQuery: "INSERT INTO metrics \\(id,mtype,delta,hash,labels,last_update\\)",
+			wantArgs:  []driver.Value{labeled.ID, labeled.MType, *labeled.Delta, labeled.Hash, `{"code":"200","method":"GET"}`, sqlmock.AnyArg()},
+		},
+		{
+			name:    "Upsert gauge without value -> ERROR",
+			metric:  metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType},
+			wantErr: errs.ErrInvalidValue,
+		},
+		{
+			name:    "Upsert counter without delta -> ERROR",
+			metric:  metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType},
+			wantErr: errs.ErrInvalidValue,
+		},
+		{
+			name:    "Upsert metric with unknown type -> ERROR",
+			metric:  metricPkg.Metric{ID: "testMetric", MType: "summary"},
+			wantErr: errs.ErrUnknownType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			store, mock := newMockStorage(t)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, store.Upsert(context.Background(), tt.metric), tt.wantErr)
+				require.NoError(t, mock.ExpectationsWereMet())
+				return
+			}
+
+			mock.ExpectExec(tt.wantQuery).WithArgs(tt.wantArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			require.NoError(t, store.Upsert(context.Background(), tt.metric))
+			require.NoError(t, mock.ExpectationsWereMet())
+
+			stored, err := store.memory.Get(context.Background(), tt.metric)
+			require.NoError(t, err)
+			require.Equal(t, tt.metric, stored)
+		})
+	}
+}
+
+func TestStorage_Get(t *testing.T) {
+
+	columns := []string{"id", "mtype", "delta", "value", "hash", "labels", "last_update", "histogram"}
+
+	t.Run("Get gauge -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update").
+			WithArgs("testGauge", metricPkg.GaugeType, "").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("testGauge", metricPkg.GaugeType, nil, 1.5, "", "", nil, ""))
+
+		want, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+
+		got, err := store.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Get gauge with labels -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update").
+			WithArgs("testGauge", metricPkg.GaugeType, `{"host":"a"}`).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("testGauge", metricPkg.GaugeType, nil, 1.5, "", `{"host":"a"}`, nil, ""))
+
+		want, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge",
+			metricPkg.WithValueFloat(1.5),
+			metricPkg.WithLabels(metricPkg.Labels{"host": "a"}))
+
+		got, err := store.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType, Labels: metricPkg.Labels{"host": "a"}})
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Get unknown metric -> NOT FOUND", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update").
+			WithArgs("unknown", metricPkg.CounterType, "").
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := store.Get(context.Background(), metricPkg.Metric{ID: "unknown", MType: metricPkg.CounterType})
+		require.ErrorIs(t, err, errs.ErrNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorage_GetBatch(t *testing.T) {
+
+	store, mock := newMockStorage(t)
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(100.023))
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(100))
+
+	rows := sqlmock.NewRows([]string{"id", "mtype", "delta", "value", "hash", "labels", "last_update", "histogram"}).
+		AddRow(gauge.ID, gauge.MType, nil, *gauge.Value, "", "", nil, "").
+		AddRow(counter.ID, counter.MType, *counter.Delta, nil, "", "", nil, "")
+
+	mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update").WillReturnRows(rows)
+
+	metrics, err := store.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []metricPkg.Metric{gauge, counter}, metrics)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_GetByType(t *testing.T) {
+
+	store, mock := newMockStorage(t)
+
+	first, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "a", metricPkg.WithValueFloat(1))
+	second, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "b", metricPkg.WithValueFloat(2))
+
+	rows := sqlmock.NewRows([]string{"id", "mtype", "delta", "value", "hash", "labels", "last_update", "histogram"}).
+		AddRow(first.ID, first.MType, nil, *first.Value, "", "", nil, "").
+		AddRow(second.ID, second.MType, nil, *second.Value, "", "", nil, "")
+
+	mock.ExpectQuery(`SELECT id,mtype,delta,value,hash,labels,last_update,histogram\s+FROM metrics\s+WHERE mtype=\$1\s+ORDER BY id`).
+		WithArgs(metricPkg.GaugeType).
+		WillReturnRows(rows)
+
+	metrics, err := store.GetByType(context.Background(), metricPkg.GaugeType)
+	require.NoError(t, err)
+	require.Equal(t, []metricPkg.Metric{first, second}, metrics)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_Count(t *testing.T) {
+
+	store, mock := newMockStorage(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM metrics WHERE mtype=\$1`).
+		WithArgs(metricPkg.GaugeType).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, err := store.Count(context.Background(), metricPkg.GaugeType)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_CountTenant(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		owner     string
+		wantQuery string
+		wantArgs  []driver.Value
+	}{
+		{
+			name:      "Default tenant -> metrics without namespace",
+			owner:     "default",
+			wantQuery: `SELECT COUNT\(\*\) FROM metrics WHERE mtype=\$1 AND id NOT LIKE '%/%'`,
+			wantArgs:  []driver.Value{metricPkg.GaugeType},
+		},
+		{
+			name:      "Tenant -> metrics with tenant prefix",
+			owner:     "team_a",
+			wantQuery: `SELECT COUNT\(\*\) FROM metrics WHERE mtype=\$1 AND id LIKE \$2`,
+			wantArgs:  []driver.Value{metricPkg.GaugeType, `team\_a/%`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			store, mock := newMockStorage(t)
+
+			mock.ExpectQuery(tt.wantQuery).
+				WithArgs(tt.wantArgs...).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+			count, err := store.CountTenant(context.Background(), tt.owner, metricPkg.GaugeType)
+			require.NoError(t, err)
+			require.Equal(t, 2, count)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestStorage_Reset(t *testing.T) {
+
+	t.Run("Reset known counter -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update").
+			WithArgs("testCounter", metricPkg.CounterType, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "mtype", "delta", "value", "hash", "labels", "last_update", "histogram"}).
+				AddRow("testCounter", metricPkg.CounterType, 10, nil, "", "", nil, ""))
+
+		mock.ExpectExec("INSERT INTO metrics \\(id,mtype,delta,hash,labels,last_update\\)").
+			WithArgs("testCounter", metricPkg.CounterType, int64(0), "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, store.Reset(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Reset unknown counter -> NOT FOUND", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "mtype", "delta", "value", "hash", "labels", "last_update", "histogram"}))
+
+		err := store.Reset(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+		require.ErrorIs(t, err, errs.ErrNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestStorage_Histogram Состояние histogram хранится в базе данных в виде JSON,
+// а наблюдение учитывается в состоянии из базы данных
+func TestStorage_Histogram(t *testing.T) {
+
+	columns := []string{"id", "mtype", "delta", "value", "hash", "labels", "last_update", "histogram"}
+	state := `{"buckets":[1,5],"counts":[1,0,2],"sum":12.5}`
+
+	sum := 12.5
+	histogram := metricPkg.Metric{
+		ID:      "testHistogram",
+		MType:   metricPkg.HistogramType,
+		Buckets: []float64{1, 5},
+		Counts:  []uint64{1, 0, 2},
+		Sum:     &sum,
+	}
+
+	t.Run("Upsert state -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectExec("INSERT INTO metrics \\(id,mtype,histogram,hash,labels,last_update\\)").
+			WithArgs(histogram.ID, histogram.MType, state, "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, store.Upsert(context.Background(), histogram))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Get state -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update,histogram").
+			WithArgs(histogram.ID, histogram.MType, "").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(histogram.ID, histogram.MType, nil, nil, "", "", nil, state))
+
+		got, err := store.Get(context.Background(), metricPkg.Metric{ID: histogram.ID, MType: histogram.MType})
+		require.NoError(t, err)
+		require.Equal(t, histogram, got)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Upsert observation -> state updated", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update,histogram").
+			WithArgs(histogram.ID, histogram.MType, "").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(histogram.ID, histogram.MType, nil, nil, "", "", nil, state))
+
+		mock.ExpectExec("INSERT INTO metrics \\(id,mtype,histogram,hash,labels,last_update\\)").
+			WithArgs(histogram.ID, histogram.MType, `{"buckets":[1,5],"counts":[1,1,2],"sum":15.5}`, "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		observation, err := metricPkg.CreateMetric(metricPkg.HistogramType, histogram.ID, metricPkg.WithValueFloat(3))
+		require.NoError(t, err)
+
+		require.NoError(t, store.Upsert(context.Background(), observation))
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		stored, err := store.memory.Get(context.Background(), observation)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{1, 1, 2}, stored.Counts)
+		require.Nil(t, stored.Value)
+	})
+
+	t.Run("Observations in batch accumulate -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectQuery("SELECT id,mtype,delta,value,hash,labels,last_update,histogram").
+			WithArgs("newHistogram", metricPkg.HistogramType, "").
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		mock.ExpectBegin()
+		prepare := mock.ExpectPrepare("INSERT INTO metrics \\(id,mtype,histogram,hash,labels,last_update\\)")
+		prepare.ExpectExec().
+			WithArgs("newHistogram", metricPkg.HistogramType, `{"buckets":[1,5],"counts":[0,1,0],"sum":2}`, "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		prepare.ExpectExec().
+			WithArgs("newHistogram", metricPkg.HistogramType, `{"buckets":[1,5],"counts":[0,1,1],"sum":9}`, "", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		first, err := metricPkg.CreateMetric(metricPkg.HistogramType, "newHistogram", metricPkg.WithValueFloat(2))
+		require.NoError(t, err)
+		first.Buckets = []float64{1, 5}
+
+		second, err := metricPkg.CreateMetric(metricPkg.HistogramType, "newHistogram", metricPkg.WithValueFloat(7))
+		require.NoError(t, err)
+
+		require.NoError(t, store.UpsertBatch(context.Background(), []metricPkg.Metric{first, second}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorage_UpsertBatch(t *testing.T) {
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(10))
+
+	t.Run("Upsert batch in one transaction -> OK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectBegin()
+		prepareGauge := mock.ExpectPrepare("INSERT INTO metrics \\(id,mtype,value,hash,labels,last_update\\)")
+		prepareGauge.ExpectExec().WithArgs(gauge.ID, gauge.MType, *gauge.Value, "", "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		prepareCounter := mock.ExpectPrepare("INSERT INTO metrics \\(id,mtype,delta,hash,labels,last_update\\)")
+		prepareCounter.ExpectExec().WithArgs(counter.ID, counter.MType, *counter.Delta, "", "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		prepareGauge.ExpectExec().WithArgs(gauge.ID, gauge.MType, *gauge.Value, "", "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, store.UpsertBatch(context.Background(), []metricPkg.Metric{gauge, counter, gauge}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Upsert batch with invalid metric -> ROLLBACK", func(t *testing.T) {
+		store, mock := newMockStorage(t)
+
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO metrics \\(id,mtype,value,hash,labels,last_update\\)").
+			ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		invalid := metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType}
+
+		err := store.UpsertBatch(context.Background(), []metricPkg.Metric{gauge, invalid})
+		require.ErrorIs(t, err, errs.ErrInvalidValue)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		metrics, _ := store.memory.GetBatch(context.Background())
+		require.Empty(t, metrics)
+	})
+}
+
+func TestStorage_Health(t *testing.T) {
+
+	newStorage := func(t *testing.T) (*Storage, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		require.NoError(t, err)
+
+		return &Storage{db: db, logger: logpack.NewLogger(), memory: memstore.New()}, mock
+	}
+
+	t.Run("Database reachable -> true", func(t *testing.T) {
+		store, mock := newStorage(t)
+		mock.ExpectPing()
+
+		require.True(t, store.Health())
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Closed pool -> false", func(t *testing.T) {
+		store, mock := newStorage(t)
+		mock.ExpectClose()
+		require.NoError(t, store.db.Close())
+
+		require.False(t, store.Health())
+	})
+
+	t.Run("Database does not answer -> false after timeout", func(t *testing.T) {
+		store, mock := newStorage(t)
+		mock.ExpectPing().WillDelayFor(time.Minute)
+
+		start := time.Now()
+		require.False(t, store.Health())
+		require.Less(t, time.Since(start), healthTimeout+time.Second)
+	})
+}
+
+// BenchmarkStorage_UpsertBatch Запись 1000 метрик одной транзакцией и циклом Upsert.
+// База данных заменена sqlmock, поэтому сетевые задержки и фиксация транзакций
+// не учитываются, измеряются только затраты на стороне клиента.
+//
+// Результаты (go test -bench UpsertBatch -benchtime 20x -benchmem):
+//
+//	Batch: ~13.6 ms/op, 1.70 MB/op, 12813 allocs/op
+//	Loop:  ~14.2 ms/op, 1.88 MB/op, 17779 allocs/op
+//
+// Большая часть времени приходится на поиск ожидания в sqlmock. Основной выигрыш
+// транзакции на PostgreSQL - одна фиксация вместо фиксации после каждой метрики
+func BenchmarkStorage_UpsertBatch(b *testing.B) {
+
+	const count = 1000
+
+	metrics := make([]metricPkg.Metric, 0, count)
+	for i := 0; i < count; i++ {
+		m, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testMetric_"+strconv.Itoa(i), metricPkg.WithValueInt(int64(i)))
+		metrics = append(metrics, m)
+	}
+
+	// Запросы не сверяются с ожидаемыми, чтобы не учитывать время разбора регулярных выражений
+	anyQuery := sqlmock.QueryMatcherFunc(func(string, string) error { return nil })
+
+	newStorage := func(b *testing.B) (*Storage, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(anyQuery))
+		if err != nil {
+			b.Fatalf("error create mock: %v", err)
+		}
+		b.Cleanup(func() { db.Close() })
+
+		return &Storage{db: db, logger: logpack.NewLogger(), memory: memstore.New()}, mock
+	}
+
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			store, mock := newStorage(b)
+			mock.ExpectBegin()
+			prepare := mock.ExpectPrepare("")
+			for range metrics {
+				prepare.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+			b.StartTimer()
+
+			if err := store.UpsertBatch(context.Background(), metrics); err != nil {
+				b.Fatalf("error upsert metrics: %v", err)
+			}
+		}
+	})
+
+	b.Run("Loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			store, mock := newStorage(b)
+			for range metrics {
+				mock.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			b.StartTimer()
+
+			for _, metric := range metrics {
+				if err := store.Upsert(context.Background(), metric); err != nil {
+					b.Fatalf("error upsert metric: %v", err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package dbstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/internal/server"
+	"metrics-and-alerting/internal/storage/dbstore"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorage_WithoutRestore Без восстановления метрик в память удаление и переименование
+// работают с метриками, которые уже есть в базе данных
+func TestStorage_WithoutRestore(t *testing.T) {
+
+	ctx := context.Background()
+	logger := logpack.NewLogger()
+
+	lastUpdate := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Метрики, сохраненные предыдущим запуском сервера
+	previous, err := dbstore.NewWithDB(openFakeDB(t), logger)
+	require.NoError(t, err)
+
+	for i, id := range []string{"first", "second", "third"} {
+		m, err := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(float64(i)))
+		require.NoError(t, err)
+
+		m.LastUpdate = lastUpdate
+		require.NoError(t, previous.Upsert(ctx, m))
+	}
+
+	counter, err := metricPkg.CreateMetric(metricPkg.CounterType, "requests", metricPkg.WithValueInt(5))
+	require.NoError(t, err)
+	require.NoError(t, previous.Upsert(ctx, counter))
+
+	store, err := dbstore.NewWithDB(openFakeDB(t), logger)
+	require.NoError(t, err)
+
+	manager := server.New(store, logger, server.WithRestore(false))
+
+	gauge := func(id string) metricPkg.Metric {
+		return metricPkg.Metric{ID: id, MType: metricPkg.GaugeType}
+	}
+
+	t.Run("Last update", func(t *testing.T) {
+		got, err := store.Get(ctx, gauge("first"))
+		require.NoError(t, err)
+		require.True(t, got.LastUpdate.Equal(lastUpdate))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, manager.Delete(ctx, gauge("first")))
+
+		_, err := manager.Get(ctx, gauge("first"))
+		require.ErrorIs(t, err, errs.ErrNotFound)
+
+		require.ErrorIs(t, manager.Delete(ctx, gauge("first")), errs.ErrNotFound)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		require.NoError(t, manager.Rename(ctx, gauge("second"), "renamed"))
+
+		got, err := manager.Get(ctx, gauge("renamed"))
+		require.NoError(t, err)
+		require.Equal(t, 1.0, *got.Value)
+
+		_, err = manager.Get(ctx, gauge("second"))
+		require.ErrorIs(t, err, errs.ErrNotFound)
+
+		require.ErrorIs(t, manager.Rename(ctx, gauge("third"), "renamed"), errs.ErrExists)
+		require.ErrorIs(t, manager.Rename(ctx, gauge("unknown"), "other"), errs.ErrNotFound)
+	})
+
+	t.Run("Delete where", func(t *testing.T) {
+		count, err := manager.DeleteWhere(ctx, func(m metricPkg.Metric) bool {
+			return m.MType == metricPkg.GaugeType
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		metrics, err := manager.GetBatch(ctx)
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		require.Equal(t, "requests", metrics[0].ID)
+	})
+}
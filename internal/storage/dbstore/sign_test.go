@@ -0,0 +1,36 @@
+package dbstore_test
+
+import (
+	"context"
+	"testing"
+
+	"metrics-and-alerting/internal/storage/dbstore"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorage_Hash Подпись метрики сохраняется в базе данных для всех алгоритмов хеширования
+func TestStorage_Hash(t *testing.T) {
+
+	for _, algo := range []string{metricPkg.HashSHA256, metricPkg.HashSHA512} {
+		t.Run(algo, func(t *testing.T) {
+
+			store, err := dbstore.NewWithDB(openFakeDB(t), logpack.NewLogger())
+			require.NoError(t, err)
+
+			metric, err := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+			require.NoError(t, err)
+
+			metric.Hash, err = metric.SignWith(algo, []byte("secret"))
+			require.NoError(t, err)
+
+			require.NoError(t, store.Upsert(context.Background(), metric))
+
+			got, err := store.Get(context.Background(), metric)
+			require.NoError(t, err)
+			require.Equal(t, metric.Hash, got.Hash)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package dbstore_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"metrics-and-alerting/internal/server"
+	"metrics-and-alerting/internal/storage/dbstore"
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTenantIsolation Метрики арендаторов в базе данных хранятся с пространством имен
+// и не видны другим арендаторам, как и в памяти
+func TestTenantIsolation(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	store, err := dbstore.NewWithDB(openFakeDB(t), logger)
+	require.NoError(t, err)
+
+	manager := server.New(store, logger)
+
+	owners := map[string]context.Context{
+		tenant.Default: context.Background(),
+		"team-a":       tenant.WithTenant(context.Background(), "team-a"),
+		"team-b":       tenant.WithTenant(context.Background(), "team-b"),
+	}
+
+	gauge := func(id string, value float64) metricPkg.Metric {
+		m, err := metricPkg.CreateMetric(metricPkg.GaugeType, id, metricPkg.WithValueFloat(value))
+		require.NoError(t, err)
+		return m
+	}
+
+	counter := func(id string, delta int64) metricPkg.Metric {
+		m, err := metricPkg.CreateMetric(metricPkg.CounterType, id, metricPkg.WithValueInt(delta))
+		require.NoError(t, err)
+		return m
+	}
+
+	// Одинаковое имя метрики у разных арендаторов
+	values := map[string]float64{tenant.Default: 1, "team-a": 2, "team-b": 3}
+	for owner, value := range values {
+		require.NoError(t, manager.Upsert(owners[owner], gauge("cpu", value)))
+	}
+
+	// Counter арендатора накапливается из значения в базе данных
+	require.NoError(t, manager.Upsert(owners["team-a"], counter("requests", 5)))
+	require.NoError(t, manager.Upsert(owners["team-a"], counter("requests", 5)))
+
+	require.NoError(t, manager.UpsertBatch(owners["team-b"], []metricPkg.Metric{gauge("memory", 4), counter("requests", 7)}))
+
+	// '_' в идентификаторе арендатора не совпадает с любым символом при подсчете
+	owners["team_c"] = tenant.WithTenant(context.Background(), "team_c")
+	owners["teamxc"] = tenant.WithTenant(context.Background(), "teamxc")
+	require.NoError(t, manager.Upsert(owners["team_c"], gauge("cpu", 6)))
+	require.NoError(t, manager.Upsert(owners["teamxc"], gauge("cpu", 7)))
+
+	// Имя максимальной длины помещается в столбец id вместе с пространством имен
+	long := strings.Repeat("a", metricPkg.DefaultNameMaxLen)
+	require.NoError(t, manager.Upsert(owners["team-a"], gauge(long, 5)))
+
+	t.Run("Same name in every tenant", func(t *testing.T) {
+		for owner, value := range values {
+			got, err := manager.Get(owners[owner], metricPkg.Metric{ID: "cpu", MType: metricPkg.GaugeType})
+			require.NoError(t, err)
+			require.Equal(t, "cpu", got.ID)
+			require.Equal(t, value, *got.Value)
+		}
+	})
+
+	t.Run("Counter accumulated per tenant", func(t *testing.T) {
+		got, err := manager.Get(owners["team-a"], metricPkg.Metric{ID: "requests", MType: metricPkg.CounterType})
+		require.NoError(t, err)
+		require.Equal(t, int64(10), *got.Delta)
+
+		got, err = manager.Get(owners["team-b"], metricPkg.Metric{ID: "requests", MType: metricPkg.CounterType})
+		require.NoError(t, err)
+		require.Equal(t, int64(7), *got.Delta)
+
+		_, err = manager.Get(owners[tenant.Default], metricPkg.Metric{ID: "requests", MType: metricPkg.CounterType})
+		require.ErrorIs(t, err, errs.ErrNotFound)
+	})
+
+	t.Run("Long name", func(t *testing.T) {
+		got, err := manager.Get(owners["team-a"], metricPkg.Metric{ID: long, MType: metricPkg.GaugeType})
+		require.NoError(t, err)
+		require.Equal(t, 5.0, *got.Value)
+	})
+
+	t.Run("Metrics by type of tenant", func(t *testing.T) {
+		metrics, err := manager.GetByType(owners["team-b"], metricPkg.GaugeType)
+		require.NoError(t, err)
+		require.Len(t, metrics, 2)
+		require.Equal(t, "cpu", metrics[0].ID)
+		require.Equal(t, "memory", metrics[1].ID)
+
+		count, err := manager.Count(owners["team-a"], metricPkg.GaugeType)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		count, err = manager.Count(owners[tenant.Default], metricPkg.GaugeType)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+
+		count, err = manager.Count(owners["team_c"], metricPkg.GaugeType)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"metrics-and-alerting/internal/storage/dbstore"
+	"metrics-and-alerting/internal/storage/filestorage"
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+)
+
+// Виды хранилища метрик
+const (
+	KindDatabase = "Database"
+	KindFile     = "File"
+	KindMemory   = "Memory"
+)
+
+// Все хранилища метрик реализуют Repository
+var (
+	_ Repository = (*memstore.Storage)(nil)
+	_ Repository = (*filestorage.Storage)(nil)
+	_ Repository = (*dbstore.Storage)(nil)
+)
+
+// Config Параметры выбора хранилища метрик
+type Config struct {
+	DatabaseDSN  string
+	StoreFile    string
+	StoreFormat  string // формат файла с метриками, по умолчанию filestorage.FormatJSONL
+	StoreBackups int    // количество резервных копий файла с метриками
+
+	DBMaxOpenConns    int           // максимальное количество открытых соединений с базой данных, 0 - не ограничено
+	DBMaxIdleConns    int           // максимальное количество простаивающих соединений с базой данных
+	DBConnMaxLifetime time.Duration // время жизни соединения с базой данных, 0 - не ограничено
+
+	EnableReadCache bool          // кэш чтения перед базой данных
+	ReadCacheTTL    time.Duration // время жизни метрики в кэше чтения, по умолчанию DefaultReadCacheTTL
+}
+
+// Kind Вид хранилища, выбранный по параметрам
+func (cfg Config) Kind() (string, error) {
+
+	switch {
+	case len(cfg.DatabaseDSN) != 0 && len(cfg.StoreFile) != 0:
+		return ``, fmt.Errorf("%w: database DSN and store file are both set, use only one", errs.ErrStorageConflict)
+
+	case len(cfg.DatabaseDSN) != 0:
+		return KindDatabase, nil
+
+	case len(cfg.StoreFile) != 0:
+		return KindFile, nil
+
+	default:
+		return KindMemory, nil
+	}
+}
+
+// New Создание хранилища метрик по параметрам:
+// база данных, если задан DatabaseDSN, файл, если задан StoreFile, иначе память.
+// При EnableReadCache перед базой данных добавляется кэш чтения ReadCache
+func New(cfg Config, logger *logpack.LogPack) (Repository, error) {
+
+	kind, err := cfg.Kind()
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case KindDatabase:
+		db, err := dbstore.New(cfg.DatabaseDSN, logger,
+			dbstore.WithPool(cfg.DBMaxOpenConns, cfg.DBMaxIdleConns, cfg.DBConnMaxLifetime))
+		if err != nil {
+			return nil, fmt.Errorf("could not create database storage: %w", err)
+		}
+
+		if cfg.EnableReadCache {
+			return NewReadCache(db, cfg.ReadCacheTTL), nil
+		}
+
+		return db, nil
+
+	case KindFile:
+		opts := make([]filestorage.OptionsStorage, 0, 2)
+		if len(cfg.StoreFormat) != 0 {
+			opts = append(opts, filestorage.WithFormat(cfg.StoreFormat))
+		}
+
+		if cfg.StoreBackups > 0 {
+			opts = append(opts, filestorage.WithBackups(cfg.StoreBackups))
+		}
+
+		return filestorage.New(cfg.StoreFile, logger, opts...), nil
+
+	default:
+		return memstore.New(), nil
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"metrics-and-alerting/internal/storage/filestorage"
+	"metrics-and-alerting/internal/storage/memstore"
+	"metrics-and-alerting/pkg/errs"
+	"metrics-and-alerting/pkg/logpack"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+	unreachableDSN := "host=127.0.0.1 port=1 user=user dbname=metrics sslmode=disable connect_timeout=1"
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		wantType interface{}
+		wantErr  error
+	}{
+		{
+			name:     "Nothing set -> memory",
+			cfg:      Config{},
+			wantType: &memstore.Storage{},
+		},
+		{
+			name:     "Store file set -> file",
+			cfg:      Config{StoreFile: fileName},
+			wantType: &filestorage.Storage{},
+		},
+		{
+			name: "Database DSN set -> database",
+			cfg:  Config{DatabaseDSN: unreachableDSN},
+		},
+		{
+			name:    "Database DSN and store file set -> ERROR",
+			cfg:     Config{DatabaseDSN: unreachableDSN, StoreFile: fileName},
+			wantErr: errs.ErrStorageConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			store, err := New(tt.cfg, logger)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Nil(t, store)
+				return
+			}
+
+			if tt.wantType == nil {
+				// База данных недоступна: ошибка должна прийти из хранилища базы данных
+				require.ErrorContains(t, err, "could not create database storage")
+				return
+			}
+
+			require.NoError(t, err)
+			require.IsType(t, tt.wantType, store)
+		})
+	}
+}
@@ -2,10 +2,16 @@ package filestorage
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"metrics-and-alerting/internal/storage/memstore"
 	"metrics-and-alerting/pkg/errs"
@@ -13,24 +19,74 @@ import (
 	metricPkg "metrics-and-alerting/pkg/metric"
 )
 
+// maxLineSize Максимальный размер строки файла с метриками
+const maxLineSize = 64 * 1024 * 1024
+
+// Форматы файла с метриками
+const (
+	FormatJSONL = "jsonl" // метрика в формате JSON на каждой строке
+	FormatJSON  = "json"  // один JSON массив метрик с отступами
+)
+
+type OptionsStorage func(*Storage)
+
+// record Запись файла с метриками: метрика и время ее последнего обновления на сервере.
+// Время обновления не входит в JSON метрики, поэтому сохраняется отдельным полем
+type record struct {
+	metricPkg.Metric
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+}
+
 type Storage struct {
+	mu       sync.Mutex // сериализация чтения и записи файла
+	closed   bool
 	fileName string
+	format   string
+	backups  int // количество резервных копий файла <файл>.1 ... <файл>.N
 	logger   *logpack.LogPack
 	memory   *memstore.Storage
 }
 
-func New(fileName string, logger *logpack.LogPack) *Storage {
+func New(fileName string, logger *logpack.LogPack, opts ...OptionsStorage) *Storage {
 
 	store := &Storage{
 		fileName: fileName,
+		format:   FormatJSONL,
 		logger:   logger,
 		memory:   memstore.New(),
 	}
 
+	for _, opt := range opts {
+		opt(store)
+	}
+
 	return store
 }
 
-func (store Storage) open(flag int) (*os.File, error) {
+// WithFormat Формат, в котором метрики сохраняются в файл.
+// При загрузке формат определяется по содержимому файла
+func WithFormat(format string) OptionsStorage {
+	return func(store *Storage) {
+		store.format = format
+	}
+}
+
+// WithBackups Количество резервных копий файла с метриками.
+// Перед каждым сохранением текущий файл становится копией <файл>.1,
+// предыдущие копии сдвигаются на один номер, а копия с номером больше count удаляется.
+// 0 - резервные копии не создаются
+func WithBackups(count int) OptionsStorage {
+	return func(store *Storage) {
+		store.backups = count
+	}
+}
+
+// ValidFormat Проверка, что формат файла поддерживается
+func ValidFormat(format string) bool {
+	return format == FormatJSONL || format == FormatJSON
+}
+
+func (store *Storage) open(flag int) (*os.File, error) {
 	if len(store.fileName) < 1 {
 		return nil, errs.ErrInvalidFilePath
 	}
@@ -38,109 +94,465 @@ func (store Storage) open(flag int) (*os.File, error) {
 	return os.OpenFile(store.fileName, flag, 0777)
 }
 
-func (store Storage) Flush() error {
+// Flush Сохранение метрик в файл.
+// Данные записываются во временный файл рядом с основным, который затем переименовывается,
+// поэтому при сбое во время записи предыдущий файл остается целым.
+// Если заданы резервные копии, перед заменой основного файла выполняется их ротация
+func (store *Storage) Flush(ctx context.Context) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
-	file, errFile := store.open(os.O_CREATE | os.O_WRONLY | os.O_TRUNC)
-	if errFile != nil {
-		return fmt.Errorf("error open fileStorage fo rewrite: %w", errFile)
+	if len(store.fileName) < 1 {
+		return errs.ErrInvalidFilePath
 	}
 
-	defer func() {
-		if err := file.Close(); err != nil {
-			store.logger.Err.Printf("Could not close file after flush: %v\n", err)
-		}
-	}()
-
-	writer := bufio.NewWriter(file)
-	metrics, errMemory := store.memory.GetBatch()
+	metrics, errMemory := store.memory.GetBatch(ctx)
 	if errMemory != nil {
 		return fmt.Errorf("could not save metrics. Memory storage returned error: %w", errMemory)
 	}
 
-	data, errEncode := json.Marshal(&metrics)
+	data, errEncode := store.encode(encode(metrics))
 	if errEncode != nil {
 		return fmt.Errorf("could not save metrics. Marshal slice metrics retured error: %w", errEncode)
 	}
 
+	if err := store.replaceFile(ctx, data, true); err != nil {
+		return fmt.Errorf("could not save metrics: %w", err)
+	}
+
+	return nil
+}
+
+// Compact Перезапись файла, в которой из повторов метрики с одним типом, ID и метками
+// остается последняя запись. Файл заменяется так же атомарно, как при Flush.
+// Метрики в памяти не изменяются: в них повторов нет, а несохраненные изменения
+// записываются в файл вызовом Flush
+func (store *Storage) Compact(ctx context.Context) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	records, err := store.readFile(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not compact metrics: %w", err)
+	}
+
+	unique := dedupe(records)
+	if len(unique) == len(records) {
+		return nil
+	}
+
+	data, err := store.encode(unique)
+	if err != nil {
+		return fmt.Errorf("could not compact metrics. Marshal slice metrics retured error: %w", err)
+	}
+
+	if err := store.replaceFile(ctx, data, false); err != nil {
+		return fmt.Errorf("could not compact metrics: %w", err)
+	}
+
+	store.logger.Ctx(ctx).Info.Printf("Compacted store file %s: removed %d duplicates\n",
+		store.fileName, len(records)-len(unique))
+
+	return nil
+}
+
+// replaceFile Атомарная замена файла данными data через временный файл рядом с ним.
+// С rotate перед заменой выполняется ротация резервных копий
+func (store *Storage) replaceFile(ctx context.Context, data []byte, rotate bool) error {
+
+	file, errFile := os.CreateTemp(filepath.Dir(store.fileName), filepath.Base(store.fileName)+".tmp*")
+	if errFile != nil {
+		return fmt.Errorf("can not create temporary file: %w", errFile)
+	}
+
+	removeTemp := func() {
+		if err := os.Remove(file.Name()); err != nil {
+			store.logger.Ctx(ctx).Err.Printf("Could not remove temporary file %s: %v\n", file.Name(), err)
+		}
+	}
+
+	if errWrite := store.writeFile(file, data); errWrite != nil {
+		removeTemp()
+		return errWrite
+	}
+
+	if rotate {
+		// Резервная копия не должна мешать сохранению, поэтому ошибка только логируется
+		if errRotate := store.rotate(); errRotate != nil {
+			store.logger.Ctx(ctx).Err.Printf("Could not rotate store file backups: %v\n", errRotate)
+		}
+	}
+
+	if errRename := os.Rename(file.Name(), store.fileName); errRename != nil {
+		removeTemp()
+		return fmt.Errorf("can not replace file: %w", errRename)
+	}
+
+	return nil
+}
+
+// dedupe Записи без повторов: для каждого типа, ID и меток остается последняя запись
+// на месте первой
+func dedupe(records []record) []record {
+
+	unique := make([]record, 0, len(records))
+	index := make(map[string]int, len(records))
+
+	for _, r := range records {
+		if idx, ok := index[r.Key()]; ok {
+			unique[idx] = r
+			continue
+		}
+
+		index[r.Key()] = len(unique)
+		unique = append(unique, r)
+	}
+
+	return unique
+}
+
+// backupName Имя резервной копии файла с номером n
+func (store *Storage) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", store.fileName, n)
+}
+
+// rotate Сдвиг резервных копий на один номер и копирование текущего файла в <файл>.1.
+// Копия с номером backups удаляется. Основной файл остается на месте до замены новым
+func (store *Storage) rotate() error {
+
+	if store.backups <= 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(store.fileName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := os.Remove(store.backupName(store.backups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for n := store.backups - 1; n >= 1; n-- {
+		if err := os.Rename(store.backupName(n), store.backupName(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	// Жесткая ссылка сохраняет содержимое текущего файла после его замены переименованием
+	if err := os.Link(store.fileName, store.backupName(1)); err == nil {
+		return nil
+	}
+
+	return copyFile(store.fileName, store.backupName(1))
+}
+
+// copyFile Копирование файла src в dst
+func copyFile(src, dst string) error {
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, 0777)
+}
+
+// encode Записи файла для метрик
+func encode(metrics []metricPkg.Metric) []record {
+
+	records := make([]record, len(metrics))
+	for i, metric := range metrics {
+		records[i].Metric = metric
+		if !metric.LastUpdate.IsZero() {
+			lastUpdate := metric.LastUpdate
+			records[i].LastUpdate = &lastUpdate
+		}
+	}
+
+	return records
+}
+
+// encode Преобразование записей в содержимое файла в формате хранилища
+func (store *Storage) encode(records []record) ([]byte, error) {
+
+	if store.format == FormatJSON {
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return append(data, '\n'), nil
+	}
+
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decode Метрики из записей файла. Метрикам без времени обновления, например из файлов
+// предыдущих версий, назначается время загрузки loaded, чтобы они могли устареть
+func decode(records []record, loaded time.Time) []metricPkg.Metric {
+
+	metrics := make([]metricPkg.Metric, len(records))
+	for i, r := range records {
+		metrics[i] = r.Metric
+		metrics[i].LastUpdate = loaded
+		if r.LastUpdate != nil {
+			metrics[i].LastUpdate = *r.LastUpdate
+		}
+	}
+
+	return metrics
+}
+
+// writeFile Запись данных в файл, сброс их на диск и закрытие файла.
+// Без сброса на диск после переименования файл может оказаться пустым при сбое питания
+func (store *Storage) writeFile(file *os.File, data []byte) error {
+
+	writer := bufio.NewWriter(file)
+
 	if _, errWrite := writer.Write(data); errWrite != nil {
-		return fmt.Errorf("could not save metrics. Can not write in file: %w", errWrite)
+		_ = file.Close()
+		return fmt.Errorf("can not write in file: %w", errWrite)
+	}
+
+	if errFlush := writer.Flush(); errFlush != nil {
+		_ = file.Close()
+		return fmt.Errorf("can not write in file: %w", errFlush)
+	}
+
+	if errChmod := file.Chmod(0777); errChmod != nil {
+		_ = file.Close()
+		return fmt.Errorf("can not change file mode: %w", errChmod)
 	}
 
-	return writer.Flush()
+	if errSync := file.Sync(); errSync != nil {
+		_ = file.Close()
+		return fmt.Errorf("can not sync file: %w", errSync)
+	}
+
+	return file.Close()
 }
 
-func (store *Storage) Restore() error {
+// Restore Загрузка метрик из файла.
+// Формат определяется по первому значащему символу: '[' - JSON массив, иначе JSONL.
+// Файлы, в которых каждая строка содержит массив метрик, также загружаются построчно.
+// Строки, которые не удалось разобрать, пропускаются.
+// Если файла еще нет, хранилище остается пустым.
+// Из повторов метрики в файле загружается последняя запись.
+//
+// Загруженные метрики сливаются с метриками в памяти: delta counter прибавляется
+// к уже накопленному значению, чтобы не потерять приращения, принятые до загрузки,
+// gauge и гистограммы заменяются значениями из файла.
+// Поэтому повторный вызов Restore для того же файла увеличит counter повторно
+func (store *Storage) Restore(ctx context.Context) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	records, err := store.readFile(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		store.logger.Ctx(ctx).Info.Printf("Store file %s not found, starting with empty storage\n", store.fileName)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not restore metrics: %w", err)
+	}
+
+	if err := store.memory.Merge(ctx, decode(dedupe(records), time.Now())); err != nil {
+		return fmt.Errorf("could not restore metrics. Can not write in memory storage: %w", err)
+	}
+
+	return nil
+}
+
+// readFile Чтение всех записей файла в порядке следования, включая повторы
+func (store *Storage) readFile(ctx context.Context) ([]record, error) {
 
 	file, err := store.open(os.O_RDONLY)
 	if err != nil {
-		return fmt.Errorf("could not restore metrics. Can not open file for read: %w", err)
+		return nil, fmt.Errorf("can not open file for read: %w", err)
 	}
 
 	defer func() {
 		if err := file.Close(); err != nil {
-			store.logger.Err.Printf("Could not close file after restore: %v\n", err)
+			store.logger.Ctx(ctx).Err.Printf("Could not close file after read: %v\n", err)
 		}
 	}()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		data := scanner.Bytes()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("can not read file: %w", err)
+	}
+
+	content := bytes.TrimSpace(data)
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	if content[0] == '[' {
+		var records []record
+		if err := json.Unmarshal(content, &records); err == nil {
+			return records, nil
+		}
+	}
+
+	return store.readLines(ctx, data)
+}
+
+// readLines Чтение файла, в котором каждая строка содержит метрику или массив метрик
+func (store *Storage) readLines(ctx context.Context, data []byte) ([]record, error) {
 
-		var metrics []metricPkg.Metric
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
 
-		if err := json.Unmarshal(data, &metrics); err != nil {
-			return fmt.Errorf("could not restore metrics. Can not Unmarshal from file: %w", err)
+	records := make([]record, 0)
+
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		if data[0] == '[' {
+			var batch []record
+			if err := json.Unmarshal(data, &batch); err != nil {
+				store.logger.Ctx(ctx).Warn.Printf("Skip malformed line %d in file %s: %v\n", line, store.fileName, err)
+				continue
+			}
+
+			records = append(records, batch...)
+			continue
 		}
 
-		if err := store.memory.UpsertBatch(metrics); err != nil {
-			return fmt.Errorf("could not restore metrics. Can not write in memory storage: %w", err)
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			store.logger.Ctx(ctx).Warn.Printf("Skip malformed line %d in file %s: %v\n", line, store.fileName, err)
+			continue
 		}
+
+		records = append(records, r)
 	}
 
-	return nil
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can not read file: %w", err)
+	}
+
+	return records, nil
 }
 
-func (store *Storage) Upsert(metric metricPkg.Metric) error {
+func (store *Storage) Upsert(ctx context.Context, metric metricPkg.Metric) error {
 
-	if err := store.memory.Upsert(metric); err != nil {
+	if err := store.memory.Upsert(ctx, metric); err != nil {
 		return fmt.Errorf("could not upsert metric: %w", err)
 	}
 
 	return nil
 }
 
-func (store *Storage) UpsertBatch(metrics []metricPkg.Metric) error {
+func (store *Storage) UpsertBatch(ctx context.Context, metrics []metricPkg.Metric) error {
 
-	if err := store.memory.UpsertBatch(metrics); err != nil {
+	if err := store.memory.UpsertBatch(ctx, metrics); err != nil {
 		return fmt.Errorf("error update batch metrics in file storage: %w", err)
 	}
 
 	return nil
 }
 
-func (store Storage) Get(metric metricPkg.Metric) (metricPkg.Metric, error) {
-	return store.memory.Get(metric)
+func (store *Storage) Get(ctx context.Context, metric metricPkg.Metric) (metricPkg.Metric, error) {
+	return store.memory.Get(ctx, metric)
+}
+
+func (store *Storage) GetBatch(ctx context.Context) ([]metricPkg.Metric, error) {
+	return store.memory.GetBatch(ctx)
+}
+
+// GetByType Получение метрик типа typeMetric, отсортированных по ID
+func (store *Storage) GetByType(ctx context.Context, typeMetric string) ([]metricPkg.Metric, error) {
+	return store.memory.GetByType(ctx, typeMetric)
+}
+
+// Reset Сброс значения counter в ноль
+func (store *Storage) Reset(ctx context.Context, metric metricPkg.Metric) error {
+	return store.memory.Reset(ctx, metric)
 }
 
-func (store Storage) GetBatch() ([]metricPkg.Metric, error) {
-	return store.memory.GetBatch()
+// Count Количество метрик типа typeMetric
+func (store *Storage) Count(ctx context.Context, typeMetric string) (int, error) {
+	return store.memory.Count(ctx, typeMetric)
+}
+
+// CountTenant Количество метрик типа typeMetric арендатора owner
+func (store *Storage) CountTenant(ctx context.Context, owner, typeMetric string) (int, error) {
+	return store.memory.CountTenant(ctx, owner, typeMetric)
 }
 
 // Delete - Удаление метрики
-func (store *Storage) Delete(metric metricPkg.Metric) error {
+func (store *Storage) Delete(ctx context.Context, metric metricPkg.Metric) error {
 
-	if err := store.memory.Delete(metric); err != nil {
+	if err := store.memory.Delete(ctx, metric); err != nil {
 		return fmt.Errorf("could not delete metric: %w", err)
 	}
 
 	return nil
 }
 
+// DeleteWhere Удаление всех метрик, для которых match возвращает true
+func (store *Storage) DeleteWhere(ctx context.Context, match func(metricPkg.Metric) bool) (int, error) {
+
+	deleted, err := store.memory.DeleteWhere(ctx, match)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete metrics: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// Rename Переименование метрики с сохранением типа, меток и значения
+func (store *Storage) Rename(ctx context.Context, metric metricPkg.Metric, newID string) error {
+
+	if err := store.memory.Rename(ctx, metric, newID); err != nil {
+		return fmt.Errorf("could not rename metric: %w", err)
+	}
+
+	return nil
+}
+
 func (store *Storage) Health() bool {
 	_, err := os.Stat(store.fileName)
 	return !errors.Is(err, os.ErrNotExist)
 }
 
+// Close Сохранение метрик в файл перед завершением работы.
+// Повторный вызов Close ничего не делает
 func (store *Storage) Close() error {
+	store.mu.Lock()
+	closed := store.closed
+	store.closed = true
+	store.mu.Unlock()
+
+	if closed || len(store.fileName) == 0 {
+		return nil
+	}
+
+	if err := store.Flush(context.Background()); err != nil {
+		return fmt.Errorf("could not close file storage: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,368 @@
+package filestorage
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-and-alerting/pkg/logpack"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorage_FlushKeepsPreviousFile Неудачное сохранение не должно портить ранее записанный файл
+func TestStorage_FlushKeepsPreviousFile(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+
+	store := New(fileName, logger)
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+	require.NoError(t, store.Flush(context.Background()))
+
+	saved, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+
+	// NaN не кодируется в JSON, поэтому сохранение завершится ошибкой
+	broken, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "brokenGauge", metricPkg.WithValueFloat(math.NaN()))
+	require.NoError(t, store.Upsert(context.Background(), broken))
+	require.Error(t, store.Flush(context.Background()))
+
+	current, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, saved, current)
+
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+// TestStorage_FlushRenameFails Если файл не удалось заменить, временный файл удаляется
+func TestStorage_FlushRenameFails(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	// Каталог с файлом нельзя заменить файлом
+	require.NoError(t, os.Mkdir(fileName, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(fileName, "keep"), nil, 0644))
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+
+	store := New(fileName, logpack.NewLogger())
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+	require.Error(t, store.Flush(context.Background()))
+
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "metrics.json", entries[0].Name())
+}
+
+// TestStorage_Backups Перед сохранением текущий файл становится копией .1,
+// копии сдвигаются, а копия сверх заданного количества удаляется
+func TestStorage_Backups(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	store := New(fileName, logger, WithBackups(2))
+
+	saves := make([][]byte, 0, 4)
+	for i := 1; i <= 4; i++ {
+		gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueInt(int64(i)))
+		require.NoError(t, store.Upsert(context.Background(), gauge))
+		require.NoError(t, store.Flush(context.Background()))
+
+		data, err := os.ReadFile(fileName)
+		require.NoError(t, err)
+		saves = append(saves, data)
+	}
+
+	backup1, err := os.ReadFile(fileName + ".1")
+	require.NoError(t, err)
+	require.Equal(t, saves[2], backup1)
+
+	backup2, err := os.ReadFile(fileName + ".2")
+	require.NoError(t, err)
+	require.Equal(t, saves[1], backup2)
+
+	_, err = os.Stat(fileName + ".3")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Загрузка выполняется из основного файла
+	restored := New(fileName, logger, WithBackups(2))
+	require.NoError(t, restored.Restore(context.Background()))
+
+	gauge, err := restored.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, float64(4), *gauge.Value)
+}
+
+// TestStorage_NoBackups Без резервных копий рядом с файлом не появляется других файлов
+func TestStorage_NoBackups(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	store := New(fileName, logpack.NewLogger())
+	require.NoError(t, store.Flush(context.Background()))
+	require.NoError(t, store.Flush(context.Background()))
+
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+// TestStorage_RestoreSkipsMalformedLines Строки с ошибками пропускаются при загрузке
+func TestStorage_RestoreSkipsMalformedLines(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	data := `[{"id":"testGauge","type":"gauge","value":1.5}]
+[{"id":"brokenGauge","type":"gauge","val
+[{"id":"testCounter","type":"counter","delta":10}]
+`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0666))
+
+	store := New(fileName, logger)
+	require.NoError(t, store.Restore(context.Background()))
+
+	metrics, err := store.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+}
+
+// TestStorage_RestoreMergesCounters Counter из файла прибавляется к значению в памяти, gauge заменяется
+func TestStorage_RestoreMergesCounters(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	data := `[{"id":"testCounter","type":"counter","delta":10},{"id":"testGauge","type":"gauge","value":1.5}]`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0666))
+
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(5))
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(7))
+
+	store := New(fileName, logger)
+	require.NoError(t, store.Upsert(context.Background(), counter))
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+	require.NoError(t, store.Restore(context.Background()))
+
+	restored, err := store.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+	require.NoError(t, err)
+	require.Equal(t, int64(15), *restored.Delta)
+
+	restored, err = store.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *restored.Value)
+
+	// Значение, переданное в Upsert, не должно изменяться при слиянии
+	require.Equal(t, int64(5), *counter.Delta)
+}
+
+// TestStorage_RestoreMissingFile Отсутствие файла не является ошибкой загрузки
+func TestStorage_Compact(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	data := `{"id":"testGauge","type":"gauge","value":1}
+{"id":"testCounter","type":"counter","delta":10}
+{"id":"testGauge","type":"gauge","value":2,"labels":{"host":"a"}}
+{"id":"testGauge","type":"gauge","value":3}
+{"id":"testCounter","type":"counter","delta":25}
+`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0666))
+
+	store := New(fileName, logger)
+	require.NoError(t, store.Restore(context.Background()))
+
+	// Повторы counter в файле не суммируются: загружается последнее значение
+	counter, err := store.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+	require.NoError(t, err)
+	require.Equal(t, int64(25), *counter.Delta)
+
+	require.NoError(t, store.Compact(context.Background()))
+
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 3)
+
+	compacted := New(fileName, logger)
+	require.NoError(t, compacted.Restore(context.Background()))
+
+	metrics, err := compacted.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+
+	gauge, err := compacted.Get(context.Background(), metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, float64(3), *gauge.Value)
+
+	labeled, err := compacted.Get(context.Background(),
+		metricPkg.Metric{ID: "testGauge", MType: metricPkg.GaugeType, Labels: metricPkg.Labels{"host": "a"}})
+	require.NoError(t, err)
+	require.Equal(t, float64(2), *labeled.Value)
+
+	counter, err = compacted.Get(context.Background(), metricPkg.Metric{ID: "testCounter", MType: metricPkg.CounterType})
+	require.NoError(t, err)
+	require.Equal(t, int64(25), *counter.Delta)
+
+	// Файл без повторов не перезаписывается
+	before, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.NoError(t, compacted.Compact(context.Background()))
+
+	after, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(before, after))
+}
+
+func TestStorage_RestoreMissingFile(t *testing.T) {
+
+	store := New(filepath.Join(t.TempDir(), "metrics.json"), logpack.NewLogger())
+	require.NoError(t, store.Restore(context.Background()))
+
+	metrics, err := store.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, metrics)
+}
+
+// TestStorage_Format Сохранение и загрузка метрик в каждом формате, в том числе загрузка файла другого формата
+func TestStorage_Format(t *testing.T) {
+
+	logger := logpack.NewLogger()
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	counter, _ := metricPkg.CreateMetric(metricPkg.CounterType, "testCounter", metricPkg.WithValueInt(10))
+
+	// Время обновления сохраняется в файле вместе с метрикой
+	gauge.LastUpdate = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter.LastUpdate = time.Date(2022, 1, 1, 0, 0, 30, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		saveFormat    string
+		restoreFormat string
+		wantPrefix    string
+	}{
+		{
+			name:          "JSONL",
+			saveFormat:    FormatJSONL,
+			restoreFormat: FormatJSONL,
+			wantPrefix:    "{",
+		},
+		{
+			name:          "JSON",
+			saveFormat:    FormatJSON,
+			restoreFormat: FormatJSON,
+			wantPrefix:    "[\n  {",
+		},
+		{
+			name:          "JSON file restored by JSONL storage",
+			saveFormat:    FormatJSON,
+			restoreFormat: FormatJSONL,
+			wantPrefix:    "[\n  {",
+		},
+		{
+			name:          "JSONL file restored by JSON storage",
+			saveFormat:    FormatJSONL,
+			restoreFormat: FormatJSON,
+			wantPrefix:    "{",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+			store := New(fileName, logger, WithFormat(tt.saveFormat))
+			require.NoError(t, store.UpsertBatch(context.Background(), []metricPkg.Metric{gauge, counter}))
+			require.NoError(t, store.Flush(context.Background()))
+
+			data, err := os.ReadFile(fileName)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(string(data), tt.wantPrefix), string(data))
+
+			restored := New(fileName, logger, WithFormat(tt.restoreFormat))
+			require.NoError(t, restored.Restore(context.Background()))
+
+			metrics, err := restored.GetBatch(context.Background())
+			require.NoError(t, err)
+			require.ElementsMatch(t, []metricPkg.Metric{gauge, counter}, metrics)
+		})
+	}
+}
+
+// TestStorage_RestoreLastUpdate Метрикам из файла без времени обновления назначается время загрузки
+func TestStorage_RestoreLastUpdate(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	data := `{"id":"legacy","type":"gauge","value":1}
+{"id":"saved","type":"gauge","value":2,"last_update":"2022-01-01T00:00:00Z"}
+`
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0666))
+
+	store := New(fileName, logpack.NewLogger())
+
+	before := time.Now()
+	require.NoError(t, store.Restore(context.Background()))
+
+	legacy, err := store.Get(context.Background(), metricPkg.Metric{ID: "legacy", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.False(t, legacy.LastUpdate.Before(before))
+
+	saved, err := store.Get(context.Background(), metricPkg.Metric{ID: "saved", MType: metricPkg.GaugeType})
+	require.NoError(t, err)
+	require.True(t, saved.LastUpdate.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+// TestStorage_Close Метрики сохраняются при закрытии, повторное закрытие ничего не делает
+func TestStorage_Close(t *testing.T) {
+
+	logger := logpack.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+
+	store := New(fileName, logger)
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+	require.NoError(t, store.Close())
+
+	restored := New(fileName, logger)
+	require.NoError(t, restored.Restore(context.Background()))
+
+	got, err := restored.Get(context.Background(), gauge)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *got.Value)
+
+	require.NoError(t, os.Remove(fileName))
+	require.NoError(t, store.Close())
+
+	_, err = os.Stat(fileName)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestStorage_CloseError Ошибка сохранения при закрытии возвращается вызывающему
+func TestStorage_CloseError(t *testing.T) {
+
+	store := New(filepath.Join(t.TempDir(), "missing", "metrics.json"), logpack.NewLogger())
+
+	gauge, _ := metricPkg.CreateMetric(metricPkg.GaugeType, "testGauge", metricPkg.WithValueFloat(1.5))
+	require.NoError(t, store.Upsert(context.Background(), gauge))
+
+	require.Error(t, store.Close())
+	require.NoError(t, store.Close())
+}
@@ -1,46 +1,243 @@
 package memstore
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"sync"
 
+	"metrics-and-alerting/internal/tenant"
 	"metrics-and-alerting/pkg/errs"
 	metricPkg "metrics-and-alerting/pkg/metric"
 )
 
+// batchChunkSize Количество метрик набора, обновляемых под одной блокировкой
+const batchChunkSize = 256
+
+// BatchError Ошибка проверки метрики набора: позиция первой некорректной метрики и причина
+type BatchError struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("metric #%d %q: %v", e.Index, e.ID, e.Err)
+}
+
+func (e BatchError) Unwrap() error {
+	return e.Err
+}
+
 type Storage struct {
+	mu      sync.RWMutex
 	metrics []metricPkg.Metric
+	index   map[string]int   // индекс метрики в слайсе по ключу <type>:<id>{labels}
+	counts  map[string]int   // количество метрик по типу
+	owners  map[string]int   // количество метрик по арендатору и типу, ключ ownerKey
+	sorted  map[string][]int // позиции метрик типа в слайсе в порядке GetByType, нет записи - порядок вычисляется заново
 }
 
 func New() *Storage {
 	return &Storage{
 		metrics: make([]metricPkg.Metric, 0),
+		index:   make(map[string]int),
+		counts:  make(map[string]int),
+		owners:  make(map[string]int),
+		sorted:  make(map[string][]int),
 	}
 }
 
+// ownerKey Ключ количества метрик типа mType арендатора owner
+func ownerKey(owner, mType string) string {
+	return owner + tenant.Separator + mType
+}
+
+// indexKey Ключ метрики в индексе
+func indexKey(metric metricPkg.Metric) string {
+	return metric.Key()
+}
+
 // Find - Поиск метрики в слайсе
 // Возвращается индекс метрики в слайсе и ошибку, если такой метрики не существует
-func (store Storage) Find(mSeek metricPkg.Metric) (int, error) {
+func (store *Storage) Find(mSeek metricPkg.Metric) (int, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
 
-	for i, m := range store.metrics {
-		if m.MType == mSeek.MType && m.ID == mSeek.ID {
-			return i, nil
-		}
+	return store.find(indexKey(mSeek))
+}
+
+// find - Поиск метрики в слайсе по ключу индекса без блокировки
+func (store *Storage) find(key string) (int, error) {
+
+	if idx, ok := store.index[key]; ok {
+		return idx, nil
 	}
 
 	return -1, errs.ErrNotFound
 }
 
+// reindex Перестроение индекса для метрик, начиная с позиции from
+func (store *Storage) reindex(from int) {
+
+	if store.index == nil {
+		store.index = make(map[string]int, len(store.metrics))
+	}
+
+	for i := from; i < len(store.metrics); i++ {
+		store.index[indexKey(store.metrics[i])] = i
+	}
+}
+
+// added Учет добавленной метрики в количестве метрик типа и арендатора и сброс порядка метрик типа
+func (store *Storage) added(metric metricPkg.Metric) {
+
+	if store.counts == nil {
+		store.counts = make(map[string]int)
+	}
+	if store.owners == nil {
+		store.owners = make(map[string]int)
+	}
+
+	store.counts[metric.MType]++
+	store.owners[ownerKey(tenant.Owner(metric.ID), metric.MType)]++
+	delete(store.sorted, metric.MType)
+}
+
+// removed Учет удаленной метрики в количестве метрик типа и арендатора.
+// Позиции метрик в слайсе после удаления сдвигаются, поэтому сбрасывается порядок метрик всех типов
+func (store *Storage) removed(metric metricPkg.Metric) {
+
+	if store.counts[metric.MType]--; store.counts[metric.MType] <= 0 {
+		delete(store.counts, metric.MType)
+	}
+
+	key := ownerKey(tenant.Owner(metric.ID), metric.MType)
+	if store.owners[key]--; store.owners[key] <= 0 {
+		delete(store.owners, key)
+	}
+
+	store.sorted = make(map[string][]int)
+}
+
+// recount Пересчет количества метрик по типам и арендаторам и сброс порядка метрик всех типов
+func (store *Storage) recount() {
+
+	store.counts = make(map[string]int)
+	store.owners = make(map[string]int)
+	store.sorted = make(map[string][]int)
+
+	for _, metric := range store.metrics {
+		store.counts[metric.MType]++
+		store.owners[ownerKey(tenant.Owner(metric.ID), metric.MType)]++
+	}
+}
+
+// sortedPositions Позиции метрик типа typeMetric в слайсе, отсортированные по ID и меткам, без блокировки.
+// Порядок вычисляется заново, только если метрики добавлялись, удалялись или переименовывались
+func (store *Storage) sortedPositions(typeMetric string) []int {
+
+	if positions, ok := store.sorted[typeMetric]; ok {
+		return positions
+	}
+
+	type entry struct {
+		idx    int
+		id     string
+		labels string
+	}
+
+	entries := make([]entry, 0, store.counts[typeMetric])
+	for idx, metric := range store.metrics {
+		if metric.MType == typeMetric {
+			entries = append(entries, entry{idx: idx, id: metric.ID, labels: metric.Labels.String()})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].id != entries[j].id {
+			return entries[i].id < entries[j].id
+		}
+
+		return entries[i].labels < entries[j].labels
+	})
+
+	positions := make([]int, len(entries))
+	for i, e := range entries {
+		positions[i] = e.idx
+	}
+
+	if store.sorted == nil {
+		store.sorted = make(map[string][]int)
+	}
+	store.sorted[typeMetric] = positions
+
+	return positions
+}
+
+// collect Копии метрик на позициях positions без блокировки
+func (store *Storage) collect(positions []int) []metricPkg.Metric {
+
+	metrics := make([]metricPkg.Metric, len(positions))
+	for i, idx := range positions {
+		metrics[i] = store.metrics[idx]
+	}
+
+	return metrics
+}
+
+// checkType Проверка, что метрика с тем же ID и метками не хранится с другим типом
+func (store *Storage) checkType(metric metricPkg.Metric) error {
+
+	other := metric
+	for _, mType := range metricPkg.Types {
+		if mType == metric.MType {
+			continue
+		}
+
+		other.MType = mType
+		if _, ok := store.index[indexKey(other)]; ok {
+			return fmt.Errorf("%w: metric %s is already stored as %s, not %s",
+				errs.ErrTypeMismatch, metric.ID, mType, metric.MType)
+		}
+	}
+
+	return nil
+}
+
 // Upsert Обновление значения метрики, или добавление метрики, если ранее её не существовало
-func (store *Storage) Upsert(metric metricPkg.Metric) error {
+func (store *Storage) Upsert(ctx context.Context, metric metricPkg.Metric) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.upsert(indexKey(metric), metric)
+}
 
-	if idx, err := store.Find(metric); err != nil {
+// upsert Обновление значения метрики с ключом индекса key без блокировки
+func (store *Storage) upsert(key string, metric metricPkg.Metric) error {
+
+	idx, err := store.find(key)
+	if err != nil {
+		if errType := store.checkType(metric); errType != nil {
+			return errType
+		}
+	}
+
+	if metric.MType == metricPkg.HistogramType {
+		return store.upsertHistogram(idx, err == nil, metric)
+	}
+
+	if err != nil {
 		store.metrics = append(store.metrics, metric)
+		store.reindex(len(store.metrics) - 1)
+		store.added(metric)
 	} else {
 
 		store.metrics[idx].Hash = metric.Hash
+		store.metrics[idx].LastUpdate = metric.LastUpdate
 
 		switch metric.MType {
-		case metricPkg.GaugeType:
+		case metricPkg.GaugeType, metricPkg.FloatCounterType:
 			store.metrics[idx].Value = metric.Value
 		case metricPkg.CounterType:
 			store.metrics[idx].Delta = metric.Delta
@@ -50,22 +247,171 @@ func (store *Storage) Upsert(metric metricPkg.Metric) error {
 	return nil
 }
 
-// UpsertBatch Обновление набора метрик
-func (store *Storage) UpsertBatch(metrics []metricPkg.Metric) error {
+// upsertHistogram Обновление гистограммы без блокировки.
+// Наблюдение (Value) учитывается в интервалах хранимой гистограммы,
+// полное состояние (Buckets, Counts, Sum) заменяет хранимое
+func (store *Storage) upsertHistogram(idx int, exists bool, metric metricPkg.Metric) error {
+
+	histogram := metric
+	if exists && metric.Value != nil {
+		histogram = store.metrics[idx]
+	}
+
+	histogram.Hash = metric.Hash
+	histogram.LastUpdate = metric.LastUpdate
+	histogram.Value = nil
 
-	for _, m := range metrics {
-		if err := store.Upsert(m); err != nil {
-			return fmt.Errorf("can not upsert metrics: %w", err)
+	if metric.Value != nil {
+		if err := histogram.Observe(*metric.Value); err != nil {
+			return err
 		}
 	}
 
+	if exists {
+		store.metrics[idx] = histogram
+		return nil
+	}
+
+	store.metrics = append(store.metrics, histogram)
+	store.reindex(len(store.metrics) - 1)
+	store.added(histogram)
+
+	return nil
+}
+
+// UpsertBatch Обновление набора метрик целиком или никак.
+// Сначала под блокировкой чтения проверяются все метрики набора, затем набор применяется
+// частями по batchChunkSize метрик, чтобы чтение не ожидало обновления всего набора.
+// Если какая-то метрика некорректна, возвращается BatchError с ее позицией, а хранилище не изменяется.
+// Только если конкурентный вызов между проверкой и применением сохранит метрику с тем же именем
+// и другим типом, части набора, примененные ранее, останутся в хранилище.
+// Подпись метрик проверяется до обращения к хранилищу, а значения counter, повторяющиеся
+// в наборе, накапливаются в MetricsManager: хранилище сохраняет переданное значение
+func (store *Storage) UpsertBatch(ctx context.Context, metrics []metricPkg.Metric) error {
+
+	store.mu.RLock()
+	err := store.validateBatch(metrics)
+	store.mu.RUnlock()
+
+	if err != nil {
+		return fmt.Errorf("can not upsert metrics: %w", err)
+	}
+
+	if err := store.applyBatch(metrics, store.upsert); err != nil {
+		return fmt.Errorf("can not upsert metrics: %w", err)
+	}
+
 	return nil
 }
 
+// validateBatch Проверка всех метрик набора без изменения хранилища:
+// типы и значения, совпадение типа с уже сохраненной метрикой и с другими метриками набора.
+// Вызывающий удерживает блокировку хранилища хотя бы на чтение
+func (store *Storage) validateBatch(metrics []metricPkg.Metric) error {
+
+	types := make(map[string]string, len(metrics)) // тип метрики набора по ID и меткам
+	for i, m := range metrics {
+
+		// Имя проверяется без пространства имен арендатора, с которым метрика хранится
+		named := m
+		named.ID = tenant.Name(m.ID)
+		if err := named.Validate(); err != nil {
+			return BatchError{Index: i, ID: m.ID, Err: err}
+		}
+
+		if err := store.checkType(m); err != nil {
+			return BatchError{Index: i, ID: m.ID, Err: err}
+		}
+
+		name := m.ID + m.Labels.String()
+		if mType, ok := types[name]; ok && mType != m.MType {
+			return BatchError{Index: i, ID: m.ID, Err: fmt.Errorf("%w: metric %s is already in batch as %s, not %s",
+				errs.ErrTypeMismatch, m.ID, mType, m.MType)}
+		}
+
+		types[name] = m.MType
+	}
+
+	return nil
+}
+
+// Merge Слияние набора метрик с хранилищем.
+// Delta counter прибавляется к значению, уже хранимому в памяти,
+// остальные метрики заменяются так же, как в UpsertBatch.
+// Набор применяется частями по batchChunkSize метрик, чтобы чтение
+// не ожидало слияния всего набора. При ошибке части набора, примененные ранее, остаются в хранилище
+func (store *Storage) Merge(ctx context.Context, metrics []metricPkg.Metric) error {
+
+	if err := store.applyBatch(metrics, store.merge); err != nil {
+		return fmt.Errorf("can not merge metrics: %w", err)
+	}
+
+	return nil
+}
+
+// applyBatch Применение набора метрик функцией apply частями по batchChunkSize метрик
+func (store *Storage) applyBatch(metrics []metricPkg.Metric, apply func(key string, metric metricPkg.Metric) error) error {
+
+	keys := make([]string, len(metrics))
+	for i, m := range metrics {
+		keys[i] = indexKey(m)
+	}
+
+	for from := 0; from < len(metrics); from += batchChunkSize {
+		to := from + batchChunkSize
+		if to > len(metrics) {
+			to = len(metrics)
+		}
+
+		if err := store.applyChunk(keys[from:to], metrics[from:to], apply); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyChunk Применение части набора метрик под одной блокировкой
+func (store *Storage) applyChunk(keys []string, metrics []metricPkg.Metric, apply func(key string, metric metricPkg.Metric) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i, m := range metrics {
+		if err := apply(keys[i], m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// merge Слияние метрики с ключом индекса key без блокировки.
+// Значения counter и float_counter суммируются с хранимыми, остальные метрики обновляются как в upsert
+func (store *Storage) merge(key string, metric metricPkg.Metric) error {
+
+	if metric.MType == metricPkg.CounterType && metric.Delta != nil {
+		if idx, err := store.find(key); err == nil && store.metrics[idx].Delta != nil {
+			sum := *store.metrics[idx].Delta + *metric.Delta
+			metric.Delta = &sum
+		}
+	}
+
+	if metric.MType == metricPkg.FloatCounterType && metric.Value != nil {
+		if idx, err := store.find(key); err == nil && store.metrics[idx].Value != nil {
+			sum := *store.metrics[idx].Value + *metric.Value
+			metric.Value = &sum
+		}
+	}
+
+	return store.upsert(key, metric)
+}
+
 // Get - Получение полность заполненной метрики
-func (store Storage) Get(metric metricPkg.Metric) (metricPkg.Metric, error) {
+func (store *Storage) Get(ctx context.Context, metric metricPkg.Metric) (metricPkg.Metric, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
 
-	idx, err := store.Find(metric)
+	idx, err := store.find(indexKey(metric))
 	if err != nil {
 		return metricPkg.Metric{}, err
 	}
@@ -73,36 +419,176 @@ func (store Storage) Get(metric metricPkg.Metric) (metricPkg.Metric, error) {
 	return store.metrics[idx], nil
 }
 
-// GetBatch Получение всех метрик в виде слайса
-func (store Storage) GetBatch() ([]metricPkg.Metric, error) {
+// GetBatch Получение копии всех метрик в виде слайса
+func (store *Storage) GetBatch(ctx context.Context) ([]metricPkg.Metric, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	metrics := make([]metricPkg.Metric, len(store.metrics))
+	copy(metrics, store.metrics)
+
+	return metrics, nil
+}
+
+// GetByType Получение копий метрик типа typeMetric, отсортированных по ID.
+// Метрики с одинаковым ID упорядочены по меткам. Порядок метрик запоминается
+// и не сортируется заново, пока метрики не добавлялись, не удалялись и не переименовывались
+func (store *Storage) GetByType(ctx context.Context, typeMetric string) ([]metricPkg.Metric, error) {
+	store.mu.RLock()
+
+	if positions, ok := store.sorted[typeMetric]; ok || store.counts[typeMetric] == 0 {
+		metrics := store.collect(positions)
+		store.mu.RUnlock()
+
+		return metrics, nil
+	}
+
+	store.mu.RUnlock()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.collect(store.sortedPositions(typeMetric)), nil
+}
+
+// Reset Сброс значения counter или float_counter в ноль
+func (store *Storage) Reset(ctx context.Context, metric metricPkg.Metric) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
-	return store.metrics, nil
+	if !metricPkg.IsCounter(metric.MType) {
+		return fmt.Errorf("could not reset metric %s: %w", metric.ID, errs.ErrInvalidType)
+	}
+
+	idx, err := store.find(indexKey(metric))
+	if err != nil {
+		return err
+	}
+
+	if metric.MType == metricPkg.FloatCounterType {
+		var zero float64
+		store.metrics[idx].Value = &zero
+	} else {
+		var zero int64
+		store.metrics[idx].Delta = &zero
+	}
+	store.metrics[idx].Hash = ``
+	store.metrics[idx].LastUpdate = metric.LastUpdate
+
+	return nil
+}
+
+// Count Количество метрик типа typeMetric без обхода всех метрик.
+// Для неизвестного типа возвращается 0
+func (store *Storage) Count(ctx context.Context, typeMetric string) (int, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.counts[typeMetric], nil
+}
+
+// CountTenant Количество метрик типа typeMetric арендатора owner без обхода всех метрик
+func (store *Storage) CountTenant(ctx context.Context, owner, typeMetric string) (int, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.owners[ownerKey(owner, typeMetric)], nil
 }
 
 // Delete - Удаление метрики
-func (store *Storage) Delete(metric metricPkg.Metric) error {
+func (store *Storage) Delete(ctx context.Context, metric metricPkg.Metric) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
-	idx, err := store.Find(metric)
+	idx, err := store.find(indexKey(metric))
 	if err != nil {
 		return err
 	}
 
+	store.removed(store.metrics[idx])
+
+	delete(store.index, indexKey(metric))
 	store.metrics = append(store.metrics[:idx], store.metrics[idx+1:]...)
+	store.reindex(idx)
+
+	return nil
+}
+
+// DeleteWhere Удаление всех метрик, для которых match возвращает true, под одной блокировкой.
+// Возвращается количество удаленных метрик
+func (store *Storage) DeleteWhere(ctx context.Context, match func(metricPkg.Metric) bool) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	kept := store.metrics[:0]
+	for _, metric := range store.metrics {
+		if !match(metric) {
+			kept = append(kept, metric)
+		}
+	}
+
+	deleted := len(store.metrics) - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	// Освобождение ссылок на удаленные метрики в хвосте слайса
+	for i := len(kept); i < len(store.metrics); i++ {
+		store.metrics[i] = metricPkg.Metric{}
+	}
+
+	store.metrics = kept
+	store.index = make(map[string]int, len(store.metrics))
+	store.reindex(0)
+	store.recount()
+
+	return deleted, nil
+}
+
+// Rename Переименование метрики с сохранением типа, меток и значения.
+// Если метрика с новым ID уже существует, возвращается errs.ErrExists
+func (store *Storage) Rename(ctx context.Context, metric metricPkg.Metric, newID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	idx, err := store.find(indexKey(metric))
+	if err != nil {
+		return err
+	}
+
+	renamed := store.metrics[idx]
+	renamed.ID = newID
+	renamed.Hash = ``
+
+	if _, errFind := store.find(indexKey(renamed)); errFind == nil {
+		return fmt.Errorf("%w: %s", errs.ErrExists, renamed.Key())
+	}
+
+	if errType := store.checkType(renamed); errType != nil {
+		return errType
+	}
+
+	delete(store.index, indexKey(metric))
+	store.removed(store.metrics[idx])
+	store.metrics[idx] = renamed
+	store.index[indexKey(renamed)] = idx
+	store.added(renamed)
+
 	return nil
 }
 
-func (store Storage) Flush() error {
+func (store *Storage) Flush(ctx context.Context) error {
 	return nil
 }
 
-func (store Storage) Restore() error {
+func (store *Storage) Restore(ctx context.Context) error {
 	return nil
 }
 
-func (store Storage) Close() error {
+func (store *Storage) Close() error {
 	return nil
 }
 
-func (store Storage) Health() bool {
+func (store *Storage) Health() bool {
 	return true
 }
@@ -1,10 +1,18 @@
 package memstore
 
 import (
+	"context"
+	"encoding/json"
+	"sort"
 	"strconv"
 	"testing"
+	"time"
 
+	"metrics-and-alerting/internal/tenant"
+	"metrics-and-alerting/pkg/errs"
 	"metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkInMemoryStorage_Upsert(b *testing.B) {
@@ -21,8 +29,656 @@ func BenchmarkInMemoryStorage_Upsert(b *testing.B) {
 			Delta: &delta,
 		}
 
-		if err := memStore.Upsert(m); err != nil {
+		if err := memStore.Upsert(context.Background(), m); err != nil {
 			b.Errorf("error upsert metric: %v", err)
 		}
 	}
 }
+
+// BenchmarkInMemoryStorage_Find Поиск метрики в хранилище с 50000 метрик
+func BenchmarkInMemoryStorage_Find(b *testing.B) {
+
+	const count = 50000
+
+	memStore := New()
+	for i := 0; i < count; i++ {
+		m, _ := metric.CreateMetric(metric.GaugeType, "testMetric_"+strconv.Itoa(i), metric.WithValueInt(int64(i)))
+		if err := memStore.Upsert(context.Background(), m); err != nil {
+			b.Fatalf("error upsert metric: %v", err)
+		}
+	}
+
+	seek := metric.Metric{ID: "testMetric_" + strconv.Itoa(count-1), MType: metric.GaugeType}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := memStore.Find(seek); err != nil {
+			b.Errorf("error find metric: %v", err)
+		}
+	}
+}
+
+// TestStorage_Index Индекс должен оставаться корректным после удаления метрик
+func TestStorage_Index(t *testing.T) {
+
+	memStore := New()
+
+	for i := 0; i < 5; i++ {
+		m, _ := metric.CreateMetric(metric.CounterType, "testCounter_"+strconv.Itoa(i), metric.WithValueInt(int64(i)))
+		require.NoError(t, memStore.Upsert(context.Background(), m))
+	}
+
+	require.NoError(t, memStore.Delete(context.Background(), metric.Metric{ID: "testCounter_1", MType: metric.CounterType}))
+
+	_, err := memStore.Get(context.Background(), metric.Metric{ID: "testCounter_1", MType: metric.CounterType})
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	for _, i := range []int{0, 2, 3, 4} {
+		got, err := memStore.Get(context.Background(), metric.Metric{ID: "testCounter_" + strconv.Itoa(i), MType: metric.CounterType})
+		require.NoError(t, err)
+		require.Equal(t, int64(i), *got.Delta)
+	}
+}
+
+func TestStorage_Delete(t *testing.T) {
+
+	gauge, _ := metric.CreateMetric(metric.GaugeType, "testGauge", metric.WithValueFloat(1.5))
+	counter, _ := metric.CreateMetric(metric.CounterType, "testCounter", metric.WithValueInt(10))
+
+	tests := []struct {
+		name      string
+		stored    []metric.Metric
+		delete    metric.Metric
+		wantErr   error
+		wantCount int
+	}{
+		{
+			name:      "Delete existing metric -> OK",
+			stored:    []metric.Metric{gauge, counter},
+			delete:    gauge,
+			wantCount: 1,
+		},
+		{
+			name:      "Delete missing metric -> NOT FOUND",
+			stored:    []metric.Metric{gauge},
+			delete:    counter,
+			wantErr:   errs.ErrNotFound,
+			wantCount: 1,
+		},
+		{
+			name:      "Delete last remaining metric -> OK",
+			stored:    []metric.Metric{counter},
+			delete:    counter,
+			wantCount: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			memStore := New()
+			require.NoError(t, memStore.UpsertBatch(context.Background(), tt.stored))
+
+			err := memStore.Delete(context.Background(), tt.delete)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			metrics, err := memStore.GetBatch(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, tt.wantCount)
+
+			_, err = memStore.Get(context.Background(), tt.delete)
+			require.ErrorIs(t, err, errs.ErrNotFound)
+		})
+	}
+}
+
+// TestStorage_DeleteWhere Удаление нескольких метрик сохраняет индекс оставшихся
+func TestStorage_DeleteWhere(t *testing.T) {
+
+	memStore := New()
+
+	for i := 0; i < 6; i++ {
+		m, _ := metric.CreateMetric(metric.CounterType, "testCounter_"+strconv.Itoa(i), metric.WithValueInt(int64(i)))
+		require.NoError(t, memStore.Upsert(context.Background(), m))
+	}
+
+	deleted, err := memStore.DeleteWhere(context.Background(), func(m metric.Metric) bool {
+		return *m.Delta%2 == 0
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+
+	for i := 0; i < 6; i++ {
+		got, err := memStore.Get(context.Background(), metric.Metric{ID: "testCounter_" + strconv.Itoa(i), MType: metric.CounterType})
+		if i%2 == 0 {
+			require.ErrorIs(t, err, errs.ErrNotFound)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, int64(i), *got.Delta)
+	}
+
+	deleted, err = memStore.DeleteWhere(context.Background(), func(m metric.Metric) bool { return false })
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
+
+func TestStorage_Rename(t *testing.T) {
+
+	memStore := New()
+
+	for _, id := range []string{"oldName", "taken", "other"} {
+		m, _ := metric.CreateMetric(metric.GaugeType, id, metric.WithValueFloat(1.5))
+		require.NoError(t, memStore.Upsert(context.Background(), m))
+	}
+
+	source := metric.Metric{ID: "oldName", MType: metric.GaugeType}
+
+	require.ErrorIs(t, memStore.Rename(context.Background(), source, "taken"), errs.ErrExists)
+	require.ErrorIs(t, memStore.Rename(context.Background(), metric.Metric{ID: "missing", MType: metric.GaugeType}, "newName"), errs.ErrNotFound)
+
+	require.NoError(t, memStore.Rename(context.Background(), source, "newName"))
+
+	_, err := memStore.Get(context.Background(), source)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	got, err := memStore.Get(context.Background(), metric.Metric{ID: "newName", MType: metric.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *got.Value)
+
+	// Индекс остальных метрик не изменился
+	other, err := memStore.Get(context.Background(), metric.Metric{ID: "other", MType: metric.GaugeType})
+	require.NoError(t, err)
+	require.Equal(t, "other", other.ID)
+}
+
+// BenchmarkInMemoryStorage_GetParallel Конкурентное чтение метрик
+func BenchmarkInMemoryStorage_GetParallel(b *testing.B) {
+
+	memStore := New()
+	for i := 0; i < 1000; i++ {
+		m, _ := metric.CreateMetric(metric.GaugeType, "testMetric_"+strconv.Itoa(i), metric.WithValueInt(int64(i)))
+		if err := memStore.Upsert(context.Background(), m); err != nil {
+			b.Fatalf("error upsert metric: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		seek := metric.Metric{ID: "testMetric_500", MType: metric.GaugeType}
+
+		for pb.Next() {
+			if _, err := memStore.Get(context.Background(), seek); err != nil {
+				b.Errorf("error get metric: %v", err)
+			}
+		}
+	})
+}
+
+// TestStorage_Histogram Наблюдения гистограммы учитываются в интервалах и сумме
+func TestStorage_Histogram(t *testing.T) {
+
+	memStore := New()
+
+	histogram, err := metric.CreateMetric(metric.HistogramType, "latency",
+		metric.WithBuckets([]float64{0.1, 0.5, 1}),
+		metric.WithValueFloat(0.05))
+	require.NoError(t, err)
+	require.NoError(t, memStore.Upsert(context.Background(), histogram))
+
+	for _, value := range []float64{0.3, 0.5, 2} {
+		observation, _ := metric.CreateMetric(metric.HistogramType, "latency", metric.WithValueFloat(value))
+		require.NoError(t, memStore.Upsert(context.Background(), observation))
+	}
+
+	got, err := memStore.Get(context.Background(), metric.Metric{ID: "latency", MType: metric.HistogramType})
+	require.NoError(t, err)
+	require.Nil(t, got.Value)
+	require.Equal(t, []float64{0.1, 0.5, 1}, got.Buckets)
+	require.Equal(t, []uint64{1, 2, 0, 1}, got.Counts)
+	require.InDelta(t, 2.85, *got.Sum, 1e-9)
+
+	// Полученная ранее копия не должна меняться при новых наблюдениях
+	observation, _ := metric.CreateMetric(metric.HistogramType, "latency", metric.WithValueFloat(0.7))
+	require.NoError(t, memStore.Upsert(context.Background(), observation))
+	require.Equal(t, []uint64{1, 2, 0, 1}, got.Counts)
+
+	data, err := json.Marshal(got)
+	require.NoError(t, err)
+
+	var decoded metric.Metric
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, got, decoded)
+	require.NoError(t, decoded.Validate())
+
+	// Полное состояние гистограммы заменяет хранимое
+	require.NoError(t, memStore.Upsert(context.Background(), decoded))
+	restored, err := memStore.Get(context.Background(), decoded)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 0, 1}, restored.Counts)
+}
+
+// TestStorage_HistogramDefaultBuckets Без заданных границ используются DefaultBuckets
+func TestStorage_HistogramDefaultBuckets(t *testing.T) {
+
+	memStore := New()
+
+	observation, _ := metric.CreateMetric(metric.HistogramType, "latency", metric.WithValueFloat(0.2))
+	require.NoError(t, memStore.Upsert(context.Background(), observation))
+
+	got, err := memStore.Get(context.Background(), observation)
+	require.NoError(t, err)
+	require.Equal(t, metric.DefaultBuckets, got.Buckets)
+	require.Len(t, got.Counts, len(metric.DefaultBuckets)+1)
+	require.Equal(t, uint64(1), got.Counts[5])
+}
+
+// TestStorage_Labels Метрики с одинаковым ID и разными метками хранятся отдельно
+func TestStorage_Labels(t *testing.T) {
+
+	memStore := New()
+
+	get, _ := metric.CreateMetric(metric.CounterType, "http_requests",
+		metric.WithValueInt(3),
+		metric.WithLabels(metric.Labels{"method": "GET"}))
+	post, _ := metric.CreateMetric(metric.CounterType, "http_requests",
+		metric.WithValueInt(5),
+		metric.WithLabels(metric.Labels{"method": "POST"}))
+	plain, _ := metric.CreateMetric(metric.CounterType, "http_requests", metric.WithValueInt(7))
+
+	require.NoError(t, memStore.UpsertBatch(context.Background(), []metric.Metric{get, post, plain}))
+
+	metrics, err := memStore.GetBatch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+
+	for _, want := range []metric.Metric{get, post, plain} {
+		got, err := memStore.Get(context.Background(), metric.Metric{ID: want.ID, MType: want.MType, Labels: want.Labels})
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	require.NoError(t, memStore.Delete(context.Background(), post))
+
+	_, err = memStore.Get(context.Background(), post)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	_, err = memStore.Get(context.Background(), get)
+	require.NoError(t, err)
+}
+
+// TestStorage_GetByType Возвращаются все метрики запрошенного типа и только они, по порядку ID
+func TestStorage_GetByType(t *testing.T) {
+
+	memStore := New()
+
+	gaugeB, _ := metric.CreateMetric(metric.GaugeType, "b", metric.WithValueFloat(2))
+	gaugeA, _ := metric.CreateMetric(metric.GaugeType, "a", metric.WithValueFloat(1))
+	gaugeLabeled, _ := metric.CreateMetric(metric.GaugeType, "a",
+		metric.WithValueFloat(3),
+		metric.WithLabels(metric.Labels{"host": "x"}))
+	counter, _ := metric.CreateMetric(metric.CounterType, "c", metric.WithValueInt(5))
+
+	require.NoError(t, memStore.UpsertBatch(context.Background(), []metric.Metric{gaugeB, counter, gaugeLabeled, gaugeA}))
+
+	gauges, err := memStore.GetByType(context.Background(), metric.GaugeType)
+	require.NoError(t, err)
+	require.Equal(t, []metric.Metric{gaugeA, gaugeLabeled, gaugeB}, gauges)
+
+	counters, err := memStore.GetByType(context.Background(), metric.CounterType)
+	require.NoError(t, err)
+	require.Equal(t, []metric.Metric{counter}, counters)
+
+	histograms, err := memStore.GetByType(context.Background(), metric.HistogramType)
+	require.NoError(t, err)
+	require.Empty(t, histograms)
+}
+
+func TestStorage_Reset(t *testing.T) {
+
+	store := New()
+
+	counter, _ := metric.CreateMetric(metric.CounterType, "testCounter", metric.WithValueInt(10))
+	gauge, _ := metric.CreateMetric(metric.GaugeType, "testGauge", metric.WithValueFloat(1.5))
+	require.NoError(t, store.UpsertBatch(context.Background(), []metric.Metric{counter, gauge}))
+
+	require.NoError(t, store.Reset(context.Background(), metric.Metric{ID: "testCounter", MType: metric.CounterType}))
+
+	got, err := store.Get(context.Background(), counter)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), *got.Delta)
+
+	require.ErrorIs(t, store.Reset(context.Background(), metric.Metric{ID: "unknown", MType: metric.CounterType}), errs.ErrNotFound)
+	require.ErrorIs(t, store.Reset(context.Background(), metric.Metric{ID: "testGauge", MType: metric.GaugeType}), errs.ErrInvalidType)
+}
+
+// batchMetrics Набор из count метрик counter с разными ID
+func batchMetrics(count int) []metric.Metric {
+
+	metrics := make([]metric.Metric, 0, count)
+	for i := 0; i < count; i++ {
+		m, _ := metric.CreateMetric(metric.CounterType, "testMetric_"+strconv.Itoa(i), metric.WithValueInt(int64(i)))
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// BenchmarkStorage_UpsertBatch Обновление набора из 100000 метрик.
+// Write - время обновления набора без конкурентного чтения.
+// Read - обновление набора при чтении метрики раз в 100 мкс,
+// read-max-ns - наибольшее время ожидания чтения.
+//
+// Результаты (go test -bench UpsertBatch -benchtime 20x, 1 CPU), с проверкой всего набора:
+//
+//	под одной блокировкой:   Write ~170 ms/op; Read ~145 ms/op, read-max-ns ~145-215 ms
+//	применение по частям:    Write ~145 ms/op; Read ~155 ms/op, read-max-ns ~0.15-19 ms
+func BenchmarkStorage_UpsertBatch(b *testing.B) {
+
+	const count = 100000
+
+	metrics := batchMetrics(count)
+
+	b.Run("Write", func(b *testing.B) {
+
+		memStore := New()
+		for i := 0; i < b.N; i++ {
+			if err := memStore.UpsertBatch(context.Background(), metrics); err != nil {
+				b.Fatalf("error upsert metrics: %v", err)
+			}
+		}
+	})
+
+	b.Run("Read", func(b *testing.B) {
+
+		memStore := New()
+		if err := memStore.UpsertBatch(context.Background(), metrics); err != nil {
+			b.Fatalf("error upsert metrics: %v", err)
+		}
+
+		seek := metric.Metric{ID: "testMetric_0", MType: metric.CounterType}
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+
+		var readMax int64
+		go func() {
+			defer close(stopped)
+
+			ticker := time.NewTicker(100 * time.Microsecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+
+				start := time.Now()
+				if _, err := memStore.Get(context.Background(), seek); err != nil {
+					b.Errorf("error get metric: %v", err)
+					return
+				}
+
+				if wait := time.Since(start).Nanoseconds(); wait > readMax {
+					readMax = wait
+				}
+			}
+		}()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := memStore.UpsertBatch(context.Background(), metrics); err != nil {
+				b.Fatalf("error upsert metrics: %v", err)
+			}
+		}
+		b.StopTimer()
+
+		close(done)
+		<-stopped
+
+		b.ReportMetric(float64(readMax), "read-max-ns")
+	})
+}
+
+// TestStorage_UpsertBatchLarge Большой набор обновляется полностью, последнее значение метрики в наборе сохраняется
+func TestStorage_UpsertBatchLarge(t *testing.T) {
+
+	store := New()
+
+	metrics := batchMetrics(2*batchChunkSize + 10)
+	last, _ := metric.CreateMetric(metric.CounterType, "testMetric_0", metric.WithValueInt(-1))
+	metrics = append(metrics, last)
+
+	require.NoError(t, store.UpsertBatch(context.Background(), metrics))
+
+	count, err := store.Count(context.Background(), metric.CounterType)
+	require.NoError(t, err)
+	require.Equal(t, 2*batchChunkSize+10, count)
+
+	got, err := store.Get(context.Background(), last)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), *got.Delta)
+
+	mismatch, _ := metric.CreateMetric(metric.GaugeType, "testMetric_1", metric.WithValueFloat(1))
+	err = store.UpsertBatch(context.Background(), append(batchMetrics(batchChunkSize), mismatch))
+	require.ErrorIs(t, err, errs.ErrTypeMismatch)
+}
+
+// TestStorage_UpsertBatchAtomic Набор применяется целиком, а при некорректной метрике хранилище не изменяется
+func TestStorage_UpsertBatchAtomic(t *testing.T) {
+
+	valid := func() []metric.Metric {
+		gauge, _ := metric.CreateMetric(metric.GaugeType, "testGauge", metric.WithValueFloat(1.5))
+		counter, _ := metric.CreateMetric(metric.CounterType, "testCounter", metric.WithValueInt(3))
+		floatCounter, _ := metric.CreateMetric(metric.FloatCounterType, "testFloat", metric.WithValueFloat(0.5))
+
+		return []metric.Metric{gauge, counter, floatCounter}
+	}
+
+	t.Run("All valid", func(t *testing.T) {
+		store := New()
+		require.NoError(t, store.UpsertBatch(context.Background(), valid()))
+
+		metrics, err := store.GetBatch(context.Background())
+		require.NoError(t, err)
+		require.Len(t, metrics, 3)
+	})
+
+	invalidValue := 2.0
+	tests := []struct {
+		name    string
+		third   metric.Metric
+		wantErr error
+	}{
+		{
+			name:    "Gauge without value",
+			third:   metric.Metric{ID: "broken", MType: metric.GaugeType},
+			wantErr: errs.ErrInvalidValue,
+		},
+		{
+			name:    "Unknown type",
+			third:   metric.Metric{ID: "broken", MType: "summary", Value: &invalidValue},
+			wantErr: errs.ErrUnknownType,
+		},
+		{
+			name:    "Invalid name",
+			third:   metric.Metric{ID: "broken name", MType: metric.GaugeType, Value: &invalidValue},
+			wantErr: errs.ErrInvalidID,
+		},
+		{
+			name:    "Type differs from metric in batch",
+			third:   metric.Metric{ID: "testGauge", MType: metric.FloatCounterType, Value: &invalidValue},
+			wantErr: errs.ErrTypeMismatch,
+		},
+		{
+			name:    "Type differs from stored metric",
+			third:   metric.Metric{ID: "stored", MType: metric.GaugeType, Value: &invalidValue},
+			wantErr: errs.ErrTypeMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			store := New()
+
+			stored, _ := metric.CreateMetric(metric.CounterType, "stored", metric.WithValueInt(1))
+			require.NoError(t, store.Upsert(context.Background(), stored))
+
+			batch := valid()
+			batch = append(batch[:2], tt.third, batch[2])
+
+			err := store.UpsertBatch(context.Background(), batch)
+			require.ErrorIs(t, err, tt.wantErr)
+
+			var batchErr BatchError
+			require.ErrorAs(t, err, &batchErr)
+			require.Equal(t, 2, batchErr.Index)
+
+			metrics, err := store.GetBatch(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, []metric.Metric{stored}, metrics)
+		})
+	}
+}
+
+// TestStorage_CountAndOrder Количество и порядок метрик по типам совпадают с полным обходом
+// после каждой операции добавления, обновления, переименования и удаления
+func TestStorage_CountAndOrder(t *testing.T) {
+
+	ctx := context.Background()
+	memStore := New()
+
+	gauge := func(id string) metric.Metric {
+		m, err := metric.CreateMetric(metric.GaugeType, id, metric.WithValueFloat(1))
+		require.NoError(t, err)
+		return m
+	}
+
+	counter := func(id string) metric.Metric {
+		m, err := metric.CreateMetric(metric.CounterType, id, metric.WithValueInt(1))
+		require.NoError(t, err)
+		return m
+	}
+
+	steps := []struct {
+		name  string
+		apply func() error
+	}{
+		{name: "Add gauge c", apply: func() error { return memStore.Upsert(ctx, gauge("c")) }},
+		{name: "Add gauge a", apply: func() error { return memStore.Upsert(ctx, gauge("a")) }},
+		{name: "Add counter x", apply: func() error { return memStore.Upsert(ctx, counter("x")) }},
+		{name: "Update gauge a", apply: func() error { return memStore.Upsert(ctx, gauge("a")) }},
+		{name: "Add batch", apply: func() error {
+			return memStore.UpsertBatch(ctx, []metric.Metric{gauge("b"), counter("y"), gauge("d")})
+		}},
+		{name: "Delete gauge c", apply: func() error { return memStore.Delete(ctx, gauge("c")) }},
+		{name: "Rename gauge d to aa", apply: func() error { return memStore.Rename(ctx, gauge("d"), "aa") }},
+		{name: "Merge counter x", apply: func() error { return memStore.Merge(ctx, []metric.Metric{counter("x")}) }},
+		{name: "Delete gauges a*", apply: func() error {
+			_, err := memStore.DeleteWhere(ctx, func(m metric.Metric) bool {
+				return m.MType == metric.GaugeType && m.ID[0] == 'a'
+			})
+			return err
+		}},
+		{name: "Add gauge a again", apply: func() error { return memStore.Upsert(ctx, gauge("a")) }},
+		{name: "Add tenant metrics", apply: func() error {
+			return memStore.UpsertBatch(ctx, []metric.Metric{
+				tenant.Scope("team-a", gauge("a")), tenant.Scope("team-a", gauge("b")), tenant.Scope("team-b", counter("x")),
+			})
+		}},
+		{name: "Delete tenant gauge", apply: func() error { return memStore.Delete(ctx, tenant.Scope("team-a", gauge("b"))) }},
+		{name: "Rename tenant gauge", apply: func() error {
+			return memStore.Rename(ctx, tenant.Scope("team-a", gauge("a")), "team-a/c")
+		}},
+		{name: "Delete all", apply: func() error {
+			_, err := memStore.DeleteWhere(ctx, func(metric.Metric) bool { return true })
+			return err
+		}},
+	}
+
+	for _, step := range steps {
+		require.NoError(t, step.apply(), step.name)
+
+		for _, typeMetric := range metric.Types {
+			wantIDs := make([]string, 0)
+			for _, m := range memStore.metrics {
+				if m.MType == typeMetric {
+					wantIDs = append(wantIDs, m.ID)
+				}
+			}
+			sort.Strings(wantIDs)
+
+			count, err := memStore.Count(ctx, typeMetric)
+			require.NoError(t, err)
+			require.Equal(t, len(wantIDs), count, "%s: count %s", step.name, typeMetric)
+
+			for _, owner := range []string{tenant.Default, "team-a", "team-b"} {
+				want := 0
+				for _, id := range wantIDs {
+					if tenant.Owner(id) == owner {
+						want++
+					}
+				}
+
+				count, err := memStore.CountTenant(ctx, owner, typeMetric)
+				require.NoError(t, err)
+				require.Equal(t, want, count, "%s: count %s of %s", step.name, typeMetric, owner)
+			}
+
+			// Второе чтение берет порядок из кэша
+			for i := 0; i < 2; i++ {
+				metrics, err := memStore.GetByType(ctx, typeMetric)
+				require.NoError(t, err)
+
+				ids := make([]string, 0, len(metrics))
+				for _, m := range metrics {
+					ids = append(ids, m.ID)
+				}
+				require.Equal(t, wantIDs, ids, "%s: order %s", step.name, typeMetric)
+			}
+		}
+	}
+}
+
+// BenchmarkStorage_CountAndGetByType Количество и список метрик типа в хранилище с 50000 метрик,
+// которые запрашивает часто обновляемая страница со списком метрик.
+//
+// Результаты (go test -bench CountAndGetByType -benchtime 200x, 1 CPU):
+//
+//	с обходом и сортировкой при каждом вызове: Count ~240 us/op; GetByType ~46 ms/op
+//	со счетчиками и запомненным порядком:      Count ~25 ns/op;  GetByType ~5.5 ms/op
+func BenchmarkStorage_CountAndGetByType(b *testing.B) {
+
+	const count = 50000
+
+	memStore := New()
+	for i := 0; i < count; i++ {
+		m, _ := metric.CreateMetric(metric.GaugeType, "testMetric_"+strconv.Itoa(i), metric.WithValueInt(int64(i)))
+		if err := memStore.Upsert(context.Background(), m); err != nil {
+			b.Fatalf("error upsert metric: %v", err)
+		}
+	}
+
+	b.Run("Count", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := memStore.Count(context.Background(), metric.GaugeType); err != nil {
+				b.Fatalf("error count metrics: %v", err)
+			}
+		}
+	})
+
+	b.Run("GetByType", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := memStore.GetByType(context.Background(), metric.GaugeType); err != nil {
+				b.Fatalf("error get metrics: %v", err)
+			}
+		}
+	})
+}
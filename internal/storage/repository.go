@@ -1,18 +1,25 @@
 package storage
 
 import (
+	"context"
 	"metrics-and-alerting/pkg/metric"
 )
 
+// Repository Хранилище метрик. Типы метрик определены в пакете metric
 type Repository interface {
-	Upsert(metric metric.Metric) error
-	UpsertBatch(metrics []metric.Metric) error
-	Get(metric metric.Metric) (metric.Metric, error)
-	GetBatch() ([]metric.Metric, error)
-	Delete(metric metric.Metric) error
+	Upsert(ctx context.Context, metric metric.Metric) error
+	UpsertBatch(ctx context.Context, metrics []metric.Metric) error
+	Get(ctx context.Context, metric metric.Metric) (metric.Metric, error)
+	GetBatch(ctx context.Context) ([]metric.Metric, error)
+	GetByType(ctx context.Context, typeMetric string) ([]metric.Metric, error)
+	Count(ctx context.Context, typeMetric string) (int, error)
+	Delete(ctx context.Context, metric metric.Metric) error
+	DeleteWhere(ctx context.Context, match func(metric.Metric) bool) (int, error)
+	Rename(ctx context.Context, metric metric.Metric, newID string) error
+	Reset(ctx context.Context, metric metric.Metric) error
 
-	Flush() error
-	Restore() error
+	Flush(ctx context.Context) error
+	Restore(ctx context.Context) error
 	Close() error
 
 	Health() bool
@@ -0,0 +1,102 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+)
+
+const (
+	// Default Арендатор запросов без идентификатора арендатора.
+	// Метрики арендатора по умолчанию хранятся без пространства имен, как до разделения на арендаторов
+	Default = "default"
+
+	// Separator Разделитель арендатора и имени метрики в хранилище: <арендатор>/<имя>.
+	// metric.ValidateName отклоняет этот символ при любом шаблоне имени, поэтому пространства имен не пересекаются
+	Separator = metricPkg.NameReserved
+
+	// MaxLen Максимальная длина идентификатора арендатора
+	MaxLen = 64
+)
+
+var pattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+type tenantKey struct{}
+
+// Validate Проверка идентификатора арендатора: латинские буквы, цифры, '_' и '-', не длиннее MaxLen
+func Validate(id string) error {
+
+	if len(id) == 0 || len(id) > MaxLen || !pattern.MatchString(id) {
+		return fmt.Errorf("%w: %q", errs.ErrInvalidTenant, id)
+	}
+
+	return nil
+}
+
+// WithTenant Контекст с идентификатором арендатора запроса
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext Идентификатор арендатора из контекста.
+// Если арендатор не задан, возвращается Default
+func FromContext(ctx context.Context) string {
+
+	id, _ := ctx.Value(tenantKey{}).(string)
+	if len(id) == 0 {
+		return Default
+	}
+
+	return id
+}
+
+// Scope Метрика в пространстве имен арендатора id
+func Scope(id string, metric metricPkg.Metric) metricPkg.Metric {
+
+	if id != Default {
+		metric.ID = id + Separator + metric.ID
+	}
+
+	return metric
+}
+
+// Unscope Метрика с именем без пространства имен арендатора id.
+// Если метрика принадлежит другому арендатору, возвращается false
+func Unscope(id string, metric metricPkg.Metric) (metricPkg.Metric, bool) {
+
+	if id == Default {
+		return metric, !strings.Contains(metric.ID, Separator)
+	}
+
+	prefix := id + Separator
+	if !strings.HasPrefix(metric.ID, prefix) {
+		return metric, false
+	}
+
+	metric.ID = strings.TrimPrefix(metric.ID, prefix)
+	return metric, true
+}
+
+// Owner Арендатор, которому принадлежит метрика с именем id в хранилище
+func Owner(id string) string {
+
+	if i := strings.Index(id, Separator); i >= 0 {
+		return id[:i]
+	}
+
+	return Default
+}
+
+// Name Имя метрики без пространства имен арендатора, которому она принадлежит
+func Name(id string) string {
+
+	if i := strings.Index(id, Separator); i >= 0 {
+		return id[i+len(Separator):]
+	}
+
+	return id
+}
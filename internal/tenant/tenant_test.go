@@ -0,0 +1,110 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"metrics-and-alerting/pkg/errs"
+	metricPkg "metrics-and-alerting/pkg/metric"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{name: "Letters and digits", id: "team1"},
+		{name: "Dash and underscore", id: "team-a_b"},
+		{name: "Empty", id: "", wantErr: true},
+		{name: "Separator", id: "team/a", wantErr: true},
+		{name: "Space", id: "team a", wantErr: true},
+		{name: "Too long", id: string(make([]byte, MaxLen+1)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := Validate(tt.id)
+			if tt.wantErr {
+				require.ErrorIs(t, err, errs.ErrInvalidTenant)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+
+	require.Equal(t, Default, FromContext(context.Background()))
+	require.Equal(t, "team-a", FromContext(WithTenant(context.Background(), "team-a")))
+}
+
+func TestScope(t *testing.T) {
+
+	metric := metricPkg.Metric{ID: "cpu", MType: metricPkg.GaugeType}
+
+	tests := []struct {
+		name     string
+		tenant   string
+		metric   metricPkg.Metric
+		wantID   string
+		wantOwns bool
+	}{
+		{
+			name:     "Default tenant keeps name",
+			tenant:   Default,
+			metric:   metric,
+			wantID:   "cpu",
+			wantOwns: true,
+		},
+		{
+			name:     "Tenant prefixes name",
+			tenant:   "team-a",
+			metric:   metric,
+			wantID:   "team-a/cpu",
+			wantOwns: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			scoped := Scope(tt.tenant, tt.metric)
+			require.Equal(t, tt.wantID, scoped.ID)
+
+			unscoped, ok := Unscope(tt.tenant, scoped)
+			require.Equal(t, tt.wantOwns, ok)
+			require.Equal(t, tt.metric, unscoped)
+		})
+	}
+
+	t.Run("Metric of another tenant", func(t *testing.T) {
+
+		_, ok := Unscope("team-b", Scope("team-a", metric))
+		require.False(t, ok)
+
+		_, ok = Unscope(Default, Scope("team-a", metric))
+		require.False(t, ok)
+
+		_, ok = Unscope("team-a", metric)
+		require.False(t, ok)
+	})
+}
+
+func TestName(t *testing.T) {
+
+	require.Equal(t, "cpu", Name("cpu"))
+	require.Equal(t, "cpu", Name(Scope("team-a", metricPkg.Metric{ID: "cpu"}).ID))
+}
+
+func TestOwner(t *testing.T) {
+
+	require.Equal(t, Default, Owner("cpu"))
+	require.Equal(t, "team-a", Owner(Scope("team-a", metricPkg.Metric{ID: "cpu"}).ID))
+}
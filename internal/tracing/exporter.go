@@ -0,0 +1,345 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracesPath Путь приема спанов в OTLP/HTTP, если в endpoint путь не указан
+const tracesPath = "/v1/traces"
+
+// Коды статуса спана в OTLP
+const (
+	statusUnset = 0
+	statusOk    = 1
+	statusError = 2
+)
+
+type (
+	// Exporter Экспорт спанов по протоколу OTLP/HTTP в JSON кодировке
+	Exporter struct {
+		url    string
+		client *http.Client
+
+		mu       sync.Mutex
+		shutdown bool
+	}
+
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string     `json:"stringValue,omitempty"`
+		BoolValue   *bool       `json:"boolValue,omitempty"`
+		IntValue    *string     `json:"intValue,omitempty"`
+		DoubleValue *float64    `json:"doubleValue,omitempty"`
+		ArrayValue  *otlpValues `json:"arrayValue,omitempty"`
+	}
+
+	otlpValues struct {
+		Values []otlpValue `json:"values"`
+	}
+)
+
+var _ sdktrace.SpanExporter = (*Exporter)(nil)
+
+// NewExporter Экспорт спанов на endpoint вида host:port или http(s)://host:port[/path].
+// Если путь не указан, используется /v1/traces
+func NewExporter(endpoint string) (*Exporter, error) {
+
+	endpoint = strings.TrimSpace(endpoint)
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect OTLP endpoint: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("incorrect OTLP endpoint scheme: %s", u.Scheme)
+	}
+
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("incorrect OTLP endpoint: empty host")
+	}
+
+	if len(strings.Trim(u.Path, "/")) == 0 {
+		u.Path = tracesPath
+	}
+
+	return &Exporter{
+		url:    u.String(),
+		client: &http.Client{},
+	}, nil
+}
+
+// ExportSpans Отправка набора спанов одним запросом
+func (exp *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+
+	exp.mu.Lock()
+	shutdown := exp.shutdown
+	exp.mu.Unlock()
+
+	if shutdown || len(spans) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(convertSpans(spans))
+	if err != nil {
+		return fmt.Errorf("could not encode spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, exp.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("could not create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := exp.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not export spans: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("could not read OTLP response: %w", err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("could not export spans: OTLP endpoint responded %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Shutdown Остановка экспорта. Последующие спаны отбрасываются
+func (exp *Exporter) Shutdown(ctx context.Context) error {
+
+	exp.mu.Lock()
+	exp.shutdown = true
+	exp.mu.Unlock()
+
+	exp.client.CloseIdleConnections()
+	return ctx.Err()
+}
+
+// convertSpans Группировка спанов по ресурсу и библиотеке инструментирования
+func convertSpans(spans []sdktrace.ReadOnlySpan) otlpRequest {
+
+	var req otlpRequest
+
+	resources := make(map[attribute.Distinct]int)
+	scopes := make(map[attribute.Distinct]map[string]int)
+
+	for _, span := range spans {
+
+		var key attribute.Distinct
+		var attrs []attribute.KeyValue
+		if res := span.Resource(); res != nil {
+			key, attrs = res.Equivalent(), res.Attributes()
+		}
+
+		idxRes, ok := resources[key]
+		if !ok {
+			idxRes = len(req.ResourceSpans)
+			resources[key] = idxRes
+			scopes[key] = make(map[string]int)
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: convertAttributes(attrs)},
+			})
+		}
+
+		lib := span.InstrumentationLibrary()
+		scopeKey := lib.Name + "@" + lib.Version
+
+		resSpans := &req.ResourceSpans[idxRes]
+		idxScope, ok := scopes[key][scopeKey]
+		if !ok {
+			idxScope = len(resSpans.ScopeSpans)
+			scopes[key][scopeKey] = idxScope
+			resSpans.ScopeSpans = append(resSpans.ScopeSpans, otlpScopeSpans{
+				Scope: otlpScope{Name: lib.Name, Version: lib.Version},
+			})
+		}
+
+		resSpans.ScopeSpans[idxScope].Spans = append(resSpans.ScopeSpans[idxScope].Spans, convertSpan(span))
+	}
+
+	return req
+}
+
+// convertSpan Спан в представлении OTLP JSON
+func convertSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+
+	sc := span.SpanContext()
+
+	s := otlpSpan{
+		TraceID:           sc.TraceID().String(),
+		SpanID:            sc.SpanID().String(),
+		Name:              span.Name(),
+		Kind:              int(span.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		Attributes:        convertAttributes(span.Attributes()),
+		Status:            convertStatus(span.Status()),
+	}
+
+	if parent := span.Parent(); parent.HasSpanID() {
+		s.ParentSpanID = parent.SpanID().String()
+	}
+
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   convertAttributes(event.Attributes),
+		})
+	}
+
+	return s
+}
+
+// convertStatus Статус спана в кодах OTLP
+func convertStatus(status sdktrace.Status) otlpStatus {
+
+	switch status.Code {
+	case codes.Ok:
+		return otlpStatus{Code: statusOk}
+	case codes.Error:
+		return otlpStatus{Code: statusError, Message: status.Description}
+	default:
+		return otlpStatus{Code: statusUnset}
+	}
+}
+
+// convertAttributes Атрибуты в представлении OTLP JSON
+func convertAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	converted := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		converted = append(converted, otlpKeyValue{
+			Key:   string(attr.Key),
+			Value: convertValue(attr.Value),
+		})
+	}
+
+	return converted
+}
+
+// convertValue Значение атрибута в представлении OTLP JSON.
+// Целые числа передаются строкой, как того требует OTLP JSON для int64
+func convertValue(value attribute.Value) otlpValue {
+
+	switch value.Type() {
+	case attribute.BOOL:
+		v := value.AsBool()
+		return otlpValue{BoolValue: &v}
+
+	case attribute.INT64:
+		v := strconv.FormatInt(value.AsInt64(), 10)
+		return otlpValue{IntValue: &v}
+
+	case attribute.FLOAT64:
+		v := value.AsFloat64()
+		return otlpValue{DoubleValue: &v}
+
+	case attribute.BOOLSLICE:
+		values := make([]otlpValue, 0, len(value.AsBoolSlice()))
+		for _, v := range value.AsBoolSlice() {
+			values = append(values, convertValue(attribute.BoolValue(v)))
+		}
+		return otlpValue{ArrayValue: &otlpValues{Values: values}}
+
+	case attribute.INT64SLICE:
+		values := make([]otlpValue, 0, len(value.AsInt64Slice()))
+		for _, v := range value.AsInt64Slice() {
+			values = append(values, convertValue(attribute.Int64Value(v)))
+		}
+		return otlpValue{ArrayValue: &otlpValues{Values: values}}
+
+	case attribute.FLOAT64SLICE:
+		values := make([]otlpValue, 0, len(value.AsFloat64Slice()))
+		for _, v := range value.AsFloat64Slice() {
+			values = append(values, convertValue(attribute.Float64Value(v)))
+		}
+		return otlpValue{ArrayValue: &otlpValues{Values: values}}
+
+	case attribute.STRINGSLICE:
+		values := make([]otlpValue, 0, len(value.AsStringSlice()))
+		for _, v := range value.AsStringSlice() {
+			values = append(values, convertValue(attribute.StringValue(v)))
+		}
+		return otlpValue{ArrayValue: &otlpValues{Values: values}}
+
+	default:
+		v := value.Emit()
+		return otlpValue{StringValue: &v}
+	}
+}
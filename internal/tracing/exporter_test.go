@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestNewExporter(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		endpoint string
+		wantURL  string
+		wantErr  bool
+	}{
+		{
+			name:     "Host and port",
+			endpoint: "localhost:4318",
+			wantURL:  "http://localhost:4318/v1/traces",
+		},
+		{
+			name:     "URL without path",
+			endpoint: "https://collector:4318/",
+			wantURL:  "https://collector:4318/v1/traces",
+		},
+		{
+			name:     "URL with path",
+			endpoint: "http://collector:4318/otlp/v1/traces",
+			wantURL:  "http://collector:4318/otlp/v1/traces",
+		},
+		{
+			name:     "Unsupported scheme",
+			endpoint: "grpc://collector:4317",
+			wantErr:  true,
+		},
+		{
+			name:     "Empty host",
+			endpoint: "http:///v1/traces",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			exporter, err := NewExporter(tt.endpoint)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantURL, exporter.url)
+		})
+	}
+}
+
+func TestExporter_ExportSpans(t *testing.T) {
+
+	received := make(chan otlpRequest, 1)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var req otlpRequest
+		require.NoError(t, json.Unmarshal(data, &req))
+		received <- req
+	}))
+	defer collector.Close()
+
+	exporter, err := NewExporter(collector.URL)
+	require.NoError(t, err)
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer(TracerName)
+
+	ctx, parent := tracer.Start(context.Background(), "handler.UpdateURL")
+	_, child := tracer.Start(ctx, "postgres.Upsert")
+	child.SetAttributes(AttrMetricType.String("gauge"), AttrMetricCount.Int(1))
+	End(child, errors.New("connection refused"))
+
+	req := <-received
+	require.Len(t, req.ResourceSpans, 1)
+	require.Len(t, req.ResourceSpans[0].ScopeSpans, 1)
+	assert.Equal(t, TracerName, req.ResourceSpans[0].ScopeSpans[0].Scope.Name)
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "postgres.Upsert", span.Name)
+	assert.Equal(t, parent.SpanContext().TraceID().String(), span.TraceID)
+	assert.Equal(t, parent.SpanContext().SpanID().String(), span.ParentSpanID)
+	assert.Equal(t, statusError, span.Status.Code)
+	assert.Equal(t, "connection refused", span.Status.Message)
+
+	require.Len(t, span.Attributes, 2)
+	assert.Equal(t, "metric.type", span.Attributes[0].Key)
+	assert.Equal(t, "gauge", *span.Attributes[0].Value.StringValue)
+	assert.Equal(t, "1", *span.Attributes[1].Value.IntValue)
+
+	End(parent, nil)
+	<-received
+
+	require.NoError(t, provider.Shutdown(context.Background()))
+}
+
+func TestSetupDisabled(t *testing.T) {
+
+	shutdown, err := Setup("")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
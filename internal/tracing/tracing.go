@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"metrics-and-alerting/pkg/metric"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName Имя tracer, которым создаются спаны сервера
+	TracerName = "metrics-and-alerting"
+
+	// ServiceName Имя сервиса в экспортируемых спанах
+	ServiceName = "metrics-server"
+
+	// AttrMetricType Атрибут спана с типом метрики
+	AttrMetricType = attribute.Key("metric.type")
+	// AttrMetricTypes Атрибут спана с типами метрик набора
+	AttrMetricTypes = attribute.Key("metric.types")
+	// AttrMetricCount Атрибут спана с количеством метрик набора
+	AttrMetricCount = attribute.Key("metric.count")
+)
+
+// Setup Настройка экспорта спанов по OTLP/HTTP на endpoint и распространения контекста
+// трассировки в формате W3C Trace Context.
+// Если endpoint не задан, трассировка не выполняется.
+// Возвращаемая функция отправляет накопленные спаны и останавливает экспорт
+func Setup(endpoint string) (func(ctx context.Context) error, error) {
+
+	if len(endpoint) == 0 {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	exporter, err := NewExporter(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(ServiceName),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+// Start Начало спана операции name в контексте ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End Завершение спана. Ошибка операции записывается в спан
+func End(span trace.Span, err error) {
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// MetricTypes Атрибуты спана для набора метрик: количество и типы без повторов
+func MetricTypes(metrics []metric.Metric) []attribute.KeyValue {
+
+	unique := make([]string, 0, len(metric.Types))
+	known := make(map[string]struct{}, len(metric.Types))
+
+	for _, m := range metrics {
+		if _, ok := known[m.MType]; ok {
+			continue
+		}
+
+		known[m.MType] = struct{}{}
+		unique = append(unique, m.MType)
+	}
+
+	return []attribute.KeyValue{
+		AttrMetricCount.Int(len(metrics)),
+		AttrMetricTypes.StringSlice(unique),
+	}
+}
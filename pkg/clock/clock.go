@@ -0,0 +1,48 @@
+package clock
+
+import "time"
+
+type (
+	// Clock Источник времени и тикеров.
+	// Позволяет подменить время в тестах логики, зависящей от времени
+	Clock interface {
+		Now() time.Time
+		NewTicker(d time.Duration) Ticker
+	}
+
+	// Ticker Тикер, отправляющий время в канал C с заданным периодом
+	Ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	// Real Время и тикеры пакета time
+	Real struct{}
+
+	realTicker struct {
+		ticker *time.Ticker
+	}
+)
+
+var _ Clock = Real{}
+
+// New Часы, использующие пакет time
+func New() Clock {
+	return Real{}
+}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
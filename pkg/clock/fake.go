@@ -0,0 +1,104 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Fake Часы для тестов. Время меняется только вызовом Advance,
+	// тикеры срабатывают, когда время доходит до очередного периода
+	Fake struct {
+		mu      sync.Mutex
+		now     time.Time
+		tickers []*fakeTicker
+	}
+
+	fakeTicker struct {
+		clock  *Fake
+		c      chan time.Time
+		period time.Duration
+		next   time.Time
+	}
+)
+
+var _ Clock = (*Fake)(nil)
+
+// NewFake Часы для тестов с начальным временем now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (clock *Fake) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	return clock.now
+}
+
+// NewTicker Тикер с периодом d. Как и time.NewTicker, при d <= 0 вызывает панику
+func (clock *Fake) NewTicker(d time.Duration) Ticker {
+
+	if d <= 0 {
+		panic("non-positive interval for clock.Fake.NewTicker")
+	}
+
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	ticker := &fakeTicker{
+		clock:  clock,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   clock.now.Add(d),
+	}
+
+	clock.tickers = append(clock.tickers, ticker)
+	return ticker
+}
+
+// Advance Перевод времени вперед на d.
+// Как и у тикеров пакета time, срабатывания, которые не успели прочитать из канала, пропускаются
+func (clock *Fake) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.now = clock.now.Add(d)
+
+	for _, ticker := range clock.tickers {
+		for !ticker.next.After(clock.now) {
+			select {
+			case ticker.c <- ticker.next:
+			default:
+			}
+
+			ticker.next = ticker.next.Add(ticker.period)
+		}
+	}
+}
+
+// Tickers Количество запущенных тикеров
+func (clock *Fake) Tickers() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	return len(clock.tickers)
+}
+
+func (ticker *fakeTicker) C() <-chan time.Time {
+	return ticker.c
+}
+
+func (ticker *fakeTicker) Stop() {
+	clock := ticker.clock
+
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	for i, t := range clock.tickers {
+		if t == ticker {
+			clock.tickers = append(clock.tickers[:i], clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
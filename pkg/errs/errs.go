@@ -25,7 +25,24 @@ var (
 	ErrInvalidType  = NewErr("metric has incorrect type")
 	ErrInvalidValue = NewErr("metric has incorrect value")
 	ErrInvalidJSON  = NewErr("can't convert data JSON to metric")
+	ErrInvalidMsgp  = NewErr("can't convert data MessagePack to metric")
+	ErrInvalidOp    = NewErr("metric has incorrect update operation")
+	ErrInvalidLabel = NewErr("metric has incorrect label name")
 	ErrSignFailed   = NewErr("sign verification failed")
+	ErrTypeMismatch = NewErr("metric already exists with another type")
+	ErrExists       = NewErr("metric already exists")
+	ErrNoStats      = NewErr("metric has no statistics")
+
+	ErrInvalidDelta   = NewErr("metric field delta must be an integer")
+	ErrInvalidNumber  = NewErr("metric field value must be a number")
+	ErrValueOnCounter = NewErr("metric counter must not have field value")
+	ErrDeltaOnGauge   = NewErr("metric gauge must not have field delta")
+	ErrDeltaOnFloat   = NewErr("metric float_counter must not have field delta")
+	ErrDeltaAndValue  = NewErr("metric must not have both fields delta and value")
+
+	ErrUnknownHashAlgo = NewErr("unknown hash algorithm")
+
+	ErrInvalidTenant = NewErr("incorrect tenant id")
 )
 
 // Ошибки внешнего хранилища
@@ -33,6 +50,8 @@ var (
 	ErrInvalidFilePath  = NewErr("invalid path to fileStorage storage")
 	ErrInvalidDSN       = NewErr("invalid data source name")
 	ErrFailedConnection = NewErr("can not create connection")
+	ErrStorageConflict  = NewErr("conflicting storage options")
+	ErrCapacity         = NewErr("metrics capacity exceeded")
 )
 
 // ErrorHTTP - Преобразование ошибки Storage в HTTP код
@@ -44,18 +63,38 @@ func ErrorHTTP(err error) int {
 	}
 
 	switch storeErr {
-	case ErrNotFound:
+	case ErrNotFound, ErrNoStats:
 		return http.StatusNotFound
 
 	case ErrUnknownType:
 		return http.StatusNotImplemented
 
+	case ErrTypeMismatch, ErrExists:
+		return http.StatusConflict
+
+	case ErrCapacity:
+		return http.StatusInsufficientStorage
+
+	case
+		ErrValueOnCounter,
+		ErrDeltaOnGauge,
+		ErrDeltaOnFloat,
+		ErrDeltaAndValue:
+
+		return http.StatusUnprocessableEntity
+
 	case
 		ErrInvalidID,
 		ErrInvalidType,
 		ErrInvalidValue,
 		ErrInvalidJSON,
-		ErrSignFailed:
+		ErrInvalidMsgp,
+		ErrInvalidOp,
+		ErrInvalidLabel,
+		ErrInvalidDelta,
+		ErrInvalidNumber,
+		ErrSignFailed,
+		ErrInvalidTenant:
 
 		return http.StatusBadRequest
 
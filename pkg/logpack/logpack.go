@@ -1,31 +1,144 @@
 package logpack
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"sync"
 )
 
+// Level Минимальный уровень сообщений, которые выводятся в лог
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// levelNames Названия уровней логирования в конфигурации
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
 var (
 	singleLogger *LogPack
 	once         sync.Once
 )
 
 type LogPack struct {
+	Debug *log.Logger
 	Info  *log.Logger
+	Warn  *log.Logger
 	Err   *log.Logger
 	Fatal *log.Logger
+
+	out    io.Writer // вывод для Debug и Info
+	errOut io.Writer // вывод для Warn, Err и Fatal
 }
 
 func NewLogger() *LogPack {
 
 	once.Do(func() {
-		singleLogger = &LogPack{
-			Info:  log.New(os.Stdout, "INFO\t", log.LstdFlags),
-			Err:   log.New(os.Stderr, "ERROR\t", log.Lshortfile|log.LstdFlags),
-			Fatal: log.New(os.Stderr, "FATAL\t", log.Lshortfile|log.LstdFlags),
-		}
+		singleLogger = New(os.Stdout, os.Stderr)
 	})
 
 	return singleLogger
 }
+
+// New Набор логгеров с выводом Debug и Info в out, остальных уровней - в errOut.
+// Минимальный уровень - LevelInfo
+func New(out, errOut io.Writer) *LogPack {
+
+	lp := &LogPack{
+		Debug: log.New(out, "DEBUG\t", log.Lshortfile|log.LstdFlags),
+		Info:  log.New(out, "INFO\t", log.LstdFlags),
+		Warn:  log.New(errOut, "WARN\t", log.Lshortfile|log.LstdFlags),
+		Err:   log.New(errOut, "ERROR\t", log.Lshortfile|log.LstdFlags),
+		Fatal: log.New(errOut, "FATAL\t", log.Lshortfile|log.LstdFlags),
+
+		out:    out,
+		errOut: errOut,
+	}
+
+	lp.SetLevel(LevelInfo)
+	return lp
+}
+
+// ParseLevel Уровень логирования по названию: debug, info, warn, error
+func ParseLevel(name string) (Level, error) {
+
+	level, ok := levelNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return LevelInfo, fmt.Errorf("unknown log level %q: use debug, info, warn or error", name)
+	}
+
+	return level, nil
+}
+
+// SetLevel Отключение вывода сообщений ниже уровня level.
+// Сообщения Fatal выводятся всегда
+func (lp *LogPack) SetLevel(level Level) {
+
+	lp.Debug.SetOutput(writerForLevel(LevelDebug, level, lp.out))
+	lp.Info.SetOutput(writerForLevel(LevelInfo, level, lp.out))
+	lp.Warn.SetOutput(writerForLevel(LevelWarn, level, lp.errOut))
+	lp.Err.SetOutput(writerForLevel(LevelError, level, lp.errOut))
+}
+
+// writerForLevel Вывод для логгера уровня level при минимальном уровне min
+func writerForLevel(level, min Level, out io.Writer) io.Writer {
+
+	if level < min {
+		return io.Discard
+	}
+
+	return out
+}
+
+// requestIDKey Ключ идентификатора запроса в контексте
+type requestIDKey struct{}
+
+// WithRequestID Контекст с идентификатором запроса, который добавляется в сообщения логгера из Ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID Идентификатор запроса из контекста.
+// Если идентификатор не задан, возвращается пустая строка
+func RequestID(ctx context.Context) string {
+
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Ctx Набор логгеров, добавляющих в каждое сообщение идентификатор запроса из контекста.
+// Если идентификатор не задан, возвращается исходный набор
+func (lp *LogPack) Ctx(ctx context.Context) *LogPack {
+
+	id := RequestID(ctx)
+	if len(id) == 0 {
+		return lp
+	}
+
+	withID := func(l *log.Logger) *log.Logger {
+		return log.New(l.Writer(), l.Prefix()+"request_id="+id+"\t", l.Flags())
+	}
+
+	return &LogPack{
+		Debug:  withID(lp.Debug),
+		Info:   withID(lp.Info),
+		Warn:   withID(lp.Warn),
+		Err:    withID(lp.Err),
+		Fatal:  withID(lp.Fatal),
+		out:    lp.out,
+		errOut: lp.errOut,
+	}
+}
@@ -0,0 +1,90 @@
+package logpack
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogPack_SetLevel(t *testing.T) {
+
+	var out, errOut bytes.Buffer
+
+	logger := New(&out, &errOut)
+
+	level, err := ParseLevel("warn")
+	require.NoError(t, err)
+	logger.SetLevel(level)
+
+	logger.Debug.Println("debug message")
+	logger.Info.Println("info message")
+	logger.Warn.Println("warn message")
+	logger.Err.Println("error message")
+
+	assert.Empty(t, out.String())
+	assert.Contains(t, errOut.String(), "WARN")
+	assert.Contains(t, errOut.String(), "warn message")
+	assert.Contains(t, errOut.String(), "error message")
+
+	out.Reset()
+	logger.SetLevel(LevelDebug)
+
+	logger.Debug.Println("debug message")
+	logger.Info.Println("info message")
+
+	assert.Contains(t, out.String(), "debug message")
+	assert.Contains(t, out.String(), "info message")
+}
+
+func TestParseLevel(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		level   string
+		want    Level
+		wantErr bool
+	}{
+		{name: "Debug", level: "debug", want: LevelDebug},
+		{name: "Info", level: "info", want: LevelInfo},
+		{name: "Warn in upper case", level: "WARN", want: LevelWarn},
+		{name: "Error", level: "error", want: LevelError},
+		{name: "Unknown", level: "verbose", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			level, err := ParseLevel(tt.level)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, level)
+		})
+	}
+}
+
+func TestLogPack_Ctx(t *testing.T) {
+
+	var out, errOut bytes.Buffer
+
+	logger := New(&out, &errOut)
+	require.Same(t, logger, logger.Ctx(context.Background()))
+
+	ctx := WithRequestID(context.Background(), "req-42")
+	require.Equal(t, "req-42", RequestID(ctx))
+
+	logger.Ctx(ctx).Err.Println("error message")
+	logger.Ctx(ctx).Debug.Println("debug message")
+
+	assert.Contains(t, errOut.String(), "ERROR\trequest_id=req-42\t")
+	assert.Contains(t, errOut.String(), "error message")
+
+	// Уровень логирования сохраняется
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,111 @@
+package metric
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"metrics-and-alerting/pkg/errs"
+)
+
+// DecodeJSON Разбор метрики из JSON с проверкой полей.
+// В отличие от json.Unmarshal, для неверного типа delta или value
+// и недопустимого сочетания полей возвращаются отдельные ошибки
+func DecodeJSON(data []byte) (Metric, error) {
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Metric{}, fmt.Errorf("%w: %v", errs.ErrInvalidJSON, err)
+	}
+
+	if err := validateFields(fields); err != nil {
+		return Metric{}, err
+	}
+
+	var metric Metric
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return Metric{}, fmt.Errorf("%w: %v", errs.ErrInvalidJSON, err)
+	}
+
+	return metric, nil
+}
+
+// DecodeJSONBatch Разбор набора метрик из JSON массива с проверкой полей каждой метрики
+func DecodeJSONBatch(data []byte) ([]Metric, error) {
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("%w: %v", errs.ErrInvalidJSON, err)
+	}
+
+	metrics := make([]Metric, 0, len(items))
+	for i, item := range items {
+
+		metric, err := DecodeJSON(item)
+		if err != nil {
+			return nil, fmt.Errorf("metric #%d: %w", i, err)
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// validateFields Проверка типов полей delta и value и их сочетания с типом метрики
+func validateFields(fields map[string]json.RawMessage) error {
+
+	var mType string
+	if raw, ok := fields["type"]; ok && !isNull(raw) {
+		if err := json.Unmarshal(raw, &mType); err != nil {
+			return fmt.Errorf(`%w: field "type" must be a string`, errs.ErrInvalidType)
+		}
+	}
+
+	rawDelta, hasDelta := fields["delta"]
+	hasDelta = hasDelta && !isNull(rawDelta)
+
+	rawValue, hasValue := fields["value"]
+	hasValue = hasValue && !isNull(rawValue)
+
+	if hasDelta {
+		var delta int64
+		if err := json.Unmarshal(rawDelta, &delta); err != nil {
+			return fmt.Errorf("%w: got %s", errs.ErrInvalidDelta, rawDelta)
+		}
+	}
+
+	if hasValue {
+		var value float64
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			return fmt.Errorf("%w: got %s", errs.ErrInvalidNumber, rawValue)
+		}
+	}
+
+	return checkValueFields(mType, hasDelta, hasValue)
+}
+
+// checkValueFields Проверка сочетания полей delta и value с типом метрики
+func checkValueFields(mType string, hasDelta, hasValue bool) error {
+
+	switch {
+	case hasDelta && hasValue:
+		return errs.ErrDeltaAndValue
+
+	case hasValue && mType == CounterType:
+		return errs.ErrValueOnCounter
+
+	case hasDelta && mType == GaugeType:
+		return errs.ErrDeltaOnGauge
+
+	case hasDelta && mType == FloatCounterType:
+		return errs.ErrDeltaOnFloat
+	}
+
+	return nil
+}
+
+// isNull Признак значения null в JSON
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
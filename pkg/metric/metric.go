@@ -3,37 +3,164 @@ package metric
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
+	"math"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"metrics-and-alerting/pkg/errs"
 )
 
 const (
-	GaugeType   string = "gauge"
-	CounterType string = "counter"
+	GaugeType        string = "gauge"
+	CounterType      string = "counter"
+	HistogramType    string = "histogram"
+	FloatCounterType string = "float_counter"
+)
+
+// OpInc Операция обновления gauge, при которой значение добавляется к сохраненному.
+// Без операции значение gauge заменяется
+const OpInc = "inc"
+
+// Types Поддерживаемые типы метрик
+var Types = []string{GaugeType, CounterType, HistogramType, FloatCounterType}
+
+// IsCounter Признак монотонного счетчика, значения которого накапливаются: counter и float_counter
+func IsCounter(typeMetric string) bool {
+	return typeMetric == CounterType || typeMetric == FloatCounterType
+}
+
+// Ограничения имени метрики по умолчанию
+const (
+	DefaultNamePattern = `^[a-zA-Z_][a-zA-Z0-9_]*$`
+	DefaultNameMaxLen  = 256
+
+	// NameReserved Символ, запрещенный в имени метрики независимо от шаблона имени
+	NameReserved = "/"
+)
+
+// nameRules Ограничения имени метрики, действующие для CreateMetric и Validate
+var nameRules = struct {
+	mu      sync.RWMutex
+	pattern *regexp.Regexp
+	maxLen  int
+}{
+	pattern: regexp.MustCompile(DefaultNamePattern),
+	maxLen:  DefaultNameMaxLen,
+}
+
+// labelNamePattern Имя метки. Кавычки, запятые и '=' в имени запрещены,
+// иначе разные наборы меток могли бы совпасть в каноническом виде Labels.String
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// DefaultBuckets Верхние границы интервалов гистограммы по умолчанию
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Алгоритмы хеширования для подписи метрики
+const (
+	HashSHA256 string = "sha256"
+	HashSHA512 string = "sha512"
 )
 
 type (
 	OptionsMetric func(*Metric) error
 
+	// Labels Метки метрики. Метрики с одинаковым ID и разными метками различаются
+	Labels map[string]string
+
 	Metric struct {
 		ID    string   `json:"id"`              // имя метрики
 		MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
 		Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-		Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+		Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge и float_counter или наблюдение histogram
 		Hash  string   `json:"hash,omitempty"`  // значение метрики
+		Op    string   `json:"op,omitempty"`    // операция обновления: пусто - замена значения, inc - приращение gauge
+
+		Labels Labels `json:"labels,omitempty"` // метки метрики
+
+		LastUpdate time.Time `json:"-"` // время последнего обновления метрики на сервере
+
+		Buckets []float64 `json:"buckets,omitempty"` // верхние границы интервалов histogram
+		Counts  []uint64  `json:"counts,omitempty"`  // количество наблюдений в интервалах histogram, последний - выше всех границ
+		Sum     *float64  `json:"sum,omitempty"`     // сумма наблюдений histogram
+	}
+
+	// Stats Статистика по последним значениям gauge
+	Stats struct {
+		Last  float64 `json:"last"`  // последнее значение
+		Min   float64 `json:"min"`   // минимальное значение
+		Max   float64 `json:"max"`   // максимальное значение
+		Avg   float64 `json:"avg"`   // среднее значение
+		Count int     `json:"count"` // количество значений
 	}
 )
 
+// SetNameRules Установка ограничений имени метрики: регулярное выражение и максимальная длина.
+// Пустой pattern и maxLen <= 0 заменяются значениями по умолчанию
+func SetNameRules(pattern string, maxLen int) error {
+
+	if len(pattern) == 0 {
+		pattern = DefaultNamePattern
+	}
+
+	if maxLen <= 0 {
+		maxLen = DefaultNameMaxLen
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid metric name pattern: %w", err)
+	}
+
+	nameRules.mu.Lock()
+	defer nameRules.mu.Unlock()
+
+	nameRules.pattern = re
+	nameRules.maxLen = maxLen
+
+	return nil
+}
+
+// ValidateName Проверка имени метрики на соответствие ограничениям
+func ValidateName(id string) error {
+
+	nameRules.mu.RLock()
+	defer nameRules.mu.RUnlock()
+
+	if len(id) < 1 {
+		return fmt.Errorf(`%w: field "id" is empty`, errs.ErrInvalidID)
+	}
+
+	if len(id) > nameRules.maxLen {
+		return fmt.Errorf(`%w: field "id" is longer than %d characters`, errs.ErrInvalidID, nameRules.maxLen)
+	}
+
+	// '/' отделяет имя метрики от пространства имен арендатора в хранилище,
+	// поэтому запрещен при любом шаблоне имени
+	if strings.Contains(id, NameReserved) {
+		return fmt.Errorf(`%w: field "id" %q contains reserved %q`, errs.ErrInvalidID, id, NameReserved)
+	}
+
+	if !nameRules.pattern.MatchString(id) {
+		return fmt.Errorf(`%w: field "id" %q does not match pattern %s`, errs.ErrInvalidID, id, nameRules.pattern)
+	}
+
+	return nil
+}
+
 // CreateMetric Создание метрики
 // Используется паттерн "Функциональные опции"
 func CreateMetric(typeMetric, id string, opts ...OptionsMetric) (Metric, error) {
 
-	if len(id) < 1 {
-		return Metric{}, errs.ErrInvalidID
+	if err := ValidateName(id); err != nil {
+		return Metric{}, err
 	}
 
 	if len(typeMetric) < 1 {
@@ -60,10 +187,10 @@ func WithValue(data string) OptionsMetric {
 	return func(metric *Metric) error {
 
 		switch metric.MType {
-		case GaugeType:
+		case GaugeType, HistogramType, FloatCounterType:
 
 			val, err := strconv.ParseFloat(data, 64)
-			if err != nil {
+			if err != nil || !finite(val) {
 				return fmt.Errorf("could not create metric: %w", errs.ErrInvalidValue)
 			}
 
@@ -90,7 +217,7 @@ func WithValueFloat(value float64) OptionsMetric {
 	return func(metric *Metric) error {
 
 		switch metric.MType {
-		case GaugeType:
+		case GaugeType, HistogramType, FloatCounterType:
 			metric.Value = &value
 
 		case CounterType:
@@ -111,7 +238,7 @@ func WithValueInt(value int64) OptionsMetric {
 	return func(metric *Metric) error {
 
 		switch metric.MType {
-		case GaugeType:
+		case GaugeType, HistogramType, FloatCounterType:
 			val := float64(value)
 			metric.Value = &val
 
@@ -126,15 +253,241 @@ func WithValueInt(value int64) OptionsMetric {
 	}
 }
 
+// WithLabels Опция конструктора метрики - метки метрики
+func WithLabels(labels Labels) OptionsMetric {
+	return func(metric *Metric) error {
+
+		if len(labels) == 0 {
+			metric.Labels = nil
+			return nil
+		}
+
+		if err := labels.Validate(); err != nil {
+			return fmt.Errorf("could not create metric: %w", err)
+		}
+
+		metric.Labels = make(Labels, len(labels))
+		for name, value := range labels {
+			metric.Labels[name] = value
+		}
+
+		return nil
+	}
+}
+
+// Validate Проверка имен меток
+func (labels Labels) Validate() error {
+
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) {
+			return fmt.Errorf("%w: %q does not match pattern %s", errs.ErrInvalidLabel, name, labelNamePattern)
+		}
+	}
+
+	return nil
+}
+
+// String Метки в каноническом виде {name="value",...} с сортировкой по имени.
+// Для метрики без меток возвращается пустая строка
+func (labels Labels) String() string {
+
+	if len(labels) == 0 {
+		return ``
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder := strings.Builder{}
+	builder.WriteString("{")
+
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+
+		builder.WriteString(name)
+		builder.WriteString("=")
+		builder.WriteString(strconv.Quote(labels[name]))
+	}
+
+	builder.WriteString("}")
+
+	return builder.String()
+}
+
+// Key Идентификатор метрики с учетом типа и меток в формате <type>:<id>{labels}
+func (metric Metric) Key() string {
+	return metric.MType + ":" + metric.ID + metric.Labels.String()
+}
+
+// WithBuckets Опция конструктора метрики - границы интервалов гистограммы.
+// Границы должны строго возрастать, счетчики интервалов и сумма обнуляются
+func WithBuckets(buckets []float64) OptionsMetric {
+	return func(metric *Metric) error {
+
+		if metric.MType != HistogramType {
+			return fmt.Errorf("could not set buckets: %w", errs.ErrUnknownType)
+		}
+
+		if !validBuckets(buckets) {
+			return fmt.Errorf("could not set buckets: %w", errs.ErrInvalidValue)
+		}
+
+		sum := 0.0
+
+		metric.Buckets = append([]float64(nil), buckets...)
+		metric.Counts = make([]uint64, len(buckets)+1)
+		metric.Sum = &sum
+
+		return nil
+	}
+}
+
+// validBuckets Проверка, что границы интервалов заданы и строго возрастают
+func validBuckets(buckets []float64) bool {
+
+	if len(buckets) == 0 {
+		return false
+	}
+
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Observe Учет наблюдения value в гистограмме: увеличение счетчика интервала и суммы.
+// Если границы интервалов не заданы, используются DefaultBuckets
+func (metric *Metric) Observe(value float64) error {
+
+	if metric.MType != HistogramType {
+		return errs.ErrUnknownType
+	}
+
+	if len(metric.Buckets) == 0 {
+		metric.Buckets = append([]float64(nil), DefaultBuckets...)
+	}
+
+	// Счетчики копируются, чтобы не изменять данные, которые могли быть отданы из хранилища
+	counts := make([]uint64, len(metric.Buckets)+1)
+	copy(counts, metric.Counts)
+	counts[sort.SearchFloat64s(metric.Buckets, value)]++
+
+	sum := value
+	if metric.Sum != nil {
+		sum += *metric.Sum
+	}
+
+	metric.Counts = counts
+	metric.Sum = &sum
+
+	return nil
+}
+
+// Validate Проверка метрики: должны быть заданы ID, известный тип и значение, соответствующее типу,
+// имена меток должны быть корректны
+func (metric Metric) Validate() error {
+
+	if err := ValidateName(metric.ID); err != nil {
+		return err
+	}
+
+	if err := metric.Labels.Validate(); err != nil {
+		return err
+	}
+
+	if metric.Op != "" && (metric.Op != OpInc || metric.MType != GaugeType) {
+		return fmt.Errorf("%w: %q for %s", errs.ErrInvalidOp, metric.Op, metric.MType)
+	}
+
+	switch metric.MType {
+	case GaugeType:
+		if metric.Value == nil || !finite(*metric.Value) {
+			return errs.ErrInvalidValue
+		}
+
+	case CounterType:
+		if metric.Delta == nil {
+			return errs.ErrInvalidValue
+		}
+
+	case FloatCounterType:
+		// Приращение монотонного счетчика не может быть отрицательным
+		if metric.Value == nil || !finite(*metric.Value) || *metric.Value < 0 {
+			return errs.ErrInvalidValue
+		}
+
+	case HistogramType:
+		if len(metric.Buckets) != 0 && !validBuckets(metric.Buckets) {
+			return errs.ErrInvalidValue
+		}
+
+		// Метрика содержит либо наблюдение, либо полное состояние гистограммы
+		if metric.Value != nil {
+			if !finite(*metric.Value) {
+				return errs.ErrInvalidValue
+			}
+
+			return nil
+		}
+
+		if metric.Sum == nil || !finite(*metric.Sum) || len(metric.Buckets) == 0 || len(metric.Counts) != len(metric.Buckets)+1 {
+			return errs.ErrInvalidValue
+		}
+
+	default:
+		return errs.ErrUnknownType
+	}
+
+	return nil
+}
+
+// finite Признак конечного значения: NaN и бесконечности не сохраняются,
+// так как не представимы в JSON
+func finite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// HashFunc Функция хеширования по названию алгоритма.
+// Пустое название соответствует алгоритму по умолчанию SHA256
+func HashFunc(algo string) (func() hash.Hash, error) {
+
+	switch strings.ToLower(algo) {
+	case "", HashSHA256:
+		return sha256.New, nil
+	case HashSHA512:
+		return sha512.New, nil
+	default:
+		return nil, errs.ErrUnknownHashAlgo
+	}
+}
+
 // Sign Подпись метрики
 // Данные метрики преобразуются в строку формата <id>:<type>:<value>
 // и при помощи алгоритка SHA256 и ключа key вычиляется хеш метрики
 func (metric Metric) Sign(key []byte) (string, error) {
+	return metric.SignWith(HashSHA256, key)
+}
+
+// SignWith Подпись метрики с использованием алгоритма хеширования algo
+func (metric Metric) SignWith(algo string, key []byte) (string, error) {
 
 	if len(key) == 0 {
 		return ``, nil
 	}
 
+	hashFunc, err := HashFunc(algo)
+	if err != nil {
+		return ``, err
+	}
+
 	var src string
 
 	switch metric.MType {
@@ -148,20 +501,40 @@ func (metric Metric) Sign(key []byte) (string, error) {
 			metric.MType,
 			*metric.Delta)
 
-	case GaugeType:
+	case GaugeType, FloatCounterType:
 		if metric.Value == nil {
 			return ``, errs.ErrInvalidValue
 		}
 
-		src = fmt.Sprintf("%s:%s:%f",
+		src = fmt.Sprintf("%s:%s:%s",
+			metric.ID,
+			metric.MType,
+			FormatFloat(*metric.Value))
+
+	case HistogramType:
+		// Наблюдение подписывается по значению, состояние гистограммы - по сумме
+		value := metric.Value
+		if value == nil {
+			value = metric.Sum
+		}
+
+		if value == nil {
+			return ``, errs.ErrInvalidValue
+		}
+
+		src = fmt.Sprintf("%s:%s:%s",
 			metric.ID,
 			metric.MType,
-			*metric.Value)
+			FormatFloat(*value))
+
 	default:
 		return ``, errs.ErrUnknownType
 	}
 
-	h := hmac.New(sha256.New, key)
+	// Метки добавляются в отсортированном виде, чтобы одинаковые наборы подписывались одинаково
+	src += metric.Labels.String()
+
+	h := hmac.New(hashFunc, key)
 	if _, err := h.Write([]byte(src)); err != nil {
 		return ``, err
 	}
@@ -169,6 +542,14 @@ func (metric Metric) Sign(key []byte) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// FormatFloat Единое строковое представление значений gauge и гистограммы.
+// Используется кратчайшая десятичная запись без экспоненты, по которой float64
+// восстанавливается без потерь, как и при хранении в JSON и базе данных.
+// Поэтому подпись, вывод значения и значение после восстановления из хранилища совпадают
+func FormatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
 // Map Преобразование структуры метрики в map
 // Возвращаемый map содержит ключи "type","name","value"
 func (metric Metric) Map() map[string]string {
@@ -180,15 +561,18 @@ func (metric Metric) Map() map[string]string {
 	data["value"] = ""
 
 	switch metric.MType {
-	case GaugeType:
+	case GaugeType, FloatCounterType:
 		if metric.Value != nil {
-			data["value"] = strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+			data["value"] = FormatFloat(*metric.Value)
 		}
 
 	case CounterType:
 		if metric.Delta != nil {
 			data["value"] = strconv.FormatInt(*metric.Delta, 10)
 		}
+
+	case HistogramType:
+		data["value"] = metric.StringValue()
 	}
 
 	return data
@@ -197,20 +581,47 @@ func (metric Metric) Map() map[string]string {
 // StringValue Преобразование значения метрики в строку
 func (metric Metric) StringValue() string {
 	switch metric.MType {
-	case GaugeType:
+	case GaugeType, FloatCounterType:
 		if metric.Value != nil {
-			return strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+			return FormatFloat(*metric.Value)
 		}
 
 	case CounterType:
 		if metric.Delta != nil {
 			return strconv.FormatInt(*metric.Delta, 10)
 		}
+
+	case HistogramType:
+		return metric.histogramString()
 	}
 
 	return ``
 }
 
+// histogramString Состояние гистограммы в виде строки формата
+// sum=<сумма> <граница>:<количество> ... +Inf:<количество>
+func (metric Metric) histogramString() string {
+
+	if metric.Sum == nil || len(metric.Counts) != len(metric.Buckets)+1 {
+		return ``
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("sum=")
+	builder.WriteString(FormatFloat(*metric.Sum))
+
+	for i, count := range metric.Counts {
+		bound := "+Inf"
+		if i < len(metric.Buckets) {
+			bound = FormatFloat(metric.Buckets[i])
+		}
+
+		builder.WriteString(fmt.Sprintf(" %s:%d", bound, count))
+	}
+
+	return builder.String()
+}
+
 // ShotString Данные метрики в виде строки в компактном виде
 // Возвращаемая строка имеет формат: <type>/<id>/<value>
 func (metric Metric) ShotString() string {
@@ -219,18 +630,22 @@ func (metric Metric) ShotString() string {
 	builder.WriteString(metric.MType)
 	builder.WriteString(" / ")
 	builder.WriteString(metric.ID)
+	builder.WriteString(metric.Labels.String())
 	builder.WriteString(" / ")
 
 	switch metric.MType {
-	case GaugeType:
+	case GaugeType, FloatCounterType:
 		if metric.Value != nil {
-			builder.WriteString(fmt.Sprintf("%f", *metric.Value))
+			builder.WriteString(FormatFloat(*metric.Value))
 		}
 
 	case CounterType:
 		if metric.Delta != nil {
 			builder.WriteString(fmt.Sprintf("%d", *metric.Delta))
 		}
+
+	case HistogramType:
+		builder.WriteString(metric.histogramString())
 	}
 
 	return builder.String()
@@ -247,6 +662,10 @@ func (metric Metric) String() string {
 	builder.WriteString(fmt.Sprintf("\t TYPE: %s\n", metric.MType))
 	builder.WriteString(fmt.Sprintf("\t HASH: %s\n", metric.Hash))
 
+	if len(metric.Labels) != 0 {
+		builder.WriteString(fmt.Sprintf("\t LABELS: %s\n", metric.Labels))
+	}
+
 	if metric.Delta != nil {
 		builder.WriteString(fmt.Sprintf("\t DELTA: %d\n", *metric.Delta))
 	} else {
@@ -254,7 +673,7 @@ func (metric Metric) String() string {
 	}
 
 	if metric.Value != nil {
-		builder.WriteString(fmt.Sprintf("\t VALUE: %f\n", *metric.Value))
+		builder.WriteString(fmt.Sprintf("\t VALUE: %s\n", FormatFloat(*metric.Value)))
 	} else {
 		builder.WriteString("\t VALUE: nil\n")
 	}
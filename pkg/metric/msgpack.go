@@ -0,0 +1,74 @@
+package metric
+
+import (
+	"bytes"
+	"fmt"
+
+	"metrics-and-alerting/pkg/errs"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackTag Теги полей, по которым кодируется MessagePack: имена полей совпадают с JSON
+const msgpackTag = "json"
+
+// EncodeMsgpack Кодирование метрики, набора метрик или ответа с метриками в MessagePack.
+// Имена полей и пропуск пустых полей такие же, как в JSON
+func EncodeMsgpack(v interface{}) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag(msgpackTag)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeMsgpack Разбор метрики из MessagePack с проверкой сочетания полей delta и value, как в DecodeJSON
+func DecodeMsgpack(data []byte) (Metric, error) {
+
+	var metric Metric
+	if err := decodeMsgpack(data, &metric); err != nil {
+		return Metric{}, err
+	}
+
+	if err := checkValueFields(metric.MType, metric.Delta != nil, metric.Value != nil); err != nil {
+		return Metric{}, err
+	}
+
+	return metric, nil
+}
+
+// DecodeMsgpackBatch Разбор набора метрик из MessagePack массива с проверкой полей каждой метрики
+func DecodeMsgpackBatch(data []byte) ([]Metric, error) {
+
+	var metrics []Metric
+	if err := decodeMsgpack(data, &metrics); err != nil {
+		return nil, err
+	}
+
+	for i, metric := range metrics {
+		if err := checkValueFields(metric.MType, metric.Delta != nil, metric.Value != nil); err != nil {
+			return nil, fmt.Errorf("metric #%d: %w", i, err)
+		}
+	}
+
+	return metrics, nil
+}
+
+// decodeMsgpack Разбор MessagePack в v по тем же именам полей, что и в JSON
+func decodeMsgpack(data []byte, v interface{}) error {
+
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag(msgpackTag)
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrInvalidMsgp, err)
+	}
+
+	return nil
+}
@@ -25,6 +25,9 @@ const _ = grpc.SupportPackageIsVersion7
 type MetricsClient interface {
 	UpsertGauge(ctx context.Context, in *UpsertGaugeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	UpsertCounter(ctx context.Context, in *UpsertCounterRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Update(ctx context.Context, opts ...grpc.CallOption) (Metrics_UpdateClient, error)
+	UpdateBatch(ctx context.Context, in *UpdateBatchRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*Metric, error)
 }
 
 type metricsClient struct {
@@ -53,12 +56,67 @@ func (c *metricsClient) UpsertCounter(ctx context.Context, in *UpsertCounterRequ
 	return out, nil
 }
 
+func (c *metricsClient) Update(ctx context.Context, opts ...grpc.CallOption) (Metrics_UpdateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Metrics_ServiceDesc.Streams[0], "/metrics.Metrics/Update", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsUpdateClient{stream}
+	return x, nil
+}
+
+type Metrics_UpdateClient interface {
+	Send(*Metric) error
+	CloseAndRecv() (*emptypb.Empty, error)
+	grpc.ClientStream
+}
+
+type metricsUpdateClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsUpdateClient) Send(m *Metric) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricsUpdateClient) CloseAndRecv() (*emptypb.Empty, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(emptypb.Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricsClient) UpdateBatch(ctx context.Context, in *UpdateBatchRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/metrics.Metrics/UpdateBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*Metric, error) {
+	out := new(Metric)
+	err := c.cc.Invoke(ctx, "/metrics.Metrics/GetMetric", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility
 type MetricsServer interface {
 	UpsertGauge(context.Context, *UpsertGaugeRequest) (*emptypb.Empty, error)
 	UpsertCounter(context.Context, *UpsertCounterRequest) (*emptypb.Empty, error)
+	Update(Metrics_UpdateServer) error
+	UpdateBatch(context.Context, *UpdateBatchRequest) (*emptypb.Empty, error)
+	GetMetric(context.Context, *GetMetricRequest) (*Metric, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -72,6 +130,15 @@ func (UnimplementedMetricsServer) UpsertGauge(context.Context, *UpsertGaugeReque
 func (UnimplementedMetricsServer) UpsertCounter(context.Context, *UpsertCounterRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpsertCounter not implemented")
 }
+func (UnimplementedMetricsServer) Update(Metrics_UpdateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedMetricsServer) UpdateBatch(context.Context, *UpdateBatchRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateBatch not implemented")
+}
+func (UnimplementedMetricsServer) GetMetric(context.Context, *GetMetricRequest) (*Metric, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetric not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 
 // UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
@@ -121,6 +188,68 @@ func _Metrics_UpsertCounter_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metrics_Update_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsServer).Update(&metricsUpdateServer{stream})
+}
+
+type Metrics_UpdateServer interface {
+	SendAndClose(*emptypb.Empty) error
+	Recv() (*Metric, error)
+	grpc.ServerStream
+}
+
+type metricsUpdateServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsUpdateServer) SendAndClose(m *emptypb.Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricsUpdateServer) Recv() (*Metric, error) {
+	m := new(Metric)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Metrics_UpdateBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).UpdateBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metrics.Metrics/UpdateBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).UpdateBatch(ctx, req.(*UpdateBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_GetMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metrics.Metrics/GetMetric",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetMetric(ctx, req.(*GetMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -136,7 +265,21 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpsertCounter",
 			Handler:    _Metrics_UpsertCounter_Handler,
 		},
+		{
+			MethodName: "UpdateBatch",
+			Handler:    _Metrics_UpdateBatch_Handler,
+		},
+		{
+			MethodName: "GetMetric",
+			Handler:    _Metrics_GetMetric_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Update",
+			Handler:       _Metrics_Update_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/metrics.proto",
 }